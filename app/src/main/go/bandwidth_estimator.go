@@ -0,0 +1,153 @@
+package kinetic
+
+import (
+	"fmt"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/rtcp"
+)
+
+// BandwidthEstimatorAlgorithm selects the congestion controller a WHIP sink
+// sizes the encoder bitrate with.
+type BandwidthEstimatorAlgorithm int
+
+const (
+	// BandwidthEstimatorGCC is pion's Google Congestion Control, driven by
+	// TWCC feedback.
+	BandwidthEstimatorGCC BandwidthEstimatorAlgorithm = iota
+	// BandwidthEstimatorSCReAM is SCReAM v2, as specified by
+	// draft-johansson-ccwg-rfc8298bis-screamv2 (the revision of RFC 8298),
+	// driven by TWCC or RFC 8888 feedback.
+	BandwidthEstimatorSCReAM
+	// BandwidthEstimatorFixed always targets InitialBitrate, e.g. for links
+	// with known, dedicated capacity.
+	BandwidthEstimatorFixed
+)
+
+func (a BandwidthEstimatorAlgorithm) String() string {
+	switch a {
+	case BandwidthEstimatorGCC:
+		return "GCC"
+	case BandwidthEstimatorSCReAM:
+		return "SCReAM"
+	case BandwidthEstimatorFixed:
+		return "fixed"
+	}
+	return fmt.Sprintf("BandwidthEstimatorAlgorithm(%d)", int(a))
+}
+
+// Default bitrate bounds in bps. The floor leaves 336 kbps of video next to
+// 64 kbps of audio; some ingests, e.g. IVS, need 200+ kbps for TWCC.
+const (
+	defaultInitialBitrate = 1_000_000
+	defaultMinBitrate     = 400_000
+	defaultMaxBitrate     = 7_500_000
+)
+
+// BandwidthEstimatorConfig configures a WHIP sink's congestion controller.
+// The zero value is GCC starting at 1 Mbps between 400 kbps and 7.5 Mbps,
+// using only its delay-based estimate.
+type BandwidthEstimatorConfig struct {
+	Algorithm BandwidthEstimatorAlgorithm
+
+	// Bitrates in bps. Zero uses the default.
+	InitialBitrate int
+	MinBitrate     int
+	MaxBitrate     int
+
+	// UseLossEstimate lets GCC lower the target on packet loss too. It's off
+	// by default because some ingests, e.g. IVS, report 60%+ loss at low
+	// bitrates that isn't there. SCReAM always reacts to loss.
+	UseLossEstimate bool
+}
+
+// withDefaults fills in the default bitrates and keeps them consistent.
+func (c BandwidthEstimatorConfig) withDefaults() BandwidthEstimatorConfig {
+	if c.MinBitrate <= 0 {
+		c.MinBitrate = defaultMinBitrate
+	}
+	if c.MaxBitrate <= 0 {
+		c.MaxBitrate = max(defaultMaxBitrate, c.MinBitrate)
+	}
+	if c.InitialBitrate <= 0 {
+		c.InitialBitrate = defaultInitialBitrate
+	}
+	c.InitialBitrate = min(max(c.InitialBitrate, c.MinBitrate), c.MaxBitrate)
+	return c
+}
+
+// validate reports bounds that can't be satisfied.
+func (c BandwidthEstimatorConfig) validate() error {
+	c = c.withDefaults()
+	if c.MinBitrate > c.MaxBitrate {
+		return fmt.Errorf("minimum bitrate %d is above maximum %d", c.MinBitrate, c.MaxBitrate)
+	}
+	switch c.Algorithm {
+	case BandwidthEstimatorGCC, BandwidthEstimatorSCReAM, BandwidthEstimatorFixed:
+		return nil
+	}
+	return fmt.Errorf("unknown bandwidth estimator %v", c.Algorithm)
+}
+
+// newEstimator creates the configured estimator for a new PeerConnection.
+func (c BandwidthEstimatorConfig) newEstimator() (cc.BandwidthEstimator, error) {
+	c = c.withDefaults()
+	switch c.Algorithm {
+	case BandwidthEstimatorGCC:
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(c.InitialBitrate),
+			gcc.SendSideBWEMinBitrate(c.MinBitrate),
+			gcc.SendSideBWEMaxBitrate(c.MaxBitrate),
+		)
+	case BandwidthEstimatorSCReAM:
+		return newScreamEstimator(c.InitialBitrate, c.MinBitrate, c.MaxBitrate), nil
+	case BandwidthEstimatorFixed:
+		return &fixedEstimator{bitrate: c.InitialBitrate}, nil
+	}
+	return nil, fmt.Errorf("unknown bandwidth estimator %v", c.Algorithm)
+}
+
+// targetBitrate returns estimator's target bitrate within the configured
+// bounds.
+func (c BandwidthEstimatorConfig) targetBitrate(estimator cc.BandwidthEstimator) int {
+	c = c.withDefaults()
+	targetBitrate := estimator.GetTargetBitrate()
+	if c.Algorithm == BandwidthEstimatorGCC && !c.UseLossEstimate {
+		if delayBitrate, ok := estimator.GetStats()["delayTargetBitrate"].(int); ok && delayBitrate > 0 {
+			targetBitrate = delayBitrate
+		}
+	}
+	return min(max(targetBitrate, c.MinBitrate), c.MaxBitrate)
+}
+
+// fixedEstimator is a cc.BandwidthEstimator that ignores feedback.
+type fixedEstimator struct {
+	bitrate int
+}
+
+var _ cc.BandwidthEstimator = (*fixedEstimator)(nil)
+
+func (e *fixedEstimator) AddStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return writer
+}
+
+func (e *fixedEstimator) WriteRTCP([]rtcp.Packet, interceptor.Attributes) error {
+	return nil
+}
+
+func (e *fixedEstimator) GetTargetBitrate() int {
+	return e.bitrate
+}
+
+// OnTargetBitrateChange never calls f because the target never changes.
+func (e *fixedEstimator) OnTargetBitrateChange(func(int)) {}
+
+func (e *fixedEstimator) GetStats() map[string]any {
+	return map[string]any{"targetBitrate": e.bitrate}
+}
+
+func (e *fixedEstimator) Close() error {
+	return nil
+}
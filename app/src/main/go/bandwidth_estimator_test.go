@@ -0,0 +1,56 @@
+package kinetic
+
+import (
+	"testing"
+)
+
+func TestBandwidthEstimatorConfigDefaults(t *testing.T) {
+	for _, tc := range []struct {
+		config                BandwidthEstimatorConfig
+		initial, lower, upper int
+	}{
+		{BandwidthEstimatorConfig{}, 1_000_000, 400_000, 7_500_000},
+		{BandwidthEstimatorConfig{MinBitrate: 200_000, MaxBitrate: 3_000_000}, 1_000_000, 200_000, 3_000_000},
+		// The initial bitrate is clamped to the bounds.
+		{BandwidthEstimatorConfig{InitialBitrate: 20_000_000}, 7_500_000, 400_000, 7_500_000},
+		{BandwidthEstimatorConfig{MaxBitrate: 500_000}, 500_000, 400_000, 500_000},
+		{BandwidthEstimatorConfig{MinBitrate: 10_000_000}, 10_000_000, 10_000_000, 10_000_000},
+	} {
+		got := tc.config.withDefaults()
+		if got.InitialBitrate != tc.initial || got.MinBitrate != tc.lower || got.MaxBitrate != tc.upper {
+			t.Errorf("%+v: got %d, %d-%d, want %d, %d-%d", tc.config,
+				got.InitialBitrate, got.MinBitrate, got.MaxBitrate, tc.initial, tc.lower, tc.upper)
+		}
+	}
+
+	if err := (BandwidthEstimatorConfig{MinBitrate: 2_000_000, MaxBitrate: 1_000_000}).validate(); err == nil {
+		t.Error("expected an error for a minimum above the maximum")
+	}
+	if err := (BandwidthEstimatorConfig{Algorithm: 42}).validate(); err == nil {
+		t.Error("expected an error for an unknown algorithm")
+	}
+}
+
+func TestFixedBandwidthEstimator(t *testing.T) {
+	config := BandwidthEstimatorConfig{Algorithm: BandwidthEstimatorFixed, InitialBitrate: 2_500_000}
+	estimator, err := config.newEstimator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.targetBitrate(estimator); got != 2_500_000 {
+		t.Errorf("target %d bps, want 2500000", got)
+	}
+}
+
+func TestBandwidthEstimatorTargetBitrateBounds(t *testing.T) {
+	config := BandwidthEstimatorConfig{Algorithm: BandwidthEstimatorSCReAM, MinBitrate: 500_000, MaxBitrate: 1_500_000}
+	for _, tc := range []struct{ estimate, want int }{
+		{100_000, 500_000},
+		{1_000_000, 1_000_000},
+		{9_000_000, 1_500_000},
+	} {
+		if got := config.targetBitrate(&fixedEstimator{bitrate: tc.estimate}); got != tc.want {
+			t.Errorf("estimate %d: target %d, want %d", tc.estimate, got, tc.want)
+		}
+	}
+}
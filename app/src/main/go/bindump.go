@@ -1,6 +1,7 @@
 package kinetic
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -15,29 +16,25 @@ type BinaryDumpSink struct {
 	file      *os.File
 }
 
+var _ Sink = (*BinaryDumpSink)(nil)
+
 func NewBinaryDumpSink(directory string) *BinaryDumpSink {
 	return &BinaryDumpSink{directory: directory}
 }
 
-func (s *BinaryDumpSink) WriteSample(track int, buf []byte, ptsMicroseconds int64, mediaCodecFlags int32) error {
-	// if it's a keyframe, create a new file based on the pts.
-	if MediaCodecBufferFlag(mediaCodecFlags)&MediaCodecBufferFlagKeyFrame != 0 {
-		if s.file != nil {
-			if err := s.file.Close(); err != nil {
-				return err
-			}
-		}
-		file, err := os.Create(fmt.Sprintf("%s/%d.ucf", s.directory, ptsMicroseconds))
-		if err != nil {
+func (s *BinaryDumpSink) WriteSample(track int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	// if it's a video keyframe, create a new file based on the pts. Audio
+	// buffers are all flagged as keyframes, so they never start a file.
+	if track == 0 && flags&MediaCodecBufferFlagKeyFrame != 0 {
+		if err := s.startFile(ptsMicroseconds); err != nil {
 			return err
 		}
-		s.file = file
 	}
 	// write the flags and pts to the buffer
 	if s.file != nil {
 		header := make([]byte, 16)
 		binary.LittleEndian.PutUint16(header[0:2], uint16(track+1))
-		binary.LittleEndian.PutUint16(header[2:4], uint16(mediaCodecFlags))
+		binary.LittleEndian.PutUint16(header[2:4], uint16(flags))
 		binary.LittleEndian.PutUint64(header[4:12], uint64(ptsMicroseconds))
 		binary.LittleEndian.PutUint32(header[12:16], uint32(len(buf)))
 		if _, err := s.file.Write(header); err != nil {
@@ -50,6 +47,31 @@ func (s *BinaryDumpSink) WriteSample(track int, buf []byte, ptsMicroseconds int6
 	return nil
 }
 
+// startFile closes the current file and starts a new one named by the pts.
+// Keyframes start files; the HLS sink also starts them to cut long GOPs.
+func (s *BinaryDumpSink) startFile(ptsMicroseconds int64) error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+	}
+	file, err := os.Create(fmt.Sprintf("%s/%d.ucf", s.directory, ptsMicroseconds))
+	if err != nil {
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+func (s *BinaryDumpSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
 func (t *BinaryDumpSink) ReadManifest() ([]ManifestEntry, error) {
 	files, err := os.ReadDir(t.directory)
 	if err != nil {
@@ -114,39 +136,74 @@ type BinaryDumpSample struct {
 }
 
 func (r *BinaryDumpSampleReader) Next() (*BinaryDumpSample, error) {
-	header := make([]byte, 16)
-	if _, err := r.file.Read(header); err != nil {
-		if errors.Is(err, io.EOF) {
-			// try to find the next manifest entry
-			manifest, err := r.t.ReadManifest()
-			if err != nil {
-				return nil, err
-			}
-			for _, e := range manifest {
-				if e.PTS > r.PTS0 {
-					file, err := os.Open(e.FileAbsolutePath)
-					if err != nil {
-						return nil, err
-					}
-					if err := r.file.Close(); err != nil {
-						return nil, err
-					}
-					r.file = file
-					r.PTS0 = e.PTS
-					return r.Next()
+	sample, err := readBinaryDumpSample(r.file)
+	if errors.Is(err, io.EOF) {
+		// try to find the next manifest entry
+		manifest, err := r.t.ReadManifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range manifest {
+			if e.PTS > r.PTS0 {
+				file, err := os.Open(e.FileAbsolutePath)
+				if err != nil {
+					return nil, err
+				}
+				if err := r.file.Close(); err != nil {
+					return nil, err
 				}
+				r.file = file
+				r.PTS0 = e.PTS
+				return r.Next()
 			}
+		}
+		return nil, io.EOF
+	}
+	return sample, err
+}
+
+// ReadSegment returns every sample in the file that starts at the keyframe
+// with the given pts.
+func (t *BinaryDumpSink) ReadSegment(ptsMicroseconds int64) ([]*BinaryDumpSample, error) {
+	file, err := os.Open(fmt.Sprintf("%s/%d.ucf", t.directory, ptsMicroseconds))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	var samples []*BinaryDumpSample
+	for {
+		sample, err := readBinaryDumpSample(br)
+		if errors.Is(err, io.EOF) {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+}
+
+func readBinaryDumpSample(r io.Reader) (*BinaryDumpSample, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// A sample cut short by a crash mid-write.
 			return nil, io.EOF
 		}
 		return nil, err
 	}
-	track := int(binary.LittleEndian.Uint16(header[2:4]))
-	flags := MediaCodecBufferFlag(binary.LittleEndian.Uint16(header[0:2]))
+	track := int(binary.LittleEndian.Uint16(header[0:2])) - 1
+	flags := MediaCodecBufferFlag(binary.LittleEndian.Uint16(header[2:4]))
 	pts := int64(binary.LittleEndian.Uint64(header[4:12]))
 	size := int(binary.LittleEndian.Uint32(header[12:16]))
 	data := make([]byte, size)
-	if _, err := r.file.Read(data); err != nil {
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
 		return nil, err
 	}
-	return &BinaryDumpSample{Track: track, Flags: flags, PTS: int64(pts), Data: data}, nil
+	return &BinaryDumpSample{Track: track, Flags: flags, PTS: pts, Data: data}, nil
 }
@@ -6,6 +6,7 @@ package main
 // void GoSRTOnPLI(int64_t handle);
 import "C"
 import (
+	"encoding/json"
 	"log"
 	"runtime"
 	"runtime/debug"
@@ -20,6 +21,7 @@ var (
 	mu          sync.RWMutex
 	srtSinks    = make(map[int64]*kinetic.SRTSink)
 	ristSinks   = make(map[int64]*kinetic.RISTSink)
+	ristSources = make(map[int64]*kinetic.RISTSource)
 	uvcSources  = make(map[int64]*kinetic.UVCSource)
 	uvcStreams  = make(map[int64]*kinetic.UVCStream)
 	whipSinks   = make(map[int64]*kinetic.WHIPSink)
@@ -35,7 +37,7 @@ func GoInit() {
 }
 
 //export GoCreateSRTSink
-func GoCreateSRTSink(urlStr *C.char, mimeTypesStr *C.char) (handle int64) {
+func GoCreateSRTSink(urlStr *C.char, mimeTypesStr *C.char, securityStr *C.char) (handle int64) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("PANIC in GoCreateSRTSink: %v\nStack trace:\n%s", r, debug.Stack())
@@ -45,8 +47,20 @@ func GoCreateSRTSink(urlStr *C.char, mimeTypesStr *C.char) (handle int64) {
 	
 	url := C.GoString(urlStr)
 	mimeTypes := C.GoString(mimeTypesStr)
+
+	// The security config is JSON encoded; an empty string leaves the
+	// connection unencrypted unless the URL says otherwise.
+	var opts []kinetic.SRTSinkOption
+	if securityJSON := C.GoString(securityStr); securityJSON != "" {
+		var security kinetic.SRTSecurityConfig
+		if err := json.Unmarshal([]byte(securityJSON), &security); err != nil {
+			log.Printf("SRT: invalid security config: %v", err)
+			return 0
+		}
+		opts = append(opts, kinetic.WithSRTSecurity(security))
+	}
 	
-	sink, err := kinetic.NewSRTSink(url, mimeTypes)
+	sink, err := kinetic.NewSRTSink(url, mimeTypes, opts...)
 	if err != nil {
 		return 0
 	}
@@ -78,12 +92,13 @@ func GoSRTSinkWriteSample(handle int64, streamIndex int32, data unsafe.Pointer,
 	
 	// Convert C bytes to Go slice without copying
 	goData := (*[1 << 30]byte)(data)[:length:length]
-	sink.WriteSample(int(streamIndex), goData, ptsMicroseconds, flags)
+	sink.WriteSample(int(streamIndex), goData, ptsMicroseconds, kinetic.MediaCodecBufferFlag(flags))
 }
 
 //export GoSRTSinkWriteH264
 func GoSRTSinkWriteH264(handle int64, data unsafe.Pointer, length int32, pts int64) {
-	// For backward compatibility, map to WriteSample with stream index 0
+	// For backward compatibility, map to WriteSample with stream index 0.
+	// Without flags the sink finds keyframes in the bitstream.
 	GoSRTSinkWriteSample(handle, 0, data, length, pts, 0)
 }
 
@@ -123,6 +138,36 @@ func GoSRTSinkGetBandwidth(handle int64) int64 {
 	return sink.GetStatsAndBandwidth()
 }
 
+//export GoSRTSinkGetStats
+func GoSRTSinkGetStats(handle int64) *C.char {
+	mu.RLock()
+	sink, ok := srtSinks[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return C.CString("{}")
+	}
+
+	return statsJSON(sink.Stats())
+}
+
+//export GoSRTSinkSetPassphrase
+func GoSRTSinkSetPassphrase(handle int64, passphraseStr *C.char) int32 {
+	mu.RLock()
+	sink, ok := srtSinks[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return 0
+	}
+
+	if err := sink.SetPassphrase(C.GoString(passphraseStr)); err != nil {
+		log.Printf("SRT: failed to set passphrase: %v", err)
+		return 0
+	}
+	return 1
+}
+
 //export GoSRTSinkSetPLICallback
 func GoSRTSinkSetPLICallback(handle int64) {
 	mu.Lock()
@@ -142,7 +187,7 @@ func (w *srtPLICallbackWrapper) OnPLI() {
 }
 
 //export GoCreateRISTSink
-func GoCreateRISTSink(urlStr *C.char, mimeTypesStr *C.char) (handle int64) {
+func GoCreateRISTSink(urlStr *C.char, mimeTypesStr *C.char, peersStr *C.char, securityStr *C.char) (handle int64) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("PANIC in GoCreateRISTSink: %v\nStack trace:\n%s", r, debug.Stack())
@@ -153,7 +198,29 @@ func GoCreateRISTSink(urlStr *C.char, mimeTypesStr *C.char) (handle int64) {
 	url := C.GoString(urlStr)
 	mimeTypes := C.GoString(mimeTypesStr)
 
-	sink, err := kinetic.NewRISTSink(url, mimeTypes)
+	// Extra peers are a JSON array; an empty string sends to the URL only.
+	var opts []kinetic.RISTSinkOption
+	if peersJSON := C.GoString(peersStr); peersJSON != "" {
+		var peers []kinetic.RISTPeerConfig
+		if err := json.Unmarshal([]byte(peersJSON), &peers); err != nil {
+			log.Printf("RIST: invalid peers: %v", err)
+			return 0
+		}
+		opts = append(opts, kinetic.WithRISTPeers(peers...))
+	}
+
+	// The security config is JSON encoded; an empty string leaves the
+	// connections unencrypted unless the URL says otherwise.
+	if securityJSON := C.GoString(securityStr); securityJSON != "" {
+		var security kinetic.RISTSecurityConfig
+		if err := json.Unmarshal([]byte(securityJSON), &security); err != nil {
+			log.Printf("RIST: invalid security config: %v", err)
+			return 0
+		}
+		opts = append(opts, kinetic.WithRISTSecurity(security))
+	}
+
+	sink, err := kinetic.NewRISTSink(url, mimeTypes, opts...)
 	if err != nil {
 		log.Printf("Failed to create RIST sink: %v", err)
 		return 0
@@ -184,7 +251,7 @@ func GoRISTSinkWriteSample(handle int64, streamIndex int32, data unsafe.Pointer,
 	}
 
 	goData := (*[1 << 30]byte)(data)[:length:length]
-	sink.WriteSample(int(streamIndex), goData, ptsMicroseconds, flags)
+	sink.WriteSample(int(streamIndex), goData, ptsMicroseconds, kinetic.MediaCodecBufferFlag(flags))
 }
 
 //export GoRISTSinkWriteH264
@@ -202,6 +269,43 @@ func GoRISTSinkWriteOpus(handle int64, data unsafe.Pointer, length int32, pts in
 	GoRISTSinkWriteSample(handle, 1, data, length, pts, 0)
 }
 
+//export GoRISTSinkGetBandwidth
+func GoRISTSinkGetBandwidth(handle int64) int64 {
+	mu.RLock()
+	sink, ok := ristSinks[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return 0
+	}
+
+	return sink.GetStatsAndBandwidth()
+}
+
+//export GoRISTSinkGetStats
+func GoRISTSinkGetStats(handle int64) *C.char {
+	mu.RLock()
+	sink, ok := ristSinks[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return C.CString("{}")
+	}
+
+	return statsJSON(sink.Stats())
+}
+
+// statsJSON encodes a sink's stats for the Kotlin side, which frees the
+// string.
+func statsJSON(stats any) *C.char {
+	b, err := json.Marshal(stats)
+	if err != nil {
+		log.Printf("Failed to encode stats: %v", err)
+		return C.CString("{}")
+	}
+	return C.CString(string(b))
+}
+
 //export GoRISTSinkClose
 func GoRISTSinkClose(handle int64) {
 	mu.Lock()
@@ -213,6 +317,161 @@ func GoRISTSinkClose(handle int64) {
 	mu.Unlock()
 }
 
+// RIST source exports, mirroring the RTMP source's
+
+//export GoCreateRISTSource
+func GoCreateRISTSource(urlStr *C.char, securityStr *C.char) (handle int64) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in GoCreateRISTSource: %v\nStack trace:\n%s", r, debug.Stack())
+			handle = 0
+		}
+	}()
+
+	var opts []kinetic.RISTSourceOption
+	if securityJSON := C.GoString(securityStr); securityJSON != "" {
+		var security kinetic.RISTSecurityConfig
+		if err := json.Unmarshal([]byte(securityJSON), &security); err != nil {
+			log.Printf("RIST: invalid security config: %v", err)
+			return 0
+		}
+		opts = append(opts, kinetic.WithRISTSourceSecurity(security))
+	}
+
+	source, err := kinetic.NewRISTSource(C.GoString(urlStr), opts...)
+	if err != nil {
+		log.Printf("Failed to create RIST source: %v", err)
+		return 0
+	}
+
+	mu.Lock()
+	handle = nextHandle
+	nextHandle++
+	ristSources[handle] = source
+	mu.Unlock()
+
+	return handle
+}
+
+// GoRISTSourceReadVideoFrame blocks for the next video frame, returning its
+// PTS in microseconds through ptsPtr.
+//
+//export GoRISTSourceReadVideoFrame
+func GoRISTSourceReadVideoFrame(handle int64, dataPtr *unsafe.Pointer, sizePtr *int32, ptsPtr *int64) int32 {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in GoRISTSourceReadVideoFrame: %v\nStack trace:\n%s", r, debug.Stack())
+		}
+	}()
+
+	mu.RLock()
+	source, ok := ristSources[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return 0
+	}
+
+	frame := source.ReadVideoFrame()
+	if frame == nil {
+		return 0
+	}
+
+	*dataPtr = C.CBytes(frame.Data)
+	*sizePtr = int32(len(frame.Data))
+	*ptsPtr = frame.PTS
+
+	return 1
+}
+
+// GoRISTSourceReadAudioFrame blocks for the next audio frame, returning its
+// PTS in microseconds through ptsPtr.
+//
+//export GoRISTSourceReadAudioFrame
+func GoRISTSourceReadAudioFrame(handle int64, dataPtr *unsafe.Pointer, sizePtr *int32, ptsPtr *int64) int32 {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in GoRISTSourceReadAudioFrame: %v\nStack trace:\n%s", r, debug.Stack())
+		}
+	}()
+
+	mu.RLock()
+	source, ok := ristSources[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return 0
+	}
+
+	frame := source.ReadAudioFrame()
+	if frame == nil {
+		return 0
+	}
+
+	*dataPtr = C.CBytes(frame.Data)
+	*sizePtr = int32(len(frame.Data))
+	*ptsPtr = frame.PTS
+
+	return 1
+}
+
+//export GoRISTSourceGetVideoCodec
+func GoRISTSourceGetVideoCodec(handle int64) *C.char {
+	mu.RLock()
+	source, ok := ristSources[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return C.CString("")
+	}
+
+	return C.CString(string(source.VideoCodec()))
+}
+
+//export GoRISTSourceGetAudioCodec
+func GoRISTSourceGetAudioCodec(handle int64) *C.char {
+	mu.RLock()
+	source, ok := ristSources[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return C.CString("")
+	}
+
+	return C.CString(string(source.AudioCodec()))
+}
+
+//export GoRISTSourceIsClosed
+func GoRISTSourceIsClosed(handle int64) int32 {
+	mu.RLock()
+	source, ok := ristSources[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return 1 // Treat missing as closed
+	}
+
+	if source.IsClosed() {
+		return 1
+	}
+	return 0
+}
+
+//export GoRISTSourceClose
+func GoRISTSourceClose(handle int64) {
+	mu.Lock()
+	source, ok := ristSources[handle]
+	if ok {
+		delete(ristSources, handle)
+	}
+	mu.Unlock()
+
+	// Closing waits for the receiving goroutine, so don't hold the lock.
+	if ok {
+		source.Close()
+	}
+}
+
 //export GoCreateUVCSource
 func GoCreateUVCSource(fd int32) (handle int64) {
 	defer func() {
@@ -344,7 +603,7 @@ func GoUVCStreamClose(handle int64) {
 }
 
 //export GoCreateWHIPSink
-func GoCreateWHIPSink(urlStr, tokenStr, mimeTypesStr *C.char) (handle int64) {
+func GoCreateWHIPSink(urlStr, tokenStr, mimeTypesStr, optionsStr *C.char) (handle int64) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("PANIC in GoCreateWHIPSink: %v\nStack trace:\n%s", r, debug.Stack())
@@ -356,9 +615,18 @@ func GoCreateWHIPSink(urlStr, tokenStr, mimeTypesStr *C.char) (handle int64) {
 	token := C.GoString(tokenStr)
 	mimeTypes := C.GoString(mimeTypesStr)
 
+	// The WebRTC options are JSON encoded; an empty string uses the defaults.
+	var options kinetic.WebRTCOptions
+	if optionsJSON := C.GoString(optionsStr); optionsJSON != "" {
+		if err := json.Unmarshal([]byte(optionsJSON), &options); err != nil {
+			log.Printf("WHIP: invalid WebRTC options: %v", err)
+			return 0
+		}
+	}
+
 	log.Printf("WHIP: creating sink url=%s mimeTypes=%s", url, mimeTypes)
 
-	sink, err := kinetic.NewWHIPSink(url, token, mimeTypes)
+	sink, err := kinetic.NewWHIPSink(url, token, mimeTypes, kinetic.WithWHIPWebRTCOptions(options))
 	if err != nil {
 		log.Printf("WHIP: failed to create sink: %v", err)
 		return 0
@@ -389,6 +657,38 @@ func GoWHIPSinkSetPLICallback(handle int64) {
 	mu.Unlock()
 }
 
+// GoWHIPSinkWriteSample writes a sample of any of the sink's codecs and
+// returns the video track's target bitrate in bps, or 0 for audio.
+//
+//export GoWHIPSinkWriteSample
+func GoWHIPSinkWriteSample(handle int64, streamIndex int32, data unsafe.Pointer, length int32, ptsMicroseconds int64, flags int32) (bitrate int32) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in GoWHIPSinkWriteSample: %v\nStack trace:\n%s", r, debug.Stack())
+			bitrate = 0
+		}
+	}()
+
+	mu.RLock()
+	sink, ok := whipSinks[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return 0
+	}
+
+	// Make a copy since the original data might be reused
+	dataCopy := C.GoBytes(data, C.int(length))
+	if err := sink.WriteSample(int(streamIndex), dataCopy, ptsMicroseconds, kinetic.MediaCodecBufferFlag(flags)); err != nil {
+		log.Printf("Error writing WHIP sample: %v", err)
+		return 0
+	}
+	if streamIndex != 0 {
+		return 0
+	}
+	return int32(sink.TargetBitrate())
+}
+
 //export GoWHIPSinkWriteH264
 func GoWHIPSinkWriteH264(handle int64, data unsafe.Pointer, length int32, pts int64) (bitrate int32) {
 	defer func() {
@@ -4,10 +4,13 @@ package main
 
 // #include <stdint.h>
 // #include <stdlib.h>
+// int GoRTMPOnAuthorize(int64_t handle, char* app, char* streamKey);
 import "C"
 import (
+	"fmt"
 	"log"
 	"runtime/debug"
+	"strings"
 	"time"
 	"unsafe"
 
@@ -18,8 +21,70 @@ import (
 var (
 	rtmpServers = make(map[int64]*kinetic.RTMPServer)
 	rtmpSources = make(map[int64]*kinetic.RTMPSource)
+	rtmpSinks   = make(map[int64]*kinetic.RTMPSink)
 )
 
+// RTMP Sink exports
+
+//export GoCreateRTMPSink
+func GoCreateRTMPSink(urlStr *C.char, mimeTypesStr *C.char) (handle int64) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in GoCreateRTMPSink: %v\nStack trace:\n%s", r, debug.Stack())
+			handle = 0
+		}
+	}()
+
+	url := C.GoString(urlStr)
+	mimeTypes := C.GoString(mimeTypesStr)
+
+	sink, err := kinetic.NewRTMPSink(url, mimeTypes)
+	if err != nil {
+		log.Printf("RTMP sink create error: %v", err)
+		return 0
+	}
+
+	mu.Lock()
+	handle = nextHandle
+	nextHandle++
+	rtmpSinks[handle] = sink
+	mu.Unlock()
+
+	return handle
+}
+
+//export GoRTMPSinkWriteSample
+func GoRTMPSinkWriteSample(handle int64, streamIndex int32, data unsafe.Pointer, length int32, ptsMicroseconds int64, flags int32) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in GoRTMPSinkWriteSample: %v\nStack trace:\n%s", r, debug.Stack())
+		}
+	}()
+
+	mu.RLock()
+	sink, ok := rtmpSinks[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	// Convert C bytes to Go slice without copying
+	goData := (*[1 << 30]byte)(data)[:length:length]
+	sink.WriteSample(int(streamIndex), goData, ptsMicroseconds, kinetic.MediaCodecBufferFlag(flags))
+}
+
+//export GoRTMPSinkClose
+func GoRTMPSinkClose(handle int64) {
+	mu.Lock()
+	sink, ok := rtmpSinks[handle]
+	if ok {
+		sink.Close()
+		delete(rtmpSinks, handle)
+	}
+	mu.Unlock()
+}
+
 // RTMP Server exports
 
 //export GoCreateRTMPServer
@@ -90,22 +155,9 @@ func GoRTMPServerGetPort(handle int64) int32 {
 	return int32(server.Port())
 }
 
-//export GoRTMPServerGetSource
-func GoRTMPServerGetSource(handle int64) int64 {
-	mu.RLock()
-	server, ok := rtmpServers[handle]
-	mu.RUnlock()
-
-	if !ok {
-		return 0
-	}
-
-	source := server.GetSource()
-	if source == nil {
-		return 0
-	}
-
-	// Check if we already have a handle for this source
+// rtmpSourceHandle returns the existing handle for source or creates one, so
+// repeated lookups of the same publisher don't leak handles.
+func rtmpSourceHandle(source *kinetic.RTMPSource) int64 {
 	mu.Lock()
 	defer mu.Unlock()
 
@@ -123,8 +175,26 @@ func GoRTMPServerGetSource(handle int64) int64 {
 	return sourceHandle
 }
 
+//export GoRTMPServerGetSource
+func GoRTMPServerGetSource(handle int64, keyStr *C.char) int64 {
+	mu.RLock()
+	server, ok := rtmpServers[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return 0
+	}
+
+	source := server.GetSource(C.GoString(keyStr))
+	if source == nil {
+		return 0
+	}
+
+	return rtmpSourceHandle(source)
+}
+
 //export GoRTMPServerWaitForSource
-func GoRTMPServerWaitForSource(handle int64, timeoutMs int32) int64 {
+func GoRTMPServerWaitForSource(handle int64, keyStr *C.char, timeoutMs int32) int64 {
 	mu.RLock()
 	server, ok := rtmpServers[handle]
 	mu.RUnlock()
@@ -133,18 +203,55 @@ func GoRTMPServerWaitForSource(handle int64, timeoutMs int32) int64 {
 		return 0
 	}
 
-	source := server.WaitForSource(time.Duration(timeoutMs) * time.Millisecond)
+	source := server.WaitForSource(C.GoString(keyStr), time.Duration(timeoutMs)*time.Millisecond)
 	if source == nil {
 		return 0
 	}
 
-	mu.Lock()
-	sourceHandle := nextHandle
-	nextHandle++
-	rtmpSources[sourceHandle] = source
-	mu.Unlock()
+	return rtmpSourceHandle(source)
+}
 
-	return sourceHandle
+// GoRTMPServerListSources returns the connected publisher keys separated by
+// newlines. The caller must free the result.
+//
+//export GoRTMPServerListSources
+func GoRTMPServerListSources(handle int64) *C.char {
+	mu.RLock()
+	server, ok := rtmpServers[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return C.CString("")
+	}
+
+	return C.CString(strings.Join(server.ListSources(), "\n"))
+}
+
+// GoRTMPServerSetAuthorizer returns 0 if there's no such server, so the
+// caller can report it rather than leave every publisher accepted.
+//
+//export GoRTMPServerSetAuthorizer
+func GoRTMPServerSetAuthorizer(handle int64) int32 {
+	mu.RLock()
+	server, ok := rtmpServers[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return 0
+	}
+
+	server.SetAuthorizer(func(app, streamKey string) error {
+		cApp := C.CString(app)
+		defer C.free(unsafe.Pointer(cApp))
+		cStreamKey := C.CString(streamKey)
+		defer C.free(unsafe.Pointer(cStreamKey))
+
+		if C.GoRTMPOnAuthorize(C.int64_t(handle), cApp, cStreamKey) == 0 {
+			return fmt.Errorf("rejected by authorizer")
+		}
+		return nil
+	})
+	return 1
 }
 
 //export GoRTMPSourceReadVideoFrame
@@ -227,6 +334,32 @@ func GoRTMPSourceGetAudioPTS(handle int64) int64 {
 	return source.GetAudioPTS()
 }
 
+//export GoRTMPSourceGetVideoCodec
+func GoRTMPSourceGetVideoCodec(handle int64) *C.char {
+	mu.RLock()
+	source, ok := rtmpSources[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return C.CString("")
+	}
+
+	return C.CString(string(source.VideoCodec()))
+}
+
+//export GoRTMPSourceGetAudioCodec
+func GoRTMPSourceGetAudioCodec(handle int64) *C.char {
+	mu.RLock()
+	source, ok := rtmpSources[handle]
+	mu.RUnlock()
+
+	if !ok {
+		return C.CString("")
+	}
+
+	return C.CString(string(source.AudioCodec()))
+}
+
 //export GoRTMPSourceIsClosed
 func GoRTMPSourceIsClosed(handle int64) int32 {
 	mu.RLock()
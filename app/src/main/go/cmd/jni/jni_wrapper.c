@@ -2,6 +2,7 @@
 #include <stdlib.h>
 #include <string.h>
 #include <stdint.h>
+#include <pthread.h>
 #include "_cgo_export.h"
 
 // Helper function to convert jstring to C string
@@ -43,18 +44,30 @@ Java_com_kevmo314_kineticstreamer_kinetic_Kinetic_init(JNIEnv* env, jclass clazz
 }
 
 JNIEXPORT jlong JNICALL
-Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_create(JNIEnv* env, jclass clazz, jstring url, jstring mimeTypes) {
+Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_create(JNIEnv* env, jclass clazz, jstring url, jstring mimeTypes, jstring security) {
     const char* urlStr = jstring_to_cstring(env, url);
     const char* mimeTypesStr = jstring_to_cstring(env, mimeTypes);
+    const char* securityStr = jstring_to_cstring(env, security);
     
-    jlong handle = GoCreateSRTSink((char*)urlStr, (char*)mimeTypesStr);
+    jlong handle = GoCreateSRTSink((char*)urlStr, (char*)mimeTypesStr, (char*)securityStr);
     
     release_cstring(env, url, urlStr);
     release_cstring(env, mimeTypes, mimeTypesStr);
+    release_cstring(env, security, securityStr);
     
     return handle;
 }
 
+JNIEXPORT void JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_writeSample(JNIEnv* env, jobject obj, jlong handle, jint streamIndex, jbyteArray data, jlong pts, jint flags) {
+    jbyte* bytes = jbyteArray_to_bytes(env, data);
+    jsize length = (*env)->GetArrayLength(env, data);
+    
+    GoSRTSinkWriteSample(handle, streamIndex, bytes, length, pts, flags);
+    
+    release_bytes(env, data, bytes);
+}
+
 JNIEXPORT void JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_writeH264(JNIEnv* env, jobject obj, jlong handle, jbyteArray data, jlong pts) {
     jbyte* bytes = jbyteArray_to_bytes(env, data);
@@ -100,6 +113,22 @@ Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_getBandwidth(JNIEnv* env, jobj
     return GoSRTSinkGetBandwidth(handle);
 }
 
+JNIEXPORT jboolean JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_setPassphrase(JNIEnv* env, jobject obj, jlong handle, jstring passphrase) {
+    const char* passphraseStr = jstring_to_cstring(env, passphrase);
+    jboolean ok = GoSRTSinkSetPassphrase(handle, (char*)passphraseStr) != 0;
+    release_cstring(env, passphrase, passphraseStr);
+    return ok;
+}
+
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_getStats(JNIEnv* env, jobject obj, jlong handle) {
+    char* stats = GoSRTSinkGetStats(handle);
+    jstring result = (*env)->NewStringUTF(env, stats);
+    free(stats);  // Free the C string allocated by Go
+    return result;
+}
+
 JNIEXPORT void JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_setPLICallback(JNIEnv* env, jobject obj, jlong handle, jobject callback) {
     if (handle >= 100) return;
@@ -117,12 +146,16 @@ Java_com_kevmo314_kineticstreamer_kinetic_SRTSink_setPLICallback(JNIEnv* env, jo
 // ---- RIST sink JNI bridge ---------------------------------------------------
 
 JNIEXPORT jlong JNICALL
-Java_com_kevmo314_kineticstreamer_kinetic_RISTSink_create(JNIEnv* env, jclass clazz, jstring url, jstring mimeTypes) {
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSink_create(JNIEnv* env, jclass clazz, jstring url, jstring mimeTypes, jstring peers, jstring security) {
     const char* urlStr = (*env)->GetStringUTFChars(env, url, NULL);
     const char* mimeTypesStr = (*env)->GetStringUTFChars(env, mimeTypes, NULL);
-    jlong handle = GoCreateRISTSink((char*)urlStr, (char*)mimeTypesStr);
+    const char* peersStr = jstring_to_cstring(env, peers);
+    const char* securityStr = jstring_to_cstring(env, security);
+    jlong handle = GoCreateRISTSink((char*)urlStr, (char*)mimeTypesStr, (char*)peersStr, (char*)securityStr);
     (*env)->ReleaseStringUTFChars(env, url, urlStr);
     (*env)->ReleaseStringUTFChars(env, mimeTypes, mimeTypesStr);
+    release_cstring(env, peers, peersStr);
+    release_cstring(env, security, securityStr);
     return handle;
 }
 
@@ -150,11 +183,106 @@ Java_com_kevmo314_kineticstreamer_kinetic_RISTSink_writeOpus(JNIEnv* env, jobjec
     release_bytes(env, data, bytes);
 }
 
+JNIEXPORT jlong JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSink_getBandwidth(JNIEnv* env, jobject obj, jlong handle) {
+    return GoRISTSinkGetBandwidth(handle);
+}
+
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSink_getStats(JNIEnv* env, jobject obj, jlong handle) {
+    char* stats = GoRISTSinkGetStats(handle);
+    jstring result = (*env)->NewStringUTF(env, stats);
+    free(stats);  // Free the C string allocated by Go
+    return result;
+}
+
 JNIEXPORT void JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_RISTSink_close(JNIEnv* env, jobject obj, jlong handle) {
     GoRISTSinkClose(handle);
 }
 
+// ---- RIST source JNI bridge -------------------------------------------------
+
+JNIEXPORT jlong JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSource_nativeCreate(JNIEnv* env, jclass clazz, jstring url, jstring security) {
+    const char* urlStr = jstring_to_cstring(env, url);
+    const char* securityStr = jstring_to_cstring(env, security);
+    jlong handle = GoCreateRISTSource((char*)urlStr, (char*)securityStr);
+    release_cstring(env, url, urlStr);
+    release_cstring(env, security, securityStr);
+    return handle;
+}
+
+JNIEXPORT jbyteArray JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSource_nativeReadVideoFrame(JNIEnv* env, jobject obj, jlong handle, jlongArray ptsOut) {
+    void* dataPtr = NULL;
+    int32_t size = 0;
+    GoInt64 pts = 0;
+
+    int32_t success = GoRISTSourceReadVideoFrame(handle, &dataPtr, &size, &pts);
+    if (success == 0 || dataPtr == NULL || size == 0) {
+        return NULL;
+    }
+
+    jbyteArray result = (*env)->NewByteArray(env, size);
+    if (result != NULL) {
+        (*env)->SetByteArrayRegion(env, result, 0, size, (jbyte*)dataPtr);
+        jlong ptsValue = pts;
+        (*env)->SetLongArrayRegion(env, ptsOut, 0, 1, &ptsValue);
+    }
+
+    free(dataPtr);
+    return result;
+}
+
+JNIEXPORT jbyteArray JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSource_nativeReadAudioFrame(JNIEnv* env, jobject obj, jlong handle, jlongArray ptsOut) {
+    void* dataPtr = NULL;
+    int32_t size = 0;
+    GoInt64 pts = 0;
+
+    int32_t success = GoRISTSourceReadAudioFrame(handle, &dataPtr, &size, &pts);
+    if (success == 0 || dataPtr == NULL || size == 0) {
+        return NULL;
+    }
+
+    jbyteArray result = (*env)->NewByteArray(env, size);
+    if (result != NULL) {
+        (*env)->SetByteArrayRegion(env, result, 0, size, (jbyte*)dataPtr);
+        jlong ptsValue = pts;
+        (*env)->SetLongArrayRegion(env, ptsOut, 0, 1, &ptsValue);
+    }
+
+    free(dataPtr);
+    return result;
+}
+
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSource_nativeGetVideoCodec(JNIEnv* env, jobject obj, jlong handle) {
+    char* codec = GoRISTSourceGetVideoCodec(handle);
+    jstring result = (*env)->NewStringUTF(env, codec);
+    free(codec);  // Free the C string allocated by Go
+    return result;
+}
+
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSource_nativeGetAudioCodec(JNIEnv* env, jobject obj, jlong handle) {
+    char* codec = GoRISTSourceGetAudioCodec(handle);
+    jstring result = (*env)->NewStringUTF(env, codec);
+    free(codec);  // Free the C string allocated by Go
+    return result;
+}
+
+JNIEXPORT jint JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSource_nativeIsClosed(JNIEnv* env, jobject obj, jlong handle) {
+    return GoRISTSourceIsClosed(handle);
+}
+
+JNIEXPORT void JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RISTSource_nativeClose(JNIEnv* env, jobject obj, jlong handle) {
+    GoRISTSourceClose(handle);
+}
+
 // Called from Go when SRT detects packet loss
 void GoSRTOnPLI(int64_t handle) {
     if (g_jvm == NULL || handle >= 100 || g_srtPLICallbacks[handle] == NULL) return;
@@ -225,20 +353,34 @@ Java_com_kevmo314_kineticstreamer_kinetic_UVCStream_close(JNIEnv* env, jobject o
 }
 
 JNIEXPORT jlong JNICALL
-Java_com_kevmo314_kineticstreamer_kinetic_WHIPSink_create(JNIEnv* env, jclass clazz, jstring url, jstring token, jstring mimeTypes) {
+Java_com_kevmo314_kineticstreamer_kinetic_WHIPSink_create(JNIEnv* env, jclass clazz, jstring url, jstring token, jstring mimeTypes, jstring options) {
     const char* urlStr = jstring_to_cstring(env, url);
     const char* tokenStr = jstring_to_cstring(env, token);
     const char* mimeTypesStr = jstring_to_cstring(env, mimeTypes);
+    const char* optionsStr = jstring_to_cstring(env, options);
     
-    jlong handle = GoCreateWHIPSink((char*)urlStr, (char*)tokenStr, (char*)mimeTypesStr);
+    jlong handle = GoCreateWHIPSink((char*)urlStr, (char*)tokenStr, (char*)mimeTypesStr, (char*)optionsStr);
     
     release_cstring(env, url, urlStr);
     release_cstring(env, token, tokenStr);
     release_cstring(env, mimeTypes, mimeTypesStr);
+    release_cstring(env, options, optionsStr);
     
     return handle;
 }
 
+JNIEXPORT jint JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_WHIPSink_writeSample(JNIEnv* env, jobject obj, jlong handle, jint streamIndex, jbyteArray data, jlong pts, jint flags) {
+    jbyte* bytes = jbyteArray_to_bytes(env, data);
+    jsize length = (*env)->GetArrayLength(env, data);
+    
+    jint bitrate = GoWHIPSinkWriteSample(handle, streamIndex, bytes, length, pts, flags);
+    
+    release_bytes(env, data, bytes);
+    
+    return bitrate;
+}
+
 JNIEXPORT jint JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_WHIPSink_writeH264(JNIEnv* env, jobject obj, jlong handle, jbyteArray data, jlong pts) {
     jbyte* bytes = jbyteArray_to_bytes(env, data);
@@ -318,6 +460,63 @@ Java_com_kevmo314_kineticstreamer_kinetic_WHIPSink_getPeerConnectionState(JNIEnv
 // RTMP Server JNI wrappers (64-bit platforms only)
 #if defined(__aarch64__) || defined(__x86_64__)
 
+// Global references for RTMP authorizer callbacks, by server handle. Each
+// holds a reference while installed and one per callback in progress, so
+// replacing or removing it doesn't free it from under GoRTMPOnAuthorize.
+typedef struct rtmp_authorizer {
+    int64_t handle;
+    jobject callback;
+    int refs;
+    struct rtmp_authorizer* next;
+} rtmp_authorizer;
+
+static rtmp_authorizer* g_rtmpAuthorizers = NULL;
+static pthread_mutex_t g_rtmpAuthorizersMu = PTHREAD_MUTEX_INITIALIZER;
+
+// Must be called with g_rtmpAuthorizersMu held. Returns the authorizer, which
+// the caller then owns the installed reference of.
+static rtmp_authorizer* rtmp_authorizer_unlink_locked(int64_t handle) {
+    for (rtmp_authorizer** p = &g_rtmpAuthorizers; *p != NULL; p = &(*p)->next) {
+        if ((*p)->handle == handle) {
+            rtmp_authorizer* a = *p;
+            *p = a->next;
+            return a;
+        }
+    }
+    return NULL;
+}
+
+static rtmp_authorizer* rtmp_authorizer_acquire(int64_t handle) {
+    pthread_mutex_lock(&g_rtmpAuthorizersMu);
+    rtmp_authorizer* a = g_rtmpAuthorizers;
+    while (a != NULL && a->handle != handle) {
+        a = a->next;
+    }
+    if (a != NULL) {
+        a->refs++;
+    }
+    pthread_mutex_unlock(&g_rtmpAuthorizersMu);
+    return a;
+}
+
+static void rtmp_authorizer_release(JNIEnv* env, rtmp_authorizer* a) {
+    if (a == NULL) return;
+    pthread_mutex_lock(&g_rtmpAuthorizersMu);
+    int last = --a->refs == 0;
+    pthread_mutex_unlock(&g_rtmpAuthorizersMu);
+    if (last) {
+        (*env)->DeleteGlobalRef(env, a->callback);
+        free(a);
+    }
+}
+
+static void rtmp_authorizer_remove(JNIEnv* env, int64_t handle) {
+    pthread_mutex_lock(&g_rtmpAuthorizersMu);
+    rtmp_authorizer* a = rtmp_authorizer_unlink_locked(handle);
+    pthread_mutex_unlock(&g_rtmpAuthorizersMu);
+    rtmp_authorizer_release(env, a);
+}
+
 JNIEXPORT jlong JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeCreate(JNIEnv* env, jobject obj, jint port) {
     return GoCreateRTMPServer(port);
@@ -331,6 +530,9 @@ Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeStart(JNIEnv* env, jo
 JNIEXPORT void JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeStop(JNIEnv* env, jobject obj, jlong handle) {
     GoRTMPServerStop(handle);
+
+    // Clean up authorizer callback if exists, once no callback uses it
+    rtmp_authorizer_remove(env, handle);
 }
 
 JNIEXPORT jint JNICALL
@@ -339,13 +541,97 @@ Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeGetPort(JNIEnv* env,
 }
 
 JNIEXPORT jlong JNICALL
-Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeGetSource(JNIEnv* env, jobject obj, jlong handle) {
-    return GoRTMPServerGetSource(handle);
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeGetSource(JNIEnv* env, jobject obj, jlong handle, jstring key) {
+    const char* keyStr = jstring_to_cstring(env, key);
+    jlong result = GoRTMPServerGetSource(handle, (char*)keyStr);
+    release_cstring(env, key, keyStr);
+    return result;
 }
 
 JNIEXPORT jlong JNICALL
-Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeWaitForSource(JNIEnv* env, jobject obj, jlong handle, jint timeoutMs) {
-    return GoRTMPServerWaitForSource(handle, timeoutMs);
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeWaitForSource(JNIEnv* env, jobject obj, jlong handle, jstring key, jint timeoutMs) {
+    const char* keyStr = jstring_to_cstring(env, key);
+    jlong result = GoRTMPServerWaitForSource(handle, (char*)keyStr, timeoutMs);
+    release_cstring(env, key, keyStr);
+    return result;
+}
+
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeListSources(JNIEnv* env, jobject obj, jlong handle) {
+    char* keys = GoRTMPServerListSources(handle);
+    jstring result = (*env)->NewStringUTF(env, keys);
+    free(keys);  // Free the C string allocated by Go
+    return result;
+}
+
+JNIEXPORT void JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeSetAuthorizer(JNIEnv* env, jobject obj, jlong handle, jobject authorizer) {
+    rtmp_authorizer* a = malloc(sizeof(rtmp_authorizer));
+    if (a == NULL) {
+        (*env)->ThrowNew(env, (*env)->FindClass(env, "java/lang/OutOfMemoryError"), "RTMP authorizer");
+        return;
+    }
+    a->handle = handle;
+    a->callback = (*env)->NewGlobalRef(env, authorizer);
+    a->refs = 1;
+
+    // Store global reference to callback, replacing any previous one
+    pthread_mutex_lock(&g_rtmpAuthorizersMu);
+    rtmp_authorizer* old = rtmp_authorizer_unlink_locked(handle);
+    a->next = g_rtmpAuthorizers;
+    g_rtmpAuthorizers = a;
+    pthread_mutex_unlock(&g_rtmpAuthorizersMu);
+    rtmp_authorizer_release(env, old);
+
+    // Register with Go
+    if (GoRTMPServerSetAuthorizer(handle) == 0) {
+        rtmp_authorizer_remove(env, handle);
+        (*env)->ThrowNew(env, (*env)->FindClass(env, "java/lang/IllegalStateException"),
+                         "Failed to set RTMP authorizer: no such server");
+    }
+}
+
+// Called from Go on the connection's goroutine. Publishers are rejected if
+// the callback is missing or throws.
+int GoRTMPOnAuthorize(int64_t handle, char* app, char* streamKey) {
+    if (g_jvm == NULL) return 0;
+
+    JNIEnv* env;
+    int attached = 0;
+    if ((*g_jvm)->GetEnv(g_jvm, (void**)&env, JNI_VERSION_1_6) != JNI_OK) {
+        if ((*g_jvm)->AttachCurrentThread(g_jvm, &env, NULL) != JNI_OK) return 0;
+        attached = 1;
+    }
+
+    rtmp_authorizer* a = rtmp_authorizer_acquire(handle);
+    if (a == NULL) {
+        if (attached) {
+            (*g_jvm)->DetachCurrentThread(g_jvm);
+        }
+        return 0;
+    }
+
+    int allowed = 0;
+    jclass cls = (*env)->GetObjectClass(env, a->callback);
+    jmethodID method = (*env)->GetMethodID(env, cls, "authorize", "(Ljava/lang/String;Ljava/lang/String;)Z");
+    if (method != NULL) {
+        jstring jApp = (*env)->NewStringUTF(env, app);
+        jstring jStreamKey = (*env)->NewStringUTF(env, streamKey);
+        allowed = (*env)->CallBooleanMethod(env, a->callback, method, jApp, jStreamKey);
+        if ((*env)->ExceptionCheck(env)) {
+            (*env)->ExceptionClear(env);
+            allowed = 0;
+        }
+        (*env)->DeleteLocalRef(env, jApp);
+        (*env)->DeleteLocalRef(env, jStreamKey);
+    }
+    (*env)->DeleteLocalRef(env, cls);
+    rtmp_authorizer_release(env, a);
+
+    if (attached) {
+        (*g_jvm)->DetachCurrentThread(g_jvm);
+    }
+    return allowed;
 }
 
 // RTMP Source JNI wrappers
@@ -398,6 +684,22 @@ Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeGetAudioPTS(JNIEnv* e
     return GoRTMPSourceGetAudioPTS(handle);
 }
 
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeGetVideoCodec(JNIEnv* env, jobject obj, jlong handle) {
+    char* codec = GoRTMPSourceGetVideoCodec(handle);
+    jstring result = (*env)->NewStringUTF(env, codec);
+    free(codec);  // Free the C string allocated by Go
+    return result;
+}
+
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeGetAudioCodec(JNIEnv* env, jobject obj, jlong handle) {
+    char* codec = GoRTMPSourceGetAudioCodec(handle);
+    jstring result = (*env)->NewStringUTF(env, codec);
+    free(codec);  // Free the C string allocated by Go
+    return result;
+}
+
 JNIEXPORT jint JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeIsClosed(JNIEnv* env, jobject obj, jlong handle) {
     return GoRTMPSourceIsClosed(handle);
@@ -408,6 +710,31 @@ Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeClose(JNIEnv* env, jo
     GoRTMPSourceClose(handle);
 }
 
+// RTMP Sink JNI wrappers
+
+JNIEXPORT jlong JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSink_nativeCreate(JNIEnv* env, jobject obj, jstring url, jstring mimeTypes) {
+    const char* urlStr = jstring_to_cstring(env, url);
+    const char* mimeTypesStr = jstring_to_cstring(env, mimeTypes);
+    jlong handle = GoCreateRTMPSink((char*)urlStr, (char*)mimeTypesStr);
+    release_cstring(env, url, urlStr);
+    release_cstring(env, mimeTypes, mimeTypesStr);
+    return handle;
+}
+
+JNIEXPORT void JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSink_nativeWriteSample(JNIEnv* env, jobject obj, jlong handle, jint streamIndex, jbyteArray data, jlong pts, jint flags) {
+    jbyte* bytes = jbyteArray_to_bytes(env, data);
+    jsize length = (*env)->GetArrayLength(env, data);
+    GoRTMPSinkWriteSample(handle, streamIndex, bytes, length, pts, flags);
+    release_bytes(env, data, bytes);
+}
+
+JNIEXPORT void JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSink_nativeClose(JNIEnv* env, jobject obj, jlong handle) {
+    GoRTMPSinkClose(handle);
+}
+
 #else
 // Stub implementations for 32-bit platforms
 
@@ -430,15 +757,23 @@ Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeGetPort(JNIEnv* env,
 }
 
 JNIEXPORT jlong JNICALL
-Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeGetSource(JNIEnv* env, jobject obj, jlong handle) {
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeGetSource(JNIEnv* env, jobject obj, jlong handle, jstring key) {
     return 0;
 }
 
 JNIEXPORT jlong JNICALL
-Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeWaitForSource(JNIEnv* env, jobject obj, jlong handle, jint timeoutMs) {
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeWaitForSource(JNIEnv* env, jobject obj, jlong handle, jstring key, jint timeoutMs) {
     return 0;
 }
 
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeListSources(JNIEnv* env, jobject obj, jlong handle) {
+    return (*env)->NewStringUTF(env, "");
+}
+
+JNIEXPORT void JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPServer_nativeSetAuthorizer(JNIEnv* env, jobject obj, jlong handle, jobject authorizer) {}
+
 JNIEXPORT jbyteArray JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeReadVideoFrame(JNIEnv* env, jobject obj, jlong handle) {
     return NULL;
@@ -459,6 +794,16 @@ Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeGetAudioPTS(JNIEnv* e
     return 0;
 }
 
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeGetVideoCodec(JNIEnv* env, jobject obj, jlong handle) {
+    return (*env)->NewStringUTF(env, "");
+}
+
+JNIEXPORT jstring JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeGetAudioCodec(JNIEnv* env, jobject obj, jlong handle) {
+    return (*env)->NewStringUTF(env, "");
+}
+
 JNIEXPORT jint JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeIsClosed(JNIEnv* env, jobject obj, jlong handle) {
     return 1; // Always closed on 32-bit
@@ -467,4 +812,15 @@ Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeIsClosed(JNIEnv* env,
 JNIEXPORT void JNICALL
 Java_com_kevmo314_kineticstreamer_kinetic_RTMPSource_nativeClose(JNIEnv* env, jobject obj, jlong handle) {}
 
+JNIEXPORT jlong JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSink_nativeCreate(JNIEnv* env, jobject obj, jstring url, jstring mimeTypes) {
+    return 0; // RTMP not supported on 32-bit
+}
+
+JNIEXPORT void JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSink_nativeWriteSample(JNIEnv* env, jobject obj, jlong handle, jint streamIndex, jbyteArray data, jlong pts, jint flags) {}
+
+JNIEXPORT void JNICALL
+Java_com_kevmo314_kineticstreamer_kinetic_RTMPSink_nativeClose(JNIEnv* env, jobject obj, jlong handle) {}
+
 #endif // __aarch64__ || __x86_64__
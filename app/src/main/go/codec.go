@@ -0,0 +1,298 @@
+package kinetic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/av1"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+)
+
+// splitNALUs splits an Annex B byte stream into individual NAL units.
+// It handles both 3-byte (0x00 0x00 0x01) and 4-byte (0x00 0x00 0x00 0x01) start codes.
+func splitNALUs(buf []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+
+	for i := 0; i < len(buf); i++ {
+		// Check for 4-byte start code
+		if i+3 < len(buf) && buf[i] == 0x00 && buf[i+1] == 0x00 && buf[i+2] == 0x00 && buf[i+3] == 0x01 {
+			if start >= 0 {
+				nalus = append(nalus, buf[start:i])
+			}
+			start = i + 4
+			i += 3
+			continue
+		}
+		// Check for 3-byte start code
+		if i+2 < len(buf) && buf[i] == 0x00 && buf[i+1] == 0x00 && buf[i+2] == 0x01 {
+			if start >= 0 {
+				nalus = append(nalus, buf[start:i])
+			}
+			start = i + 3
+			i += 2
+			continue
+		}
+	}
+
+	// Append the last NAL unit
+	if start >= 0 && start < len(buf) {
+		nalus = append(nalus, buf[start:])
+	}
+
+	return nalus
+}
+
+// isNonReferenceFrame reports whether an Annex B access unit has only
+// slices no other frame is predicted from, so it can be dropped without
+// breaking decoding: nal_ref_idc 0 in H.264, or a sub-layer non-reference
+// picture in H.265.
+func isNonReferenceFrame(buf []byte, hevc bool) bool {
+	slices := 0
+	for _, nalu := range splitNALUs(buf) {
+		if len(nalu) == 0 {
+			continue
+		}
+		if hevc {
+			// VCL types below 16 are non-reference when even, e.g. TRAIL_N.
+			typ := (nalu[0] >> 1) & 0x3F
+			if typ > 31 {
+				continue
+			}
+			if typ > 14 || typ%2 != 0 {
+				return false
+			}
+		} else {
+			typ := nalu[0] & 0x1F
+			if typ != 1 && typ != 5 {
+				continue
+			}
+			if nalu[0]&0x60 != 0 {
+				return false
+			}
+		}
+		slices++
+	}
+	return slices > 0
+}
+
+// isRandomAccessFrame reports whether an Annex B access unit has a slice
+// decoding can start from: an IDR slice in H.264, or an IRAP picture (BLA,
+// IDR or CRA) in H.265. It finds keyframes for callers that don't pass
+// MediaCodec's flags.
+func isRandomAccessFrame(buf []byte, hevc bool) bool {
+	for _, nalu := range splitNALUs(buf) {
+		if len(nalu) == 0 {
+			continue
+		}
+		if hevc {
+			if typ := (nalu[0] >> 1) & 0x3F; typ >= 16 && typ <= 21 {
+				return true
+			}
+		} else if nalu[0]&0x1F == 5 {
+			return true
+		}
+	}
+	return false
+}
+
+// buildAVCDecoderConfigurationRecord builds an avcC box body (ISO/IEC
+// 14496-15 5.3.3.1) with one SPS, one PPS and 4-byte NALU lengths.
+func buildAVCDecoderConfigurationRecord(sps, pps []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0x01)   // configurationVersion
+	b.WriteByte(sps[1]) // AVCProfileIndication
+	b.WriteByte(sps[2]) // profile_compatibility
+	b.WriteByte(sps[3]) // AVCLevelIndication
+	b.WriteByte(0xFF)   // lengthSizeMinusOne = 3
+	b.WriteByte(0xE1)   // numOfSequenceParameterSets = 1
+	binary.Write(&b, binary.BigEndian, uint16(len(sps)))
+	b.Write(sps)
+	b.WriteByte(0x01) // numOfPictureParameterSets
+	binary.Write(&b, binary.BigEndian, uint16(len(pps)))
+	b.Write(pps)
+	return b.Bytes()
+}
+
+// buildHEVCDecoderConfigurationRecord builds an hvcC box body (ISO/IEC
+// 14496-15 8.3.3.1) with one VPS, SPS and PPS and 4-byte NALU lengths.
+func buildHEVCDecoderConfigurationRecord(vps, sps, pps []byte) ([]byte, error) {
+	var s h265.SPS
+	if err := s.Unmarshal(sps); err != nil {
+		return nil, fmt.Errorf("failed to parse H.265 SPS: %w", err)
+	}
+
+	// general_profile_space through general_level_idc are the 12 bytes
+	// following the NAL header and the vps_id/max_sub_layers byte, and hvcC
+	// stores them verbatim.
+	rbsp := h264.EmulationPreventionRemove(sps)
+	if len(rbsp) < 15 {
+		return nil, fmt.Errorf("H.265 SPS too short")
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(0x01) // configurationVersion
+	b.Write(rbsp[3:15])
+	b.Write([]byte{0xF0, 0x00})                           // min_spatial_segmentation_idc = 0
+	b.WriteByte(0xFC)                                     // parallelismType = 0
+	b.WriteByte(0xFC | byte(s.ChromaFormatIdc&0x03))      // chromaFormat
+	b.WriteByte(0xF8 | byte(s.BitDepthLumaMinus8&0x07))   // bitDepthLumaMinus8
+	b.WriteByte(0xF8 | byte(s.BitDepthChromaMinus8&0x07)) // bitDepthChromaMinus8
+	b.Write([]byte{0x00, 0x00})                           // avgFrameRate = 0
+	var nesting byte
+	if s.TemporalIDNestingFlag {
+		nesting = 1
+	}
+	// constantFrameRate = 0, numTemporalLayers, temporalIdNested,
+	// lengthSizeMinusOne = 3
+	b.WriteByte((s.MaxSubLayersMinus1+1)<<3 | nesting<<2 | 0x03)
+	b.WriteByte(3) // numOfArrays
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		b.WriteByte(0x80 | (nalu[0]>>1)&0x3F) // array_completeness = 1, NAL_unit_type
+		binary.Write(&b, binary.BigEndian, uint16(1))
+		binary.Write(&b, binary.BigEndian, uint16(len(nalu)))
+		b.Write(nalu)
+	}
+	return b.Bytes(), nil
+}
+
+// parseHEVCDecoderConfigurationRecord returns every parameter set NALU in
+// an hvcC box body.
+func parseHEVCDecoderConfigurationRecord(data []byte) ([][]byte, error) {
+	if len(data) < 23 {
+		return nil, fmt.Errorf("hvcC too short")
+	}
+
+	var nalus [][]byte
+	numArrays := int(data[22])
+	offset := 23
+	for i := 0; i < numArrays; i++ {
+		if offset+3 > len(data) {
+			return nil, fmt.Errorf("hvcC truncated")
+		}
+		numNalus := int(binary.BigEndian.Uint16(data[offset+1:]))
+		offset += 3
+		for j := 0; j < numNalus; j++ {
+			if offset+2 > len(data) {
+				return nil, fmt.Errorf("hvcC truncated")
+			}
+			naluLen := int(binary.BigEndian.Uint16(data[offset:]))
+			offset += 2
+			if offset+naluLen > len(data) {
+				return nil, fmt.Errorf("hvcC truncated")
+			}
+			nalus = append(nalus, data[offset:offset+naluLen])
+			offset += naluLen
+		}
+	}
+	return nalus, nil
+}
+
+// buildAV1CodecConfigurationRecord builds an av1C box body (AV1-ISOBMFF
+// 2.3.3) whose configOBUs hold the given sequence header OBU.
+func buildAV1CodecConfigurationRecord(sequenceHeader []byte) ([]byte, error) {
+	var h av1.SequenceHeader
+	if err := h.Unmarshal(sequenceHeader); err != nil {
+		return nil, fmt.Errorf("failed to parse AV1 sequence header: %w", err)
+	}
+
+	// configOBUs must use the low-overhead format, i.e. carry a size field.
+	configOBUs, err := av1.BitstreamMarshal([][]byte{sequenceHeader})
+	if err != nil {
+		return nil, err
+	}
+
+	var level, tier byte
+	if len(h.SeqLevelIdx) > 0 {
+		level = h.SeqLevelIdx[0]
+	}
+	if len(h.SeqTier) > 0 && h.SeqTier[0] {
+		tier = 1
+	}
+	flag := func(v bool) byte {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	cc := h.ColorConfig
+
+	record := []byte{
+		0x81, // marker = 1, version = 1
+		h.SeqProfile<<5 | level&0x1F,
+		tier<<7 | flag(cc.HighBitDepth)<<6 | flag(cc.TwelveBit)<<5 | flag(cc.MonoChrome)<<4 |
+			flag(cc.SubsamplingX)<<3 | flag(cc.SubsamplingY)<<2 | byte(cc.ChromaSamplePosition)&0x03,
+		0x00, // initial_presentation_delay_present = 0
+	}
+	return append(record, configOBUs...), nil
+}
+
+// parseAV1CodecConfigurationRecord returns the configOBUs of an av1C box body.
+func parseAV1CodecConfigurationRecord(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0x81 {
+		return nil, fmt.Errorf("invalid av1C")
+	}
+	return data[4:], nil
+}
+
+// findAV1SequenceHeader returns the sequence header OBU in a low-overhead
+// format temporal unit, or nil if there isn't one.
+func findAV1SequenceHeader(tu []byte) []byte {
+	obus, err := av1.BitstreamUnmarshal(tu, false)
+	if err != nil {
+		return nil
+	}
+	for _, obu := range obus {
+		if av1.OBUType((obu[0]>>3)&0x0F) == av1.OBUTypeSequenceHeader {
+			return obu
+		}
+	}
+	return nil
+}
+
+// buildOpusHead builds an RFC 7845 5.1 identification header for channel
+// mapping family 0.
+func buildOpusHead(channels int, preSkip uint16) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // version
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:], preSkip)
+	binary.LittleEndian.PutUint32(head[12:], 48000)
+	// output gain and mapping family are zero
+	return head
+}
+
+// parseOpusCodecConfig extracts the OpusHead from an Android Opus encoder's
+// codec config buffer, which wraps it in an "AOPUSHDR" marker followed by a
+// little-endian 64-bit length. Older encoders emit a bare OpusHead.
+func parseOpusCodecConfig(buf []byte) []byte {
+	if bytes.HasPrefix(buf, []byte("OpusHead")) {
+		return buf
+	}
+	if bytes.HasPrefix(buf, []byte("AOPUSHDR")) && len(buf) >= 16 {
+		n := binary.LittleEndian.Uint64(buf[8:16])
+		if n <= uint64(len(buf)-16) {
+			return buf[16 : 16+n]
+		}
+	}
+	return nil
+}
+
+// stripAV1TemporalDelimiters removes temporal delimiter OBUs, which
+// ISO-BMFF style samples must not contain.
+func stripAV1TemporalDelimiters(tu []byte) []byte {
+	obus, err := av1.BitstreamUnmarshal(tu, false)
+	if err != nil {
+		return tu
+	}
+	var out []byte
+	for _, obu := range obus {
+		if (obu[0]>>3)&0x0F != 2 { // OBU_TEMPORAL_DELIMITER
+			out = append(out, obu...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package kinetic
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A 1920x1080 Main profile SPS, with placeholder VPS/PPS (only their NAL
+// headers are inspected).
+var (
+	testHEVCVPS = []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff}
+	testHEVCSPS = []byte{
+		0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+		0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+		0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+		0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+		0xe0, 0x80,
+	}
+	testHEVCPPS = []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+
+	// A sequence header OBU without a size field.
+	testAV1SequenceHeader = []byte{8, 0, 0, 0, 66, 167, 191, 228, 96, 13, 0, 64}
+)
+
+func TestHEVCDecoderConfigurationRecord(t *testing.T) {
+	record, err := buildHEVCDecoderConfigurationRecord(testHEVCVPS, testHEVCSPS, testHEVCPPS)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+
+	if record[0] != 1 {
+		t.Errorf("configurationVersion = %d, want 1", record[0])
+	}
+	if profile := record[1] & 0x1F; profile != 1 {
+		t.Errorf("general_profile_idc = %d, want 1", profile)
+	}
+	// general_level_idc 120 = level 4
+	if record[12] != 120 {
+		t.Errorf("general_level_idc = %d, want 120", record[12])
+	}
+	if chroma := record[16] & 0x03; chroma != 1 {
+		t.Errorf("chromaFormat = %d, want 1", chroma)
+	}
+	if lengthSize := record[21]&0x03 + 1; lengthSize != 4 {
+		t.Errorf("NALU length size = %d, want 4", lengthSize)
+	}
+
+	nalus, err := parseHEVCDecoderConfigurationRecord(record)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := [][]byte{testHEVCVPS, testHEVCSPS, testHEVCPPS}
+	if len(nalus) != len(want) {
+		t.Fatalf("got %d NALUs, want %d", len(nalus), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(nalus[i], want[i]) {
+			t.Errorf("NALU %d = %x, want %x", i, nalus[i], want[i])
+		}
+	}
+}
+
+func TestAV1CodecConfigurationRecord(t *testing.T) {
+	record, err := buildAV1CodecConfigurationRecord(testAV1SequenceHeader)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if record[0] != 0x81 {
+		t.Errorf("marker/version = %#x, want 0x81", record[0])
+	}
+	if level := record[1] & 0x1F; level != 8 {
+		t.Errorf("seq_level_idx_0 = %d, want 8", level)
+	}
+
+	configOBUs, err := parseAV1CodecConfigurationRecord(record)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	// configOBUs must carry a size field
+	if configOBUs[0]&0x02 == 0 {
+		t.Errorf("configOBUs missing obu_has_size_field")
+	}
+	if findAV1SequenceHeader(configOBUs) == nil {
+		t.Errorf("sequence header not found in configOBUs %x", configOBUs)
+	}
+}
+
+func TestStripAV1TemporalDelimiters(t *testing.T) {
+	td := []byte{0x12, 0x00}
+	frame := []byte{0x32, 0x03, 0xaa, 0xbb, 0xcc} // OBU_FRAME, size 3
+
+	got := stripAV1TemporalDelimiters(append(append([]byte(nil), td...), frame...))
+	if !bytes.Equal(got, frame) {
+		t.Errorf("got %x, want %x", got, frame)
+	}
+}
+
+func TestParseOpusCodecConfig(t *testing.T) {
+	head := buildOpusHead(2, 312)
+	if len(head) != 19 || string(head[:8]) != "OpusHead" || head[9] != 2 {
+		t.Fatalf("unexpected OpusHead %x", head)
+	}
+
+	if got := parseOpusCodecConfig(head); !bytes.Equal(got, head) {
+		t.Errorf("bare OpusHead: got %x", got)
+	}
+
+	// Android wraps each header in a marker and a 64-bit length.
+	wrapped := []byte("AOPUSHDR")
+	wrapped = append(wrapped, byte(len(head)), 0, 0, 0, 0, 0, 0, 0)
+	wrapped = append(wrapped, head...)
+	wrapped = append(wrapped, []byte("AOPUSDLY")...)
+	wrapped = append(wrapped, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	if got := parseOpusCodecConfig(wrapped); !bytes.Equal(got, head) {
+		t.Errorf("wrapped OpusHead: got %x", got)
+	}
+
+	if got := parseOpusCodecConfig([]byte{1, 2, 3}); got != nil {
+		t.Errorf("garbage: got %x", got)
+	}
+}
+
+func TestIsNonReferenceFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		buf  []byte
+		hevc bool
+		want bool
+	}{
+		{"H.264 IDR", []byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x65, 0x88}, false, false},
+		{"H.264 reference P", []byte{0, 0, 0, 1, 0x41, 0x9a}, false, false},
+		{"H.264 non-reference B", []byte{0, 0, 0, 1, 0x06, 0x05, 0, 0, 1, 0x01, 0x9e}, false, true},
+		{"H.264 parameter sets only", []byte{0, 0, 0, 1, 0x67, 0x42}, false, false},
+		{"H.265 TRAIL_R", []byte{0, 0, 0, 1, 0x02, 0x01, 0xd0}, true, false},
+		{"H.265 TRAIL_N", []byte{0, 0, 0, 1, 0x00, 0x01, 0xd0}, true, true},
+		{"H.265 IDR", []byte{0, 0, 0, 1, 0x26, 0x01, 0xaf}, true, false},
+	} {
+		if got := isNonReferenceFrame(tc.buf, tc.hevc); got != tc.want {
+			t.Errorf("%s: isNonReferenceFrame = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIsRandomAccessFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		buf  []byte
+		hevc bool
+		want bool
+	}{
+		{"H.264 IDR", []byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x65, 0x88}, false, true},
+		{"H.264 reference P", []byte{0, 0, 0, 1, 0x41, 0x9a}, false, false},
+		{"H.264 parameter sets only", []byte{0, 0, 0, 1, 0x67, 0x42}, false, false},
+		{"H.265 TRAIL_R", []byte{0, 0, 0, 1, 0x02, 0x01, 0xd0}, true, false},
+		{"H.265 IDR", []byte{0, 0, 0, 1, 0x26, 0x01, 0xaf}, true, true},
+		{"H.265 CRA", []byte{0, 0, 0, 1, 0x2a, 0x01, 0xaf}, true, true},
+	} {
+		if got := isRandomAccessFrame(tc.buf, tc.hevc); got != tc.want {
+			t.Errorf("%s: isRandomAccessFrame = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
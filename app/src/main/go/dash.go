@@ -0,0 +1,323 @@
+package kinetic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDASHAddr = ":8081"
+
+// DASH requires a bandwidth for every representation but there's only one
+// per track, so players have nothing to choose between and these nominal
+// values only seed their throughput estimate.
+const (
+	dashVideoBandwidth = 2_500_000
+	dashAudioBandwidth = 128_000
+)
+
+// DASHServer serves the recording of an HLSSink as MPEG-DASH, for players
+// that don't speak HLS. The manifest is a dynamic MPD with a SegmentTimeline
+// built from the HLS sink's segment list, and each track is its own CMAF
+// adaptation set muxed on request from the same disk store, so a recording is
+// only stored once however many protocols serve it.
+//
+// The MPD lists the same window of segments as the HLS playlist and becomes
+// static once the HLS sink's stream ends.
+type DASHServer struct {
+	hls         *HLSSink
+	bearerToken string
+
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewDASHServer starts serving /manifest.mpd for hls on addr, or :8081 if
+// addr is empty. If bearerToken is set, requests must carry it in an
+// Authorization header.
+func NewDASHServer(hls *HLSSink, addr, bearerToken string) (*DASHServer, error) {
+	if addr == "" {
+		addr = defaultDASHAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("DASH: failed to listen on %s: %w", addr, err)
+	}
+	s := &DASHServer{hls: hls, bearerToken: bearerToken, listener: listener}
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("DASH: server stopped: %v", err)
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *DASHServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops serving. The HLS sink is left running.
+func (s *DASHServer) Close() error {
+	return s.server.Shutdown(context.Background())
+}
+
+func (s *DASHServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.bearerToken != "" && r.Header.Get("Authorization") != "Bearer "+s.bearerToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "manifest.mpd" {
+		s.serveManifest(w)
+		return
+	}
+
+	// Everything else is {track}/init.mp4 or {track}/segment{N}.m4s.
+	id, name, ok := strings.Cut(path, "/")
+	track, err := strconv.Atoi(id)
+	if !ok || err != nil || track < 0 || track >= len(s.hls.tracks) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch {
+	case name == "init.mp4":
+		s.serveInit(w, track)
+	case strings.HasPrefix(name, "segment") && strings.HasSuffix(name, ".m4s"):
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "segment"), ".m4s"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.serveSegment(w, track, sequence)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *DASHServer) serveManifest(w http.ResponseWriter) {
+	// Build the muxers first for the track parameters; they take the HLS
+	// sink's lock themselves.
+	muxers := make([]*fmp4Muxer, len(s.hls.tracks))
+	for i := range muxers {
+		muxer, err := s.newTrackMuxer(i)
+		if err != nil {
+			log.Printf("DASH: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		muxers[i] = muxer
+	}
+
+	h := s.hls
+	h.Lock()
+	defer h.Unlock()
+
+	segments := h.segments
+	if h.playlistType == HLSPlaylistTypeLive && len(segments) > h.windowSize {
+		segments = segments[len(segments)-h.windowSize:]
+	}
+	if len(segments) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	target := h.targetDurationLocked()
+	last := segments[len(segments)-1]
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011,urn:mpeg:dash:profile:cmaf:2019"`)
+	if h.ended {
+		end := time.Duration(last.pts-h.origin)*time.Microsecond + last.duration
+		fmt.Fprintf(&b, ` type="static" mediaPresentationDuration="%s"`, dashDuration(end))
+	} else {
+		fmt.Fprintf(&b, ` type="dynamic" availabilityStartTime="%s" publishTime="%s" minimumUpdatePeriod="%s" suggestedPresentationDelay="%s"`,
+			dashTime(h.availabilityStart), dashTime(time.Now()), dashDuration(target), dashDuration(3*target))
+		if h.playlistType == HLSPlaylistTypeLive {
+			fmt.Fprintf(&b, ` timeShiftBufferDepth="%s"`, dashDuration(time.Duration(h.windowSize)*target))
+		}
+	}
+	fmt.Fprintf(&b, ` minBufferTime="%s">`+"\n", dashDuration(target))
+	b.WriteString(`  <Period id="0" start="PT0S">` + "\n")
+
+	for i, muxer := range muxers {
+		t := muxer.tracks[0]
+		if t.isVideo() {
+			startWithSAP := ` startWithSAP="1"`
+			if h.cutBetweenKeyframes {
+				startWithSAP = ""
+			}
+			fmt.Fprintf(&b, `    <AdaptationSet id="%d" contentType="video" mimeType="video/mp4" segmentAlignment="true"%s>`+"\n", i, startWithSAP)
+			fmt.Fprintf(&b, `      <Representation id="%d" codecs="%s" bandwidth="%d" width="%d" height="%d">`+"\n",
+				i, t.codecString(), dashVideoBandwidth, t.width, t.height)
+		} else {
+			fmt.Fprintf(&b, `    <AdaptationSet id="%d" contentType="audio" mimeType="audio/mp4" segmentAlignment="true">`+"\n", i)
+			fmt.Fprintf(&b, `      <Representation id="%d" codecs="%s" bandwidth="%d" audioSamplingRate="%d">`+"\n",
+				i, t.codecString(), dashAudioBandwidth, t.timescale)
+		}
+		fmt.Fprintf(&b, `        <SegmentTemplate timescale="%d" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/segment$Number$.m4s" startNumber="%d">`+"\n",
+			t.timescale, segments[0].sequence)
+		b.WriteString("          <SegmentTimeline>\n")
+		writeDASHTimeline(&b, muxer, t, segments)
+		b.WriteString("          </SegmentTimeline>\n")
+		b.WriteString("        </SegmentTemplate>\n")
+		b.WriteString("      </Representation>\n")
+		b.WriteString("    </AdaptationSet>\n")
+	}
+	b.WriteString("  </Period>\n")
+	b.WriteString("</MPD>\n")
+
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b.Bytes())
+}
+
+// writeDASHTimeline writes the S elements for segments in t's timescale,
+// merging runs of equal durations with @r.
+func writeDASHTimeline(b *bytes.Buffer, muxer *fmp4Muxer, t *fmp4Track, segments []hlsSegment) {
+	type entry struct{ t, d int64 }
+	var entries []entry
+	for i, seg := range segments {
+		start := muxer.decodeTime(t, seg.pts)
+		// Durations run to the next segment's start, so rounding doesn't
+		// leave gaps in the timeline.
+		end := muxer.decodeTime(t, seg.pts+seg.duration.Microseconds())
+		if i+1 < len(segments) {
+			end = muxer.decodeTime(t, segments[i+1].pts)
+		}
+		entries = append(entries, entry{start, end - start})
+	}
+	for i := 0; i < len(entries); {
+		n := 1
+		for i+n < len(entries) && entries[i+n].d == entries[i].d {
+			n++
+		}
+		if n > 1 {
+			fmt.Fprintf(b, `            <S t="%d" d="%d" r="%d"/>`+"\n", entries[i].t, entries[i].d, n-1)
+		} else {
+			fmt.Fprintf(b, `            <S t="%d" d="%d"/>`+"\n", entries[i].t, entries[i].d)
+		}
+		i += n
+	}
+}
+
+func dashTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func dashDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
+
+func (s *DASHServer) serveInit(w http.ResponseWriter, track int) {
+	muxer, err := s.newTrackMuxer(track)
+	if err != nil {
+		log.Printf("DASH: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	init, err := muxer.initSegment()
+	if err != nil {
+		// No codec config yet.
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Write(init)
+}
+
+func (s *DASHServer) serveSegment(w http.ResponseWriter, track int, sequence uint64) {
+	h := s.hls
+	h.Lock()
+	i := slices.IndexFunc(h.segments, func(seg hlsSegment) bool { return seg.sequence == sequence })
+	var seg hlsSegment
+	if i >= 0 {
+		seg = h.segments[i]
+	}
+	h.Unlock()
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	samples, err := h.store.ReadSegment(seg.pts)
+	if err != nil {
+		log.Printf("DASH: failed to read segment %d: %v", sequence, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	muxer, err := s.newTrackMuxer(track)
+	if err != nil {
+		log.Printf("DASH: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	muxer.sequence = uint32(seg.sequence + 1)
+	if !seg.independent {
+		muxer.startAnywhere()
+	}
+	for _, sample := range samples {
+		if sample.Track != track {
+			continue
+		}
+		if err := muxer.writeSample(0, sample.Data, sample.PTS, sample.Flags); err != nil {
+			log.Printf("DASH: failed to mux segment %d: %v", sequence, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	muxer.flush(seg.pts + seg.duration.Microseconds())
+	f, err := muxer.fragment(math.MaxInt64)
+	if err != nil {
+		log.Printf("DASH: failed to mux segment %d: %v", sequence, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if f == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/iso.segment")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(f.data)
+}
+
+// newTrackMuxer returns a single track fMP4 muxer for one of the HLS sink's
+// tracks, primed with its codec configuration and sharing its timeline.
+func (s *DASHServer) newTrackMuxer(track int) (*fmp4Muxer, error) {
+	h := s.hls
+	h.Lock()
+	config := h.codecConfig[track]
+	origin := h.origin
+	h.Unlock()
+
+	muxer, err := newFMP4Muxer(h.tracks[track : track+1])
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		if err := muxer.writeSample(0, config, origin, MediaCodecBufferFlagCodecConfig); err != nil {
+			return nil, err
+		}
+	}
+	muxer.setOrigin(origin)
+	return muxer, nil
+}
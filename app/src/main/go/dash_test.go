@@ -0,0 +1,155 @@
+package kinetic
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+)
+
+type testMPD struct {
+	Type                      string `xml:"type,attr"`
+	MediaPresentationDuration string `xml:"mediaPresentationDuration,attr"`
+	AdaptationSets            []struct {
+		ContentType     string `xml:"contentType,attr"`
+		Representations []struct {
+			ID       string `xml:"id,attr"`
+			Codecs   string `xml:"codecs,attr"`
+			Width    int    `xml:"width,attr"`
+			Height   int    `xml:"height,attr"`
+			Template struct {
+				Timescale   int    `xml:"timescale,attr"`
+				Media       string `xml:"media,attr"`
+				StartNumber uint64 `xml:"startNumber,attr"`
+				Timeline    []struct {
+					T int64 `xml:"t,attr"`
+					D int64 `xml:"d,attr"`
+					R int   `xml:"r,attr"`
+				} `xml:"SegmentTimeline>S"`
+			} `xml:"SegmentTemplate"`
+		} `xml:"Representation"`
+	} `xml:"Period>AdaptationSet"`
+}
+
+func getTestMPD(t *testing.T, url string) *testMPD {
+	t.Helper()
+	status, body := getTestHLS(t, url)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, status)
+	}
+	var mpd testMPD
+	if err := xml.Unmarshal(body, &mpd); err != nil {
+		t.Fatalf("failed to parse MPD: %v\n%s", err, body)
+	}
+	return &mpd
+}
+
+func TestDASHServer(t *testing.T) {
+	sink, _ := newTestHLSSink(t, WithHLSWindowSize(3))
+	server, err := NewDASHServer(sink, "127.0.0.1:0", "")
+	if err != nil {
+		t.Fatalf("NewDASHServer: %v", err)
+	}
+	defer server.Close()
+	base := fmt.Sprintf("http://%s", server.Addr())
+
+	if status, _ := getTestHLS(t, base+"/manifest.mpd"); status != http.StatusNotFound {
+		t.Errorf("manifest before the first segment: status %d, want 404", status)
+	}
+
+	writeTestGOPs(t, sink, 0, 5)
+
+	mpd := getTestMPD(t, base+"/manifest.mpd")
+	if mpd.Type != "dynamic" {
+		t.Errorf("MPD@type = %q, want dynamic", mpd.Type)
+	}
+	if len(mpd.AdaptationSets) != 2 {
+		t.Fatalf("got %d adaptation sets, want 2", len(mpd.AdaptationSets))
+	}
+	for i, want := range []struct {
+		contentType string
+		codecs      string
+		timescale   int
+	}{
+		{"video", "avc1.64001f", 90000},
+		{"audio", "mp4a.40.2", 48000},
+	} {
+		set := mpd.AdaptationSets[i]
+		if set.ContentType != want.contentType || len(set.Representations) != 1 {
+			t.Fatalf("adaptation set %d = %+v, want one %s representation", i, set, want.contentType)
+		}
+		rep := set.Representations[0]
+		if rep.Codecs != want.codecs {
+			t.Errorf("%s codecs = %q, want %q", want.contentType, rep.Codecs, want.codecs)
+		}
+		if rep.Template.Timescale != want.timescale {
+			t.Errorf("%s timescale = %d, want %d", want.contentType, rep.Template.Timescale, want.timescale)
+		}
+		// The window holds segments 1 to 3.
+		if rep.Template.StartNumber != 1 {
+			t.Errorf("%s startNumber = %d, want 1", want.contentType, rep.Template.StartNumber)
+		}
+		timeline := rep.Template.Timeline
+		second := int64(want.timescale)
+		if len(timeline) != 1 || timeline[0].T != second || timeline[0].D != second || timeline[0].R != 2 {
+			t.Errorf("%s timeline = %+v, want three one second segments from t=%d", want.contentType, timeline, second)
+		}
+	}
+	if rep := mpd.AdaptationSets[0].Representations[0]; rep.Width != 1280 || rep.Height != 720 {
+		t.Errorf("video size = %dx%d, want 1280x720", rep.Width, rep.Height)
+	}
+
+	status, init := getTestHLS(t, base+"/1/init.mp4")
+	if status != http.StatusOK {
+		t.Fatalf("GET 1/init.mp4: status %d", status)
+	}
+	tracks := unmarshalTestInit(t, init).Tracks
+	if len(tracks) != 1 {
+		t.Fatalf("audio init segment has %d tracks, want 1", len(tracks))
+	}
+	if _, ok := tracks[0].Codec.(*fmp4.CodecMPEG4Audio); !ok {
+		t.Errorf("audio codec = %T, want AAC", tracks[0].Codec)
+	}
+
+	for _, tc := range []struct {
+		track            int
+		minTFDT, maxTFDT uint64
+	}{
+		{0, 180000, 180000},
+		// The first audio frame at or after 2s.
+		{1, 96000, 96000 + 1024},
+	} {
+		status, segment := getTestHLS(t, fmt.Sprintf("%s/%d/segment2.m4s", base, tc.track))
+		if status != http.StatusOK {
+			t.Fatalf("GET %d/segment2.m4s: status %d", tc.track, status)
+		}
+		parts := unmarshalTestParts(t, segment)
+		if len(parts) != 1 {
+			t.Fatalf("track %d segment isn't a single moof/mdat pair", tc.track)
+		}
+		track := parts[0].Tracks[0]
+		if track.ID != 1 {
+			t.Errorf("track %d segment isn't for track ID 1", tc.track)
+		}
+		if track.BaseTime < tc.minTFDT || track.BaseTime > tc.maxTFDT {
+			t.Errorf("track %d tfdt = %d, want %d to %d", tc.track, track.BaseTime, tc.minTFDT, tc.maxTFDT)
+		}
+	}
+
+	if status, _ := getTestHLS(t, base+"/0/segment4.m4s"); status != http.StatusNotFound {
+		t.Errorf("segment still being recorded: status %d, want 404", status)
+	}
+	if status, _ := getTestHLS(t, base+"/2/init.mp4"); status != http.StatusNotFound {
+		t.Errorf("nonexistent track: status %d, want 404", status)
+	}
+
+	if err := sink.WriteSample(0, nil, 5_000_000, MediaCodecBufferFlagEndOfStream); err != nil {
+		t.Fatalf("WriteSample: %v", err)
+	}
+	mpd = getTestMPD(t, base+"/manifest.mpd")
+	if mpd.Type != "static" || mpd.MediaPresentationDuration != "PT5.000S" {
+		t.Errorf("ended MPD type = %q, duration = %q, want static PT5.000S", mpd.Type, mpd.MediaPresentationDuration)
+	}
+}
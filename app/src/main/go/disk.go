@@ -20,6 +20,8 @@ type DiskSink struct {
 	tracks []*DiskTrack
 }
 
+var _ Sink = (*DiskSink)(nil)
+
 func NewDiskSink(directory string, encodedKeys string) (*DiskSink, error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -42,12 +44,11 @@ func (s *DiskSink) Track(i int) *DiskTrack {
 }
 
 // WriteSample implements the Sink interface
-func (s *DiskSink) WriteSample(trackIndex int, buf []byte, ptsMicroseconds int64) (bool, error) {
+func (s *DiskSink) WriteSample(trackIndex int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
 	if trackIndex < 0 || trackIndex >= len(s.tracks) {
-		return false, fmt.Errorf("track index out of range: %d", trackIndex)
+		return fmt.Errorf("track index out of range: %d", trackIndex)
 	}
-	// Use 0 for flags since the Sink interface doesn't provide them
-	return false, s.tracks[trackIndex].WriteSample(buf, ptsMicroseconds, 0)
+	return s.tracks[trackIndex].WriteSample(buf, ptsMicroseconds, int32(flags))
 }
 
 // Close implements the Sink interface
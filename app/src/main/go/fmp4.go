@@ -0,0 +1,602 @@
+package kinetic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/av1"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/pkg/codecs/vp9"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4/seekablebuffer"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+)
+
+// fmp4VideoTimescale is the media timescale of video tracks. Audio tracks
+// use their sample rate.
+const fmp4VideoTimescale = 90000
+
+// fmp4Muxer turns encoder output into a CMAF style fragmented MP4: one init
+// segment followed by moof/mdat fragments. The boxes are written by
+// mediacommon; the muxer only times and buffers samples, and callers decide
+// where fragments are cut, so the same muxer backs FMP4Sink as well as the
+// segmented outputs.
+//
+// The encoders don't emit B-frames, so decode and presentation time are the
+// same and no composition offsets are written.
+type fmp4Muxer struct {
+	tracks []*fmp4Track
+	video  *fmp4Track // nil for audio-only streams
+
+	// started is set once the first sample is accepted, which freezes the
+	// track configuration. origin is the pts at decode time zero: the first
+	// sample's, unless setOrigin fixed it beforehand.
+	started   bool
+	origin    int64
+	originSet bool
+	sequence  uint32
+}
+
+type fmp4Track struct {
+	id        uint32
+	codec     MediaFormatMimeType
+	timescale uint32
+
+	// Codec configuration. H.264/H.265 keep their parameter sets in
+	// vps/sps/pps, AV1 keeps its sequence header OBU in sps and VP9 the
+	// configuration from its first keyframe's header in vp9. AAC keeps its
+	// AudioSpecificConfig in config.
+	vps, sps, pps []byte
+	vp9           *fmp4.CodecVP9
+	config        []byte
+	width, height int
+	channels      int
+
+	// configChanged is set when the parameter sets change after the init
+	// segment was built, which the sample entry can't describe.
+	configChanged bool
+
+	// samples have a known duration and are ready to be fragmented. pending
+	// is the most recent sample, whose duration is only known once the next
+	// sample arrives.
+	samples      []*fmp4Sample
+	pending      *fmp4Sample
+	lastDuration uint32
+}
+
+type fmp4Sample struct {
+	pts      int64 // microseconds
+	dts      int64 // timescale units since the muxer origin
+	duration uint32
+	keyframe bool
+	data     []byte
+}
+
+// fmp4Fragment is a complete moof/mdat pair.
+type fmp4Fragment struct {
+	data        []byte
+	start       int64 // pts of the earliest sample, microseconds
+	duration    time.Duration
+	independent bool // starts with a video keyframe
+}
+
+func newFMP4Muxer(codecs []MediaFormatMimeType) (*fmp4Muxer, error) {
+	m := &fmp4Muxer{sequence: 1}
+	for i, codec := range codecs {
+		t := &fmp4Track{id: uint32(i + 1), codec: codec}
+		switch codec {
+		case MediaFormatMimeTypeVideoH264, MediaFormatMimeTypeVideoH265, MediaFormatMimeTypeVideoAV1,
+			MediaFormatMimeTypeVideoVP9:
+			if m.video != nil {
+				return nil, fmt.Errorf("only one video track is supported")
+			}
+			t.timescale = fmp4VideoTimescale
+			m.video = t
+		case MediaFormatMimeTypeAudioAAC:
+			// Same default as the MPEG-TS sinks until the encoder's own
+			// AudioSpecificConfig arrives.
+			c := codec.MPEGTSCodec().(*mpegts.CodecMPEG4Audio)
+			config, err := c.Config.Marshal()
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal default AAC config: %w", err)
+			}
+			t.config = config
+			t.timescale = uint32(c.Config.SampleRate)
+			t.channels = c.Config.ChannelCount
+		case MediaFormatMimeTypeAudioOpus:
+			// The encoder is configured for stereo. Its own OpusHead
+			// replaces this once the codec config buffer arrives.
+			t.timescale = 48000
+			t.channels = 2
+		default:
+			return nil, fmt.Errorf("unsupported codec %s", codec)
+		}
+		m.tracks = append(m.tracks, t)
+	}
+	if len(m.tracks) == 0 {
+		return nil, fmt.Errorf("no tracks")
+	}
+	return m, nil
+}
+
+// setOrigin fixes the pts at decode time zero, so that separately muxed
+// pieces of one stream share a timeline.
+func (m *fmp4Muxer) setOrigin(ptsMicroseconds int64) {
+	m.origin = ptsMicroseconds
+	m.originSet = true
+}
+
+// startAnywhere lets the muxer start on any sample rather than waiting for a
+// video keyframe, for a segment cut between keyframes. The track
+// configuration must be known already.
+func (m *fmp4Muxer) startAnywhere() {
+	m.started = m.ready()
+}
+
+// ready reports whether every track's codec configuration is known, i.e.
+// whether initSegment can be built.
+func (m *fmp4Muxer) ready() bool {
+	for _, t := range m.tracks {
+		switch t.codec {
+		case MediaFormatMimeTypeVideoH264:
+			if t.sps == nil || t.pps == nil {
+				return false
+			}
+		case MediaFormatMimeTypeVideoH265:
+			if t.vps == nil || t.sps == nil || t.pps == nil {
+				return false
+			}
+		case MediaFormatMimeTypeVideoAV1:
+			if t.sps == nil {
+				return false
+			}
+		case MediaFormatMimeTypeVideoVP9:
+			if t.vp9 == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeSample buffers one encoder output buffer. Codec config buffers and
+// in-band parameter sets update the track configuration; they are not
+// samples themselves.
+func (m *fmp4Muxer) writeSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	if i < 0 || i >= len(m.tracks) {
+		return fmt.Errorf("track index out of range: %d", i)
+	}
+	t := m.tracks[i]
+	isConfig := flags&MediaCodecBufferFlagCodecConfig != 0
+	isKeyframe := flags&MediaCodecBufferFlagKeyFrame != 0
+
+	var data []byte
+	switch t.codec {
+	case MediaFormatMimeTypeVideoH264, MediaFormatMimeTypeVideoH265:
+		data = t.parseNALUs(buf, m.started)
+	case MediaFormatMimeTypeVideoAV1:
+		tu := buf
+		if isConfig && len(buf) > 0 && buf[0] == 0x81 {
+			if configOBUs, err := parseAV1CodecConfigurationRecord(buf); err == nil {
+				tu = configOBUs
+			}
+		}
+		if sequenceHeader := findAV1SequenceHeader(tu); sequenceHeader != nil {
+			t.setParam(&t.sps, sequenceHeader, m.started)
+		}
+		if !isConfig {
+			data = stripAV1TemporalDelimiters(buf)
+		}
+	case MediaFormatMimeTypeVideoVP9:
+		if isConfig {
+			return nil
+		}
+		if isKeyframe {
+			t.setVP9Config(buf, m.started)
+		}
+		data = buf
+	case MediaFormatMimeTypeAudioAAC:
+		if isConfig {
+			var config mpeg4audio.Config
+			if err := config.Unmarshal(buf); err != nil {
+				log.Printf("FMP4: ignoring invalid AudioSpecificConfig: %v", err)
+				return nil
+			}
+			if !m.started {
+				t.config = append([]byte(nil), buf...)
+				t.timescale = uint32(config.SampleRate)
+				t.channels = config.ChannelCount
+			} else if !bytes.Equal(buf, t.config) {
+				log.Printf("FMP4: ignoring AudioSpecificConfig change on track %d", i)
+			}
+			return nil
+		}
+		data = buf
+		isKeyframe = true
+	case MediaFormatMimeTypeAudioOpus:
+		if head := parseOpusCodecConfig(buf); head != nil {
+			if !m.started && len(head) >= 19 {
+				t.channels = int(head[9])
+			}
+			return nil
+		}
+		if isConfig {
+			// OpusTags and other side data.
+			return nil
+		}
+		data = buf
+		isKeyframe = true
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	if !m.started {
+		// Start on a video keyframe so the output is decodable from its
+		// first sample and audio lines up with video.
+		if m.video != nil && (t != m.video || !isKeyframe) {
+			return nil
+		}
+		if !m.ready() {
+			return nil
+		}
+		m.started = true
+		if !m.originSet {
+			m.origin = ptsMicroseconds
+			m.originSet = true
+		}
+	}
+	if ptsMicroseconds < m.origin {
+		return nil
+	}
+
+	s := &fmp4Sample{
+		pts:      ptsMicroseconds,
+		dts:      m.decodeTime(t, ptsMicroseconds),
+		keyframe: isKeyframe,
+		// The caller may reuse buf as soon as we return.
+		data: append([]byte(nil), data...),
+	}
+	if p := t.pending; p != nil {
+		if s.dts <= p.dts {
+			log.Printf("FMP4: dropping non-monotonic sample on track %d", i)
+			return nil
+		}
+		p.duration = uint32(s.dts - p.dts)
+		t.lastDuration = p.duration
+		t.samples = append(t.samples, p)
+	}
+	t.pending = s
+	return nil
+}
+
+// decodeTime converts a pts to t's timescale relative to the origin.
+func (m *fmp4Muxer) decodeTime(t *fmp4Track, ptsMicroseconds int64) int64 {
+	return ((ptsMicroseconds-m.origin)*int64(t.timescale) + 500_000) / 1_000_000
+}
+
+// parseNALUs records any parameter sets in an Annex-B access unit and
+// returns the remaining NALUs in length-prefixed form.
+func (t *fmp4Track) parseNALUs(buf []byte, started bool) []byte {
+	isHEVC := t.codec == MediaFormatMimeTypeVideoH265
+
+	var out []byte
+	for _, nalu := range splitNALUs(buf) {
+		if len(nalu) == 0 {
+			continue
+		}
+		var param *[]byte
+		if isHEVC {
+			switch (nalu[0] >> 1) & 0x3F {
+			case 32: // VPS
+				param = &t.vps
+			case 33: // SPS
+				param = &t.sps
+			case 34: // PPS
+				param = &t.pps
+			case 35: // AUD, not used in MP4
+				continue
+			}
+		} else {
+			switch nalu[0] & 0x1F {
+			case 7: // SPS
+				param = &t.sps
+			case 8: // PPS
+				param = &t.pps
+			case 9: // AUD, not used in MP4
+				continue
+			}
+		}
+		if param != nil {
+			t.setParam(param, nalu, started)
+			continue
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(nalu)))
+		out = append(out, nalu...)
+	}
+	return out
+}
+
+// setParam stores a parameter set. Once the init segment may have been
+// built the sample entry is fixed, so changes are only logged.
+func (t *fmp4Track) setParam(param *[]byte, value []byte, started bool) {
+	if bytes.Equal(*param, value) {
+		return
+	}
+	if started {
+		if !t.configChanged {
+			log.Printf("FMP4: ignoring %s parameter set change on track %d", t.codec, t.id-1)
+			t.configChanged = true
+		}
+		return
+	}
+	*param = append([]byte(nil), value...)
+	switch t.codec {
+	case MediaFormatMimeTypeVideoH264:
+		if param == &t.sps {
+			var sps h264.SPS
+			if err := sps.Unmarshal(value); err == nil {
+				t.width, t.height = sps.Width(), sps.Height()
+			}
+		}
+	case MediaFormatMimeTypeVideoH265:
+		if param == &t.sps {
+			var sps h265.SPS
+			if err := sps.Unmarshal(value); err == nil {
+				t.width, t.height = sps.Width(), sps.Height()
+			}
+		}
+	case MediaFormatMimeTypeVideoAV1:
+		var h av1.SequenceHeader
+		if err := h.Unmarshal(value); err == nil {
+			t.width, t.height = h.Width(), h.Height()
+		}
+	}
+}
+
+// setVP9Config records the configuration in a keyframe's uncompressed
+// header. VP9 has no separate codec config, so the first keyframe fixes the
+// sample entry and later changes are only logged.
+func (t *fmp4Track) setVP9Config(frame []byte, started bool) {
+	var h vp9.Header
+	if err := h.Unmarshal(frame); err != nil || h.ColorConfig == nil {
+		return
+	}
+	config := &fmp4.CodecVP9{
+		Width:             h.Width(),
+		Height:            h.Height(),
+		Profile:           h.Profile,
+		BitDepth:          h.ColorConfig.BitDepth,
+		ChromaSubsampling: h.ChromaSubsampling(),
+		ColorRange:        h.ColorConfig.ColorRange,
+	}
+	if t.vp9 != nil && *t.vp9 == *config {
+		return
+	}
+	if started {
+		if !t.configChanged {
+			log.Printf("FMP4: ignoring %s configuration change on track %d", t.codec, t.id-1)
+			t.configChanged = true
+		}
+		return
+	}
+	t.vp9 = config
+	t.width, t.height = config.Width, config.Height
+}
+
+// flush closes out the most recent sample of every track. If the video
+// track's end pts is known and after its last sample, that sample lasts until
+// end; other durations are guessed from the sample before. Call it before the
+// final fragment.
+func (m *fmp4Muxer) flush(end int64) {
+	for _, t := range m.tracks {
+		p := t.pending
+		if p == nil {
+			continue
+		}
+		p.duration = t.lastDuration
+		if t == m.video && end > p.pts {
+			p.duration = uint32(m.decodeTime(t, end) - p.dts)
+		}
+		if p.duration == 0 {
+			switch t.codec {
+			case MediaFormatMimeTypeAudioAAC:
+				p.duration = 1024
+			case MediaFormatMimeTypeAudioOpus:
+				p.duration = t.timescale / 50 // 20ms
+			default:
+				p.duration = t.timescale / 30
+			}
+		}
+		t.samples = append(t.samples, p)
+		t.pending = nil
+	}
+}
+
+// fragment packages every buffered sample with a pts before the given one
+// into a moof/mdat pair. It returns nil if there is nothing to write.
+func (m *fmp4Muxer) fragment(before int64) (*fmp4Fragment, error) {
+	part := &fmp4.Part{SequenceNumber: m.sequence}
+	var f *fmp4Fragment
+	for _, t := range m.tracks {
+		n := 0
+		for n < len(t.samples) && t.samples[n].pts < before {
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		samples := t.samples[:n]
+		t.samples = t.samples[n:]
+
+		if f == nil {
+			f = &fmp4Fragment{start: samples[0].pts, independent: true}
+		}
+		f.start = min(f.start, samples[0].pts)
+
+		track := &fmp4.PartTrack{ID: int(t.id), BaseTime: uint64(samples[0].dts)}
+		var ticks int64
+		for _, s := range samples {
+			track.Samples = append(track.Samples, &fmp4.PartSample{
+				Duration:        s.duration,
+				IsNonSyncSample: !s.keyframe,
+				Payload:         s.data,
+			})
+			ticks += int64(s.duration)
+		}
+		part.Tracks = append(part.Tracks, track)
+
+		// The fragment duration follows the video track, or the first audio
+		// track for audio-only streams.
+		if t == m.video || (m.video == nil && t == m.tracks[0]) {
+			f.duration = time.Duration(ticks) * time.Second / time.Duration(t.timescale)
+			f.independent = samples[0].keyframe
+		}
+	}
+	if f == nil {
+		return nil, nil
+	}
+
+	var buf seekablebuffer.Buffer
+	if err := part.Marshal(&buf); err != nil {
+		return nil, fmt.Errorf("failed to marshal fragment: %w", err)
+	}
+	f.data = buf.Bytes()
+	m.sequence++
+	return f, nil
+}
+
+// initSegment builds the ftyp and moov boxes describing every track.
+func (m *fmp4Muxer) initSegment() ([]byte, error) {
+	if !m.ready() {
+		return nil, fmt.Errorf("codec configuration not known yet")
+	}
+	var init fmp4.Init
+	for _, t := range m.tracks {
+		codec, err := t.fmp4Codec()
+		if err != nil {
+			return nil, err
+		}
+		init.Tracks = append(init.Tracks, &fmp4.InitTrack{
+			ID:        int(t.id),
+			TimeScale: t.timescale,
+			Codec:     codec,
+		})
+	}
+
+	var buf seekablebuffer.Buffer
+	if err := init.Marshal(&buf); err != nil {
+		return nil, fmt.Errorf("failed to marshal init segment: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *fmp4Track) isVideo() bool {
+	return strings.HasPrefix(string(t.codec), "video/")
+}
+
+// fmp4Codec describes the track's codec configuration for its sample entry.
+func (t *fmp4Track) fmp4Codec() (fmp4.Codec, error) {
+	switch t.codec {
+	case MediaFormatMimeTypeVideoH264:
+		return &fmp4.CodecH264{SPS: t.sps, PPS: t.pps}, nil
+	case MediaFormatMimeTypeVideoH265:
+		return &fmp4.CodecH265{VPS: t.vps, SPS: t.sps, PPS: t.pps}, nil
+	case MediaFormatMimeTypeVideoAV1:
+		// mediacommon adds the OBU size field itself and would otherwise
+		// write an empty av1C.
+		obus, err := av1.BitstreamUnmarshal(t.sps, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AV1 sequence header: %w", err)
+		}
+		return &fmp4.CodecAV1{SequenceHeader: obus[0]}, nil
+	case MediaFormatMimeTypeVideoVP9:
+		return t.vp9, nil
+	case MediaFormatMimeTypeAudioAAC:
+		var config mpeg4audio.Config
+		if err := config.Unmarshal(t.config); err != nil {
+			return nil, fmt.Errorf("invalid AudioSpecificConfig: %w", err)
+		}
+		return &fmp4.CodecMPEG4Audio{Config: config}, nil
+	case MediaFormatMimeTypeAudioOpus:
+		return &fmp4.CodecOpus{ChannelCount: t.channels}, nil
+	}
+	return nil, fmt.Errorf("unsupported codec %s", t.codec)
+}
+
+// codecString returns the RFC 6381 codecs parameter describing the sample
+// entry, as used in DASH manifests and HLS CODECS attributes.
+func (t *fmp4Track) codecString() string {
+	switch t.codec {
+	case MediaFormatMimeTypeVideoH264:
+		if len(t.sps) < 4 {
+			return "avc1"
+		}
+		// profile_idc, constraint flags and level_idc.
+		return fmt.Sprintf("avc1.%02x%02x%02x", t.sps[1], t.sps[2], t.sps[3])
+	case MediaFormatMimeTypeVideoH265:
+		var sps h265.SPS
+		if err := sps.Unmarshal(t.sps); err != nil {
+			return "hev1"
+		}
+		ptl := sps.ProfileTierLevel
+		var compatibility uint32
+		for i, v := range ptl.GeneralProfileCompatibilityFlag {
+			if v {
+				compatibility |= 1 << i
+			}
+		}
+		tier := "L"
+		if ptl.GeneralTierFlag != 0 {
+			tier = "H"
+		}
+		flag := func(v bool, bit int) byte {
+			if v {
+				return 1 << bit
+			}
+			return 0
+		}
+		constraints := flag(ptl.GeneralProgressiveSourceFlag, 7) | flag(ptl.GeneralInterlacedSourceFlag, 6) |
+			flag(ptl.GeneralNonPackedConstraintFlag, 5) | flag(ptl.GeneralFrameOnlyConstraintFlag, 4)
+		space := ""
+		if ptl.GeneralProfileSpace > 0 {
+			space = string(rune('A' + ptl.GeneralProfileSpace - 1))
+		}
+		return fmt.Sprintf("hev1.%s%d.%X.%s%d.%02X", space, ptl.GeneralProfileIdc, compatibility, tier, ptl.GeneralLevelIdc, constraints)
+	case MediaFormatMimeTypeVideoAV1:
+		var h av1.SequenceHeader
+		if err := h.Unmarshal(t.sps); err != nil {
+			return "av01"
+		}
+		var level uint8
+		if len(h.SeqLevelIdx) > 0 {
+			level = h.SeqLevelIdx[0]
+		}
+		tier := "M"
+		if len(h.SeqTier) > 0 && h.SeqTier[0] {
+			tier = "H"
+		}
+		depth := 8
+		if h.ColorConfig.TwelveBit {
+			depth = 12
+		} else if h.ColorConfig.HighBitDepth {
+			depth = 10
+		}
+		return fmt.Sprintf("av01.%d.%02d%s.%02d", h.SeqProfile, level, tier, depth)
+	case MediaFormatMimeTypeAudioAAC:
+		var config mpeg4audio.Config
+		if err := config.Unmarshal(t.config); err != nil {
+			return "mp4a.40.2"
+		}
+		return fmt.Sprintf("mp4a.40.%d", config.Type)
+	case MediaFormatMimeTypeAudioOpus:
+		return "opus"
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package kinetic
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fmp4AudioFragmentDuration is how often audio-only recordings are
+// fragmented, since there are no keyframes to cut on.
+const fmp4AudioFragmentDuration = time.Second
+
+// FMP4Sink records to a fragmented MP4 file that any player can open. The
+// init segment is written once the codec configuration is known and a
+// moof/mdat fragment follows each GOP, synced to disk as it is written, so a
+// crash only loses the GOP in progress.
+type FMP4Sink struct {
+	sync.Mutex
+
+	file  *os.File
+	muxer *fmp4Muxer
+
+	wroteInit    bool
+	lastFragment int64
+	closed       bool
+}
+
+var _ Sink = (*FMP4Sink)(nil)
+
+// NewFMP4Sink creates the file at path. encodedMediaFormatMimeTypes is the
+// ;-separated track list; H.264, H.265, AV1, VP9, AAC and Opus are supported.
+func NewFMP4Sink(path string, encodedMediaFormatMimeTypes string) (*FMP4Sink, error) {
+	var codecs []MediaFormatMimeType
+	for _, v := range strings.Split(encodedMediaFormatMimeTypes, ";") {
+		codecs = append(codecs, MediaFormatMimeType(v))
+	}
+	muxer, err := newFMP4Muxer(codecs)
+	if err != nil {
+		return nil, fmt.Errorf("FMP4: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FMP4Sink{file: file, muxer: muxer}, nil
+}
+
+// WriteSample implements the Sink interface
+func (s *FMP4Sink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("FMP4: sink closed")
+	}
+
+	if err := s.muxer.writeSample(i, buf, ptsMicroseconds, flags); err != nil {
+		return fmt.Errorf("FMP4: %w", err)
+	}
+	if !s.muxer.started || flags&MediaCodecBufferFlagCodecConfig != 0 {
+		return nil
+	}
+
+	var cut bool
+	if s.muxer.video != nil {
+		cut = s.muxer.tracks[i] == s.muxer.video && flags&MediaCodecBufferFlagKeyFrame != 0
+	} else {
+		start := s.muxer.origin
+		if s.wroteInit {
+			start = s.lastFragment
+		}
+		cut = ptsMicroseconds-start >= fmp4AudioFragmentDuration.Microseconds()
+	}
+	if !cut {
+		return nil
+	}
+	return s.writeFragmentLocked(ptsMicroseconds)
+}
+
+// writeFragmentLocked writes every sample buffered before pts, preceded by
+// the init segment if it hasn't been written yet.
+func (s *FMP4Sink) writeFragmentLocked(pts int64) error {
+	// The muxer only knows a sample's duration once the next one on the
+	// same track arrives, so the sample at pts must already be buffered for
+	// the fragment before it to be complete.
+	f, err := s.muxer.fragment(pts)
+	if err != nil {
+		return fmt.Errorf("FMP4: %w", err)
+	}
+	if f == nil {
+		return nil
+	}
+	if !s.wroteInit {
+		init, err := s.muxer.initSegment()
+		if err != nil {
+			return fmt.Errorf("FMP4: %w", err)
+		}
+		if _, err := s.file.Write(init); err != nil {
+			return err
+		}
+		s.wroteInit = true
+	}
+	if _, err := s.file.Write(f.data); err != nil {
+		return err
+	}
+	s.lastFragment = pts
+	return s.file.Sync()
+}
+
+// Close implements the Sink interface
+func (s *FMP4Sink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.muxer.flush(math.MinInt64)
+	err := s.writeFragmentLocked(math.MaxInt64)
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
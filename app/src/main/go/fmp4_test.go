@@ -0,0 +1,253 @@
+package kinetic
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+)
+
+// A 1280x720 High profile SPS.
+var (
+	testH264SPS = []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+		0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+		0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+		0xcb,
+	}
+	testH264PPS = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+)
+
+// unmarshalTestInit parses the init segment at the start of data.
+func unmarshalTestInit(t *testing.T, data []byte) *fmp4.Init {
+	t.Helper()
+	var init fmp4.Init
+	if err := init.Unmarshal(bytes.NewReader(data)); err != nil {
+		t.Fatalf("invalid init segment: %v", err)
+	}
+	return &init
+}
+
+// unmarshalTestParts parses every moof/mdat fragment in data.
+func unmarshalTestParts(t *testing.T, data []byte) fmp4.Parts {
+	t.Helper()
+	var parts fmp4.Parts
+	if err := parts.Unmarshal(data); err != nil {
+		t.Fatalf("invalid fragments: %v", err)
+	}
+	return parts
+}
+
+func testAnnexB(nalus ...[]byte) []byte {
+	var b []byte
+	for _, nalu := range nalus {
+		b = append(b, 0x00, 0x00, 0x00, 0x01)
+		b = append(b, nalu...)
+	}
+	return b
+}
+
+func TestFMP4SinkH264AAC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.mp4")
+	sink, err := NewFMP4Sink(path, string(MediaFormatMimeTypeVideoH264)+";"+string(MediaFormatMimeTypeAudioAAC))
+	if err != nil {
+		t.Fatalf("NewFMP4Sink: %v", err)
+	}
+
+	// A delta frame before the first keyframe must be dropped.
+	if err := sink.WriteSample(0, testAnnexB([]byte{0x41, 0x9a}), 0, 0); err != nil {
+		t.Fatalf("WriteSample: %v", err)
+	}
+	if err := sink.WriteSample(0, testAnnexB(testH264SPS, testH264PPS), 0, MediaCodecBufferFlagCodecConfig); err != nil {
+		t.Fatalf("WriteSample: %v", err)
+	}
+	if err := sink.WriteSample(1, []byte{0x11, 0x90}, 0, MediaCodecBufferFlagCodecConfig); err != nil {
+		t.Fatalf("WriteSample: %v", err)
+	}
+
+	// Two one second GOPs at 30fps, with 1024 sample AAC frames at 48kHz.
+	const frames = 60
+	audioPTS := int64(0)
+	for i := 0; i < frames; i++ {
+		pts := int64(i) * 1_000_000 / 30
+		flags := MediaCodecBufferFlag(0)
+		nalu := []byte{0x41, 0x9a, byte(i)}
+		if i%30 == 0 {
+			flags = MediaCodecBufferFlagKeyFrame
+			nalu = []byte{0x65, 0x88, byte(i)}
+		}
+		if err := sink.WriteSample(0, testAnnexB(nalu), pts+1000, flags); err != nil {
+			t.Fatalf("WriteSample video %d: %v", i, err)
+		}
+		for ; audioPTS <= pts; audioPTS += 1024 * 1_000_000 / 48000 {
+			if err := sink.WriteSample(1, []byte{0x21, 0x00, byte(i)}, audioPTS+1000, 0); err != nil {
+				t.Fatalf("WriteSample audio: %v", err)
+			}
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	init := unmarshalTestInit(t, data)
+	if len(init.Tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(init.Tracks))
+	}
+	video, ok := init.Tracks[0].Codec.(*fmp4.CodecH264)
+	if !ok {
+		t.Fatalf("video codec = %T, want H.264", init.Tracks[0].Codec)
+	}
+	if !bytes.Equal(video.SPS, testH264SPS) || !bytes.Equal(video.PPS, testH264PPS) {
+		t.Errorf("video parameter sets = %x, %x", video.SPS, video.PPS)
+	}
+	audio, ok := init.Tracks[1].Codec.(*fmp4.CodecMPEG4Audio)
+	if !ok {
+		t.Fatalf("audio codec = %T, want AAC", init.Tracks[1].Codec)
+	}
+	if config, _ := audio.Config.Marshal(); !bytes.Equal(config, []byte{0x11, 0x90}) {
+		t.Errorf("AudioSpecificConfig = %x, want the encoder's 1190", config)
+	}
+
+	parts := unmarshalTestParts(t, data)
+	if len(parts) != 2 {
+		t.Fatalf("got %d fragments, want 2", len(parts))
+	}
+	var videoSamples int
+	var videoDecodeTime uint64
+	for n, part := range parts {
+		if part.SequenceNumber != uint32(n+1) {
+			t.Errorf("fragment %d sequence_number = %d", n, part.SequenceNumber)
+		}
+		for _, track := range part.Tracks {
+			if track.ID != 1 {
+				continue
+			}
+			if n == 0 && len(track.Samples) != 30 {
+				t.Errorf("first fragment has %d video samples, want 30", len(track.Samples))
+			}
+			if track.BaseTime != videoDecodeTime {
+				t.Errorf("fragment %d video tfdt = %d, want %d", n, track.BaseTime, videoDecodeTime)
+			}
+			for s, sample := range track.Samples {
+				if sample.IsNonSyncSample != (s != 0) {
+					t.Errorf("fragment %d sample %d non-sync = %v", n, s, sample.IsNonSyncSample)
+				}
+				videoDecodeTime += uint64(sample.Duration)
+			}
+			if payload := track.Samples[0].Payload; !bytes.Equal(payload, []byte{0, 0, 0, 3, 0x65, 0x88, byte(n * 30)}) {
+				t.Errorf("fragment %d first video sample = %x, want a length-prefixed IDR", n, payload)
+			}
+			videoSamples += len(track.Samples)
+		}
+	}
+	if videoSamples != frames {
+		t.Errorf("wrote %d video samples, want %d", videoSamples, frames)
+	}
+	if videoDecodeTime != frames*3000 {
+		t.Errorf("video duration = %d, want %d", videoDecodeTime, frames*3000)
+	}
+}
+
+func TestFMP4MuxerInitSegment(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		codecs  []MediaFormatMimeType
+		config  [][]byte
+		video   fmp4.Codec
+		audio   fmp4.Codec
+		rfc6381 []string
+	}{
+		{
+			"hevc opus",
+			[]MediaFormatMimeType{MediaFormatMimeTypeVideoH265, MediaFormatMimeTypeAudioOpus},
+			[][]byte{testAnnexB(testHEVCVPS, testHEVCSPS, testHEVCPPS), buildOpusHead(1, 312)},
+			&fmp4.CodecH265{VPS: testHEVCVPS, SPS: testHEVCSPS, PPS: testHEVCPPS},
+			&fmp4.CodecOpus{ChannelCount: 1},
+			[]string{"hev1.1.6.L120.90", "opus"},
+		},
+		{
+			"av1 aac",
+			[]MediaFormatMimeType{MediaFormatMimeTypeVideoAV1, MediaFormatMimeTypeAudioAAC},
+			[][]byte{append([]byte{0x0a, byte(len(testAV1SequenceHeader) - 1)}, testAV1SequenceHeader[1:]...), nil},
+			&fmp4.CodecAV1{SequenceHeader: testAV1SequenceHeader},
+			&fmp4.CodecMPEG4Audio{Config: mpeg4audio.Config{Type: mpeg4audio.ObjectTypeAACLC, SampleRate: 48000, ChannelCount: 2}},
+			[]string{"av01.0.08M.08", "mp4a.40.2"},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			m, err := newFMP4Muxer(ca.codecs)
+			if err != nil {
+				t.Fatalf("newFMP4Muxer: %v", err)
+			}
+			if m.ready() {
+				t.Fatal("ready before the video config arrived")
+			}
+			for i, config := range ca.config {
+				if config == nil {
+					continue
+				}
+				if err := m.writeSample(i, config, 0, MediaCodecBufferFlagCodecConfig); err != nil {
+					t.Fatalf("writeSample: %v", err)
+				}
+			}
+			init, err := m.initSegment()
+			if err != nil {
+				t.Fatalf("initSegment: %v", err)
+			}
+
+			for i, want := range ca.rfc6381 {
+				if got := m.tracks[i].codecString(); got != want {
+					t.Errorf("track %d codecs = %q, want %q", i, got, want)
+				}
+			}
+
+			tracks := unmarshalTestInit(t, init).Tracks
+			if len(tracks) != 2 {
+				t.Fatalf("got %d tracks, want 2", len(tracks))
+			}
+			for i, want := range []fmp4.Codec{ca.video, ca.audio} {
+				if got := tracks[i].Codec; !reflect.DeepEqual(got, want) {
+					t.Errorf("track %d codec = %#v, want %#v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFMP4MuxerVP9(t *testing.T) {
+	m, err := newFMP4Muxer([]MediaFormatMimeType{MediaFormatMimeTypeVideoVP9})
+	if err != nil {
+		t.Fatalf("newFMP4Muxer: %v", err)
+	}
+	// The uncompressed header of a 1280x720 profile 0 keyframe.
+	keyframe := []byte{0x82, 0x49, 0x83, 0x42, 0x40, 0x4f, 0xf0, 0x2c, 0xf0, 0x00}
+	if err := m.writeSample(0, keyframe, 0, MediaCodecBufferFlagKeyFrame); err != nil {
+		t.Fatalf("writeSample: %v", err)
+	}
+	if !m.started {
+		t.Fatal("didn't start on the first keyframe")
+	}
+	init, err := m.initSegment()
+	if err != nil {
+		t.Fatalf("initSegment: %v", err)
+	}
+	want := &fmp4.CodecVP9{Width: 1280, Height: 720, BitDepth: 8, ChromaSubsampling: 1}
+	if got := unmarshalTestInit(t, init).Tracks[0].Codec; !reflect.DeepEqual(got, want) {
+		t.Errorf("codec = %#v, want %#v", got, want)
+	}
+}
+
+func TestNewFMP4SinkRejectsUnsupportedCodec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.mp4")
+	if _, err := NewFMP4Sink(path, string(MediaFormatMimeTypeVideoVP8)); err == nil {
+		t.Fatal("expected an error for VP8")
+	}
+}
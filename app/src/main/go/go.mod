@@ -6,17 +6,21 @@ require (
 	github.com/bluenviron/gortsplib/v4 v4.6.2
 	github.com/bluenviron/mediacommon v1.9.2
 	github.com/google/uuid v1.6.0
+	github.com/grafov/m3u8 v0.11.1
 	github.com/kevmo314/go-uvc v0.0.0-20260201233718-a8678bfc12e6
 	github.com/pion/interceptor v0.1.42
 	github.com/pion/rtcp v1.2.16
 	github.com/pion/rtp v1.8.27
+	github.com/pion/sdp/v3 v3.0.17
 	github.com/pion/transport/v3 v3.1.1
+	github.com/pion/turn/v4 v4.1.3
 	github.com/pion/webrtc/v4 v4.2.0
 	github.com/yutopp/go-flv v0.3.1
 	github.com/yutopp/go-rtmp v0.0.7
 )
 
 require (
+	github.com/abema/go-mp4 v1.4.1 // indirect
 	github.com/asticode/go-astikit v0.30.0 // indirect
 	github.com/asticode/go-astits v1.13.0 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
@@ -30,10 +34,8 @@ require (
 	github.com/pion/mdns/v2 v2.1.0 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
 	github.com/pion/sctp v1.9.0 // indirect
-	github.com/pion/sdp/v3 v3.0.17 // indirect
 	github.com/pion/srtp/v3 v3.0.9 // indirect
 	github.com/pion/stun/v3 v3.0.2 // indirect
-	github.com/pion/turn/v4 v4.1.3 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/sirupsen/logrus v1.7.0 // indirect
 	github.com/wlynxg/anet v0.0.5 // indirect
@@ -1,117 +1,842 @@
 package kinetic
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
-	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
 	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
 )
 
+type HLSPlaylistType int
+
+const (
+	// HLSPlaylistTypeLive serves a sliding window of the most recent
+	// segments.
+	HLSPlaylistTypeLive HLSPlaylistType = iota
+	// HLSPlaylistTypeEvent serves every segment since the start so viewers
+	// can seek back to the beginning.
+	HLSPlaylistTypeEvent
+)
+
+type HLSSegmentFormat int
+
+const (
+	HLSSegmentFormatMPEGTS HLSSegmentFormat = iota
+	HLSSegmentFormatFMP4
+)
+
+const (
+	defaultHLSAddr           = ":8080"
+	defaultHLSWindowSize     = 6
+	defaultHLSPartTarget     = 200 * time.Millisecond
+	defaultHLSTargetDuration = 2 * time.Second
+)
+
+// HLSSinkOption configures the HLS sink
+type HLSSinkOption func(*HLSSink)
+
+// WithHLSPlaylistType selects a live (sliding window) or EVENT playlist.
+func WithHLSPlaylistType(t HLSPlaylistType) HLSSinkOption {
+	return func(s *HLSSink) { s.playlistType = t }
+}
+
+// WithHLSSegmentFormat selects MPEG-TS or fMP4 segments. AV1 requires fMP4.
+func WithHLSSegmentFormat(f HLSSegmentFormat) HLSSinkOption {
+	return func(s *HLSSink) { s.segmentFormat = f }
+}
+
+// WithHLSWindowSize sets how many segments a live playlist lists.
+func WithHLSWindowSize(n int) HLSSinkOption {
+	return func(s *HLSSink) { s.windowSize = n }
+}
+
+// WithHLSTargetDuration sets the longest a segment may be, rounded up to a
+// whole second, 2s by default. It's the playlist's EXT-X-TARGETDURATION,
+// which mustn't change, so GOPs longer than it are cut between keyframes.
+func WithHLSTargetDuration(d time.Duration) HLSSinkOption {
+	return func(s *HLSSink) {
+		s.targetDuration = int(math.Ceil(d.Seconds()))
+	}
+}
+
+// WithHLSLowLatency enables Low-Latency HLS with partial segments of at
+// most partTarget, or 200ms if partTarget is zero. It requires fMP4
+// segments.
+func WithHLSLowLatency(partTarget time.Duration) HLSSinkOption {
+	return func(s *HLSSink) {
+		if partTarget <= 0 {
+			partTarget = defaultHLSPartTarget
+		}
+		s.partTarget = partTarget
+	}
+}
+
+// HLSSink is an HLS origin for the segments recorded by a BinaryDumpSink.
+// Samples written to the HLS sink are recorded through to the disk store,
+// which starts a new file on every video keyframe; each file is one media
+// segment. A GOP longer than the target duration is split into segments
+// that don't start with a keyframe. Segments are muxed to MPEG-TS or fMP4 on request, so the disk
+// store stays the single copy of the media.
+//
+// Playlists support blocking reload: a request with _HLS_msn=N is held until
+// segment N is available.
+//
+// In Low-Latency mode the sink also muxes live samples into partial segments
+// as they arrive, advertises the next one with a preload hint and holds
+// _HLS_msn/_HLS_part requests until the part exists. Recent segments are
+// served as the concatenation of their parts so both views carry the same
+// bytes; older ones fall back to the disk store.
+//
+// The HLS sink writes to the disk store itself, so don't also route samples
+// to diskSink.
 type HLSSink struct {
-	server *http.Server
+	sync.Mutex
+
+	store         *BinaryDumpSink
+	bearerToken   string
+	tracks        []MediaFormatMimeType
+	playlistType  HLSPlaylistType
+	segmentFormat HLSSegmentFormat
+	windowSize    int
+	partTarget    time.Duration // zero unless Low-Latency HLS is enabled
+
+	listener net.Listener
+	server   *http.Server
+
+	// codecConfig holds each track's most recent codec config buffer, which
+	// the disk store only captures if it arrives mid-segment.
+	codecConfig [][]byte
+
+	origin         int64 // pts of the first segment
+	segments       []hlsSegment
+	current        *hlsSegment // segment being recorded
+	lastPTS        int64
+	targetDuration int // seconds, fixed for the life of the playlist
+	ended          bool
+
+	// cutBetweenKeyframes is set once a segment had to start without a
+	// keyframe, after which segments are no longer advertised as
+	// independent.
+	cutBetweenKeyframes bool
+
+	// availabilityStart is the wall clock time of origin, back-dated from
+	// when the first segment was published so it includes the encoder's
+	// latency. The DASH server uses it as availabilityStartTime.
+	availabilityStart time.Time
+
+	// live muxes partial segments in Low-Latency mode. partStart is the pts
+	// the part in progress starts at and lastVideoPTS estimates the frame
+	// duration when deciding whether the next frame still fits in the part.
+	live         *fmp4Muxer
+	partStart    int64
+	lastVideoPTS int64
+
+	// changed is closed and replaced whenever segments or parts change.
+	changed chan struct{}
+}
+
+type hlsSegment struct {
+	sequence    uint64
+	pts         int64 // first pts, which names the file in the disk store
+	duration    time.Duration
+	independent bool // starts with a keyframe
+
+	// parts are only kept while the segment is near the live edge.
+	parts []*hlsPart
+}
+
+type hlsPart struct {
+	duration    time.Duration
+	independent bool
+	data        []byte
+}
+
+var _ Sink = (*HLSSink)(nil)
+
+// NewHLSSink starts serving /manifest.m3u8 on addr, or :8080 if addr is
+// empty. If bearerToken is set, requests must carry it in an Authorization
+// header. Track 0 must be video.
+func NewHLSSink(diskSink *BinaryDumpSink, addr, bearerToken, encodedMediaFormatMimeTypes string, opts ...HLSSinkOption) (*HLSSink, error) {
+	s := &HLSSink{
+		store:          diskSink,
+		bearerToken:    bearerToken,
+		windowSize:     defaultHLSWindowSize,
+		targetDuration: int(defaultHLSTargetDuration.Seconds()),
+		changed:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.windowSize <= 0 {
+		return nil, fmt.Errorf("HLS: invalid window size %d", s.windowSize)
+	}
+	if s.targetDuration <= 0 {
+		return nil, fmt.Errorf("HLS: invalid target duration %ds", s.targetDuration)
+	}
+
+	for _, v := range strings.Split(encodedMediaFormatMimeTypes, ";") {
+		t := MediaFormatMimeType(v)
+		switch t {
+		case MediaFormatMimeTypeVideoH264, MediaFormatMimeTypeVideoH265,
+			MediaFormatMimeTypeAudioAAC, MediaFormatMimeTypeAudioOpus:
+		case MediaFormatMimeTypeVideoAV1:
+			if s.segmentFormat != HLSSegmentFormatFMP4 {
+				return nil, fmt.Errorf("HLS: %s requires fMP4 segments", v)
+			}
+		default:
+			return nil, fmt.Errorf("HLS: unsupported codec %s", v)
+		}
+		s.tracks = append(s.tracks, t)
+	}
+	if !strings.HasPrefix(string(s.tracks[0]), "video/") {
+		return nil, fmt.Errorf("HLS: track 0 must be video")
+	}
+	s.codecConfig = make([][]byte, len(s.tracks))
+
+	if s.partTarget > 0 {
+		if s.segmentFormat != HLSSegmentFormatFMP4 {
+			return nil, fmt.Errorf("HLS: Low-Latency HLS requires fMP4 segments")
+		}
+		live, err := newFMP4Muxer(s.tracks)
+		if err != nil {
+			return nil, fmt.Errorf("HLS: %w", err)
+		}
+		s.live = live
+	}
+
+	if addr == "" {
+		addr = defaultHLSAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("HLS: failed to listen on %s: %w", addr, err)
+	}
+	s.listener = listener
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HLS: server stopped: %v", err)
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the sink is serving on.
+func (s *HLSSink) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// WriteSample implements the Sink interface. A video keyframe, or a frame
+// that would run the segment past the target duration, closes the current
+// segment; EndOfStream ends the playlist.
+func (s *HLSSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if i < 0 || i >= len(s.tracks) {
+		return fmt.Errorf("track index out of range: %d", i)
+	}
+	if s.ended {
+		return fmt.Errorf("HLS: stream ended")
+	}
+
+	isKeyframe := i == 0 && flags&MediaCodecBufferFlagKeyFrame != 0
+	if flags&MediaCodecBufferFlagCodecConfig != 0 {
+		// The Opus encoder's OpusHead is followed by other config buffers
+		// that mustn't replace it.
+		if s.tracks[i] != MediaFormatMimeTypeAudioOpus || parseOpusCodecConfig(buf) != nil {
+			s.codecConfig[i] = append([]byte(nil), buf...)
+		}
+	} else if isKeyframe && s.codecConfig[0] == nil {
+		// Some encoders only send parameter sets in-band.
+		s.codecConfig[0] = inBandCodecConfig(s.tracks[0], buf)
+	}
+
+	// Cut before a frame if the frame after it would run the segment past
+	// the target duration, assuming it's as long as the last.
+	isForcedCut := false
+	if i == 0 && !isKeyframe && s.current != nil && flags&MediaCodecBufferFlagCodecConfig == 0 {
+		frame := ptsMicroseconds - s.lastVideoPTS
+		isForcedCut = ptsMicroseconds-s.current.pts+frame > s.targetDurationLocked().Microseconds()
+	}
+	if isForcedCut {
+		if err := s.store.startFile(ptsMicroseconds); err != nil {
+			return err
+		}
+	}
+
+	if err := s.store.WriteSample(i, buf, ptsMicroseconds, flags); err != nil {
+		return err
+	}
+	if s.live != nil {
+		if isKeyframe && s.current == nil {
+			s.live.setOrigin(ptsMicroseconds)
+		}
+		if err := s.live.writeSample(i, buf, ptsMicroseconds, flags); err != nil {
+			return fmt.Errorf("HLS: %w", err)
+		}
+	}
+
+	if isKeyframe || isForcedCut {
+		if s.current != nil {
+			// The frame closes out the last part of the segment.
+			s.writePartLocked(ptsMicroseconds)
+			s.finishSegmentLocked(ptsMicroseconds)
+		} else {
+			s.origin = ptsMicroseconds
+		}
+		if isForcedCut && !s.cutBetweenKeyframes {
+			log.Printf("HLS: GOP longer than the %ds target duration, cutting segments between keyframes", s.targetDuration)
+			s.cutBetweenKeyframes = true
+		}
+		s.current = &hlsSegment{sequence: s.nextSequenceLocked(), pts: ptsMicroseconds, independent: isKeyframe}
+		s.partStart = ptsMicroseconds
+	} else if i == 0 && s.current != nil && s.live != nil && flags&MediaCodecBufferFlagCodecConfig == 0 {
+		// Cut before this frame if the frame after it would push the part
+		// past the part target.
+		frame := ptsMicroseconds - s.lastVideoPTS
+		if ptsMicroseconds-s.partStart+frame > s.partTarget.Microseconds() {
+			s.writePartLocked(ptsMicroseconds)
+			s.partStart = ptsMicroseconds
+		}
+	}
+	if i == 0 && flags&MediaCodecBufferFlagCodecConfig == 0 {
+		s.lastVideoPTS = ptsMicroseconds
+	}
+	if s.current != nil && ptsMicroseconds > s.lastPTS {
+		s.lastPTS = ptsMicroseconds
+	}
+
+	if flags&MediaCodecBufferFlagEndOfStream != 0 {
+		s.endLocked()
+	}
+	return nil
 }
 
-func NewHLSSink(diskSink *BinaryDumpSink, url, bearerToken, encodedMediaFormatMimeTypes string) (*HLSSink, error) {
-	mediaFormatMimeTypes := strings.Split(encodedMediaFormatMimeTypes, ";")
-	tracks := make([]*mpegts.Track, len(mediaFormatMimeTypes))
-	for i, mediaFormatMimeType := range mediaFormatMimeTypes {
-		tracks[i] = &mpegts.Track{
-			PID:   uint16(i),
-			Codec: MediaFormatMimeType(mediaFormatMimeType).MPEGTSCodec(),
+// inBandCodecConfig returns the parameter sets in a keyframe, in the same
+// form as a codec config buffer.
+func inBandCodecConfig(codec MediaFormatMimeType, buf []byte) []byte {
+	if codec == MediaFormatMimeTypeVideoAV1 {
+		return findAV1SequenceHeader(buf)
+	}
+	var config []byte
+	for _, nalu := range splitNALUs(buf) {
+		if len(nalu) == 0 {
+			continue
+		}
+		var isParameterSet bool
+		if codec == MediaFormatMimeTypeVideoH265 {
+			typ := (nalu[0] >> 1) & 0x3F
+			isParameterSet = typ >= 32 && typ <= 34 // VPS, SPS, PPS
+		} else {
+			typ := nalu[0] & 0x1F
+			isParameterSet = typ == 7 || typ == 8 // SPS, PPS
 		}
+		if isParameterSet {
+			config = append(config, 0x00, 0x00, 0x00, 0x01)
+			config = append(config, nalu...)
+		}
+	}
+	return config
+}
+
+func (s *HLSSink) nextSequenceLocked() uint64 {
+	if len(s.segments) == 0 {
+		return 0
+	}
+	return s.segments[len(s.segments)-1].sequence + 1
+}
+
+// writePartLocked publishes everything the live muxer has buffered before pts
+// as the next part of the current segment.
+func (s *HLSSink) writePartLocked(pts int64) {
+	if s.live == nil {
+		return
 	}
-	server := http.Server{
-		Addr: ":8080",
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodGet {
-				w.WriteHeader(http.StatusMethodNotAllowed)
+	f, err := s.live.fragment(pts)
+	if err != nil {
+		log.Printf("HLS: failed to mux part: %v", err)
+		return
+	}
+	if f == nil {
+		return
+	}
+	s.current.parts = append(s.current.parts, &hlsPart{
+		duration:    f.duration,
+		independent: f.independent,
+		data:        f.data,
+	})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// finishSegmentLocked closes the current segment at end and publishes it.
+func (s *HLSSink) finishSegmentLocked(end int64) {
+	seg := *s.current
+	s.current = nil
+	seg.duration = time.Duration(end-seg.pts) * time.Microsecond
+	if seg.duration <= 0 {
+		return
+	}
+	s.segments = append(s.segments, seg)
+	if s.availabilityStart.IsZero() {
+		s.availabilityStart = time.Now().Add(-time.Duration(end-s.origin) * time.Microsecond)
+	}
+	if s.playlistType == HLSPlaylistTypeLive && len(s.segments) > 2*s.windowSize {
+		// Keep segments that just left the window fetchable for clients
+		// working from an older copy of the playlist.
+		s.segments = append([]hlsSegment(nil), s.segments[len(s.segments)-2*s.windowSize:]...)
+	}
+	// Parts are only listed for the last three target durations.
+	var age time.Duration
+	for i := len(s.segments) - 1; i >= 0 && s.segments[i].parts != nil; i-- {
+		if age > 3*s.targetDurationLocked() {
+			s.segments[i].parts = nil
+		}
+		age += s.segments[i].duration
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *HLSSink) endLocked() {
+	if s.ended {
+		return
+	}
+	if s.current != nil {
+		if s.live != nil {
+			s.live.flush(s.lastPTS)
+			s.writePartLocked(math.MaxInt64)
+		}
+		s.finishSegmentLocked(s.lastPTS)
+	}
+	s.ended = true
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Close ends the playlist and stops serving.
+func (s *HLSSink) Close() error {
+	s.Lock()
+	s.endLocked()
+	err := s.store.Close()
+	s.Unlock()
+
+	if serr := s.server.Shutdown(context.Background()); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (s *HLSSink) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.bearerToken != "" && r.Header.Get("Authorization") != "Bearer "+s.bearerToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch path := strings.TrimPrefix(r.URL.Path, "/"); {
+	case path == "manifest.m3u8":
+		s.servePlaylist(w, r)
+	case path == "init.mp4" && s.segmentFormat == HLSSegmentFormatFMP4:
+		s.serveInit(w)
+	case strings.HasPrefix(path, "segment"):
+		ext := ".ts"
+		if s.segmentFormat == HLSSegmentFormatFMP4 {
+			ext = ".m4s"
+		}
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(path, "segment"), ext), 10, 64)
+		if err != nil || !strings.HasSuffix(path, ext) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.serveSegment(w, sequence)
+	case strings.HasPrefix(path, "part") && s.live != nil:
+		var sequence uint64
+		var index int
+		if _, err := fmt.Sscanf(path, "part%d.%d.m4s", &sequence, &index); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.servePart(w, r, sequence, index)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *HLSSink) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if v := r.URL.Query().Get("_HLS_msn"); v != "" {
+		msn, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		part := -1
+		if v := r.URL.Query().Get("_HLS_part"); v != "" {
+			if part, err = strconv.Atoi(v); err != nil || part < 0 || s.live == nil {
+				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			if r.URL.Path == "/manifest.m3u8" {
-				manifest, err := diskSink.ReadManifest()
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-				w.Write([]byte("#EXTM3U\n"))
-				w.Write([]byte("#EXT-X-TARGETDURATION:2\n"))
-				w.Write([]byte("#EXT-X-MEDIA-SEQUENCE:0\n"))
-				for _, m := range manifest {
-					w.Write([]byte(fmt.Sprintf("#EXTINF:%.3f,\n", (time.Duration(m.PTS) * time.Nanosecond).Seconds())))
-					w.Write([]byte(fmt.Sprintf("/%s/%d.ucf\n", diskSink.directory, m.PTS)))
-				}
-				w.Write([]byte("#EXT-X-ENDLIST\n"))
-			} else if strings.HasSuffix(r.URL.Path, ".ts") {
-				// parse /%d.mp4
-				pts, err := strconv.ParseInt(strings.TrimSuffix(r.URL.Path[1:], ".ts"), 10, 64)
-				if err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
-				manifest, err := diskSink.ReadManifest()
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				for _, m := range manifest {
-					if m.PTS == pts {
-						w.Header().Set("Content-Type", "application/mpegts")
-						w.WriteHeader(http.StatusOK)
-						f, err := os.Open(fmt.Sprintf("%s/%d.ucf", diskSink.directory, pts))
-						if err != nil {
-							w.WriteHeader(http.StatusInternalServerError)
-							return
-						}
-						defer f.Close()
-						mtw := mpegts.NewWriter(w, tracks)
-						header := make([]byte, 16)
-						buf := make([]byte, 4096)
-						for {
-							if _, err := f.Read(header); err != nil {
-								break
-							}
-							track := int(binary.LittleEndian.Uint16(header[2:4]))
-							flags := MediaCodecBufferFlag(binary.LittleEndian.Uint16(header[0:2]))
-							pts := int64(binary.LittleEndian.Uint64(header[4:12]))
-							size := int(binary.LittleEndian.Uint32(header[12:16]))
-
-							if size > len(buf) {
-								buf = make([]byte, size)
-							}
-
-							t := tracks[track]
-
-							isKeyframe := MediaCodecBufferFlag(flags)&MediaCodecBufferFlagKeyFrame != 0
-
-							switch t.Codec.(type) {
-							case *mpegts.CodecH264, *mpegts.CodecH265:
-								mtw.WriteH26x(t, pts, pts, isKeyframe, [][]byte{buf})
-							case *mpegts.CodecMPEG4Audio:
-								mtw.WriteMPEG4Audio(t, pts, [][]byte{buf})
-							case *mpegts.CodecOpus:
-								mtw.WriteOpus(t, pts, [][]byte{buf})
-							}
-						}
-						return
-					}
-				}
-				w.WriteHeader(http.StatusNotFound)
-			} else {
-				w.WriteHeader(http.StatusNotFound)
+		}
+		if next := s.nextSequenceLocked(); msn > next+1 {
+			// Too far in the future to be worth holding the request.
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ready := func() bool {
+			if part < 0 {
+				return s.nextSequenceLocked() > msn
+			}
+			// A part is in the playlist once it's published or its segment
+			// has finished with fewer parts.
+			if s.current == nil || s.current.sequence < msn {
+				return false
 			}
-		}),
+			return s.current.sequence > msn || len(s.current.parts) > part
+		}
+		if !s.waitLocked(r, ready) && !s.ended {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if len(s.segments) == 0 && (s.current == nil || len(s.current.parts) == 0) {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
-	go server.ListenAndServe()
-	return &HLSSink{server: &server}, nil
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(s.playlistLocked())
 }
 
-func (s *HLSSink) Close() error {
-	return s.server.Shutdown(context.Background())
+// waitLocked holds a blocking request until ready reports true, the stream
+// ends or three target durations pass, and returns the final ready().
+func (s *HLSSink) waitLocked(r *http.Request, ready func() bool) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*s.targetDurationLocked())
+	defer cancel()
+	for !ready() && !s.ended && ctx.Err() == nil {
+		changed := s.changed
+		s.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+		}
+		s.Lock()
+	}
+	return ready()
+}
+
+// targetDurationLocked is the playlist's EXT-X-TARGETDURATION.
+func (s *HLSSink) targetDurationLocked() time.Duration {
+	return time.Duration(s.targetDuration) * time.Second
+}
+
+func (s *HLSSink) playlistLocked() []byte {
+	segments := s.segments
+	if s.playlistType == HLSPlaylistTypeLive && len(segments) > s.windowSize {
+		segments = segments[len(segments)-s.windowSize:]
+	}
+
+	version, ext := 3, ".ts"
+	if s.segmentFormat == HLSSegmentFormatFMP4 {
+		version, ext = 6, ".m4s"
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(s.targetDurationLocked().Seconds()))
+	if s.live != nil {
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", s.partTarget.Seconds())
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", (3 * s.partTarget).Seconds())
+	} else {
+		b.WriteString("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES\n")
+	}
+	if len(segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].sequence)
+	} else {
+		// Only parts of the first segment exist so far.
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", s.current.sequence)
+	}
+	if s.playlistType == HLSPlaylistTypeEvent {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+	if !s.cutBetweenKeyframes {
+		b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	if s.segmentFormat == HLSSegmentFormatFMP4 {
+		b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+	}
+	for _, seg := range segments {
+		writeHLSParts(&b, seg)
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", seg.duration.Seconds())
+		fmt.Fprintf(&b, "segment%d%s\n", seg.sequence, ext)
+	}
+	if s.current != nil && s.live != nil {
+		writeHLSParts(&b, *s.current)
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part%d.%d.m4s\"\n", s.current.sequence, len(s.current.parts))
+	}
+	if s.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.Bytes()
+}
+
+func writeHLSParts(b *bytes.Buffer, seg hlsSegment) {
+	for i, part := range seg.parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"part%d.%d.m4s\"", part.duration.Seconds(), seg.sequence, i)
+		if part.independent {
+			b.WriteString(",INDEPENDENT=YES")
+		}
+		b.WriteString("\n")
+	}
+}
+
+func (s *HLSSink) serveInit(w http.ResponseWriter) {
+	s.Lock()
+	if s.live != nil && s.live.started {
+		// Parts are muxed with the configuration the live muxer froze when
+		// it started.
+		init, err := s.live.initSegment()
+		s.Unlock()
+		if err != nil {
+			log.Printf("HLS: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(init)
+		return
+	}
+	s.Unlock()
+
+	muxer, err := s.newMuxer()
+	if err != nil {
+		log.Printf("HLS: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	init, err := muxer.initSegment()
+	if err != nil {
+		// No codec config yet.
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Write(init)
+}
+
+// newMuxer returns an fMP4 muxer primed with the codec configuration.
+func (s *HLSSink) newMuxer() (*fmp4Muxer, error) {
+	s.Lock()
+	config := slices.Clone(s.codecConfig)
+	origin := s.origin
+	s.Unlock()
+
+	muxer, err := newFMP4Muxer(s.tracks)
+	if err != nil {
+		return nil, err
+	}
+	for i, buf := range config {
+		if buf != nil {
+			// Only parameter sets are taken from in-band keyframes, since
+			// the muxer hasn't started.
+			if err := muxer.writeSample(i, buf, origin, MediaCodecBufferFlagCodecConfig); err != nil {
+				return nil, err
+			}
+		}
+	}
+	muxer.setOrigin(origin)
+	return muxer, nil
+}
+
+func (s *HLSSink) serveSegment(w http.ResponseWriter, sequence uint64) {
+	s.Lock()
+	i := slices.IndexFunc(s.segments, func(seg hlsSegment) bool { return seg.sequence == sequence })
+	var seg hlsSegment
+	if i >= 0 {
+		seg = s.segments[i]
+	}
+	s.Unlock()
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if seg.parts != nil {
+		// The parts are still cached, so serve the same bytes clients
+		// already fetched as parts.
+		var data []byte
+		for _, part := range seg.parts {
+			data = append(data, part.data...)
+		}
+		w.Header().Set("Content-Type", "video/iso.segment")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write(data)
+		return
+	}
+
+	samples, err := s.store.ReadSegment(seg.pts)
+	if err != nil {
+		log.Printf("HLS: failed to read segment %d: %v", sequence, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var data []byte
+	if s.segmentFormat == HLSSegmentFormatFMP4 {
+		w.Header().Set("Content-Type", "video/iso.segment")
+		data, err = s.muxFMP4(seg, samples)
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+		data, err = s.muxMPEGTS(samples)
+	}
+	if err != nil {
+		log.Printf("HLS: failed to mux segment %d: %v", sequence, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(data)
+}
+
+// servePart serves a partial segment. A request for the part the playlist
+// hints at is held until the part is published.
+func (s *HLSSink) servePart(w http.ResponseWriter, r *http.Request, sequence uint64, index int) {
+	s.Lock()
+	defer s.Unlock()
+
+	// find reports done once the part is known to exist or not.
+	find := func() (part *hlsPart, done bool) {
+		if s.current != nil && s.current.sequence == sequence {
+			if index < len(s.current.parts) {
+				return s.current.parts[index], true
+			}
+			return nil, false
+		}
+		i := slices.IndexFunc(s.segments, func(seg hlsSegment) bool { return seg.sequence == sequence })
+		if i >= 0 && index < len(s.segments[i].parts) {
+			return s.segments[i].parts[index], true
+		}
+		return nil, true
+	}
+	part, done := find()
+	if !done && index == len(s.current.parts) {
+		s.waitLocked(r, func() bool {
+			part, done = find()
+			return done
+		})
+	}
+	if part == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/iso.segment")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(part.data)
+}
+
+func (s *HLSSink) muxFMP4(seg hlsSegment, samples []*BinaryDumpSample) ([]byte, error) {
+	muxer, err := s.newMuxer()
+	if err != nil {
+		return nil, err
+	}
+	muxer.sequence = uint32(seg.sequence + 1)
+	if !seg.independent {
+		muxer.startAnywhere()
+	}
+	for _, sample := range samples {
+		if sample.Track < 0 || sample.Track >= len(s.tracks) {
+			continue
+		}
+		if err := muxer.writeSample(sample.Track, sample.Data, sample.PTS, sample.Flags); err != nil {
+			return nil, err
+		}
+	}
+	muxer.flush(seg.pts + seg.duration.Microseconds())
+	f, err := muxer.fragment(math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, fmt.Errorf("no samples")
+	}
+	return f.data, nil
+}
+
+func (s *HLSSink) muxMPEGTS(samples []*BinaryDumpSample) ([]byte, error) {
+	s.Lock()
+	config := slices.Clone(s.codecConfig)
+	s.Unlock()
+
+	tracks := make([]*mpegts.Track, len(s.tracks))
+	for i, t := range s.tracks {
+		codec := t.MPEGTSCodec()
+		if c, ok := codec.(*mpegts.CodecMPEG4Audio); ok && config[i] != nil {
+			var asc mpeg4audio.Config
+			if err := asc.Unmarshal(config[i]); err == nil {
+				c.Config = asc
+			}
+		}
+		tracks[i] = &mpegts.Track{Codec: codec}
+	}
+
+	var b bytes.Buffer
+	bw := bufio.NewWriter(&b)
+	mpw := mpegts.NewWriter(bw, tracks)
+	for _, sample := range samples {
+		if sample.Track < 0 || sample.Track >= len(tracks) || sample.Flags&MediaCodecBufferFlagCodecConfig != 0 {
+			continue
+		}
+		t := tracks[sample.Track]
+		pts := sample.PTS * 90000 / 1_000_000
+
+		var err error
+		switch t.Codec.(type) {
+		case *mpegts.CodecH264, *mpegts.CodecH265:
+			isKeyframe := sample.Flags&MediaCodecBufferFlagKeyFrame != 0
+			nalus := splitNALUs(sample.Data)
+			if isKeyframe && config[sample.Track] != nil {
+				// Decoders joining at this segment need the parameter sets.
+				nalus = append(splitNALUs(config[sample.Track]), nalus...)
+			}
+			if len(nalus) == 0 {
+				continue
+			}
+			err = mpw.WriteH26x(t, pts, pts, isKeyframe, nalus)
+		case *mpegts.CodecMPEG4Audio:
+			err = mpw.WriteMPEG4Audio(t, pts, [][]byte{sample.Data})
+		case *mpegts.CodecOpus:
+			err = mpw.WriteOpus(t, pts, [][]byte{sample.Data})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
 }
@@ -0,0 +1,468 @@
+package kinetic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/grafov/m3u8"
+)
+
+func newTestHLSSink(t *testing.T, opts ...HLSSinkOption) (*HLSSink, string) {
+	t.Helper()
+	store := NewBinaryDumpSink(t.TempDir())
+	sink, err := NewHLSSink(store, "127.0.0.1:0", "", string(MediaFormatMimeTypeVideoH264)+";"+string(MediaFormatMimeTypeAudioAAC), opts...)
+	if err != nil {
+		t.Fatalf("NewHLSSink: %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+
+	if err := sink.WriteSample(0, testAnnexB(testH264SPS, testH264PPS), 0, MediaCodecBufferFlagCodecConfig); err != nil {
+		t.Fatalf("WriteSample: %v", err)
+	}
+	if err := sink.WriteSample(1, []byte{0x11, 0x90}, 0, MediaCodecBufferFlagCodecConfig); err != nil {
+		t.Fatalf("WriteSample: %v", err)
+	}
+	return sink, fmt.Sprintf("http://%s", sink.Addr())
+}
+
+// writeTestGOPs writes one second GOPs of 30fps H.264 with 48kHz AAC,
+// starting at the given GOP index.
+func writeTestGOPs(t *testing.T, sink Sink, from, n int) {
+	t.Helper()
+	writeTestFrames(t, sink, from*30, (from+n)*30)
+}
+
+// writeTestFrames writes video frames [from, to) of the stream
+// writeTestGOPs produces, with the audio up to the last one.
+func writeTestFrames(t *testing.T, sink Sink, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		pts := int64(i) * 1_000_000 / 30
+		flags := MediaCodecBufferFlag(0)
+		nalu := []byte{0x41, 0x9a, byte(i)}
+		if i%30 == 0 {
+			flags = MediaCodecBufferFlagKeyFrame
+			nalu = []byte{0x65, 0x88, byte(i)}
+		}
+		if err := sink.WriteSample(0, testAnnexB(nalu), pts, flags); err != nil {
+			t.Fatalf("WriteSample video %d: %v", i, err)
+		}
+		next := int64(i+1) * 1_000_000 / 30
+		for k := (pts*48 + 1_023_999) / 1_024_000; k*1_024_000/48 < next; k++ {
+			audioPTS := k * 1_024_000 / 48
+			if err := sink.WriteSample(1, []byte{0x21, 0x00, byte(i)}, audioPTS, 0); err != nil {
+				t.Fatalf("WriteSample audio: %v", err)
+			}
+		}
+	}
+}
+
+func getTestHLS(t *testing.T, url string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	return resp.StatusCode, body
+}
+
+func getTestPlaylist(t *testing.T, url string) (*m3u8.MediaPlaylist, []*m3u8.MediaSegment) {
+	t.Helper()
+	status, body := getTestHLS(t, url)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, status)
+	}
+	return parseTestPlaylist(t, body)
+}
+
+func parseTestPlaylist(t *testing.T, body []byte) (*m3u8.MediaPlaylist, []*m3u8.MediaSegment) {
+	t.Helper()
+	p, listType, err := m3u8.DecodeFrom(bytes.NewReader(body), true)
+	if err != nil {
+		t.Fatalf("failed to parse playlist: %v\n%s", err, body)
+	}
+	if listType != m3u8.MEDIA {
+		t.Fatalf("got a master playlist:\n%s", body)
+	}
+	media := p.(*m3u8.MediaPlaylist)
+	var segments []*m3u8.MediaSegment
+	for _, seg := range media.Segments {
+		if seg != nil {
+			segments = append(segments, seg)
+		}
+	}
+	return media, segments
+}
+
+func TestHLSSinkLivePlaylist(t *testing.T) {
+	sink, base := newTestHLSSink(t, WithHLSWindowSize(3), WithHLSTargetDuration(time.Second))
+
+	if status, _ := getTestHLS(t, base+"/manifest.m3u8"); status != http.StatusNotFound {
+		t.Errorf("playlist before the first segment: status %d, want 404", status)
+	}
+
+	// The fifth keyframe closes the fourth segment.
+	writeTestGOPs(t, sink, 0, 5)
+
+	p, segments := getTestPlaylist(t, base+"/manifest.m3u8")
+	if p.SeqNo != 1 {
+		t.Errorf("EXT-X-MEDIA-SEQUENCE = %d, want 1", p.SeqNo)
+	}
+	if p.TargetDuration != 1 {
+		t.Errorf("EXT-X-TARGETDURATION = %v, want 1", p.TargetDuration)
+	}
+	if p.Closed {
+		t.Error("live playlist has EXT-X-ENDLIST")
+	}
+	if p.MediaType != 0 {
+		t.Errorf("live playlist has EXT-X-PLAYLIST-TYPE %d", p.MediaType)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	for i, seg := range segments {
+		if seg.Duration != 1 {
+			t.Errorf("segment %d EXTINF = %v, want 1", i, seg.Duration)
+		}
+		if want := fmt.Sprintf("segment%d.ts", i+1); seg.URI != want {
+			t.Errorf("segment %d URI = %q, want %q", i, seg.URI, want)
+		}
+	}
+
+	status, ts := getTestHLS(t, base+"/"+segments[2].URI)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status %d", segments[2].URI, status)
+	}
+	if len(ts) == 0 || len(ts)%188 != 0 {
+		t.Fatalf("segment is %d bytes, want a whole number of TS packets", len(ts))
+	}
+	for off := 0; off < len(ts); off += 188 {
+		if ts[off] != 0x47 {
+			t.Fatalf("missing sync byte at offset %d", off)
+		}
+	}
+
+	if status, _ := getTestHLS(t, base+"/segment4.ts"); status != http.StatusNotFound {
+		t.Errorf("segment still being recorded: status %d, want 404", status)
+	}
+}
+
+func TestHLSSinkEventPlaylistFMP4(t *testing.T) {
+	sink, base := newTestHLSSink(t, WithHLSPlaylistType(HLSPlaylistTypeEvent), WithHLSSegmentFormat(HLSSegmentFormatFMP4), WithHLSWindowSize(1))
+
+	writeTestGOPs(t, sink, 0, 3)
+	if err := sink.WriteSample(0, nil, 3_000_000, MediaCodecBufferFlagEndOfStream); err != nil {
+		t.Fatalf("WriteSample: %v", err)
+	}
+
+	p, segments := getTestPlaylist(t, base+"/manifest.m3u8")
+	if p.SeqNo != 0 {
+		t.Errorf("EXT-X-MEDIA-SEQUENCE = %d, want 0", p.SeqNo)
+	}
+	if p.MediaType != m3u8.EVENT {
+		t.Errorf("EXT-X-PLAYLIST-TYPE = %d, want EVENT", p.MediaType)
+	}
+	if !p.Closed {
+		t.Error("ended playlist has no EXT-X-ENDLIST")
+	}
+	if p.Map == nil || p.Map.URI != "init.mp4" {
+		t.Fatalf("EXT-X-MAP = %+v, want init.mp4", p.Map)
+	}
+	// An EVENT playlist ignores the window.
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+
+	status, init := getTestHLS(t, base+"/init.mp4")
+	if status != http.StatusOK {
+		t.Fatalf("GET init.mp4: status %d", status)
+	}
+	codec := unmarshalTestInit(t, init).Tracks[0].Codec
+	if _, ok := codec.(*fmp4.CodecH264); !ok {
+		t.Errorf("video codec = %T, want H.264", codec)
+	}
+
+	status, segment := getTestHLS(t, base+"/"+segments[1].URI)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status %d", segments[1].URI, status)
+	}
+	parts := unmarshalTestParts(t, segment)
+	if len(parts) != 1 {
+		t.Fatalf("segment isn't a single moof/mdat pair")
+	}
+	video := parts[0].Tracks[0]
+	if video.BaseTime != 90000 {
+		t.Errorf("second segment video tfdt = %d, want 90000", video.BaseTime)
+	}
+	if count := len(video.Samples); count != 30 {
+		t.Errorf("second segment has %d video samples, want 30", count)
+	}
+	var duration uint32
+	for _, sample := range video.Samples {
+		duration += sample.Duration
+	}
+	if duration != 90000 {
+		t.Errorf("second segment video duration = %d, want 90000", duration)
+	}
+
+	if err := sink.WriteSample(0, testAnnexB([]byte{0x65}), 4_000_000, MediaCodecBufferFlagKeyFrame); err == nil {
+		t.Error("expected an error writing after end of stream")
+	}
+}
+
+func TestHLSSinkCutsLongGOPs(t *testing.T) {
+	sink, base := newTestHLSSink(t, WithHLSPlaylistType(HLSPlaylistTypeEvent), WithHLSSegmentFormat(HLSSegmentFormatFMP4), WithHLSTargetDuration(time.Second))
+
+	// Three seconds of 30fps video with a single keyframe.
+	for i := 0; i < 90; i++ {
+		flags := MediaCodecBufferFlag(0)
+		nalu := []byte{0x41, 0x9a, byte(i)}
+		if i == 0 {
+			flags = MediaCodecBufferFlagKeyFrame
+			nalu = []byte{0x65, 0x88, byte(i)}
+		}
+		if err := sink.WriteSample(0, testAnnexB(nalu), int64(i)*1_000_000/30, flags); err != nil {
+			t.Fatalf("WriteSample video %d: %v", i, err)
+		}
+	}
+	if err := sink.WriteSample(0, nil, 3_000_000, MediaCodecBufferFlagEndOfStream); err != nil {
+		t.Fatalf("WriteSample: %v", err)
+	}
+
+	status, body := getTestHLS(t, base+"/manifest.m3u8")
+	if status != http.StatusOK {
+		t.Fatalf("GET manifest.m3u8: status %d", status)
+	}
+	p, segments := parseTestPlaylist(t, body)
+	if p.TargetDuration != 1 {
+		t.Errorf("EXT-X-TARGETDURATION = %v, want 1", p.TargetDuration)
+	}
+	if tags := testPlaylistTags(body, "#EXT-X-INDEPENDENT-SEGMENTS"); len(tags) != 0 {
+		t.Error("playlist cut between keyframes has EXT-X-INDEPENDENT-SEGMENTS")
+	}
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	for i, seg := range segments {
+		if seg.Duration != 1 {
+			t.Errorf("segment %d EXTINF = %v, want 1", i, seg.Duration)
+		}
+	}
+
+	// The segment without a keyframe still has all of its samples.
+	status, segment := getTestHLS(t, base+"/"+segments[1].URI)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status %d", segments[1].URI, status)
+	}
+	parts := unmarshalTestParts(t, segment)
+	if len(parts) != 1 {
+		t.Fatalf("segment isn't a single moof/mdat pair")
+	}
+	if count := len(parts[0].Tracks[0].Samples); count != 30 {
+		t.Errorf("second segment has %d video samples, want 30", count)
+	}
+}
+
+func TestNewHLSSinkRejectsInvalidTargetDuration(t *testing.T) {
+	store := NewBinaryDumpSink(t.TempDir())
+	if _, err := NewHLSSink(store, "127.0.0.1:0", "", string(MediaFormatMimeTypeVideoH264), WithHLSTargetDuration(0)); err == nil {
+		t.Fatal("expected an error for a zero target duration")
+	}
+}
+
+func TestHLSSinkBlockingReload(t *testing.T) {
+	sink, base := newTestHLSSink(t)
+	writeTestGOPs(t, sink, 0, 2)
+
+	type result struct {
+		resp *http.Response
+		err  error
+		at   time.Time
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(base + "/manifest.m3u8?_HLS_msn=1")
+		done <- result{resp, err, time.Now()}
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	wrote := time.Now()
+	writeTestGOPs(t, sink, 2, 1)
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("GET: %v", res.err)
+		}
+		defer res.resp.Body.Close()
+		if res.at.Before(wrote) {
+			t.Error("blocking request returned before segment 1 was available")
+		}
+		body, err := io.ReadAll(res.resp.Body)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		if _, segments := parseTestPlaylist(t, body); len(segments) != 2 {
+			t.Errorf("got %d segments, want 2", len(segments))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocking request didn't return")
+	}
+
+	if status, _ := getTestHLS(t, base+"/manifest.m3u8?_HLS_msn=10"); status != http.StatusBadRequest {
+		t.Errorf("far future _HLS_msn: status %d, want 400", status)
+	}
+}
+
+// testPlaylistTags returns the attributes of every tag with the given name,
+// since the m3u8 package doesn't parse the Low-Latency HLS tags.
+func testPlaylistTags(body []byte, tag string) []string {
+	var attrs []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if v, ok := strings.CutPrefix(line, tag+":"); ok {
+			attrs = append(attrs, v)
+		}
+	}
+	return attrs
+}
+
+func TestHLSSinkLowLatency(t *testing.T) {
+	sink, base := newTestHLSSink(t, WithHLSSegmentFormat(HLSSegmentFormatFMP4), WithHLSLowLatency(0))
+
+	// One full segment and the first 13 frames of the next, which make two
+	// 200ms parts with a third in progress.
+	writeTestFrames(t, sink, 0, 43)
+
+	status, body := getTestHLS(t, base+"/manifest.m3u8")
+	if status != http.StatusOK {
+		t.Fatalf("GET manifest.m3u8: status %d", status)
+	}
+	if got := testPlaylistTags(body, "#EXT-X-PART-INF"); len(got) != 1 || got[0] != "PART-TARGET=0.200" {
+		t.Errorf("EXT-X-PART-INF = %q, want PART-TARGET=0.200", got)
+	}
+	if got := testPlaylistTags(body, "#EXT-X-SERVER-CONTROL"); len(got) != 1 || got[0] != "CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600" {
+		t.Errorf("EXT-X-SERVER-CONTROL = %q", got)
+	}
+	parts := testPlaylistTags(body, "#EXT-X-PART")
+	if len(parts) != 7 {
+		t.Fatalf("got %d parts, want 7:\n%s", len(parts), body)
+	}
+	for i, part := range parts {
+		seq, index := i/5, i%5
+		want := fmt.Sprintf("DURATION=0.200,URI=\"part%d.%d.m4s\"", seq, index)
+		if index == 0 {
+			want += ",INDEPENDENT=YES"
+		}
+		if part != want {
+			t.Errorf("part %d = %q, want %q", i, part, want)
+		}
+	}
+	if got := testPlaylistTags(body, "#EXT-X-PRELOAD-HINT"); len(got) != 1 || got[0] != "TYPE=PART,URI=\"part1.2.m4s\"" {
+		t.Errorf("EXT-X-PRELOAD-HINT = %q, want part1.2.m4s", got)
+	}
+	if _, segments := parseTestPlaylist(t, body); len(segments) != 1 || segments[0].URI != "segment0.m4s" {
+		t.Fatalf("segments = %+v, want segment0.m4s", segments)
+	}
+
+	var whole []byte
+	for i := 0; i < 5; i++ {
+		status, part := getTestHLS(t, fmt.Sprintf("%s/part0.%d.m4s", base, i))
+		if status != http.StatusOK {
+			t.Fatalf("GET part0.%d.m4s: status %d", i, status)
+		}
+		parts := unmarshalTestParts(t, part)
+		if len(parts) != 1 {
+			t.Fatalf("part0.%d.m4s isn't a single moof/mdat pair", i)
+		}
+		if count := len(parts[0].Tracks[0].Samples); count != 6 {
+			t.Errorf("part0.%d.m4s has %d video samples, want 6", i, count)
+		}
+		whole = append(whole, part...)
+	}
+	if status, segment := getTestHLS(t, base+"/segment0.m4s"); status != http.StatusOK || !bytes.Equal(segment, whole) {
+		t.Errorf("segment0.m4s (status %d) isn't its parts concatenated", status)
+	}
+
+	// Both a playlist request for the next part and the hinted part itself
+	// block until the part is published.
+	type result struct {
+		status int
+		body   []byte
+		at     time.Time
+	}
+	get := func(url string, done chan<- result) {
+		resp, err := http.Get(url)
+		if err != nil {
+			done <- result{}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		done <- result{resp.StatusCode, body, time.Now()}
+	}
+	playlist := make(chan result, 1)
+	part := make(chan result, 1)
+	go get(base+"/manifest.m3u8?_HLS_msn=1&_HLS_part=2", playlist)
+	go get(base+"/part1.2.m4s", part)
+
+	time.Sleep(200 * time.Millisecond)
+	wrote := time.Now()
+	writeTestFrames(t, sink, 43, 49)
+
+	for _, done := range []chan result{playlist, part} {
+		select {
+		case res := <-done:
+			if res.status != http.StatusOK {
+				t.Fatalf("blocking request: status %d", res.status)
+			}
+			if res.at.Before(wrote) {
+				t.Error("blocking request returned before the part was available")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("blocking request didn't return")
+		}
+	}
+}
+
+func TestHLSSinkBearerToken(t *testing.T) {
+	store := NewBinaryDumpSink(t.TempDir())
+	sink, err := NewHLSSink(store, "127.0.0.1:0", "secret", string(MediaFormatMimeTypeVideoH264))
+	if err != nil {
+		t.Fatalf("NewHLSSink: %v", err)
+	}
+	defer sink.Close()
+
+	url := fmt.Sprintf("http://%s/manifest.m3u8", sink.Addr())
+	if status, _ := getTestHLS(t, url); status != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", status)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		t.Error("request with the token was rejected")
+	}
+}
+
+func TestNewHLSSinkRequiresFMP4ForAV1(t *testing.T) {
+	store := NewBinaryDumpSink(t.TempDir())
+	if _, err := NewHLSSink(store, "127.0.0.1:0", "", string(MediaFormatMimeTypeVideoAV1)); err == nil {
+		t.Fatal("expected an error for AV1 in MPEG-TS")
+	}
+}
@@ -8,8 +8,18 @@ import (
 	"github.com/pion/webrtc/v4"
 )
 
-type SampleWriter interface {
-	WriteSample(buf []byte, ptsMicroseconds int64) error
+// Sink is implemented by every output. Track i indexes into the
+// ;-separated mime type list the sink was created with: by convention track
+// 0 is video and tracks 1+ are audio.
+type Sink interface {
+	WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error
+	Close() error
+}
+
+// MediaFrame holds a single frame with timestamp, as read from a source
+type MediaFrame struct {
+	Data []byte
+	PTS  int64 // microseconds
 }
 
 type MediaFormatMimeType string
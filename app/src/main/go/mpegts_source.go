@@ -0,0 +1,228 @@
+package kinetic
+
+import (
+	"io"
+	"log"
+	"sync"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/pkg/codecs/opus"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+)
+
+// mpegtsSource demuxes the first video and audio tracks of an MPEG-TS stream
+// into frames. It's embedded by the sources that receive MPEG-TS, SRTSource
+// and RISTSource, which feed it with demux and close it with closeLocked.
+type mpegtsSource struct {
+	protocol string // Prefixes log messages
+
+	videoQueue chan *MediaFrame // Annex B for H.264/H.265
+	audioQueue chan *MediaFrame // Raw AAC frames (no ADTS) or Opus packets
+
+	// Detected codecs, empty until the publisher's PMT is read.
+	videoCodec MediaFormatMimeType
+	audioCodec MediaFormatMimeType
+
+	closed bool
+	mu     sync.RWMutex
+}
+
+func (s *mpegtsSource) init(protocol string) {
+	s.protocol = protocol
+	s.videoQueue = make(chan *MediaFrame, 60) // ~2 seconds of video at 30fps
+	s.audioQueue = make(chan *MediaFrame, 100)
+}
+
+// demux reads the stream from r into the queues until it ends.
+func (s *mpegtsSource) demux(r io.Reader) {
+	mr, err := mpegts.NewReader(mpegts.NewBufferedReader(r))
+	if err != nil {
+		if !s.IsClosed() {
+			log.Printf("%s: failed to read MPEG-TS tracks: %v\n", s.protocol, err)
+		}
+		return
+	}
+	mr.OnDecodeError(func(err error) {
+		log.Printf("%s: MPEG-TS decode error: %v\n", s.protocol, err)
+	})
+
+	// Timestamps are relative to the first one, and unwrapped past the 33
+	// bits MPEG-TS has.
+	var td *mpegts.TimeDecoder
+	decode := func(pts int64) int64 {
+		if td == nil {
+			td = mpegts.NewTimeDecoder(pts)
+		}
+		return td.Decode(pts).Microseconds()
+	}
+
+	var video, audio bool
+	for _, track := range mr.Tracks() {
+		switch codec := track.Codec.(type) {
+		case *mpegts.CodecH264, *mpegts.CodecH265:
+			if video {
+				continue
+			}
+			video = true
+			if _, ok := codec.(*mpegts.CodecH265); ok {
+				s.setVideoCodec(MediaFormatMimeTypeVideoH265)
+			} else {
+				s.setVideoCodec(MediaFormatMimeTypeVideoH264)
+			}
+			mr.OnDataH26x(track, func(pts int64, _ int64, au [][]byte) error {
+				// The H.264 Annex B format is the same for H.265.
+				frame, err := h264.AnnexBMarshal(au)
+				if err != nil {
+					log.Printf("%s: failed to marshal access unit: %v\n", s.protocol, err)
+					return nil
+				}
+				s.pushVideo(&MediaFrame{Data: frame, PTS: decode(pts)})
+				return nil
+			})
+
+		case *mpegts.CodecOpus:
+			if audio {
+				continue
+			}
+			audio = true
+			s.setAudioCodec(MediaFormatMimeTypeAudioOpus)
+			mr.OnDataOpus(track, func(pts int64, packets [][]byte) error {
+				t := decode(pts)
+				for _, packet := range packets {
+					s.pushAudio(&MediaFrame{Data: packet, PTS: t})
+					t += opus.PacketDuration(packet).Microseconds()
+				}
+				return nil
+			})
+
+		case *mpegts.CodecMPEG4Audio:
+			if audio || codec.SampleRate == 0 {
+				continue
+			}
+			audio = true
+			s.setAudioCodec(MediaFormatMimeTypeAudioAAC)
+			sampleRate := int64(codec.SampleRate)
+			mr.OnDataMPEG4Audio(track, func(pts int64, aus [][]byte) error {
+				t := decode(pts)
+				for i, au := range aus {
+					s.pushAudio(&MediaFrame{
+						Data: au,
+						PTS:  t + int64(i)*mpeg4audio.SamplesPerAccessUnit*1_000_000/sampleRate,
+					})
+				}
+				return nil
+			})
+		}
+	}
+	if !video && !audio {
+		log.Printf("%s: no supported tracks in the stream\n", s.protocol)
+		return
+	}
+
+	for {
+		if err := mr.Read(); err != nil {
+			if !s.IsClosed() {
+				log.Printf("%s: receive ended: %v\n", s.protocol, err)
+			}
+			return
+		}
+	}
+}
+
+// ReadVideoFrame reads the next video frame (blocking)
+// Returns nil when source is closed
+func (s *mpegtsSource) ReadVideoFrame() *MediaFrame {
+	frame, ok := <-s.videoQueue
+	if !ok {
+		return nil
+	}
+	return frame
+}
+
+// ReadAudioFrame reads the next audio frame (blocking)
+// Returns nil when source is closed
+func (s *mpegtsSource) ReadAudioFrame() *MediaFrame {
+	frame, ok := <-s.audioQueue
+	if !ok {
+		return nil
+	}
+	return frame
+}
+
+// VideoCodec returns the codec of the video track, or "" if it hasn't been
+// detected yet
+func (s *mpegtsSource) VideoCodec() MediaFormatMimeType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.videoCodec
+}
+
+// AudioCodec returns the codec of the audio track, or "" if it hasn't been
+// detected yet
+func (s *mpegtsSource) AudioCodec() MediaFormatMimeType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.audioCodec
+}
+
+func (s *mpegtsSource) setVideoCodec(codec MediaFormatMimeType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.videoCodec != codec {
+		log.Printf("%s: video codec %s\n", s.protocol, codec)
+		s.videoCodec = codec
+	}
+}
+
+func (s *mpegtsSource) setAudioCodec(codec MediaFormatMimeType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.audioCodec != codec {
+		log.Printf("%s: audio codec %s\n", s.protocol, codec)
+		s.audioCodec = codec
+	}
+}
+
+// pushVideo queues a video frame, dropping it if the queue is full. The lock
+// is held across the send so Close can't close the channel under us.
+func (s *mpegtsSource) pushVideo(frame *MediaFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.videoQueue <- frame:
+	default:
+		log.Printf("%s: video queue full, dropping frame\n", s.protocol)
+	}
+}
+
+// pushAudio queues an audio frame, dropping it if the queue is full.
+func (s *mpegtsSource) pushAudio(frame *MediaFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.audioQueue <- frame:
+	default:
+	}
+}
+
+// closeLocked marks the source closed and unblocks readers. The caller
+// holds mu and has checked it isn't closed already.
+func (s *mpegtsSource) closeLocked() {
+	s.closed = true
+	close(s.videoQueue)
+	close(s.audioQueue)
+}
+
+// IsClosed returns whether the source is closed
+func (s *mpegtsSource) IsClosed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closed
+}
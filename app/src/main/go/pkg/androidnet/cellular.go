@@ -0,0 +1,37 @@
+package androidnet
+
+import "strings"
+
+// cellularPrefixes are the interface names Android's modem drivers give
+// mobile data links, including the v4- CLAT interfaces that carry IPv4 over
+// IPv6-only carrier networks.
+var cellularPrefixes = []string{
+	"rmnet",     // Qualcomm
+	"rev_rmnet", // Qualcomm, reverse tethering
+	"ccmni",     // MediaTek
+	"seth_lte",  // Spreadtrum
+	"pdp",       // Exynos
+	"wwan",
+	"v4-rmnet",
+	"v4-ccmni",
+	"v4-seth_lte",
+	"v4-pdp",
+	"v4-wwan",
+}
+
+// IsCellular reports whether an interface name looks like a mobile data
+// link. getifaddrs doesn't report the link type, so this goes by the names
+// the common modem drivers use.
+func IsCellular(name string) bool {
+	for _, prefix := range cellularPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCellular reports whether the interface looks like a mobile data link.
+func (i *Interface) IsCellular() bool {
+	return IsCellular(i.Name)
+}
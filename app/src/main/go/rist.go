@@ -32,6 +32,45 @@ static int rist_sink_write(struct rist_ctx* ctx, const void* buf, size_t len) {
     block.payload_len = len;
     return rist_sender_data_write(ctx, &block);
 }
+
+extern void goRISTSenderStats(uintptr_t handle, struct rist_stats_sender_peer* stats);
+
+static int rist_stats_callback_go(void* arg, const struct rist_stats* stats) {
+    if (stats->stats_type == RIST_STATS_SENDER_PEER) {
+        goRISTSenderStats((uintptr_t)arg, (struct rist_stats_sender_peer*)&stats->stats.sender_peer);
+    }
+    rist_stats_free(stats);
+    return 0;
+}
+
+// Report stats every interval ms to the sink registered under handle.
+static int rist_set_stats_callback(struct rist_ctx* ctx, int interval, uintptr_t handle) {
+    return rist_stats_callback_set(ctx, interval, rist_stats_callback_go, (void*)handle);
+}
+
+extern void goRISTConnectionStatus(uintptr_t handle, int status);
+
+static void rist_connection_status_go(void* arg, struct rist_peer* peer, enum rist_connection_status status) {
+    goRISTConnectionStatus((uintptr_t)arg, (int)status);
+}
+
+// Report peers connecting and timing out to the sink registered under
+// handle.
+static int rist_set_connection_status_callback(struct rist_ctx* ctx, uintptr_t handle) {
+    return rist_connection_status_callback_set(ctx, rist_connection_status_go, (void*)handle);
+}
+
+extern void goRISTLog(int level, char* msg);
+
+static int rist_log_callback_go(void* arg, enum rist_log_level level, const char* msg) {
+    goRISTLog((int)level, (char*)msg);
+    return 0;
+}
+
+// Forward librist's messages up to level to Go's log package.
+static int rist_set_logging(struct rist_logging_settings** logging, enum rist_log_level level) {
+    return rist_logging_set(logging, level, rist_log_callback_go, NULL, NULL, NULL);
+}
 */
 import "C"
 import (
@@ -60,6 +99,55 @@ func ristProfileFromString(s string) C.enum_rist_profile {
 	}
 }
 
+// parseRISTURL checks a rist:// URL and splits off our own parameters,
+// returning the profile and the URL to hand to rist_parse_address2.
+func parseRISTURL(rawURL string) (C.enum_rist_profile, string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = redactRISTURL(urlErr.URL)
+		}
+		return 0, "", fmt.Errorf("RIST: failed to parse URL: %w", err)
+	}
+	if parsed.Scheme != "rist" {
+		return 0, "", fmt.Errorf("RIST: expected rist:// scheme, got %q", parsed.Scheme)
+	}
+
+	q := parsed.Query()
+	profile := ristProfileFromString(q.Get("profile"))
+	// `profile=` is our own URL extension; librist's parser doesn't know it
+	// and would reject the URL outright. Strip it before handing the URL to
+	// rist_parse_address2.
+	q.Del("profile")
+	parsed.RawQuery = q.Encode()
+	return profile, parsed.String(), nil
+}
+
+// newRISTPeerConfig parses a URL returned by parseRISTURL. The caller frees
+// the config with rist_peer_config_free2.
+func newRISTPeerConfig(peerURL string) (*C.struct_rist_peer_config, error) {
+	cURL := C.CString(peerURL)
+	defer C.free(unsafe.Pointer(cURL))
+
+	var peerCfg *C.struct_rist_peer_config
+	if rc := C.rist_parse_address2(cURL, &peerCfg); rc != 0 || peerCfg == nil {
+		return nil, fmt.Errorf("RIST: rist_parse_address2 failed: %d", int(rc))
+	}
+	return peerCfg, nil
+}
+
+// newRISTLogging returns the logging settings for a new context, which
+// librist requires, forwarding messages up to the level set by
+// SetRISTLogLevel. The caller frees them with rist_logging_settings_free2
+// after destroying the context.
+func newRISTLogging() (*C.struct_rist_logging_settings, error) {
+	var logging *C.struct_rist_logging_settings
+	if rc := C.rist_set_logging(&logging, C.enum_rist_log_level(ristLogLevel.Load())); rc != 0 {
+		return nil, fmt.Errorf("RIST: rist_logging_set failed: %d", int(rc))
+	}
+	return logging, nil
+}
+
 // ristCtxWriter adapts a librist sender context to io.Writer. Each Write is
 // chunked into MPEGTS_PACKET_SIZE-aligned blocks (the muxer's natural unit).
 type ristCtxWriter struct {
@@ -99,8 +187,40 @@ type RISTSink struct {
 	bw     *bufio.Writer
 	tracks []*mpegts.Track
 	closed bool
+
+	peers    []RISTPeerConfig // Besides the URL's
+	security *RISTSecurityConfig
+	relays   []*ristRelay // Of the peers pinned to an interface
+
+	// Stats and connection status are reported on librist threads, which
+	// Close joins while holding the sink's lock, so they have their own.
+	statsKey       uintptr
+	statsMu        sync.Mutex
+	peerStats      map[uint32]*ristPeerReport
+	connectedPeers int
+	peerTimedOut   bool // Since the last bandwidth probe
+
+	// AIMD bandwidth estimation state, also under statsMu
+	targetBitrate            int64
+	lastProbeTime            time.Time
+	lastLossTime             time.Time
+	lastPacketsSent          int64
+	lastPacketsRetransmitted int64
+}
+
+// RISTSinkOption configures the RIST sink
+type RISTSinkOption func(*RISTSink)
+
+// WithRISTSecurity encrypts or authenticates the connections to every peer,
+// see RISTSecurityConfig.
+func WithRISTSecurity(config RISTSecurityConfig) RISTSinkOption {
+	return func(s *RISTSink) {
+		s.security = &config
+	}
 }
 
+var _ Sink = (*RISTSink)(nil)
+
 var ristSinkCount int
 var ristSinkMu sync.Mutex
 
@@ -108,16 +228,14 @@ var ristSinkMu sync.Mutex
 // The URL is forwarded verbatim to librist's parser, so any query parameters
 // supported by `rist_parse_address2` (buffer=, bandwidth=, cname=, ...) are
 // honoured. The mimeTypes string is the same `;`-separated codec list used
-// by SRTSink.
-func NewRISTSink(rawURL, encodedMediaFormatMimeTypes string) (*RISTSink, error) {
-	log.Printf("RIST: creating sink for %s with mimeTypes %s", rawURL, encodedMediaFormatMimeTypes)
+// by SRTSink. Secrets in the URL are honoured too but WithRISTSecurity
+// keeps them out of the logs.
+func NewRISTSink(rawURL, encodedMediaFormatMimeTypes string, opts ...RISTSinkOption) (*RISTSink, error) {
+	log.Printf("RIST: creating sink for %s with mimeTypes %s", redactRISTURL(rawURL), encodedMediaFormatMimeTypes)
 
-	parsed, err := url.Parse(rawURL)
+	profile, _, err := parseRISTURL(rawURL)
 	if err != nil {
-		return nil, fmt.Errorf("RIST: failed to parse URL: %w", err)
-	}
-	if parsed.Scheme != "rist" {
-		return nil, fmt.Errorf("RIST: expected rist:// scheme, got %q", parsed.Scheme)
+		return nil, err
 	}
 
 	tracks := []*mpegts.Track{}
@@ -126,26 +244,23 @@ func NewRISTSink(rawURL, encodedMediaFormatMimeTypes string) (*RISTSink, error)
 		tracks = append(tracks, &mpegts.Track{Codec: codec})
 	}
 
-	q := parsed.Query()
-	profile := ristProfileFromString(q.Get("profile"))
-	// `profile=` is our own URL extension; librist's parser doesn't know it
-	// and would reject the URL outright. Strip it before handing the URL to
-	// rist_parse_address2.
-	q.Del("profile")
-	parsed.RawQuery = q.Encode()
-	cURL := C.CString(parsed.String())
-	defer C.free(unsafe.Pointer(cURL))
-
-	var peerCfg *C.struct_rist_peer_config
-	if rc := C.rist_parse_address2(cURL, &peerCfg); rc != 0 || peerCfg == nil {
-		return nil, fmt.Errorf("RIST: rist_parse_address2 failed: %d", int(rc))
+	sink := &RISTSink{
+		tracks:        tracks,
+		peerStats:     map[uint32]*ristPeerReport{},
+		targetBitrate: startBitrateBps,
+	}
+	for _, opt := range opts {
+		opt(sink)
+	}
+	if sink.security != nil {
+		if err := sink.security.validate(profile); err != nil {
+			return nil, err
+		}
 	}
-	defer C.rist_peer_config_free2(&peerCfg)
 
-	// Logging — librist will reject ctx creation if logging_settings is nil.
-	var logging *C.struct_rist_logging_settings
-	if rc := C.rist_logging_set(&logging, C.RIST_LOG_WARN, nil, nil, nil, nil); rc != 0 {
-		return nil, fmt.Errorf("RIST: rist_logging_set failed: %d", int(rc))
+	logging, err := newRISTLogging()
+	if err != nil {
+		return nil, err
 	}
 
 	var ctx *C.struct_rist_ctx
@@ -154,41 +269,60 @@ func NewRISTSink(rawURL, encodedMediaFormatMimeTypes string) (*RISTSink, error)
 		return nil, fmt.Errorf("RIST: rist_sender_create failed: %d", int(rc))
 	}
 
-	var peer *C.struct_rist_peer
-	if rc := C.rist_peer_create(ctx, &peer, peerCfg); rc != 0 {
+	for _, peer := range append([]RISTPeerConfig{{URL: rawURL}}, sink.peers...) {
+		relay, err := createRISTPeer(ctx, profile, peer, sink.security)
+		if err != nil {
+			C.rist_destroy(ctx)
+			C.rist_logging_settings_free2(&logging)
+			sink.closeRelays()
+			return nil, err
+		}
+		if relay != nil {
+			sink.relays = append(sink.relays, relay)
+		}
+	}
+
+	w := &ristCtxWriter{ctx: ctx, chunkSize: ristMPEGTSChunk}
+	sink.ctx = ctx
+	sink.logging = logging
+	sink.bw = bufio.NewWriterSize(w, ristMPEGTSChunk)
+	sink.mpw = mpegts.NewWriter(sink.bw, tracks)
+
+	sink.statsKey = registerRISTStatsSink(sink)
+	if rc := C.rist_set_stats_callback(ctx, ristStatsIntervalMs, C.uintptr_t(sink.statsKey)); rc != 0 {
+		unregisterRISTStatsSink(sink.statsKey)
 		C.rist_destroy(ctx)
 		C.rist_logging_settings_free2(&logging)
-		return nil, fmt.Errorf("RIST: rist_peer_create failed: %d", int(rc))
+		sink.closeRelays()
+		return nil, fmt.Errorf("RIST: rist_stats_callback_set failed: %d", int(rc))
+	}
+	if rc := C.rist_set_connection_status_callback(ctx, C.uintptr_t(sink.statsKey)); rc != 0 {
+		unregisterRISTStatsSink(sink.statsKey)
+		C.rist_destroy(ctx)
+		C.rist_logging_settings_free2(&logging)
+		sink.closeRelays()
+		return nil, fmt.Errorf("RIST: rist_connection_status_callback_set failed: %d", int(rc))
 	}
 
 	if rc := C.rist_start(ctx); rc != 0 {
+		unregisterRISTStatsSink(sink.statsKey)
 		C.rist_destroy(ctx)
 		C.rist_logging_settings_free2(&logging)
+		sink.closeRelays()
 		return nil, fmt.Errorf("RIST: rist_start failed: %d", int(rc))
 	}
 
-	w := &ristCtxWriter{ctx: ctx, chunkSize: ristMPEGTSChunk}
-	bw := bufio.NewWriterSize(w, ristMPEGTSChunk)
-
-	sink := &RISTSink{
-		ctx:     ctx,
-		logging: logging,
-		tracks:  tracks,
-		bw:      bw,
-		mpw:     mpegts.NewWriter(bw, tracks),
-	}
-
 	ristSinkMu.Lock()
 	ristSinkCount++
 	ristSinkMu.Unlock()
 
-	log.Printf("RIST: sink created with %d tracks", len(tracks))
+	log.Printf("RIST: sink created with %d tracks and %d peers", len(tracks), 1+len(sink.peers))
 	return sink, nil
 }
 
 // WriteSample mirrors SRTSink.WriteSample: stream index 0 is video, 1+ is
 // audio, and the data is MPEG-TS-muxed before being handed to librist.
-func (s *RISTSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, mediaCodecFlags int32) error {
+func (s *RISTSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
 	s.Lock()
 	defer s.Unlock()
 
@@ -204,7 +338,7 @@ func (s *RISTSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, mediaCo
 		return fmt.Errorf("RIST: track %d has nil codec", i)
 	}
 
-	isKeyframe := MediaCodecBufferFlag(mediaCodecFlags)&MediaCodecBufferFlagKeyFrame != 0
+	isKeyframe := flags&MediaCodecBufferFlagKeyFrame != 0
 	pts := int64((time.Duration(ptsMicroseconds) * time.Microsecond).Seconds() * 90000)
 
 	var err error
@@ -228,6 +362,15 @@ func (s *RISTSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, mediaCo
 	return s.bw.Flush()
 }
 
+// closeRelays closes the relays of pinned peers, once the context that sends
+// to them is destroyed.
+func (s *RISTSink) closeRelays() {
+	for _, relay := range s.relays {
+		relay.Close()
+	}
+	s.relays = nil
+}
+
 // Close tears down the librist context and frees its logging settings.
 func (s *RISTSink) Close() error {
 	s.Lock()
@@ -237,6 +380,7 @@ func (s *RISTSink) Close() error {
 	}
 	s.closed = true
 
+	unregisterRISTStatsSink(s.statsKey)
 	if s.ctx != nil {
 		C.rist_destroy(s.ctx)
 		s.ctx = nil
@@ -245,6 +389,7 @@ func (s *RISTSink) Close() error {
 		C.rist_logging_settings_free2(&s.logging)
 		s.logging = nil
 	}
+	s.closeRelays()
 
 	ristSinkMu.Lock()
 	ristSinkCount--
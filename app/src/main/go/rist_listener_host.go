@@ -48,7 +48,17 @@ type ristTestListener struct {
 // newRISTTestListener builds a librist receiver bound to 127.0.0.1:<port>.
 // The caller picks the port; that mirrors the URL the sink will connect to.
 func newRISTTestListener(port int) (*ristTestListener, error) {
-	listenURL := fmt.Sprintf("rist://@127.0.0.1:%d", port)
+	return listenRISTTest(fmt.Sprintf("rist://@127.0.0.1:%d", port))
+}
+
+// newRISTEncryptedTestListener returns a listener that decrypts the stream
+// with the secret, configured through the URL as other RIST tools do. The
+// secret must not need escaping.
+func newRISTEncryptedTestListener(port int, secret string) (*ristTestListener, error) {
+	return listenRISTTest(fmt.Sprintf("rist://@127.0.0.1:%d?secret=%s&aes-type=128", port, secret))
+}
+
+func listenRISTTest(listenURL string) (*ristTestListener, error) {
 	cURL := C.CString(listenURL)
 	defer C.free(unsafe.Pointer(cURL))
 
@@ -0,0 +1,58 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <librist/librist.h>
+*/
+import "C"
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// RISTLogLevel is how verbose librist's messages in the log are.
+type RISTLogLevel int
+
+const (
+	RISTLogDisable RISTLogLevel = C.RIST_LOG_DISABLE
+	RISTLogError   RISTLogLevel = C.RIST_LOG_ERROR
+	RISTLogWarn    RISTLogLevel = C.RIST_LOG_WARN
+	RISTLogNotice  RISTLogLevel = C.RIST_LOG_NOTICE
+	RISTLogInfo    RISTLogLevel = C.RIST_LOG_INFO
+	RISTLogDebug   RISTLogLevel = C.RIST_LOG_DEBUG
+)
+
+func (l RISTLogLevel) String() string {
+	switch l {
+	case RISTLogError:
+		return "error"
+	case RISTLogWarn:
+		return "warning"
+	case RISTLogNotice:
+		return "notice"
+	case RISTLogInfo:
+		return "info"
+	case RISTLogDebug:
+		return "debug"
+	}
+	return "disabled"
+}
+
+var ristLogLevel atomic.Int32
+
+func init() {
+	ristLogLevel.Store(int32(RISTLogWarn))
+}
+
+// SetRISTLogLevel sets the most verbose librist messages logged by the RIST
+// sinks and sources created afterwards, RISTLogWarn by default.
+func SetRISTLogLevel(level RISTLogLevel) {
+	ristLogLevel.Store(int32(level))
+}
+
+//export goRISTLog
+func goRISTLog(level C.int, msg *C.char) {
+	log.Printf("RIST: [%s] %s\n", RISTLogLevel(level), strings.TrimSpace(C.GoString(msg)))
+}
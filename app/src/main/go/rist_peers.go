@@ -0,0 +1,149 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <librist/librist.h>
+*/
+import "C"
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/kevmo314/kinetic/pkg/androidnet"
+)
+
+// RISTPeerConfig is an extra peer of a RISTSink, e.g. the same receiver
+// reached over another link. The sink sends to all its peers from one
+// librist context: peers with zero weight get every packet, so the receiver
+// can keep the first copy for seamless redundancy in the manner of SMPTE
+// 2022-7, and the rest share the packets in proportion to their weights.
+// It's JSON encoded when passed over JNI.
+type RISTPeerConfig struct {
+	// URL is a rist:// URL like the sink's. The sink's profile= applies to
+	// every peer, so it's ignored here.
+	URL string `json:"url"`
+
+	// Weight is zero to get every packet, or the peer's share of them.
+	// Overrides the URL's weight= parameter if set.
+	Weight int `json:"weight,omitempty"`
+
+	// Interface pins the peer to the local interface with this name, e.g.
+	// "rmnet_data0", by sending from the interface's address. The interface
+	// must be up and have an address in the receiver's family. A multicast
+	// peer is pinned with librist's miface setting instead.
+	Interface string `json:"interface,omitempty"`
+}
+
+// WithRISTPeers adds peers besides the sink's URL, see RISTPeerConfig.
+func WithRISTPeers(peers ...RISTPeerConfig) RISTSinkOption {
+	return func(s *RISTSink) {
+		s.peers = append(s.peers, peers...)
+	}
+}
+
+// ristInterfaceAddress returns the address of the interface to pin a peer
+// to, in the family of the receiver's address ip.
+func ristInterfaceAddress(name string, ip net.IP) (net.IP, error) {
+	iface, err := androidnet.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("RIST: interface %s: %w", name, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("RIST: interface %s is down", name)
+	}
+	addrs, _ := iface.Addrs()
+	for _, addr := range addrs {
+		a, ok := addr.(*net.IPAddr)
+		if !ok || a.IP.IsLinkLocalUnicast() || (a.IP.To4() != nil) != (ip.To4() != nil) {
+			continue
+		}
+		return a.IP, nil
+	}
+	return nil, fmt.Errorf("RIST: interface %s has no address to reach %s", name, ip)
+}
+
+// pinRISTPeer points a peer's URL at a relay that sends from the
+// interface's address, see ristRelay. A multicast peer keeps its URL and is
+// returned without a relay, to be pinned with miface.
+func pinRISTPeer(peerURL, iface string, profile C.enum_rist_profile) (string, *ristRelay, error) {
+	parsed, err := url.Parse(peerURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("RIST: failed to parse URL: %w", err)
+	}
+	if parsed.User != nil {
+		return "", nil, fmt.Errorf("RIST: can't pin listening peer %s to an interface", redactRISTURL(peerURL))
+	}
+	dst, err := net.ResolveUDPAddr("udp", parsed.Host)
+	if err != nil {
+		return "", nil, fmt.Errorf("RIST: failed to resolve %s: %w", parsed.Host, err)
+	}
+	if dst.IP.IsMulticast() {
+		if len(iface) >= C.RIST_MAX_STRING_SHORT {
+			return "", nil, fmt.Errorf("RIST: interface name %q too long", iface)
+		}
+		return peerURL, nil, nil
+	}
+	ip, err := ristInterfaceAddress(iface, dst.IP)
+	if err != nil {
+		return "", nil, err
+	}
+	ports := 1
+	if profile == C.RIST_PROFILE_SIMPLE {
+		ports = 2
+	}
+	relay, err := newRISTRelay(ip, dst, ports)
+	if err != nil {
+		return "", nil, err
+	}
+	parsed.Host = fmt.Sprintf("127.0.0.1:%d", relay.port)
+	return parsed.String(), relay, nil
+}
+
+// createRISTPeer adds a peer to a sender context, with the sink's security
+// config if it has one. A peer pinned to an interface comes with a relay
+// for the caller to close after destroying the context.
+func createRISTPeer(ctx *C.struct_rist_ctx, profile C.enum_rist_profile, peer RISTPeerConfig, security *RISTSecurityConfig) (*ristRelay, error) {
+	if peer.Weight < 0 {
+		return nil, fmt.Errorf("RIST: negative weight %d for peer %s", peer.Weight, redactRISTURL(peer.URL))
+	}
+
+	_, peerURL, err := parseRISTURL(peer.URL)
+	if err != nil {
+		return nil, err
+	}
+	var relay *ristRelay
+	if peer.Interface != "" {
+		if peerURL, relay, err = pinRISTPeer(peerURL, peer.Interface, profile); err != nil {
+			return nil, err
+		}
+	}
+	peerCfg, err := newRISTPeerConfig(peerURL)
+	if err != nil {
+		if relay != nil {
+			relay.Close()
+		}
+		return nil, err
+	}
+	defer C.rist_peer_config_free2(&peerCfg)
+
+	if peer.Weight > 0 {
+		peerCfg.weight = C.uint32_t(peer.Weight)
+	}
+	if peer.Interface != "" && relay == nil {
+		setRISTConfigString(peerCfg.miface[:], peer.Interface)
+	}
+	if security != nil {
+		security.apply(peerCfg)
+	}
+
+	var p *C.struct_rist_peer
+	if rc := C.rist_peer_create(ctx, &p, peerCfg); rc != 0 {
+		if relay != nil {
+			relay.Close()
+		}
+		return nil, fmt.Errorf("RIST: rist_peer_create failed for %s: %d", redactRISTURL(peer.URL), int(rc))
+	}
+	return relay, nil
+}
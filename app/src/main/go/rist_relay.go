@@ -0,0 +1,138 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+)
+
+// ristRelay pins a peer to a local interface. librist can only pin a
+// unicast peer by device name, which needs CAP_NET_RAW, so the peer is
+// pointed at the relay on loopback instead and the relay forwards its
+// packets from a socket bound to the interface's address, as SRT bonding
+// binds its links.
+type ristRelay struct {
+	port  int // on loopback, of the first link
+	links []*ristRelayLink
+}
+
+// ristRelayLink relays one of the peer's ports. The Simple profile sends
+// RTCP on the port after RTP's, so it needs two.
+type ristRelayLink struct {
+	local  *net.UDPConn // on loopback, where librist sends
+	remote *net.UDPConn // bound to the interface, connected to the receiver
+
+	// The librist socket that sent last, which gets the receiver's replies.
+	peer atomic.Pointer[net.UDPAddr]
+}
+
+// ristRelayAttempts bounds the search for consecutive free loopback ports.
+const ristRelayAttempts = 16
+
+// newRISTRelay relays ports consecutive ports of dst from the local
+// address ip.
+func newRISTRelay(ip net.IP, dst *net.UDPAddr, ports int) (*ristRelay, error) {
+	locals, err := listenRISTRelayPorts(ports)
+	if err != nil {
+		return nil, err
+	}
+	r := &ristRelay{port: locals[0].LocalAddr().(*net.UDPAddr).Port}
+	for k, local := range locals {
+		remote, err := net.DialUDP("udp", &net.UDPAddr{IP: ip}, &net.UDPAddr{IP: dst.IP, Port: dst.Port + k, Zone: dst.Zone})
+		if err != nil {
+			for _, l := range locals[k:] {
+				l.Close()
+			}
+			r.Close()
+			return nil, fmt.Errorf("RIST: failed to bind to %s: %w", ip, err)
+		}
+		r.links = append(r.links, &ristRelayLink{local: local, remote: remote})
+	}
+	for _, link := range r.links {
+		go link.forward()
+		go link.reply()
+	}
+	return r, nil
+}
+
+// listenRISTRelayPorts listens on n consecutive loopback ports, the first
+// of them even as RTP expects.
+func listenRISTRelayPorts(n int) ([]*net.UDPConn, error) {
+	for attempt := 0; attempt < ristRelayAttempts; attempt++ {
+		first, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return nil, fmt.Errorf("RIST: failed to listen on loopback: %w", err)
+		}
+		conns := []*net.UDPConn{first}
+		port := first.LocalAddr().(*net.UDPAddr).Port
+		if n > 1 && port%2 != 0 {
+			first.Close()
+			continue
+		}
+		for k := 1; k < n; k++ {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + k})
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		if len(conns) == n {
+			return conns, nil
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	return nil, fmt.Errorf("RIST: no %d consecutive free loopback ports", n)
+}
+
+// forward sends librist's packets on to the receiver until the relay is
+// closed.
+func (l *ristRelayLink) forward() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := l.local.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("RIST: relay read failed: %v", err)
+			}
+			return
+		}
+		l.peer.Store(addr)
+		// A send fails while the interface is down, which librist sees as
+		// loss like any other.
+		_, _ = l.remote.Write(buf[:n])
+	}
+}
+
+// reply sends the receiver's packets back to librist until the relay is
+// closed.
+func (l *ristRelayLink) reply() {
+	buf := make([]byte, 65536)
+	for {
+		n, err := l.remote.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// e.g. ICMP port unreachable before the receiver is up.
+			continue
+		}
+		if peer := l.peer.Load(); peer != nil {
+			_, _ = l.local.WriteToUDP(buf[:n], peer)
+		}
+	}
+}
+
+// Close stops relaying. librist's context should be destroyed first.
+func (r *ristRelay) Close() error {
+	for _, link := range r.links {
+		link.local.Close()
+		link.remote.Close()
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <librist/librist.h>
+*/
+import "C"
+import (
+	"fmt"
+	"regexp"
+)
+
+// RISTSecurityConfig configures encryption and authentication of RIST
+// peers. It's kept out of the URL so the secrets aren't logged, and is JSON
+// encoded when passed over JNI. The Simple profile supports neither.
+type RISTSecurityConfig struct {
+	// Secret is the pre-shared key the Main and Advanced profiles encrypt
+	// the stream with, the same on both peers. Empty leaves the stream
+	// unencrypted.
+	Secret string `json:"secret"`
+
+	// KeySize is 128 or 256 bits of AES. Zero uses 128 if there's a secret.
+	KeySize int `json:"keySize,omitempty"`
+
+	// KeyRotation is how many packets are sent with a key derived from the
+	// secret before deriving a new one. Zero uses librist's default.
+	KeyRotation int `json:"keyRotation,omitempty"`
+
+	// SRPUsername and SRPPassword authenticate the peer to a receiver with
+	// EAP-SRP, which then only accepts peers in its SRP file. Both or
+	// neither must be set.
+	SRPUsername string `json:"srpUsername,omitempty"`
+	SRPPassword string `json:"srpPassword,omitempty"`
+}
+
+// String redacts the secret and SRP password, so the config can be logged.
+func (c RISTSecurityConfig) String() string {
+	return fmt.Sprintf("{Secret:%s KeySize:%d KeyRotation:%d SRPUsername:%s SRPPassword:%s}",
+		redactRISTSecret(c.Secret), c.KeySize, c.KeyRotation, c.SRPUsername, redactRISTSecret(c.SRPPassword))
+}
+
+func (c *RISTSecurityConfig) validate(profile C.enum_rist_profile) error {
+	if profile == C.RIST_PROFILE_SIMPLE && (c.Secret != "" || c.SRPUsername != "") {
+		return fmt.Errorf("RIST: the simple profile supports neither encryption nor authentication")
+	}
+	if len(c.Secret) >= C.RIST_MAX_STRING_SHORT {
+		return fmt.Errorf("RIST: secret must be under %d characters", C.RIST_MAX_STRING_SHORT)
+	}
+	switch c.KeySize {
+	case 0, 128, 256:
+	default:
+		return fmt.Errorf("RIST: key size must be 128 or 256 bits, not %d", c.KeySize)
+	}
+	if c.KeyRotation < 0 {
+		return fmt.Errorf("RIST: negative key rotation %d", c.KeyRotation)
+	}
+	if c.Secret == "" && (c.KeySize != 0 || c.KeyRotation != 0) {
+		return fmt.Errorf("RIST: key size or rotation set without a secret")
+	}
+	if (c.SRPUsername == "") != (c.SRPPassword == "") {
+		return fmt.Errorf("RIST: SRP username and password must be set together")
+	}
+	if len(c.SRPUsername) >= C.RIST_MAX_STRING_LONG || len(c.SRPPassword) >= C.RIST_MAX_STRING_LONG {
+		return fmt.Errorf("RIST: SRP username and password must be under %d characters", C.RIST_MAX_STRING_LONG)
+	}
+	return nil
+}
+
+// apply replaces the security settings parsed from a peer's URL with the
+// config's, which must be valid.
+func (c *RISTSecurityConfig) apply(peerCfg *C.struct_rist_peer_config) {
+	setRISTConfigString(peerCfg.secret[:], c.Secret)
+	peerCfg.key_size = 0
+	if c.Secret != "" {
+		peerCfg.key_size = 128
+		if c.KeySize != 0 {
+			peerCfg.key_size = C.int(c.KeySize)
+		}
+	}
+	peerCfg.key_rotation = C.uint32_t(c.KeyRotation)
+	setRISTConfigString(peerCfg.srp_username[:], c.SRPUsername)
+	setRISTConfigString(peerCfg.srp_password[:], c.SRPPassword)
+}
+
+// setRISTConfigString copies s into a NUL-terminated string field of a
+// librist config, truncating it to fit and zeroing the rest.
+func setRISTConfigString(dst []C.char, s string) {
+	clear(dst)
+	for i := 0; i < len(s) && i < len(dst)-1; i++ {
+		dst[i] = C.char(s[i])
+	}
+}
+
+// ristSecretParam matches the parameters of rist_parse_address2 that carry
+// a secret: the pre-shared key and the SRP password.
+var ristSecretParam = regexp.MustCompile(`(?i)([?&](?:secret|password)=)[^&#]*`)
+
+// redactRISTURL hides the secrets in a rist:// URL, for logging.
+func redactRISTURL(s string) string {
+	return ristSecretParam.ReplaceAllString(s, "${1}REDACTED")
+}
+
+func redactRISTSecret(s string) string {
+	if s != "" {
+		return "REDACTED"
+	}
+	return s
+}
@@ -0,0 +1,158 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <librist/librist.h>
+*/
+import "C"
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// ristReadTimeoutMs bounds how long a read waits for data, and so how long
+// Close waits for the receiving goroutine.
+const ristReadTimeoutMs = 100
+
+// RISTSource receives an MPEG-TS stream over RIST, e.g. a contribution feed
+// from a remote encoder, and demuxes its first video and audio tracks into
+// frames. With rist://@host:port it listens for senders; with
+// rist://host:port it connects to a sender listening there. librist
+// recovers from dropped connections itself, so the source stays open until
+// closed.
+type RISTSource struct {
+	mpegtsSource
+
+	ctx     *C.struct_rist_ctx
+	logging *C.struct_rist_logging_settings
+	done    chan struct{} // Closed when receiving stops
+
+	security *RISTSecurityConfig
+}
+
+// RISTSourceOption configures the RIST source
+type RISTSourceOption func(*RISTSource)
+
+// WithRISTSourceSecurity decrypts the stream or authenticates to the
+// sender, see RISTSecurityConfig.
+func WithRISTSourceSecurity(config RISTSecurityConfig) RISTSourceOption {
+	return func(s *RISTSource) {
+		s.security = &config
+	}
+}
+
+// ristBlockReader reads the data blocks of a librist receiver, normally
+// seven TS packets each, until the source is closed.
+type ristBlockReader struct {
+	s    *RISTSource
+	rest []byte // Of a block larger than the last Read's buffer
+}
+
+func (r *ristBlockReader) Read(p []byte) (int, error) {
+	for len(r.rest) == 0 {
+		if r.s.IsClosed() {
+			return 0, io.EOF
+		}
+		var block *C.struct_rist_data_block
+		rc := C.rist_receiver_data_read2(r.s.ctx, &block, ristReadTimeoutMs)
+		if rc < 0 {
+			return 0, fmt.Errorf("rist_receiver_data_read2 failed: %d", int(rc))
+		}
+		if rc == 0 || block == nil {
+			continue
+		}
+		if block.payload != nil && block.payload_len > 0 {
+			r.rest = C.GoBytes(block.payload, C.int(block.payload_len))
+		}
+		C.rist_receiver_data_block_free2(&block)
+	}
+	n := copy(p, r.rest)
+	r.rest = r.rest[n:]
+	return n, nil
+}
+
+// NewRISTSource creates a source that receives MPEG-TS over RIST from the
+// URL. Like NewRISTSink, the profile= parameter picks the RIST profile and
+// the rest are handed to librist. H.264 and H.265 video and AAC and Opus
+// audio are supported.
+func NewRISTSource(rawURL string, opts ...RISTSourceOption) (*RISTSource, error) {
+	log.Printf("RIST: creating source for %s", redactRISTURL(rawURL))
+
+	profile, peerURL, err := parseRISTURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &RISTSource{done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(source)
+	}
+	if source.security != nil {
+		if err := source.security.validate(profile); err != nil {
+			return nil, err
+		}
+	}
+
+	peerCfg, err := newRISTPeerConfig(peerURL)
+	if err != nil {
+		return nil, err
+	}
+	defer C.rist_peer_config_free2(&peerCfg)
+	if source.security != nil {
+		source.security.apply(peerCfg)
+	}
+
+	logging, err := newRISTLogging()
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx *C.struct_rist_ctx
+	if rc := C.rist_receiver_create(&ctx, profile, logging); rc != 0 {
+		C.rist_logging_settings_free2(&logging)
+		return nil, fmt.Errorf("RIST: rist_receiver_create failed: %d", int(rc))
+	}
+
+	var peer *C.struct_rist_peer
+	if rc := C.rist_peer_create(ctx, &peer, peerCfg); rc != 0 {
+		C.rist_destroy(ctx)
+		C.rist_logging_settings_free2(&logging)
+		return nil, fmt.Errorf("RIST: rist_peer_create failed: %d", int(rc))
+	}
+
+	if rc := C.rist_start(ctx); rc != 0 {
+		C.rist_destroy(ctx)
+		C.rist_logging_settings_free2(&logging)
+		return nil, fmt.Errorf("RIST: rist_start failed: %d", int(rc))
+	}
+
+	source.ctx = ctx
+	source.logging = logging
+	source.init("RIST")
+	go func() {
+		source.demux(&ristBlockReader{s: source})
+		close(source.done)
+		source.Close()
+	}()
+
+	log.Printf("RIST: source created")
+	return source, nil
+}
+
+// Close closes the source, waiting for the receiving goroutine to notice
+// before tearing down the librist context.
+func (s *RISTSource) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closeLocked()
+	s.mu.Unlock()
+
+	<-s.done
+	C.rist_destroy(s.ctx)
+	C.rist_logging_settings_free2(&s.logging)
+}
@@ -0,0 +1,230 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <stdint.h>
+#include <librist/librist.h>
+*/
+import "C"
+import (
+	"cmp"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ristStatsIntervalMs is how often librist reports a sink's stats.
+const ristStatsIntervalMs = 1000
+
+// RISTConnectionStats is a snapshot of one peer's connection, as of
+// librist's last report. Packet counts are totals since the peer was
+// created.
+type RISTConnectionStats struct {
+	RTTMs             float64 `json:"rttMs"`
+	BandwidthBps      int64   `json:"bandwidthBps"`
+	RetryBandwidthBps int64   `json:"retryBandwidthBps"` // Spent on retransmissions
+
+	// Quality is the percentage of packets delivered without being
+	// retransmitted.
+	Quality float64 `json:"quality"`
+
+	PacketsSent          int64 `json:"packetsSent"`
+	PacketsReceived      int64 `json:"packetsReceived"` // RTCP from the receiver
+	PacketsRetransmitted int64 `json:"packetsRetransmitted"`
+}
+
+// add sums o into s, keeping the higher RTT and lower quality.
+func (s *RISTConnectionStats) add(o RISTConnectionStats) {
+	s.RTTMs = max(s.RTTMs, o.RTTMs)
+	s.BandwidthBps += o.BandwidthBps
+	s.RetryBandwidthBps += o.RetryBandwidthBps
+	s.Quality = min(s.Quality, o.Quality)
+	s.PacketsSent += o.PacketsSent
+	s.PacketsReceived += o.PacketsReceived
+	s.PacketsRetransmitted += o.PacketsRetransmitted
+}
+
+// RISTPeerStats describes one peer of a RISTSink. Peer IDs are librist's,
+// which numbers peers as they're created, starting with the sink's URL, and
+// again when a peer reconnects.
+type RISTPeerStats struct {
+	PeerID uint32 `json:"peerID"`
+	CNAME  string `json:"cname,omitempty"` // The receiver's
+
+	RISTConnectionStats
+}
+
+// RISTStats is a snapshot of a RISTSink's connection health. It's JSON
+// encoded when passed over JNI.
+type RISTStats struct {
+	// The sum of the peers' stats, with the highest RTT and lowest quality.
+	RISTConnectionStats
+
+	Connected        bool  `json:"connected"`        // To at least one peer
+	TargetBitrateBps int64 `json:"targetBitrateBps"` // See GetStatsAndBandwidth
+
+	Peers []RISTPeerStats `json:"peers,omitempty"`
+}
+
+// ristPeerReport is a peer's last reported stats.
+type ristPeerReport struct {
+	stats RISTPeerStats
+	at    time.Time
+}
+
+// ristPeerTimeout is how long a peer that librist stopped reporting on, e.g.
+// after it reconnected under a new ID, stays in the stats.
+const ristPeerTimeout = 5 * ristStatsIntervalMs * time.Millisecond
+
+// ristRetransmitThreshold is the share of packets retransmitted over a
+// probe interval that GetStatsAndBandwidth takes as congestion.
+const ristRetransmitThreshold = 0.02
+
+// Like SRT access callbacks, the stats and connection status callbacks get
+// a registry key rather than a pointer to the sink.
+var (
+	ristStatsSinks   sync.Map // uintptr -> *RISTSink
+	ristStatsSinkKey atomic.Uintptr
+)
+
+func registerRISTStatsSink(sink *RISTSink) uintptr {
+	key := ristStatsSinkKey.Add(1)
+	ristStatsSinks.Store(key, sink)
+	return key
+}
+
+func unregisterRISTStatsSink(key uintptr) {
+	ristStatsSinks.Delete(key)
+}
+
+//export goRISTSenderStats
+func goRISTSenderStats(key C.uintptr_t, stats *C.struct_rist_stats_sender_peer) {
+	sink, ok := ristStatsSinks.Load(uintptr(key))
+	if !ok {
+		return
+	}
+	s := sink.(*RISTSink)
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.peerStats[uint32(stats.peer_id)] = &ristPeerReport{
+		stats: RISTPeerStats{
+			PeerID: uint32(stats.peer_id),
+			CNAME:  C.GoString(&stats.cname[0]),
+			RISTConnectionStats: RISTConnectionStats{
+				RTTMs:                float64(stats.rtt),
+				BandwidthBps:         int64(stats.bandwidth),
+				RetryBandwidthBps:    int64(stats.retry_bandwidth),
+				Quality:              float64(stats.quality),
+				PacketsSent:          int64(stats.sent),
+				PacketsReceived:      int64(stats.received),
+				PacketsRetransmitted: int64(stats.retransmitted),
+			},
+		},
+		at: time.Now(),
+	}
+}
+
+//export goRISTConnectionStatus
+func goRISTConnectionStatus(key C.uintptr_t, status C.int) {
+	sink, ok := ristStatsSinks.Load(uintptr(key))
+	if !ok {
+		return
+	}
+	s := sink.(*RISTSink)
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	switch status {
+	case C.RIST_CONNECTION_ESTABLISHED, C.RIST_CLIENT_CONNECTED:
+		s.connectedPeers++
+		log.Printf("RIST: peer connected, %d connected\n", s.connectedPeers)
+	case C.RIST_CONNECTION_TIMED_OUT, C.RIST_CLIENT_TIMED_OUT:
+		// librist keeps trying to reconnect the peer.
+		s.connectedPeers = max(s.connectedPeers-1, 0)
+		s.peerTimedOut = true
+		log.Printf("RIST: peer timed out, %d connected\n", s.connectedPeers)
+	}
+}
+
+// Stats returns the sink's stats as of librist's last report, zero until
+// the first one.
+func (s *RISTSink) Stats() RISTStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.statsLocked()
+}
+
+func (s *RISTSink) statsLocked() RISTStats {
+	stats := RISTStats{
+		Connected:        s.connectedPeers > 0,
+		TargetBitrateBps: s.targetBitrate,
+	}
+	for id, report := range s.peerStats {
+		if time.Since(report.at) > ristPeerTimeout {
+			delete(s.peerStats, id)
+			continue
+		}
+		if len(stats.Peers) == 0 {
+			stats.RISTConnectionStats = report.stats.RISTConnectionStats
+		} else {
+			stats.add(report.stats.RISTConnectionStats)
+		}
+		stats.Peers = append(stats.Peers, report.stats)
+	}
+	slices.SortFunc(stats.Peers, func(a, b RISTPeerStats) int { return cmp.Compare(a.PeerID, b.PeerID) })
+	return stats
+}
+
+// GetStatsAndBandwidth returns the estimated target bandwidth in bits per
+// second, with the same AIMD algorithm as SRTSink's. Since RIST recovers
+// lost packets, congestion shows up as retransmissions, or a peer timing
+// out.
+func (s *RISTSink) GetStatsAndBandwidth() int64 {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	now := time.Now()
+
+	// Probe as often as librist reports.
+	if !s.lastProbeTime.IsZero() && now.Sub(s.lastProbeTime) < ristStatsIntervalMs*time.Millisecond {
+		return s.targetBitrate
+	}
+	s.lastProbeTime = now
+
+	stats := s.statsLocked()
+	sent := stats.PacketsSent - s.lastPacketsSent
+	retransmitted := stats.PacketsRetransmitted - s.lastPacketsRetransmitted
+	s.lastPacketsSent = stats.PacketsSent
+	s.lastPacketsRetransmitted = stats.PacketsRetransmitted
+	timedOut := s.peerTimedOut
+	s.peerTimedOut = false
+	if !timedOut && (sent <= 0 || retransmitted < 0) {
+		// No new report, or a peer dropped out of the totals.
+		return s.targetBitrate
+	}
+
+	if timedOut || float64(retransmitted) > float64(sent)*ristRetransmitThreshold {
+		s.lastLossTime = now
+
+		// Multiplicative decrease on congestion
+		oldBitrate := s.targetBitrate
+		s.targetBitrate = max(int64(float64(s.targetBitrate)*bweDecreaseFactor), minBitrateBps)
+		log.Printf("RIST BWE: LOSS (%d of %d pkts retransmitted, timed out %v) -> %d Kbps (was %d Kbps)",
+			retransmitted, sent, timedOut, s.targetBitrate/1000, oldBitrate/1000)
+		return s.targetBitrate
+	}
+
+	// Don't probe up during cooldown after loss
+	if !s.lastLossTime.IsZero() && now.Sub(s.lastLossTime) < bweLossCooldown {
+		return s.targetBitrate
+	}
+
+	// Additive increase when stable
+	if s.targetBitrate < maxBitrateBps {
+		s.targetBitrate = min(s.targetBitrate+bweIncreaseBps, maxBitrateBps)
+		log.Printf("RIST BWE: probe +%d Kbps -> %d Kbps", bweIncreaseBps/1000, s.targetBitrate/1000)
+	}
+	return s.targetBitrate
+}
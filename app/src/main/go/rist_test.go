@@ -3,8 +3,12 @@
 package kinetic
 
 import (
+	"bytes"
 	"fmt"
+	"log"
 	"net"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -55,13 +59,29 @@ func TestRISTSink_RoundTrip(t *testing.T) {
 	// produce visible MPEG-TS output the receiver can verify.
 	for i := 0; i < 60; i++ {
 		pts := int64(i) * 33_000
-		if err := sink.WriteSample(0, annexB, pts, int32(MediaCodecBufferFlagKeyFrame)); err != nil {
+		if err := sink.WriteSample(0, annexB, pts, MediaCodecBufferFlagKeyFrame); err != nil {
 			t.Fatalf("WriteSample[%d]: %v", i, err)
 		}
 		time.Sleep(20 * time.Millisecond)
 	}
 
 	res := <-recvCh
+
+	// librist reports stats once a second.
+	deadline := time.Now().Add(3 * time.Second)
+	for sink.Stats().PacketsSent == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no packets sent in the sink's stats")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !sink.Stats().Connected {
+		t.Error("sink not connected")
+	}
+	if bw := sink.GetStatsAndBandwidth(); bw < minBitrateBps || bw > maxBitrateBps {
+		t.Errorf("target bitrate %d outside [%d, %d]", bw, minBitrateBps, maxBitrateBps)
+	}
+
 	if err := sink.Close(); err != nil {
 		t.Fatalf("sink.Close: %v", err)
 	}
@@ -71,6 +91,459 @@ func TestRISTSink_RoundTrip(t *testing.T) {
 	assertMPEGTS(t, res.bytes)
 }
 
+// TestRISTSink_MultiPeer sends to two receivers from one sink, with the
+// default zero weights so each gets every packet, and verifies both get the
+// stream and are reported in the stats.
+func TestRISTSink_MultiPeer(t *testing.T) {
+	var listeners []*ristTestListener
+	var urls []string
+	for i := 0; i < 2; i++ {
+		port, err := pickFreeUDPPort()
+		if err != nil {
+			t.Fatalf("pickFreeUDPPort: %v", err)
+		}
+		listener, err := newRISTTestListener(port)
+		if err != nil {
+			t.Fatalf("newRISTTestListener: %v", err)
+		}
+		defer listener.Close()
+		listeners = append(listeners, listener)
+		urls = append(urls, fmt.Sprintf("rist://127.0.0.1:%d", port))
+	}
+
+	type recvResult struct {
+		bytes []byte
+		err   error
+	}
+	recvCh := make(chan recvResult, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			got, err := listener.Drain(188*8, 200, 6000)
+			recvCh <- recvResult{bytes: got, err: err}
+		}()
+	}
+
+	sink, err := NewRISTSink(urls[0], string(MediaFormatMimeTypeVideoH264), WithRISTPeers(RISTPeerConfig{URL: urls[1]}))
+	if err != nil {
+		t.Fatalf("NewRISTSink: %v", err)
+	}
+	defer sink.Close()
+
+	annexB := []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0x00, 0x1f,
+		0x96, 0x35, 0x40, 0xa0, 0x0b, 0x6a,
+		0x00, 0x00, 0x00, 0x01, 0x68, 0xce, 0x06, 0xe2,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x33,
+	}
+	for i := 0; i < 60; i++ {
+		if err := sink.WriteSample(0, annexB, int64(i)*33_000, MediaCodecBufferFlagKeyFrame); err != nil {
+			t.Fatalf("WriteSample[%d]: %v", i, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for range listeners {
+		res := <-recvCh
+		if res.err != nil {
+			t.Fatalf("listener: %v", res.err)
+		}
+		assertMPEGTS(t, res.bytes)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		stats := sink.Stats()
+		if len(stats.Peers) == 2 && stats.Peers[0].PacketsSent > 0 && stats.Peers[1].PacketsSent > 0 {
+			if want := stats.Peers[0].PacketsSent + stats.Peers[1].PacketsSent; stats.PacketsSent != want {
+				t.Errorf("total packets sent %d, want the peers' sum %d", stats.PacketsSent, want)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got stats %+v, want two peers sending", stats)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// TestRISTSink_PinnedPeer pins a peer to the loopback interface and checks
+// its packets come from the socket the relay bound to lo's address.
+func TestRISTSink_PinnedPeer(t *testing.T) {
+	port, err := pickFreeUDPPort()
+	if err != nil {
+		t.Fatalf("pickFreeUDPPort: %v", err)
+	}
+	receiver, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer receiver.Close()
+
+	// Only the peer reaches the receiver; the sink's own URL goes nowhere.
+	sink, err := NewRISTSink(fmt.Sprintf("rist://127.0.0.1:%d", port+2), string(MediaFormatMimeTypeVideoH264),
+		WithRISTPeers(RISTPeerConfig{URL: fmt.Sprintf("rist://127.0.0.1:%d", port), Interface: "lo"}))
+	if err != nil {
+		t.Fatalf("NewRISTSink: %v", err)
+	}
+	defer sink.Close()
+	if len(sink.relays) != 1 {
+		t.Fatalf("got %d relays, want 1", len(sink.relays))
+	}
+	bound := sink.relays[0].links[0].remote.LocalAddr().(*net.UDPAddr)
+	if !bound.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("relay bound to %s, want lo's address", bound.IP)
+	}
+
+	annexB := []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x33}
+	for i := 0; i < 10; i++ {
+		if err := sink.WriteSample(0, annexB, int64(i)*33_000, MediaCodecBufferFlagKeyFrame); err != nil {
+			t.Fatalf("WriteSample[%d]: %v", i, err)
+		}
+	}
+
+	receiver.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 1500)
+	_, from, err := receiver.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("ReadFromUDP: %v", err)
+	}
+	if !from.IP.Equal(bound.IP) || from.Port != bound.Port {
+		t.Errorf("packet came from %s, want the relay's %s", from, bound)
+	}
+}
+
+// TestRISTRelay forwards a Simple profile peer's two ports through a relay
+// bound to lo and checks the receiver's replies come back.
+func TestRISTRelay(t *testing.T) {
+	ip, err := ristInterfaceAddress("lo", net.IPv4(127, 0, 0, 1))
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+
+	var receivers []*net.UDPConn
+	conns, err := listenRISTRelayPorts(2)
+	if err != nil {
+		t.Fatalf("listenRISTRelayPorts: %v", err)
+	}
+	receivers = append(receivers, conns...)
+	defer func() {
+		for _, r := range receivers {
+			r.Close()
+		}
+	}()
+
+	dst := receivers[0].LocalAddr().(*net.UDPAddr)
+	relay, err := newRISTRelay(ip, dst, 2)
+	if err != nil {
+		t.Fatalf("newRISTRelay: %v", err)
+	}
+	defer relay.Close()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer client.Close()
+
+	buf := make([]byte, 1500)
+	for k, receiver := range receivers {
+		to := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: relay.port + k}
+		if _, err := client.WriteToUDP([]byte{byte(k)}, to); err != nil {
+			t.Fatalf("WriteToUDP: %v", err)
+		}
+		receiver.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := receiver.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("port %d: %v", k, err)
+		}
+		if n != 1 || buf[0] != byte(k) {
+			t.Errorf("port %d got %x", k, buf[:n])
+		}
+		if bound := relay.links[k].remote.LocalAddr().(*net.UDPAddr); !from.IP.Equal(ip) || from.Port != bound.Port {
+			t.Errorf("port %d: packet came from %s, want %s", k, from, bound)
+		}
+
+		if _, err := receiver.WriteToUDP([]byte{0x80 | byte(k)}, from); err != nil {
+			t.Fatalf("WriteToUDP: %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err = client.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("port %d reply: %v", k, err)
+		}
+		if n != 1 || buf[0] != 0x80|byte(k) || from.Port != to.Port {
+			t.Errorf("port %d reply %x from %s, want it from %s", k, buf[:n], from, to)
+		}
+	}
+}
+
+// TestRISTSink_PeerValidation checks peer configs are rejected before
+// they're handed to librist.
+func TestRISTSink_PeerValidation(t *testing.T) {
+	for _, peer := range []RISTPeerConfig{
+		{URL: "rist://127.0.0.1:5000", Weight: -1},
+		{URL: "rist://127.0.0.1:5000", Interface: "nonexistent0"},
+		{URL: "rist://@127.0.0.1:5000", Interface: "lo"},
+		{URL: "srt://127.0.0.1:5000"},
+	} {
+		sink, err := NewRISTSink("rist://127.0.0.1:5002", string(MediaFormatMimeTypeVideoH264), WithRISTPeers(peer))
+		if err == nil {
+			sink.Close()
+			t.Errorf("NewRISTSink with peer %+v succeeded", peer)
+		}
+	}
+}
+
+// TestRISTSource_RoundTrip points a RISTSink at a listening RISTSource and
+// verifies the source demuxes the frames the sink sent.
+func TestRISTSource_RoundTrip(t *testing.T) {
+	port, err := pickFreeUDPPort()
+	if err != nil {
+		t.Fatalf("pickFreeUDPPort: %v", err)
+	}
+
+	source, err := NewRISTSource(fmt.Sprintf("rist://@127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("NewRISTSource: %v", err)
+	}
+	defer source.Close()
+
+	sink, err := NewRISTSink(fmt.Sprintf("rist://127.0.0.1:%d", port),
+		string(MediaFormatMimeTypeVideoH264)+";"+string(MediaFormatMimeTypeAudioOpus))
+	if err != nil {
+		t.Fatalf("NewRISTSink: %v", err)
+	}
+	defer sink.Close()
+
+	annexB := []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0x00, 0x1f, 0x96, 0x35, 0x40, 0xa0, 0x0b, 0x6a,
+		0x00, 0x00, 0x00, 0x01, 0x68, 0xce, 0x06, 0xe2,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x33,
+	}
+	opusPacket := []byte{0xfc, 0xff, 0xfe} // 20 ms CELT frame
+	const frames = 30
+	for i := 0; i < frames; i++ {
+		pts := int64(i+1) * 40_000 // Nonzero, so a dropped PTS shows
+		if err := sink.WriteSample(0, annexB, pts, MediaCodecBufferFlagKeyFrame); err != nil {
+			t.Fatalf("WriteSample video[%d]: %v", i, err)
+		}
+		if err := sink.WriteSample(1, opusPacket, pts, 0); err != nil {
+			t.Fatalf("WriteSample audio[%d]: %v", i, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Blocks sent before the handshake completed are lost, so only check
+	// that frames arrive in order with the sink's spacing.
+	first := readTestFrame(t, source.ReadVideoFrame)
+	if got, want := splitNALUs(first.Data), splitNALUs(annexB); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("video frame NALUs %x, want %x", got, want)
+	}
+	if first.PTS <= 0 || first.PTS%40_000 != 0 {
+		t.Errorf("video frame PTS %d, want one of the sink's", first.PTS)
+	}
+	if next := readTestFrame(t, source.ReadVideoFrame); next.PTS-first.PTS != 40_000 {
+		t.Errorf("video frames %d us apart, want 40000", next.PTS-first.PTS)
+	}
+	audio := readTestFrame(t, source.ReadAudioFrame)
+	if fmt.Sprint(audio.Data) != fmt.Sprint(opusPacket) {
+		t.Errorf("audio frame = %x, want %x", audio.Data, opusPacket)
+	}
+	if audio.PTS <= 0 || audio.PTS%40_000 != 0 {
+		t.Errorf("audio frame PTS %d, want one of the sink's", audio.PTS)
+	}
+	if got := source.VideoCodec(); got != MediaFormatMimeTypeVideoH264 {
+		t.Errorf("video codec %s, want %s", got, MediaFormatMimeTypeVideoH264)
+	}
+	if got := source.AudioCodec(); got != MediaFormatMimeTypeAudioOpus {
+		t.Errorf("audio codec %s, want %s", got, MediaFormatMimeTypeAudioOpus)
+	}
+
+	// Closing unblocks readers once they've drained the queue.
+	source.Close()
+	for source.ReadVideoFrame() != nil {
+	}
+	if !source.IsClosed() {
+		t.Error("source not closed")
+	}
+}
+
+// TestRISTSink_Encrypted sends to a listener that decrypts with a secret,
+// and verifies the stream arrives intact with the right secret and doesn't
+// with the wrong one.
+func TestRISTSink_Encrypted(t *testing.T) {
+	const secret = "correcthorsebatterystaple"
+
+	send := func(t *testing.T, config RISTSecurityConfig) []byte {
+		t.Helper()
+		port, err := pickFreeUDPPort()
+		if err != nil {
+			t.Fatalf("pickFreeUDPPort: %v", err)
+		}
+		listener, err := newRISTEncryptedTestListener(port, secret)
+		if err != nil {
+			t.Fatalf("newRISTEncryptedTestListener: %v", err)
+		}
+		defer listener.Close()
+
+		recvCh := make(chan []byte, 1)
+		go func() {
+			got, err := listener.Drain(188*8, 200, 4000)
+			if err != nil {
+				t.Errorf("listener: %v", err)
+			}
+			recvCh <- got
+		}()
+
+		sink, err := NewRISTSink(fmt.Sprintf("rist://127.0.0.1:%d", port),
+			string(MediaFormatMimeTypeVideoH264), WithRISTSecurity(config))
+		if err != nil {
+			t.Fatalf("NewRISTSink: %v", err)
+		}
+		defer sink.Close()
+
+		annexB := []byte{
+			0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0x00, 0x1f, 0x96, 0x35, 0x40, 0xa0, 0x0b, 0x6a,
+			0x00, 0x00, 0x00, 0x01, 0x68, 0xce, 0x06, 0xe2,
+			0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x33,
+		}
+		for i := 0; i < 60; i++ {
+			if err := sink.WriteSample(0, annexB, int64(i)*33_000, MediaCodecBufferFlagKeyFrame); err != nil {
+				t.Fatalf("WriteSample[%d]: %v", i, err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return <-recvCh
+	}
+
+	t.Run("right secret", func(t *testing.T) {
+		assertMPEGTS(t, send(t, RISTSecurityConfig{Secret: secret, KeySize: 128}))
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		// librist can't tell a wrong key, so the receiver gets garbage if
+		// anything.
+		got := send(t, RISTSecurityConfig{Secret: "incorrecthorsebatterystaple", KeySize: 128})
+		if isMPEGTS(got) {
+			t.Errorf("received %d bytes of MPEG-TS with the wrong secret", len(got))
+		}
+	})
+}
+
+// isMPEGTS reports whether b is whole TS packets, each starting with the
+// sync byte.
+func isMPEGTS(b []byte) bool {
+	if len(b) == 0 || len(b)%188 != 0 {
+		return false
+	}
+	for off := 0; off < len(b); off += 188 {
+		if b[off] != 0x47 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRISTSource_Encrypted points an encrypting RISTSink at a RISTSource
+// with the same secret and verifies the source demuxes the frames.
+func TestRISTSource_Encrypted(t *testing.T) {
+	config := RISTSecurityConfig{Secret: "correcthorsebatterystaple", KeySize: 256}
+
+	port, err := pickFreeUDPPort()
+	if err != nil {
+		t.Fatalf("pickFreeUDPPort: %v", err)
+	}
+	source, err := NewRISTSource(fmt.Sprintf("rist://@127.0.0.1:%d", port), WithRISTSourceSecurity(config))
+	if err != nil {
+		t.Fatalf("NewRISTSource: %v", err)
+	}
+	defer source.Close()
+
+	sink, err := NewRISTSink(fmt.Sprintf("rist://127.0.0.1:%d", port),
+		string(MediaFormatMimeTypeVideoH264), WithRISTSecurity(config))
+	if err != nil {
+		t.Fatalf("NewRISTSink: %v", err)
+	}
+	defer sink.Close()
+
+	annexB := []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0x00, 0x1f, 0x96, 0x35, 0x40, 0xa0, 0x0b, 0x6a,
+		0x00, 0x00, 0x00, 0x01, 0x68, 0xce, 0x06, 0xe2,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x33,
+	}
+	for i := 0; i < 30; i++ {
+		if err := sink.WriteSample(0, annexB, int64(i)*40_000, MediaCodecBufferFlagKeyFrame); err != nil {
+			t.Fatalf("WriteSample[%d]: %v", i, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	frame := readTestFrame(t, source.ReadVideoFrame)
+	if got, want := splitNALUs(frame.Data), splitNALUs(annexB); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("video frame NALUs %x, want %x", got, want)
+	}
+}
+
+func TestRISTSecurityConfig(t *testing.T) {
+	tests := []struct {
+		url    string
+		config RISTSecurityConfig
+		ok     bool
+	}{
+		{"rist://127.0.0.1:1", RISTSecurityConfig{}, true},
+		{"rist://127.0.0.1:1", RISTSecurityConfig{Secret: "0123456789", KeySize: 256, KeyRotation: 1000}, true},
+		{"rist://127.0.0.1:1", RISTSecurityConfig{Secret: "0123456789", KeySize: 192}, false},
+		{"rist://127.0.0.1:1", RISTSecurityConfig{KeySize: 128}, false},
+		{"rist://127.0.0.1:1", RISTSecurityConfig{Secret: "0123456789", KeyRotation: -1}, false},
+		{"rist://127.0.0.1:1", RISTSecurityConfig{Secret: strings.Repeat("x", 128)}, false},
+		{"rist://127.0.0.1:1?profile=advanced", RISTSecurityConfig{SRPUsername: "user", SRPPassword: "pass"}, true},
+		{"rist://127.0.0.1:1", RISTSecurityConfig{SRPUsername: "user"}, false},
+		{"rist://127.0.0.1:1?profile=simple", RISTSecurityConfig{Secret: "0123456789"}, false},
+	}
+	for _, tt := range tests {
+		sink, err := NewRISTSink(tt.url, string(MediaFormatMimeTypeVideoH264), WithRISTSecurity(tt.config))
+		if err == nil {
+			sink.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("NewRISTSink(%s) with %v: err = %v, want ok %v", tt.url, tt.config, err, tt.ok)
+		}
+	}
+
+	if got := fmt.Sprint(RISTSecurityConfig{Secret: "0123456789", SRPUsername: "user", SRPPassword: "hunter2"}); strings.Contains(got, "0123456789") || strings.Contains(got, "hunter2") {
+		t.Errorf("secrets not redacted in %s", got)
+	}
+	if got, want := redactRISTURL("rist://example.com:9000?buffer=1000&secret=0123456789&aes-type=256&username=user&password=hunter2"),
+		"rist://example.com:9000?buffer=1000&secret=REDACTED&aes-type=256&username=user&password=REDACTED"; got != want {
+		t.Errorf("redactRISTURL = %s, want %s", got, want)
+	}
+}
+
+// TestRISTLogging checks librist's messages reach the log at the level set.
+func TestRISTLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	SetRISTLogLevel(RISTLogDebug)
+	defer SetRISTLogLevel(RISTLogWarn)
+
+	port, err := pickFreeUDPPort()
+	if err != nil {
+		t.Fatalf("pickFreeUDPPort: %v", err)
+	}
+	sink, err := NewRISTSink(fmt.Sprintf("rist://127.0.0.1:%d", port), string(MediaFormatMimeTypeVideoH264))
+	if err != nil {
+		t.Fatalf("NewRISTSink: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// Closing joins librist's threads, so nothing logs after.
+	sink.Close()
+
+	if !strings.Contains(buf.String(), "RIST: [") {
+		t.Errorf("no librist messages in the log:\n%s", buf.String())
+	}
+}
+
 // pickFreeUDPPort grabs a random free UDP port on localhost. We bind+close
 // to discover the OS-assigned port, then trust nothing else snags it before
 // the librist receiver opens.
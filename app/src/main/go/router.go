@@ -0,0 +1,194 @@
+package kinetic
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultRouteQueueSize is roughly two seconds of 30fps video plus audio.
+const defaultRouteQueueSize = 256
+
+// Router fans a single encoder output out to any number of sinks. Each sink
+// gets its own queue and goroutine so a stalled peer (e.g. an SRT caller
+// waiting out a reconnect) never blocks the encoder or the other outputs.
+// When a sink's queue is full the sample is dropped for that sink only; if
+// the dropped sample was video, the sink skips ahead to the next keyframe so
+// its decoder doesn't see a broken reference chain.
+type Router struct {
+	mu     sync.RWMutex
+	routes []*route
+	closed bool
+}
+
+type routedSample struct {
+	i     int
+	buf   []byte
+	pts   int64
+	flags MediaCodecBufferFlag
+}
+
+type route struct {
+	sink  Sink
+	queue chan routedSample
+	done  chan struct{}
+
+	// waitForKeyframe is only touched by Router.WriteSample for track 0,
+	// which is fed from a single video encoder thread.
+	waitForKeyframe bool
+	dropped         atomic.Uint64
+}
+
+var _ Sink = (*Router)(nil)
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// AddSink starts routing samples to s. queueSize bounds the number of samples
+// buffered for s before samples start being dropped; values <= 0 use the
+// default. The router takes ownership of s and closes it in Close.
+func (r *Router) AddSink(s Sink, queueSize int) error {
+	if queueSize <= 0 {
+		queueSize = defaultRouteQueueSize
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return fmt.Errorf("router: closed")
+	}
+	for _, rt := range r.routes {
+		if rt.sink == s {
+			return fmt.Errorf("router: sink already added")
+		}
+	}
+
+	rt := &route{
+		sink:  s,
+		queue: make(chan routedSample, queueSize),
+		done:  make(chan struct{}),
+		// Don't start a new sink mid-GOP.
+		waitForKeyframe: true,
+	}
+	go rt.run()
+	r.routes = append(r.routes, rt)
+	return nil
+}
+
+// RemoveSink stops routing samples to s and waits for its queue to drain.
+// Ownership of s returns to the caller; it is not closed.
+func (r *Router) RemoveSink(s Sink) error {
+	r.mu.Lock()
+	var rt *route
+	for i, v := range r.routes {
+		if v.sink == s {
+			rt = v
+			r.routes = append(r.routes[:i], r.routes[i+1:]...)
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	if rt == nil {
+		return fmt.Errorf("router: sink not found")
+	}
+	close(rt.queue)
+	<-rt.done
+	return nil
+}
+
+// Dropped returns the number of samples dropped for s because its queue was
+// full or it was waiting for a keyframe after a drop.
+func (r *Router) Dropped(s Sink) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rt := range r.routes {
+		if rt.sink == s {
+			return rt.dropped.Load()
+		}
+	}
+	return 0
+}
+
+// WriteSample enqueues the sample on every sink's queue without blocking.
+// buf is copied once and shared between the sinks, so sinks must treat it as
+// read-only.
+func (r *Router) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return fmt.Errorf("router: closed")
+	}
+	if len(r.routes) == 0 {
+		return nil
+	}
+
+	// The caller (typically JNI) may reuse buf as soon as we return.
+	sample := routedSample{
+		i:     i,
+		buf:   append([]byte(nil), buf...),
+		pts:   ptsMicroseconds,
+		flags: flags,
+	}
+	isVideo := i == 0
+	isKeyframe := flags&MediaCodecBufferFlagKeyFrame != 0
+	isConfig := flags&MediaCodecBufferFlagCodecConfig != 0
+
+	for _, rt := range r.routes {
+		if isVideo && rt.waitForKeyframe {
+			if !isKeyframe && !isConfig {
+				rt.dropped.Add(1)
+				continue
+			}
+			if isKeyframe {
+				rt.waitForKeyframe = false
+			}
+		}
+		select {
+		case rt.queue <- sample:
+		default:
+			rt.dropped.Add(1)
+			if isVideo {
+				rt.waitForKeyframe = true
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops routing, waits for every queue to drain, and closes the sinks.
+func (r *Router) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	routes := r.routes
+	r.routes = nil
+	r.mu.Unlock()
+
+	var errs []error
+	for _, rt := range routes {
+		close(rt.queue)
+		<-rt.done
+		if err := rt.sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (rt *route) run() {
+	defer close(rt.done)
+	for s := range rt.queue {
+		if err := rt.sink.WriteSample(s.i, s.buf, s.pts, s.flags); err != nil {
+			log.Printf("Router: %T write failed on track %d: %v", rt.sink, s.i, err)
+		}
+	}
+}
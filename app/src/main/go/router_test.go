@@ -0,0 +1,164 @@
+package kinetic
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink collects every sample it is handed. If block is non-nil,
+// WriteSample waits on it first to simulate a stalled peer.
+type recordingSink struct {
+	mu      sync.Mutex
+	samples []routedSample
+	block   chan struct{}
+	closed  bool
+}
+
+func (s *recordingSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, routedSample{i: i, buf: buf, pts: ptsMicroseconds, flags: flags})
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.samples)
+}
+
+// TestRouterStalledSinkDoesNotBlock verifies that a sink which never returns
+// from WriteSample neither blocks the router nor starves a healthy sink.
+func TestRouterStalledSinkDoesNotBlock(t *testing.T) {
+	router := NewRouter()
+
+	fast := &recordingSink{}
+	stalled := &recordingSink{block: make(chan struct{})}
+	if err := router.AddSink(fast, 4); err != nil {
+		t.Fatalf("AddSink(fast): %v", err)
+	}
+	if err := router.AddSink(stalled, 4); err != nil {
+		t.Fatalf("AddSink(stalled): %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			flags := MediaCodecBufferFlag(0)
+			if i%10 == 0 {
+				flags = MediaCodecBufferFlagKeyFrame
+			}
+			if err := router.WriteSample(0, []byte{byte(i)}, int64(i)*33_000, flags); err != nil {
+				t.Errorf("WriteSample[%d]: %v", i, err)
+			}
+			// Give the fast sink's goroutine a chance to keep up.
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WriteSample blocked on a stalled sink")
+	}
+
+	if got := fast.count(); got != 100 {
+		t.Errorf("fast sink got %d samples, want 100", got)
+	}
+	if router.Dropped(stalled) == 0 {
+		t.Error("expected samples to be dropped for the stalled sink")
+	}
+
+	close(stalled.block)
+	if err := router.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !fast.closed || !stalled.closed {
+		t.Error("Close should close every attached sink")
+	}
+}
+
+// TestRouterResumesOnKeyframe verifies that after a video drop the sink only
+// sees video again starting at the next keyframe, while audio is unaffected.
+func TestRouterResumesOnKeyframe(t *testing.T) {
+	router := NewRouter()
+	sink := &recordingSink{block: make(chan struct{})}
+	if err := router.AddSink(sink, 2); err != nil {
+		t.Fatalf("AddSink: %v", err)
+	}
+
+	// A delta frame before any keyframe is skipped.
+	router.WriteSample(0, []byte{0}, 0, 0)
+	// Keyframe + delta fill the queue (the first one is held by the blocked
+	// sink goroutine once it is scheduled, so allow for either).
+	router.WriteSample(0, []byte{1}, 1, MediaCodecBufferFlagKeyFrame)
+	router.WriteSample(0, []byte{2}, 2, 0)
+	router.WriteSample(0, []byte{3}, 3, 0)
+	router.WriteSample(0, []byte{4}, 4, 0)
+
+	close(sink.block)
+	deadline := time.Now().Add(time.Second)
+	for sink.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Now the queue has room, but the sink must wait for a keyframe.
+	time.Sleep(50 * time.Millisecond)
+	before := sink.count()
+	router.WriteSample(0, []byte{5}, 5, 0)
+	router.WriteSample(1, []byte{6}, 6, 0)
+	router.WriteSample(0, []byte{7}, 7, MediaCodecBufferFlagKeyFrame)
+
+	if err := router.RemoveSink(sink); err != nil {
+		t.Fatalf("RemoveSink: %v", err)
+	}
+	if sink.closed {
+		t.Error("RemoveSink should not close the sink")
+	}
+
+	after := sink.samples[before:]
+	if len(after) != 2 {
+		t.Fatalf("expected audio + keyframe after resync, got %d samples", len(after))
+	}
+	if after[0].i != 1 || after[1].buf[0] != 7 {
+		t.Errorf("unexpected samples after resync: %+v", after)
+	}
+	if sink.samples[0].buf[0] != 1 {
+		t.Errorf("first sample should be the first keyframe, got %d", sink.samples[0].buf[0])
+	}
+}
+
+// TestRouterCopiesBuffer verifies the caller may reuse its buffer as soon as
+// WriteSample returns.
+func TestRouterCopiesBuffer(t *testing.T) {
+	router := NewRouter()
+	sink := &recordingSink{}
+	if err := router.AddSink(sink, 0); err != nil {
+		t.Fatalf("AddSink: %v", err)
+	}
+
+	buf := []byte{1, 2, 3}
+	router.WriteSample(0, buf, 0, MediaCodecBufferFlagKeyFrame)
+	buf[0] = 9
+
+	if err := router.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if sink.samples[0].buf[0] != 1 {
+		t.Errorf("router did not copy the sample buffer")
+	}
+	if err := router.WriteSample(0, buf, 0, 0); err == nil {
+		t.Error("WriteSample after Close should fail")
+	}
+}
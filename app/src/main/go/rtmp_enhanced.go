@@ -0,0 +1,49 @@
+//go:build amd64 || arm64
+
+package kinetic
+
+// Enhanced RTMP (https://github.com/veovera/enhanced-rtmp) extends the FLV
+// tag headers with FourCC codec signalling. go-flv only understands the
+// legacy headers, so the extended ones are parsed and written by hand.
+
+// The top bit of the first video tag byte marks an extended header; the
+// remaining bits hold a 3-bit frame type and a 4-bit packet type, followed by
+// the FourCC.
+const rtmpExVideoHeaderBit = 0x80
+
+// An audio SoundFormat of 9 marks an extended header; the low nibble is the
+// packet type, followed by the FourCC.
+const rtmpExAudioSoundFormat = 9
+
+// Packet types shared by extended video and audio tags.
+const (
+	rtmpExPacketTypeSequenceStart = 0
+	rtmpExPacketTypeCodedFrames   = 1
+	rtmpExPacketTypeSequenceEnd   = 2
+	// Video only: coded frames without the composition time offset.
+	rtmpExPacketTypeCodedFramesX = 3
+)
+
+const (
+	rtmpFrameTypeKeyFrame   = 1
+	rtmpFrameTypeInterFrame = 2
+)
+
+var rtmpFourCCs = map[string]MediaFormatMimeType{
+	"avc1": MediaFormatMimeTypeVideoH264,
+	"hvc1": MediaFormatMimeTypeVideoH265,
+	"av01": MediaFormatMimeTypeVideoAV1,
+	"vp09": MediaFormatMimeTypeVideoVP9,
+	"mp4a": MediaFormatMimeTypeAudioAAC,
+	"Opus": MediaFormatMimeTypeAudioOpus,
+}
+
+// rtmpFourCC returns the Enhanced RTMP FourCC for a codec.
+func rtmpFourCC(t MediaFormatMimeType) string {
+	for fourCC, v := range rtmpFourCCs {
+		if v == t {
+			return fourCC
+		}
+	}
+	return ""
+}
@@ -0,0 +1,563 @@
+//go:build amd64 || arm64
+
+package kinetic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	flvtag "github.com/yutopp/go-flv/tag"
+	"github.com/yutopp/go-rtmp"
+	rtmpmsg "github.com/yutopp/go-rtmp/message"
+)
+
+// Chunk stream IDs used for publishing. These match what OBS and ffmpeg use.
+const (
+	rtmpAudioChunkStreamID = 4
+	rtmpVideoChunkStreamID = 6
+	rtmpChunkSize          = 4096
+)
+
+// rtmpDialTimeout bounds each attempt to reach the ingest, as SRTO_CONNTIMEO
+// does for SRTSink, so a blackholed ingest doesn't hold the sink's lock for
+// the OS's TCP connect timeout while reconnecting.
+const rtmpDialTimeout = 5 * time.Second
+
+// RTMPSink publishes to an RTMP or RTMPS ingest such as YouTube or Twitch.
+// Like SRTSink it reconnects on write failure and drops samples while the
+// connection is down.
+type RTMPSink struct {
+	sync.Mutex
+
+	scheme    string
+	addr      string
+	host      string
+	app       string
+	streamKey string
+	tcURL     string
+	tracks    []MediaFormatMimeType
+
+	conn   *rtmp.ClientConn
+	stream *rtmp.Stream
+	closed bool
+
+	// ptsBase is subtracted from every sample so RTMP timestamps, which are
+	// 32-bit milliseconds, start near zero.
+	ptsBase    int64
+	ptsBaseSet bool
+
+	// Video state. Android's encoder emits (VPS/)SPS/PPS, or the AV1
+	// sequence header, in a codec config buffer before the first keyframe;
+	// the sequence header is (re)sent whenever they change and after every
+	// reconnect.
+	vps             []byte
+	sps             []byte
+	pps             []byte
+	sentVideoHeader bool
+	waitForKeyframe bool
+
+	// Audio state. The AudioSpecificConfig or OpusHead comes from the
+	// encoder's codec config buffer if present, otherwise from the track's
+	// default config.
+	audioConfig     []byte
+	sentAudioHeader bool
+}
+
+var _ Sink = (*RTMPSink)(nil)
+
+// parseRTMPURL splits an ingest URL into the TCP address, the app (everything
+// but the last path segment) and the stream key (the last path segment plus
+// any query string, which some services use for auth).
+func parseRTMPURL(rawURL string) (scheme, addr, app, streamKey, tcURL string, err error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", "", "", "", fmt.Errorf("RTMP: failed to parse URL: %w", err)
+	}
+
+	scheme = parsed.Scheme
+	var defaultPort string
+	switch scheme {
+	case "rtmp":
+		defaultPort = "1935"
+	case "rtmps":
+		defaultPort = "443"
+	default:
+		return "", "", "", "", "", fmt.Errorf("RTMP: expected rtmp:// or rtmps:// scheme, got %q", scheme)
+	}
+
+	port := parsed.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	addr = net.JoinHostPort(parsed.Hostname(), port)
+
+	path := strings.Trim(parsed.Path, "/")
+	idx := strings.LastIndex(path, "/")
+	if idx <= 0 {
+		return "", "", "", "", "", fmt.Errorf("RTMP: URL must be of the form %s://host/app/streamKey", scheme)
+	}
+	app = path[:idx]
+	streamKey = path[idx+1:]
+	if parsed.RawQuery != "" {
+		streamKey += "?" + parsed.RawQuery
+	}
+	tcURL = fmt.Sprintf("%s://%s/%s", scheme, parsed.Host, app)
+	return scheme, addr, app, streamKey, tcURL, nil
+}
+
+// redactStreamKey keeps enough of the key to tell outputs apart in logs.
+func redactStreamKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
+
+// NewRTMPSink connects to `rtmp://host[:port]/app/streamKey` (or rtmps://)
+// and starts publishing. The mimeTypes string is the same `;`-separated codec
+// list used by SRTSink. H.264 and AAC are sent as legacy FLV; H.265, AV1 and
+// Opus use Enhanced RTMP, which the ingest must support.
+func NewRTMPSink(rawURL, encodedMediaFormatMimeTypes string) (*RTMPSink, error) {
+	scheme, addr, app, streamKey, tcURL, err := parseRTMPURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("RTMP: creating sink for %s app=%s key=%s with mimeTypes %s",
+		addr, app, redactStreamKey(streamKey), encodedMediaFormatMimeTypes)
+
+	s := &RTMPSink{
+		scheme:          scheme,
+		addr:            addr,
+		app:             app,
+		streamKey:       streamKey,
+		tcURL:           tcURL,
+		waitForKeyframe: true,
+	}
+	s.host, _, _ = net.SplitHostPort(addr)
+
+	for _, v := range strings.Split(encodedMediaFormatMimeTypes, ";") {
+		t := MediaFormatMimeType(v)
+		switch t {
+		case MediaFormatMimeTypeVideoH264, MediaFormatMimeTypeVideoH265, MediaFormatMimeTypeVideoAV1:
+		case MediaFormatMimeTypeAudioOpus:
+			// The encoder is configured for stereo. Its own OpusHead replaces
+			// this once the codec config buffer arrives.
+			s.audioConfig = buildOpusHead(2, 0)
+		case MediaFormatMimeTypeAudioAAC:
+			codec := t.MPEGTSCodec().(*mpegts.CodecMPEG4Audio)
+			config, err := codec.Config.Marshal()
+			if err != nil {
+				return nil, fmt.Errorf("RTMP: failed to marshal default AAC config: %w", err)
+			}
+			s.audioConfig = config
+		default:
+			return nil, fmt.Errorf("RTMP: unsupported codec %s", v)
+		}
+		s.tracks = append(s.tracks, t)
+	}
+
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("RTMP: sink created with %d tracks", len(s.tracks))
+	return s, nil
+}
+
+// connect dials the ingest and issues connect/createStream/publish. Caller
+// must hold the lock or be in the constructor.
+func (s *RTMPSink) connect() error {
+	log.Printf("RTMP: connecting to %s...", s.addr)
+
+	var conn *rtmp.ClientConn
+	var err error
+	dialer := &net.Dialer{Timeout: rtmpDialTimeout}
+	if s.scheme == "rtmps" {
+		conn, err = rtmp.DialWithTLSDialer(&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.host}}, s.scheme, s.addr, &rtmp.ConnConfig{})
+	} else {
+		conn, err = rtmp.DialWithDialer(dialer, s.scheme, s.addr, &rtmp.ConnConfig{})
+	}
+	if err != nil {
+		return fmt.Errorf("RTMP: dial failed: %w", err)
+	}
+
+	if err := conn.Connect(&rtmpmsg.NetConnectionConnect{
+		Command: rtmpmsg.NetConnectionConnectCommand{
+			App:      s.app,
+			Type:     "nonprivate",
+			FlashVer: "FMLE/3.0 (compatible; kinetic)",
+			TCURL:    s.tcURL,
+		},
+	}); err != nil {
+		conn.Close()
+		return fmt.Errorf("RTMP: connect failed: %w", err)
+	}
+
+	stream, err := conn.CreateStream(nil, rtmpChunkSize)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("RTMP: createStream failed: %w", err)
+	}
+
+	if err := stream.Publish(&rtmpmsg.NetStreamPublish{
+		PublishingName: s.streamKey,
+		PublishingType: "live",
+	}); err != nil {
+		conn.Close()
+		return fmt.Errorf("RTMP: publish failed: %w", err)
+	}
+	log.Printf("RTMP: publishing")
+
+	s.conn = conn
+	s.stream = stream
+	s.sentVideoHeader = false
+	s.sentAudioHeader = false
+	s.waitForKeyframe = true
+	return nil
+}
+
+// reconnect closes the old connection and tries to establish a new one.
+// Caller must hold the lock.
+func (s *RTMPSink) reconnect() error {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.stream = nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		delay := time.Duration(attempt) * 500 * time.Millisecond
+		if delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
+		log.Printf("RTMP: reconnecting (attempt %d) in %v...", attempt, delay)
+
+		// Unlock while sleeping so Close() can proceed
+		s.Unlock()
+		time.Sleep(delay)
+		s.Lock()
+
+		if s.closed {
+			return fmt.Errorf("RTMP: sink closed during reconnect")
+		}
+
+		if err := s.connect(); err != nil {
+			log.Printf("RTMP: reconnect attempt %d failed: %v", attempt, err)
+			continue
+		}
+
+		log.Printf("RTMP: reconnected")
+		return nil
+	}
+}
+
+func (s *RTMPSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("RTMP: sink closed")
+	}
+	if i < 0 || i >= len(s.tracks) {
+		return fmt.Errorf("RTMP: invalid track index %d", i)
+	}
+
+	if !s.ptsBaseSet {
+		s.ptsBase = ptsMicroseconds
+		s.ptsBaseSet = true
+	}
+	timestamp := uint32((ptsMicroseconds - s.ptsBase) / 1000)
+	if ptsMicroseconds < s.ptsBase {
+		timestamp = 0
+	}
+
+	var err error
+	switch t := s.tracks[i]; t {
+	case MediaFormatMimeTypeVideoH264, MediaFormatMimeTypeVideoH265:
+		err = s.writeNALUsLocked(t, buf, timestamp, flags)
+	case MediaFormatMimeTypeVideoAV1:
+		err = s.writeAV1Locked(buf, timestamp, flags)
+	case MediaFormatMimeTypeAudioAAC:
+		err = s.writeAACLocked(buf, timestamp, flags)
+	case MediaFormatMimeTypeAudioOpus:
+		err = s.writeOpusLocked(buf, timestamp, flags)
+	}
+	if err != nil {
+		log.Printf("RTMP: write failed: %v, reconnecting...", err)
+		if reconnErr := s.reconnect(); reconnErr != nil {
+			return reconnErr
+		}
+		// Drop this sample - the new session needs fresh sequence headers
+		return nil
+	}
+	return nil
+}
+
+// writeNALUsLocked sends an Annex-B H.264 or H.265 access unit. H.264 uses
+// the legacy FLV AVC tags that every ingest understands; H.265 uses the
+// Enhanced RTMP hvc1 FourCC.
+func (s *RTMPSink) writeNALUsLocked(codec MediaFormatMimeType, buf []byte, timestamp uint32, flags MediaCodecBufferFlag) error {
+	isHEVC := codec == MediaFormatMimeTypeVideoH265
+
+	var nalus [][]byte
+	for _, nalu := range splitNALUs(buf) {
+		if len(nalu) == 0 {
+			continue
+		}
+		var param *[]byte
+		if isHEVC {
+			switch (nalu[0] >> 1) & 0x3F {
+			case 32: // VPS
+				param = &s.vps
+			case 33: // SPS
+				param = &s.sps
+			case 34: // PPS
+				param = &s.pps
+			case 35: // AUD, not used in FLV
+				continue
+			}
+		} else {
+			switch nalu[0] & 0x1F {
+			case 7: // SPS
+				param = &s.sps
+			case 8: // PPS
+				param = &s.pps
+			case 9: // AUD, not used in FLV
+				continue
+			}
+		}
+		if param == nil {
+			nalus = append(nalus, nalu)
+		} else if !bytes.Equal(nalu, *param) {
+			*param = append([]byte(nil), nalu...)
+			s.sentVideoHeader = false
+		}
+	}
+
+	if !s.sentVideoHeader {
+		if s.sps == nil || s.pps == nil || (isHEVC && s.vps == nil) {
+			// Can't describe the stream yet.
+			return nil
+		}
+		var err error
+		if isHEVC {
+			var record []byte
+			record, err = buildHEVCDecoderConfigurationRecord(s.vps, s.sps, s.pps)
+			if err != nil {
+				log.Printf("RTMP: %v", err)
+				return nil
+			}
+			err = s.writeExVideoTag(rtmpFrameTypeKeyFrame, rtmpExPacketTypeSequenceStart, codec, record, timestamp)
+		} else {
+			record := buildAVCDecoderConfigurationRecord(s.sps, s.pps)
+			err = s.writeVideoTag(flvtag.FrameTypeKeyFrame, flvtag.AVCPacketTypeSequenceHeader, record, timestamp)
+		}
+		if err != nil {
+			return err
+		}
+		s.sentVideoHeader = true
+	}
+
+	if len(nalus) == 0 {
+		// Codec config only.
+		return nil
+	}
+
+	isKeyframe := flags&MediaCodecBufferFlagKeyFrame != 0
+	if s.waitForKeyframe {
+		if !isKeyframe {
+			return nil
+		}
+		s.waitForKeyframe = false
+	}
+
+	var avcc bytes.Buffer
+	for _, nalu := range nalus {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(nalu)))
+		avcc.Write(length[:])
+		avcc.Write(nalu)
+	}
+
+	if isHEVC {
+		frameType := byte(rtmpFrameTypeInterFrame)
+		if isKeyframe {
+			frameType = rtmpFrameTypeKeyFrame
+		}
+		// No B-frames, so the composition time offset is always zero.
+		return s.writeExVideoTag(frameType, rtmpExPacketTypeCodedFramesX, codec, avcc.Bytes(), timestamp)
+	}
+	frameType := flvtag.FrameTypeInterFrame
+	if isKeyframe {
+		frameType = flvtag.FrameTypeKeyFrame
+	}
+	return s.writeVideoTag(frameType, flvtag.AVCPacketTypeNALU, avcc.Bytes(), timestamp)
+}
+
+// writeAV1Locked sends a low-overhead format AV1 temporal unit using the
+// Enhanced RTMP av01 FourCC.
+func (s *RTMPSink) writeAV1Locked(buf []byte, timestamp uint32, flags MediaCodecBufferFlag) error {
+	isConfig := flags&MediaCodecBufferFlagCodecConfig != 0
+
+	// The codec config buffer is either a bare sequence header OBU or a
+	// full av1C record depending on the encoder.
+	tu := buf
+	if isConfig && len(buf) > 0 && buf[0] == 0x81 {
+		if configOBUs, err := parseAV1CodecConfigurationRecord(buf); err == nil {
+			tu = configOBUs
+		}
+	}
+	if sequenceHeader := findAV1SequenceHeader(tu); sequenceHeader != nil && !bytes.Equal(sequenceHeader, s.sps) {
+		// The sequence header plays the role of the SPS.
+		s.sps = append([]byte(nil), sequenceHeader...)
+		s.sentVideoHeader = false
+	}
+
+	if !s.sentVideoHeader {
+		if s.sps == nil {
+			// Can't describe the stream yet.
+			return nil
+		}
+		record, err := buildAV1CodecConfigurationRecord(s.sps)
+		if err != nil {
+			log.Printf("RTMP: %v", err)
+			return nil
+		}
+		if err := s.writeExVideoTag(rtmpFrameTypeKeyFrame, rtmpExPacketTypeSequenceStart, MediaFormatMimeTypeVideoAV1, record, timestamp); err != nil {
+			return err
+		}
+		s.sentVideoHeader = true
+	}
+
+	if isConfig {
+		return nil
+	}
+
+	isKeyframe := flags&MediaCodecBufferFlagKeyFrame != 0
+	if s.waitForKeyframe {
+		if !isKeyframe {
+			return nil
+		}
+		s.waitForKeyframe = false
+	}
+
+	frameType := byte(rtmpFrameTypeInterFrame)
+	if isKeyframe {
+		frameType = rtmpFrameTypeKeyFrame
+	}
+	return s.writeExVideoTag(frameType, rtmpExPacketTypeCodedFrames, MediaFormatMimeTypeVideoAV1, stripAV1TemporalDelimiters(buf), timestamp)
+}
+
+func (s *RTMPSink) writeAACLocked(buf []byte, timestamp uint32, flags MediaCodecBufferFlag) error {
+	if flags&MediaCodecBufferFlagCodecConfig != 0 {
+		var config mpeg4audio.Config
+		if err := config.Unmarshal(buf); err != nil {
+			log.Printf("RTMP: ignoring invalid AudioSpecificConfig: %v", err)
+			return nil
+		}
+		if !bytes.Equal(buf, s.audioConfig) {
+			s.audioConfig = append([]byte(nil), buf...)
+			s.sentAudioHeader = false
+		}
+		return nil
+	}
+
+	if !s.sentAudioHeader {
+		if err := s.writeAudioTag(flvtag.AACPacketTypeSequenceHeader, s.audioConfig, timestamp); err != nil {
+			return err
+		}
+		s.sentAudioHeader = true
+	}
+	return s.writeAudioTag(flvtag.AACPacketTypeRaw, buf, timestamp)
+}
+
+// writeOpusLocked sends an Opus packet using the Enhanced RTMP Opus FourCC.
+func (s *RTMPSink) writeOpusLocked(buf []byte, timestamp uint32, flags MediaCodecBufferFlag) error {
+	if flags&MediaCodecBufferFlagCodecConfig != 0 {
+		if head := parseOpusCodecConfig(buf); head != nil && !bytes.Equal(head, s.audioConfig) {
+			s.audioConfig = append([]byte(nil), head...)
+			s.sentAudioHeader = false
+		}
+		return nil
+	}
+
+	if !s.sentAudioHeader {
+		if err := s.writeExAudioTag(rtmpExPacketTypeSequenceStart, MediaFormatMimeTypeAudioOpus, s.audioConfig, timestamp); err != nil {
+			return err
+		}
+		s.sentAudioHeader = true
+	}
+	return s.writeExAudioTag(rtmpExPacketTypeCodedFrames, MediaFormatMimeTypeAudioOpus, buf, timestamp)
+}
+
+func (s *RTMPSink) writeVideoTag(frameType flvtag.FrameType, packetType flvtag.AVCPacketType, data []byte, timestamp uint32) error {
+	var payload bytes.Buffer
+	if err := flvtag.EncodeVideoData(&payload, &flvtag.VideoData{
+		FrameType:     frameType,
+		CodecID:       flvtag.CodecIDAVC,
+		AVCPacketType: packetType,
+		Data:          bytes.NewReader(data),
+	}); err != nil {
+		return err
+	}
+	return s.stream.Write(rtmpVideoChunkStreamID, timestamp, &rtmpmsg.VideoMessage{Payload: &payload})
+}
+
+func (s *RTMPSink) writeExVideoTag(frameType, packetType byte, codec MediaFormatMimeType, data []byte, timestamp uint32) error {
+	payload := make([]byte, 0, 5+len(data))
+	payload = append(payload, rtmpExVideoHeaderBit|frameType<<4|packetType)
+	payload = append(payload, rtmpFourCC(codec)...)
+	payload = append(payload, data...)
+	return s.stream.Write(rtmpVideoChunkStreamID, timestamp, &rtmpmsg.VideoMessage{Payload: bytes.NewReader(payload)})
+}
+
+func (s *RTMPSink) writeAudioTag(packetType flvtag.AACPacketType, data []byte, timestamp uint32) error {
+	var payload bytes.Buffer
+	// For AAC the rate/size/type bits are fixed at 44kHz/16-bit/stereo;
+	// the real values come from the AudioSpecificConfig.
+	if err := flvtag.EncodeAudioData(&payload, &flvtag.AudioData{
+		SoundFormat:   flvtag.SoundFormatAAC,
+		SoundRate:     flvtag.SoundRate44kHz,
+		SoundSize:     flvtag.SoundSize16Bit,
+		SoundType:     flvtag.SoundTypeStereo,
+		AACPacketType: packetType,
+		Data:          bytes.NewReader(data),
+	}); err != nil {
+		return err
+	}
+	return s.stream.Write(rtmpAudioChunkStreamID, timestamp, &rtmpmsg.AudioMessage{Payload: &payload})
+}
+
+func (s *RTMPSink) writeExAudioTag(packetType byte, codec MediaFormatMimeType, data []byte, timestamp uint32) error {
+	payload := make([]byte, 0, 5+len(data))
+	payload = append(payload, rtmpExAudioSoundFormat<<4|packetType)
+	payload = append(payload, rtmpFourCC(codec)...)
+	payload = append(payload, data...)
+	return s.stream.Write(rtmpAudioChunkStreamID, timestamp, &rtmpmsg.AudioMessage{Payload: bytes.NewReader(payload)})
+}
+
+func (s *RTMPSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		s.stream = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+//go:build amd64 || arm64
+
+package kinetic
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseRTMPURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		addr      string
+		app       string
+		streamKey string
+		tcURL     string
+	}{
+		{"rtmp://a.rtmp.youtube.com/live2/abcd-efgh", "a.rtmp.youtube.com:1935", "live2", "abcd-efgh", "rtmp://a.rtmp.youtube.com/live2"},
+		{"rtmps://live.example.com/app/key", "live.example.com:443", "app", "key", "rtmps://live.example.com/app"},
+		{"rtmp://127.0.0.1:1936/a/b/key?auth=1", "127.0.0.1:1936", "a/b", "key?auth=1", "rtmp://127.0.0.1:1936/a/b"},
+	}
+	for _, tt := range tests {
+		_, addr, app, streamKey, tcURL, err := parseRTMPURL(tt.url)
+		if err != nil {
+			t.Errorf("parseRTMPURL(%q): %v", tt.url, err)
+			continue
+		}
+		if addr != tt.addr || app != tt.app || streamKey != tt.streamKey || tcURL != tt.tcURL {
+			t.Errorf("parseRTMPURL(%q) = %q, %q, %q, %q", tt.url, addr, app, streamKey, tcURL)
+		}
+	}
+
+	for _, bad := range []string{"srt://host/app/key", "rtmp://host/key", "rtmp://host"} {
+		if _, _, _, _, _, err := parseRTMPURL(bad); err == nil {
+			t.Errorf("parseRTMPURL(%q): expected error", bad)
+		}
+	}
+}
+
+// TestRTMPSinkLoopback publishes to the in-process RTMPServer and checks
+// that video comes back out as Annex-B with SPS/PPS and audio as raw AAC.
+func TestRTMPSinkLoopback(t *testing.T) {
+	server := NewRTMPServer(0)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	sinkURL := fmt.Sprintf("rtmp://127.0.0.1:%d/live/test", server.Port())
+	sink, err := NewRTMPSink(sinkURL, string(MediaFormatMimeTypeVideoH264)+";"+string(MediaFormatMimeTypeAudioAAC))
+	if err != nil {
+		t.Fatalf("NewRTMPSink: %v", err)
+	}
+	defer sink.Close()
+
+	source := server.WaitForSource("", 5*time.Second)
+	if source == nil {
+		t.Fatal("No source connected within timeout")
+	}
+
+	sps := []byte{0x67, 0x42, 0x00, 0x1f, 0x96, 0x35, 0x40, 0xa0, 0x0b, 0x6a}
+	pps := []byte{0x68, 0xce, 0x06, 0xe2}
+	idr := []byte{0x65, 0x88, 0x84, 0x00, 0x33}
+	startCode := []byte{0x00, 0x00, 0x00, 0x01}
+
+	var config []byte
+	for _, nalu := range [][]byte{sps, pps} {
+		config = append(config, startCode...)
+		config = append(config, nalu...)
+	}
+	keyframe := append(append([]byte(nil), startCode...), idr...)
+	aac := []byte{0x21, 0x10, 0x04, 0x60, 0x8c, 0x1c}
+
+	if err := sink.WriteSample(0, config, 0, MediaCodecBufferFlagCodecConfig); err != nil {
+		t.Fatalf("WriteSample(config): %v", err)
+	}
+	if err := sink.WriteSample(0, keyframe, 0, MediaCodecBufferFlagKeyFrame); err != nil {
+		t.Fatalf("WriteSample(keyframe): %v", err)
+	}
+	if err := sink.WriteSample(1, aac, 20000, 0); err != nil {
+		t.Fatalf("WriteSample(audio): %v", err)
+	}
+	for _, i := range []int{-1, 2} {
+		if err := sink.WriteSample(i, aac, 20000, 0); err == nil {
+			t.Errorf("WriteSample(%d) succeeded", i)
+		}
+	}
+
+	videoCh := make(chan *MediaFrame, 1)
+	audioCh := make(chan *MediaFrame, 1)
+	go func() { videoCh <- source.ReadVideoFrame() }()
+	go func() { audioCh <- source.ReadAudioFrame() }()
+
+	select {
+	case frame := <-videoCh:
+		if frame == nil {
+			t.Fatal("source closed before video frame")
+		}
+		var want []byte
+		want = append(want, config...)
+		want = append(want, keyframe...)
+		if !bytes.Equal(frame.Data, want) {
+			t.Errorf("video frame = %x, want %x", frame.Data, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for video frame")
+	}
+
+	select {
+	case frame := <-audioCh:
+		if frame == nil {
+			t.Fatal("source closed before audio frame")
+		}
+		if !bytes.Equal(frame.Data, aac) {
+			t.Errorf("audio frame = %x, want %x", frame.Data, aac)
+		}
+		if frame.PTS != 20000 {
+			t.Errorf("audio PTS = %d, want 20000", frame.PTS)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for audio frame")
+	}
+}
+
+// TestRTMPSinkEnhancedLoopback checks that H.265 and Opus survive the trip
+// through Enhanced RTMP and that the source reports the codecs.
+func TestRTMPSinkEnhancedLoopback(t *testing.T) {
+	server := NewRTMPServer(0)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	sinkURL := fmt.Sprintf("rtmp://127.0.0.1:%d/live/test", server.Port())
+	sink, err := NewRTMPSink(sinkURL, string(MediaFormatMimeTypeVideoH265)+";"+string(MediaFormatMimeTypeAudioOpus))
+	if err != nil {
+		t.Fatalf("NewRTMPSink: %v", err)
+	}
+	defer sink.Close()
+
+	source := server.WaitForSource("", 5*time.Second)
+	if source == nil {
+		t.Fatal("No source connected within timeout")
+	}
+
+	startCode := []byte{0x00, 0x00, 0x00, 0x01}
+	var config []byte
+	for _, nalu := range [][]byte{testHEVCVPS, testHEVCSPS, testHEVCPPS} {
+		config = append(config, startCode...)
+		config = append(config, nalu...)
+	}
+	idr := []byte{0x26, 0x01, 0xaf, 0x06, 0xb8}
+	keyframe := append(append([]byte(nil), startCode...), idr...)
+	opus := []byte{0xfc, 0xff, 0xfe}
+
+	if err := sink.WriteSample(0, config, 0, MediaCodecBufferFlagCodecConfig); err != nil {
+		t.Fatalf("WriteSample(config): %v", err)
+	}
+	if err := sink.WriteSample(0, keyframe, 0, MediaCodecBufferFlagKeyFrame); err != nil {
+		t.Fatalf("WriteSample(keyframe): %v", err)
+	}
+	if err := sink.WriteSample(1, opus, 20000, 0); err != nil {
+		t.Fatalf("WriteSample(audio): %v", err)
+	}
+
+	videoCh := make(chan *MediaFrame, 1)
+	audioCh := make(chan *MediaFrame, 1)
+	go func() { videoCh <- source.ReadVideoFrame() }()
+	go func() { audioCh <- source.ReadAudioFrame() }()
+
+	select {
+	case frame := <-videoCh:
+		if frame == nil {
+			t.Fatal("source closed before video frame")
+		}
+		want := append(append([]byte(nil), config...), keyframe...)
+		if !bytes.Equal(frame.Data, want) {
+			t.Errorf("video frame = %x, want %x", frame.Data, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for video frame")
+	}
+
+	select {
+	case frame := <-audioCh:
+		if frame == nil {
+			t.Fatal("source closed before audio frame")
+		}
+		if !bytes.Equal(frame.Data, opus) {
+			t.Errorf("audio frame = %x, want %x", frame.Data, opus)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for audio frame")
+	}
+
+	if codec := source.VideoCodec(); codec != MediaFormatMimeTypeVideoH265 {
+		t.Errorf("VideoCodec() = %q, want %q", codec, MediaFormatMimeTypeVideoH265)
+	}
+	if codec := source.AudioCodec(); codec != MediaFormatMimeTypeAudioOpus {
+		t.Errorf("AudioCodec() = %q, want %q", codec, MediaFormatMimeTypeAudioOpus)
+	}
+}
+
+func TestNewRTMPSinkRejectsUnsupportedCodec(t *testing.T) {
+	if _, err := NewRTMPSink("rtmp://127.0.0.1:1/live/test", string(MediaFormatMimeTypeVideoVP9)); err == nil {
+		t.Fatal("expected error for VP9")
+	}
+}
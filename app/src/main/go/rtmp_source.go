@@ -9,6 +9,8 @@ import (
 	"io"
 	"log"
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,20 +19,23 @@ import (
 	rtmpmsg "github.com/yutopp/go-rtmp/message"
 )
 
-// MediaFrame holds a single frame with timestamp
-type MediaFrame struct {
-	Data []byte
-	PTS  int64 // microseconds
-}
-
 // RTMPSource represents an active RTMP publish session
 type RTMPSource struct {
-	videoQueue chan *MediaFrame // H.264 NALUs (Annex B format)
-	audioQueue chan *MediaFrame // AAC frames (raw AAC, no ADTS)
+	key string // app/streamKey
+
+	videoQueue chan *MediaFrame // Annex B for H.264/H.265, low-overhead OBUs for AV1, raw VP9 frames
+	audioQueue chan *MediaFrame // Raw AAC frames (no ADTS) or Opus packets
 
+	// Detected codecs, empty until the first sequence header or frame.
+	videoCodec MediaFormatMimeType
+	audioCodec MediaFormatMimeType
+
+	vps []byte // Cached VPS (H.265 only)
 	sps []byte // Cached SPS
 	pps []byte // Cached PPS
 
+	av1SequenceHeader []byte // Cached AV1 configOBUs
+
 	lastVideoPTS int64
 	lastAudioPTS int64
 
@@ -38,14 +43,25 @@ type RTMPSource struct {
 	mu     sync.RWMutex
 }
 
-// RTMPServer manages the RTMP listener
+// RTMPAuthorizer decides whether a publisher is allowed in. It is called from
+// OnConnect with an empty streamKey to vet the app, and again from OnPublish
+// with the full publishing name, including any query string. Returning an
+// error rejects the publisher.
+type RTMPAuthorizer func(app, streamKey string) error
+
+// RTMPServer manages the RTMP listener. Any number of publishers can be
+// connected at once; each is keyed by "app/streamKey".
 type RTMPServer struct {
 	listener net.Listener
 	port     int
 	server   *rtmp.Server
 
-	source     *RTMPSource
+	authorizer RTMPAuthorizer
+
+	sources    map[string]*RTMPSource
+	source     *RTMPSource      // Most recent publisher
 	sourceChan chan *RTMPSource // Signals when a new source connects
+	changed    chan struct{}    // Closed and replaced whenever sources changes
 
 	closed bool
 	mu     sync.RWMutex
@@ -56,10 +72,37 @@ type RTMPServer struct {
 func NewRTMPServer(port int) *RTMPServer {
 	return &RTMPServer{
 		port:       port,
+		sources:    make(map[string]*RTMPSource),
 		sourceChan: make(chan *RTMPSource, 1),
+		changed:    make(chan struct{}),
 	}
 }
 
+// SetAuthorizer installs a callback to accept or reject publishers. With no
+// authorizer every publisher is accepted.
+func (s *RTMPServer) SetAuthorizer(authorizer RTMPAuthorizer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizer = authorizer
+}
+
+func (s *RTMPServer) authorize(app, streamKey string) error {
+	s.mu.RLock()
+	authorizer := s.authorizer
+	s.mu.RUnlock()
+	if authorizer == nil {
+		return nil
+	}
+	return authorizer(app, streamKey)
+}
+
+// rtmpSourceKey strips any query string (often an auth token) from the
+// publishing name so it doesn't end up in the key.
+func rtmpSourceKey(app, publishingName string) string {
+	name, _, _ := strings.Cut(publishingName, "?")
+	return app + "/" + name
+}
+
 // Port returns the port the server is listening on
 func (s *RTMPServer) Port() int {
 	s.mu.RLock()
@@ -124,41 +167,96 @@ func (s *RTMPServer) Stop() {
 		s.listener.Close()
 		s.listener = nil
 	}
-	if s.source != nil {
-		s.source.Close()
-		s.source = nil
+	for key, source := range s.sources {
+		source.Close()
+		delete(s.sources, key)
 	}
+	s.source = nil
 	close(s.sourceChan)
+	close(s.changed)
 }
 
-// WaitForSource blocks until a publisher connects and returns the source
+// WaitForSource blocks until a publisher is connected on key ("app/streamKey")
+// and returns the source. If key is empty it waits for the next publisher on
+// any key instead.
 // Returns nil if timeout is reached or server is stopped
-func (s *RTMPServer) WaitForSource(timeout time.Duration) *RTMPSource {
-	select {
-	case source := <-s.sourceChan:
-		return source
-	case <-time.After(timeout):
-		return nil
+func (s *RTMPServer) WaitForSource(key string, timeout time.Duration) *RTMPSource {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	if key == "" {
+		select {
+		case source := <-s.sourceChan:
+			return source
+		case <-timer.C:
+			return nil
+		}
+	}
+
+	for {
+		s.mu.RLock()
+		source := s.sources[key]
+		changed := s.changed
+		closed := s.closed
+		s.mu.RUnlock()
+
+		if source != nil {
+			return source
+		}
+		if closed {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil
+		}
 	}
 }
 
-// GetSource returns the current source (may be nil)
-func (s *RTMPServer) GetSource() *RTMPSource {
+// GetSource returns the source publishing on key ("app/streamKey"), or the
+// most recent publisher if key is empty (may be nil)
+func (s *RTMPServer) GetSource(key string) *RTMPSource {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.source
+	if key == "" {
+		return s.source
+	}
+	return s.sources[key]
 }
 
-// setSource sets the active source (called by handler)
+// ListSources returns the keys of all connected publishers, sorted
+func (s *RTMPServer) ListSources() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.sources))
+	for key := range s.sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setSource registers a source under its key (called by handler). A new
+// publisher on a key that is already live replaces the old one, which is
+// usually an encoder reconnecting before the old connection timed out.
 func (s *RTMPServer) setSource(source *RTMPSource) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Close any existing source
-	if s.source != nil {
-		s.source.Close()
+	if s.closed {
+		source.Close()
+		return
 	}
+
+	// Close any existing source on the same key
+	if old := s.sources[source.key]; old != nil {
+		old.Close()
+	}
+	s.sources[source.key] = source
 	s.source = source
+	s.notifyLocked()
 
 	// Non-blocking send to channel
 	select {
@@ -167,10 +265,34 @@ func (s *RTMPServer) setSource(source *RTMPSource) {
 	}
 }
 
+// removeSource unregisters a source when its publisher disconnects
+func (s *RTMPServer) removeSource(source *RTMPSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sources[source.key] != source {
+		return
+	}
+	delete(s.sources, source.key)
+	if s.source == source {
+		s.source = nil
+	}
+	s.notifyLocked()
+}
+
+func (s *RTMPServer) notifyLocked() {
+	if s.closed {
+		return
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
 // rtmpHandler implements the RTMP server handler
 type rtmpHandler struct {
 	rtmp.DefaultHandler
 	server *RTMPServer
+	app    string
 	source *RTMPSource
 }
 
@@ -180,6 +302,11 @@ func (h *rtmpHandler) OnServe(conn *rtmp.Conn) {
 
 func (h *rtmpHandler) OnConnect(timestamp uint32, cmd *rtmpmsg.NetConnectionConnect) error {
 	log.Printf("RTMP: OnConnect: app=%s", cmd.Command.App)
+	if err := h.server.authorize(cmd.Command.App, ""); err != nil {
+		log.Printf("RTMP: rejected app=%s: %v", cmd.Command.App, err)
+		return err
+	}
+	h.app = cmd.Command.App
 	return nil
 }
 
@@ -189,10 +316,18 @@ func (h *rtmpHandler) OnCreateStream(timestamp uint32, cmd *rtmpmsg.NetConnectio
 }
 
 func (h *rtmpHandler) OnPublish(ctx *rtmp.StreamContext, timestamp uint32, cmd *rtmpmsg.NetStreamPublish) error {
-	log.Printf("RTMP: OnPublish: name=%s, type=%s", cmd.PublishingName, cmd.PublishingType)
+	key := rtmpSourceKey(h.app, cmd.PublishingName)
+	// Don't log the publishing name itself, it may carry a token
+	log.Printf("RTMP: OnPublish: key=%s, type=%s", key, cmd.PublishingType)
+
+	if err := h.server.authorize(h.app, cmd.PublishingName); err != nil {
+		log.Printf("RTMP: rejected key=%s: %v", key, err)
+		return err
+	}
 
 	// Create new source
 	h.source = &RTMPSource{
+		key:        key,
 		videoQueue: make(chan *MediaFrame, 60), // ~2 seconds of video at 30fps
 		audioQueue: make(chan *MediaFrame, 100),
 	}
@@ -211,60 +346,78 @@ func (h *rtmpHandler) OnAudio(timestamp uint32, payload io.Reader) error {
 		return nil
 	}
 
+	data, err := io.ReadAll(payload)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	if data[0]>>4 == rtmpExAudioSoundFormat {
+		h.onExAudio(timestamp, data)
+		return nil
+	}
+
 	// Decode FLV audio data
 	var audioData flvtag.AudioData
-	if err := flvtag.DecodeAudioData(payload, &audioData); err != nil {
+	if err := flvtag.DecodeAudioData(bytes.NewReader(data), &audioData); err != nil {
 		log.Printf("RTMP: audio decode error: %v", err)
 		return nil
 	}
 
-	// We only handle AAC
+	// Legacy FLV only carries AAC in a form we can use
 	if audioData.SoundFormat != flvtag.SoundFormatAAC {
 		return nil
 	}
+	h.source.setAudioCodec(MediaFormatMimeTypeAudioAAC)
 
-	// Decode AAC-specific data
-	var aacData flvtag.AACAudioData
-	if err := flvtag.DecodeAACAudioData(audioData.Data, &aacData); err != nil {
-		log.Printf("RTMP: AAC decode error: %v", err)
-		return nil
-	}
-
+	// DecodeAudioData has already consumed the AACPacketType byte.
 	// Skip AAC sequence header (contains AudioSpecificConfig)
-	if aacData.AACPacketType == flvtag.AACPacketTypeSequenceHeader {
+	if audioData.AACPacketType == flvtag.AACPacketTypeSequenceHeader {
 		log.Printf("RTMP: received AAC sequence header")
 		return nil
 	}
 
 	// Read raw AAC frame data
-	data, err := io.ReadAll(aacData.Data)
+	frame, err := io.ReadAll(audioData.Data)
 	if err != nil {
 		return nil
 	}
 
-	pts := int64(timestamp) * 1000 // Convert ms to microseconds
-
-	h.source.mu.Lock()
-	h.source.lastAudioPTS = pts
-	closed := h.source.closed
-	h.source.mu.Unlock()
+	h.source.pushAudio(&MediaFrame{
+		Data: frame,
+		PTS:  int64(timestamp) * 1000, // Convert ms to microseconds
+	})
+	return nil
+}
 
-	if closed {
-		return nil
+// onExAudio handles an Enhanced RTMP audio tag.
+func (h *rtmpHandler) onExAudio(timestamp uint32, data []byte) {
+	if len(data) < 5 {
+		return
 	}
+	packetType := data[0] & 0x0F
+	fourCC := string(data[1:5])
+	body := data[5:]
 
-	frame := &MediaFrame{
-		Data: data,
-		PTS:  pts,
+	codec, ok := rtmpFourCCs[fourCC]
+	if !ok || (codec != MediaFormatMimeTypeAudioAAC && codec != MediaFormatMimeTypeAudioOpus) {
+		log.Printf("RTMP: unsupported audio FourCC %q", fourCC)
+		return
 	}
+	h.source.setAudioCodec(codec)
 
-	select {
-	case h.source.audioQueue <- frame:
-	default:
-		// Queue full, drop frame
-	}
+	switch packetType {
+	case rtmpExPacketTypeSequenceStart:
+		log.Printf("RTMP: received %s sequence start", fourCC)
 
-	return nil
+	case rtmpExPacketTypeCodedFrames:
+		h.source.pushAudio(&MediaFrame{
+			Data: body,
+			PTS:  int64(timestamp) * 1000,
+		})
+
+	case rtmpExPacketTypeSequenceEnd:
+		log.Printf("RTMP: received %s sequence end", fourCC)
+	}
 }
 
 func (h *rtmpHandler) OnVideo(timestamp uint32, payload io.Reader) error {
@@ -272,31 +425,37 @@ func (h *rtmpHandler) OnVideo(timestamp uint32, payload io.Reader) error {
 		return nil
 	}
 
+	data, err := io.ReadAll(payload)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	if data[0]&rtmpExVideoHeaderBit != 0 {
+		h.onExVideo(timestamp, data)
+		return nil
+	}
+
 	// Decode FLV video data
 	var videoData flvtag.VideoData
-	if err := flvtag.DecodeVideoData(payload, &videoData); err != nil {
+	if err := flvtag.DecodeVideoData(bytes.NewReader(data), &videoData); err != nil {
 		log.Printf("RTMP: video decode error: %v", err)
 		return nil
 	}
 
-	// We only handle AVC (H.264)
+	// Legacy FLV only carries AVC (H.264) in a form we can use
 	if videoData.CodecID != flvtag.CodecIDAVC {
 		return nil
 	}
+	h.source.setVideoCodec(MediaFormatMimeTypeVideoH264)
 
-	// Decode AVC-specific data
-	var avcData flvtag.AVCVideoPacket
-	if err := flvtag.DecodeAVCVideoPacket(videoData.Data, &avcData); err != nil {
-		log.Printf("RTMP: AVC decode error: %v", err)
-		return nil
-	}
-
-	pts := int64(timestamp)*1000 + int64(avcData.CompositionTime)*1000 // CTS offset in ms
+	// DecodeVideoData has already consumed the AVCPacketType and
+	// CompositionTime header, so videoData.Data is the payload itself.
+	pts := int64(timestamp)*1000 + int64(videoData.CompositionTime)*1000 // CTS offset in ms
 
-	switch avcData.AVCPacketType {
+	switch videoData.AVCPacketType {
 	case flvtag.AVCPacketTypeSequenceHeader:
 		// AVC sequence header contains SPS/PPS
-		data, err := io.ReadAll(avcData.Data)
+		data, err := io.ReadAll(videoData.Data)
 		if err != nil {
 			return nil
 		}
@@ -307,40 +466,133 @@ func (h *rtmpHandler) OnVideo(timestamp uint32, payload io.Reader) error {
 
 	case flvtag.AVCPacketTypeNALU:
 		// NALU data in AVCC format - convert to Annex B
-		data, err := io.ReadAll(avcData.Data)
+		data, err := io.ReadAll(videoData.Data)
 		if err != nil {
 			return nil
 		}
 
 		annexB := h.avccToAnnexB(data, videoData.FrameType == flvtag.FrameTypeKeyFrame)
+		h.source.pushVideo(&MediaFrame{
+			Data: annexB,
+			PTS:  pts,
+		})
+
+	case flvtag.AVCPacketTypeEOS:
+		log.Printf("RTMP: received EOS")
+		h.source.Close()
+	}
 
-		h.source.mu.Lock()
-		h.source.lastVideoPTS = pts
-		closed := h.source.closed
-		h.source.mu.Unlock()
+	return nil
+}
 
-		if closed {
-			return nil
-		}
+// onExVideo handles an Enhanced RTMP video tag.
+func (h *rtmpHandler) onExVideo(timestamp uint32, data []byte) {
+	if len(data) < 5 {
+		return
+	}
+	frameType := (data[0] >> 4) & 0x07
+	packetType := data[0] & 0x0F
+	fourCC := string(data[1:5])
+	body := data[5:]
 
-		frame := &MediaFrame{
-			Data: annexB,
-			PTS:  pts,
+	codec, ok := rtmpFourCCs[fourCC]
+	if !ok || !strings.HasPrefix(string(codec), "video/") {
+		log.Printf("RTMP: unsupported video FourCC %q", fourCC)
+		return
+	}
+	h.source.setVideoCodec(codec)
+
+	switch packetType {
+	case rtmpExPacketTypeSequenceStart:
+		switch codec {
+		case MediaFormatMimeTypeVideoH264:
+			h.parseAVCConfig(body)
+		case MediaFormatMimeTypeVideoH265:
+			h.parseHEVCConfig(body)
+		case MediaFormatMimeTypeVideoAV1:
+			configOBUs, err := parseAV1CodecConfigurationRecord(body)
+			if err != nil {
+				log.Printf("RTMP: %v", err)
+				return
+			}
+			h.source.av1SequenceHeader = append([]byte(nil), configOBUs...)
+		}
+		log.Printf("RTMP: received %s sequence start", fourCC)
+
+	case rtmpExPacketTypeCodedFrames, rtmpExPacketTypeCodedFramesX:
+		pts := int64(timestamp) * 1000
+		// Only AVC and HEVC CodedFrames carry a composition time offset.
+		if packetType == rtmpExPacketTypeCodedFrames &&
+			(codec == MediaFormatMimeTypeVideoH264 || codec == MediaFormatMimeTypeVideoH265) {
+			if len(body) < 3 {
+				return
+			}
+			// SI24
+			cts := int32(uint32(body[0])<<24|uint32(body[1])<<16|uint32(body[2])<<8) >> 8
+			pts += int64(cts) * 1000
+			body = body[3:]
 		}
 
-		select {
-		case h.source.videoQueue <- frame:
+		isKeyframe := frameType == rtmpFrameTypeKeyFrame
+		var frame []byte
+		switch codec {
+		case MediaFormatMimeTypeVideoH264, MediaFormatMimeTypeVideoH265:
+			frame = h.avccToAnnexB(body, isKeyframe)
+		case MediaFormatMimeTypeVideoAV1:
+			frame = h.prependAV1SequenceHeader(body, isKeyframe)
 		default:
-			// Queue full, drop frame
-			log.Printf("RTMP: video queue full, dropping frame")
+			frame = body
 		}
+		h.source.pushVideo(&MediaFrame{
+			Data: frame,
+			PTS:  pts,
+		})
 
-	case flvtag.AVCPacketTypeEOS:
-		log.Printf("RTMP: received EOS")
+	case rtmpExPacketTypeSequenceEnd:
+		log.Printf("RTMP: received %s sequence end", fourCC)
 		h.source.Close()
 	}
+}
 
-	return nil
+// parseHEVCConfig parses an HEVCDecoderConfigurationRecord to extract the
+// VPS/SPS/PPS
+func (h *rtmpHandler) parseHEVCConfig(data []byte) {
+	nalus, err := parseHEVCDecoderConfigurationRecord(data)
+	if err != nil {
+		log.Printf("RTMP: HEVC config parse error: %v", err)
+		return
+	}
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch (nalu[0] >> 1) & 0x3F {
+		case 32:
+			h.source.vps = append([]byte(nil), nalu...)
+		case 33:
+			h.source.sps = append([]byte(nil), nalu...)
+		case 34:
+			h.source.pps = append([]byte(nil), nalu...)
+		}
+	}
+}
+
+// prependAV1SequenceHeader prepends the cached sequence header to keyframes
+// that don't carry one, after the temporal delimiter if there is one, so a
+// decoder can start from any keyframe.
+func (h *rtmpHandler) prependAV1SequenceHeader(tu []byte, isKeyframe bool) []byte {
+	if !isKeyframe || len(h.source.av1SequenceHeader) == 0 || findAV1SequenceHeader(tu) != nil {
+		return tu
+	}
+	var buf bytes.Buffer
+	// A temporal delimiter OBU is always two bytes: header and zero size.
+	if len(tu) >= 2 && (tu[0]>>3)&0x0F == 2 {
+		buf.Write(tu[:2])
+		tu = tu[2:]
+	}
+	buf.Write(h.source.av1SequenceHeader)
+	buf.Write(tu)
+	return buf.Bytes()
 }
 
 // parseAVCConfig parses AVCDecoderConfigurationRecord to extract SPS/PPS
@@ -402,14 +654,18 @@ func (h *rtmpHandler) parseAVCConfig(data []byte) {
 	}
 }
 
-// avccToAnnexB converts AVCC format NALUs to Annex B format
-// Prepends SPS/PPS before keyframes
+// avccToAnnexB converts AVCC/HVCC format NALUs to Annex B format
+// Prepends (VPS/)SPS/PPS before keyframes
 func (h *rtmpHandler) avccToAnnexB(avcc []byte, isKeyframe bool) []byte {
 	var buf bytes.Buffer
 	startCode := []byte{0x00, 0x00, 0x00, 0x01}
 
-	// Prepend SPS/PPS before keyframes
+	// Prepend VPS/SPS/PPS before keyframes
 	if isKeyframe && len(h.source.sps) > 0 && len(h.source.pps) > 0 {
+		if len(h.source.vps) > 0 {
+			buf.Write(startCode)
+			buf.Write(h.source.vps)
+		}
 		buf.Write(startCode)
 		buf.Write(h.source.sps)
 		buf.Write(startCode)
@@ -435,10 +691,16 @@ func (h *rtmpHandler) avccToAnnexB(avcc []byte, isKeyframe bool) []byte {
 func (h *rtmpHandler) OnClose() {
 	log.Printf("RTMP: client disconnected")
 	if h.source != nil {
+		h.server.removeSource(h.source)
 		h.source.Close()
 	}
 }
 
+// Key returns the "app/streamKey" the source is publishing on
+func (s *RTMPSource) Key() string {
+	return s.key
+}
+
 // ReadVideoFrame reads the next video frame (blocking)
 // Returns nil when source is closed
 func (s *RTMPSource) ReadVideoFrame() *MediaFrame {
@@ -459,6 +721,72 @@ func (s *RTMPSource) ReadAudioFrame() *MediaFrame {
 	return frame
 }
 
+// VideoCodec returns the codec of the video track, or "" if it hasn't been
+// detected yet
+func (s *RTMPSource) VideoCodec() MediaFormatMimeType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.videoCodec
+}
+
+// AudioCodec returns the codec of the audio track, or "" if it hasn't been
+// detected yet
+func (s *RTMPSource) AudioCodec() MediaFormatMimeType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.audioCodec
+}
+
+func (s *RTMPSource) setVideoCodec(codec MediaFormatMimeType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.videoCodec != codec {
+		log.Printf("RTMP: video codec %s", codec)
+		s.videoCodec = codec
+	}
+}
+
+func (s *RTMPSource) setAudioCodec(codec MediaFormatMimeType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.audioCodec != codec {
+		log.Printf("RTMP: audio codec %s", codec)
+		s.audioCodec = codec
+	}
+}
+
+// pushVideo queues a video frame, dropping it if the queue is full. The lock
+// is held across the send so Close can't close the channel under us.
+func (s *RTMPSource) pushVideo(frame *MediaFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastVideoPTS = frame.PTS
+	if s.closed {
+		return
+	}
+	select {
+	case s.videoQueue <- frame:
+	default:
+		// Queue full, drop frame
+		log.Printf("RTMP: video queue full, dropping frame")
+	}
+}
+
+// pushAudio queues an audio frame, dropping it if the queue is full.
+func (s *RTMPSource) pushAudio(frame *MediaFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAudioPTS = frame.PTS
+	if s.closed {
+		return
+	}
+	select {
+	case s.audioQueue <- frame:
+	default:
+		// Queue full, drop frame
+	}
+}
+
 // GetVideoPTS returns the PTS of the last video frame
 func (s *RTMPSource) GetVideoPTS() int64 {
 	s.mu.RLock()
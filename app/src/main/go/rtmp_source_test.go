@@ -73,7 +73,7 @@ func TestRTMPServerWithFFmpeg(t *testing.T) {
 	}()
 
 	// Wait for source to connect
-	source := server.WaitForSource(5 * time.Second)
+	source := server.WaitForSource("", 5*time.Second)
 	if source == nil {
 		t.Logf("ffmpeg stderr: %s", stderr.String())
 		t.Fatal("No source connected within timeout")
@@ -349,7 +349,8 @@ func TestAVCCLengthPrefixSizes(t *testing.T) {
 	}
 }
 
-// TestServerReconnect tests that a new publisher replaces the old one
+// TestServerReconnect tests that a new publisher on the same key replaces the
+// old one
 func TestServerReconnect(t *testing.T) {
 	server := NewRTMPServer(0)
 	if err := server.Start(); err != nil {
@@ -359,28 +360,186 @@ func TestServerReconnect(t *testing.T) {
 
 	// Simulate first source
 	source1 := &RTMPSource{
+		key:        "live/cam",
 		videoQueue: make(chan *MediaFrame, 10),
 		audioQueue: make(chan *MediaFrame, 10),
 	}
 	server.setSource(source1)
 
-	if server.GetSource() != source1 {
+	if server.GetSource("") != source1 {
 		t.Error("Source should be source1")
 	}
 
 	// Simulate second source (should replace first)
 	source2 := &RTMPSource{
+		key:        "live/cam",
 		videoQueue: make(chan *MediaFrame, 10),
 		audioQueue: make(chan *MediaFrame, 10),
 	}
 	server.setSource(source2)
 
-	if server.GetSource() != source2 {
+	if server.GetSource("") != source2 {
 		t.Error("Source should be source2")
 	}
+	if server.GetSource("live/cam") != source2 {
+		t.Error("Source for live/cam should be source2")
+	}
 
 	// First source should be closed
 	if !source1.IsClosed() {
 		t.Error("Old source should be closed when new one connects")
 	}
 }
+
+// TestEnhancedRTMPAV1 tests that an Enhanced RTMP av01 stream is detected and
+// that keyframes come out with the sequence header prepended
+func TestEnhancedRTMPAV1(t *testing.T) {
+	source := &RTMPSource{
+		videoQueue: make(chan *MediaFrame, 10),
+		audioQueue: make(chan *MediaFrame, 10),
+	}
+	handler := &rtmpHandler{source: source}
+
+	record, err := buildAV1CodecConfigurationRecord(testAV1SequenceHeader)
+	if err != nil {
+		t.Fatalf("buildAV1CodecConfigurationRecord: %v", err)
+	}
+	configOBUs := record[4:]
+
+	// IsExHeader | KeyFrame | SequenceStart, then the FourCC
+	sequenceStart := append([]byte{0x80 | 1<<4 | 0, 'a', 'v', '0', '1'}, record...)
+	if err := handler.OnVideo(0, bytes.NewReader(sequenceStart)); err != nil {
+		t.Fatalf("OnVideo(sequence start): %v", err)
+	}
+	if codec := source.VideoCodec(); codec != MediaFormatMimeTypeVideoAV1 {
+		t.Errorf("VideoCodec() = %q, want %q", codec, MediaFormatMimeTypeVideoAV1)
+	}
+
+	td := []byte{0x12, 0x00}
+	obuFrame := []byte{0x32, 0x03, 0xaa, 0xbb, 0xcc}
+	tu := append(append([]byte(nil), td...), obuFrame...)
+
+	// IsExHeader | KeyFrame | CodedFrames; av01 has no composition time
+	codedFrames := append([]byte{0x80 | 1<<4 | 1, 'a', 'v', '0', '1'}, tu...)
+	if err := handler.OnVideo(40, bytes.NewReader(codedFrames)); err != nil {
+		t.Fatalf("OnVideo(coded frames): %v", err)
+	}
+
+	frame := <-source.videoQueue
+	var want []byte
+	want = append(want, td...)
+	want = append(want, configOBUs...)
+	want = append(want, obuFrame...)
+	if !bytes.Equal(frame.Data, want) {
+		t.Errorf("frame = %x, want %x", frame.Data, want)
+	}
+	if frame.PTS != 40000 {
+		t.Errorf("PTS = %d, want 40000", frame.PTS)
+	}
+}
+
+// TestServerMultiplePublishers tests that publishers on different keys
+// coexist and can be waited on by key
+func TestServerMultiplePublishers(t *testing.T) {
+	server := NewRTMPServer(0)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	newSource := func(key string) *RTMPSource {
+		return &RTMPSource{
+			key:        key,
+			videoQueue: make(chan *MediaFrame, 10),
+			audioQueue: make(chan *MediaFrame, 10),
+		}
+	}
+
+	cam1 := newSource("live/cam1")
+	server.setSource(cam1)
+
+	// WaitForSource(key) should wake up when that key is published
+	waited := make(chan *RTMPSource, 1)
+	go func() { waited <- server.WaitForSource("live/cam2", 5*time.Second) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cam2 := newSource("live/cam2")
+	server.setSource(cam2)
+
+	select {
+	case source := <-waited:
+		if source != cam2 {
+			t.Errorf("WaitForSource(live/cam2) = %p, want %p", source, cam2)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForSource(live/cam2) did not return")
+	}
+
+	if cam1.IsClosed() {
+		t.Error("cam1 should stay open when cam2 publishes")
+	}
+	if keys := server.ListSources(); len(keys) != 2 || keys[0] != "live/cam1" || keys[1] != "live/cam2" {
+		t.Errorf("ListSources() = %v", keys)
+	}
+
+	server.removeSource(cam1)
+	if keys := server.ListSources(); len(keys) != 1 || keys[0] != "live/cam2" {
+		t.Errorf("ListSources() after remove = %v", keys)
+	}
+	if server.GetSource("live/cam1") != nil {
+		t.Error("GetSource(live/cam1) should be nil after remove")
+	}
+
+	if source := server.WaitForSource("live/missing", 50*time.Millisecond); source != nil {
+		t.Error("WaitForSource(live/missing) should time out")
+	}
+}
+
+// TestServerAuthorizer tests that the authorizer can reject apps and keys
+func TestServerAuthorizer(t *testing.T) {
+	server := NewRTMPServer(0)
+	server.SetAuthorizer(func(app, streamKey string) error {
+		if app != "live" {
+			return fmt.Errorf("unknown app %q", app)
+		}
+		if streamKey != "" && streamKey != "good?token=secret" {
+			return fmt.Errorf("bad stream key")
+		}
+		return nil
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	mimeTypes := string(MediaFormatMimeTypeVideoH264)
+	base := fmt.Sprintf("rtmp://127.0.0.1:%d", server.Port())
+
+	if sink, err := NewRTMPSink(base+"/other/good?token=secret", mimeTypes); err == nil {
+		sink.Close()
+		t.Error("expected connect to be rejected for app other")
+	}
+
+	// Depending on the client, a rejected publish may not surface as an
+	// error, so check the server side instead.
+	if sink, err := NewRTMPSink(base+"/live/bad", mimeTypes); err == nil {
+		defer sink.Close()
+	}
+	if source := server.WaitForSource("live/bad", 500*time.Millisecond); source != nil {
+		t.Error("publisher with a bad key should be rejected")
+	}
+
+	sink, err := NewRTMPSink(base+"/live/good?token=secret", mimeTypes)
+	if err != nil {
+		t.Fatalf("NewRTMPSink: %v", err)
+	}
+	defer sink.Close()
+
+	source := server.WaitForSource("live/good", 5*time.Second)
+	if source == nil {
+		t.Fatal("publisher with a good key should be accepted")
+	}
+	if source.Key() != "live/good" {
+		t.Errorf("Key() = %q, want live/good", source.Key())
+	}
+}
@@ -31,6 +31,8 @@ type RTSPServerSink struct {
 	pts0 int64
 }
 
+var _ Sink = (*RTSPServerSink)(nil)
+
 type rtspTrack struct {
 	media *description.Media
 
@@ -304,7 +306,7 @@ func NewRTSPServerSink(disk *BinaryDumpSink, encodedMediaFormatMimeTypes string)
 	return s, nil
 }
 
-func (s *RTSPServerSink) WriteSample(i int, buf []byte, ptsMicroseconds int64) error {
+func (s *RTSPServerSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Printf("panic: %s\n", debug.Stack())
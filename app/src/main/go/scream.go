@@ -0,0 +1,422 @@
+package kinetic
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+)
+
+// SCReAM v2 parameters, from include/scream/ScreamV2Tx.h. The headers are
+// only a reference; the estimator is implemented here so it runs in the
+// interceptor chain like GCC.
+const (
+	screamLossBeta               = 0.8  // cwnd scale on a loss event
+	screamECNCEBeta              = 0.9  // cwnd scale on an ECN-CE event
+	screamMultiplicativeIncrease = 0.05 // Max cwnd growth per RTT
+	screamBytesInFlightHeadroom  = 2.0  // cwnd may only grow while in use
+	screamQueueDelayTarget       = 100 * time.Millisecond
+	screamMSS                    = 1200
+	screamMinCwnd                = 3 * screamMSS
+	screamInitialRTT             = 100 * time.Millisecond
+	screamMinRTT                 = 10 * time.Millisecond
+	screamAlphaGain              = 1.0 / 4 // EWMA gain of the congestion level per RTT
+	screamMarkThreshold          = screamQueueDelayTarget / 4
+	screamRampUpTime             = 5 * time.Second // Until multiplicative increase is at full speed
+	screamPacketTimeout          = 2 * time.Second // Unacknowledged packets are forgotten
+	screamBaseOWDBucket          = time.Second
+	screamBaseOWDHistory         = 60 // Base OWD buckets kept
+	screamReorderThreshold       = 3  // Later packets acked before one counts as lost
+)
+
+// screamKey identifies a sent packet in feedback: by transport-wide sequence
+// number for TWCC, with ssrc 0, or by SSRC and RTP sequence number for RFC
+// 8888.
+type screamKey struct {
+	ssrc uint32
+	seq  uint16
+}
+
+type screamPacket struct {
+	key      screamKey
+	sent     time.Time
+	size     int
+	inFlight bool
+}
+
+// screamAck is a packet's entry in a feedback report. arrival is on the
+// receiver's clock, so only differences between arrivals are meaningful.
+type screamAck struct {
+	key      screamKey
+	received bool
+	arrival  time.Duration
+	ecnCE    bool
+}
+
+// screamEstimator is a cc.BandwidthEstimator implementing the window based
+// SCReAM v2 congestion control of draft-johansson-ccwg-rfc8298bis-screamv2,
+// which revises RFC 8298. It tracks the bytes in flight against a
+// congestion window that shrinks on loss, ECN-CE and queue delay, and
+// targets a bitrate of one window per RTT. The encoder, not a packet
+// queue, holds the rate to the target, so the RTT it's divided by is the
+// latest rather than the smoothed one: it rises with the queue and brakes
+// the rate before the window backs off.
+//
+// Until the first feedback arrives, e.g. when the endpoint sends none, the
+// target stays at the initial bitrate.
+type screamEstimator struct {
+	sync.Mutex
+	now func() time.Time
+
+	minBitrate int
+	maxBitrate int
+
+	packets map[screamKey]*screamPacket
+	sent    []*screamPacket // In send order
+	epoch   time.Time       // Send times are relative to this for OWD
+
+	cwnd           float64 // Bytes
+	bytesInFlight  int
+	srtt           time.Duration // Paces backoffs to once per RTT
+	rtt            time.Duration // Latest sample
+	queueDelay     time.Duration
+	baseOWD        []screamOWDBucket
+	lastCongestion time.Time
+	lossEvents     int
+	ecnEvents      int
+	averageLoss    float64 // Fraction of packets lost, smoothed per feedback
+	targetBitrate  int
+	onChange       func(int)
+
+	// Queue delay is treated like L4S marking: each packet is marked in
+	// proportion to how far its queue delay is between a quarter of the
+	// target and the target. The fraction marked per RTT is smoothed into
+	// alpha, and the window is scaled by 1-alpha/2 in every RTT with marks.
+	alpha       float64
+	marks       float64
+	markSamples int
+	lastMarkRTT time.Time
+}
+
+// screamOWDBucket is the lowest one way delay seen in one bucket of time.
+type screamOWDBucket struct {
+	start time.Time
+	min   time.Duration
+}
+
+var _ cc.BandwidthEstimator = (*screamEstimator)(nil)
+
+func newScreamEstimator(initialBitrate, minBitrate, maxBitrate int) *screamEstimator {
+	return &screamEstimator{
+		now:           time.Now,
+		minBitrate:    minBitrate,
+		maxBitrate:    maxBitrate,
+		packets:       make(map[screamKey]*screamPacket),
+		cwnd:          max(float64(initialBitrate)/8*screamInitialRTT.Seconds(), screamMinCwnd),
+		srtt:          screamInitialRTT,
+		rtt:           screamInitialRTT,
+		targetBitrate: initialBitrate,
+	}
+}
+
+// AddStream records the size and send time of every packet so feedback can
+// be matched to it.
+func (e *screamEstimator) AddStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	var twccExtensionID uint8
+	for _, ext := range info.RTPHeaderExtensions {
+		if ext.URI == sdp.TransportCCURI {
+			twccExtensionID = uint8(ext.ID)
+		}
+	}
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		key := screamKey{ssrc: header.SSRC, seq: header.SequenceNumber}
+		if twccExtensionID != 0 {
+			var ext rtp.TransportCCExtension
+			if err := ext.Unmarshal(header.GetExtension(twccExtensionID)); err == nil {
+				key = screamKey{seq: ext.TransportSequence}
+			}
+		}
+		e.onSent(key, header.MarshalSize()+len(payload))
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (e *screamEstimator) onSent(key screamKey, size int) {
+	e.Lock()
+	defer e.Unlock()
+	now := e.now()
+	if e.epoch.IsZero() {
+		e.epoch = now
+	}
+	// Forget packets feedback never came for. They aren't counted as lost,
+	// so an endpoint that sends no feedback leaves the target alone.
+	for len(e.sent) > 0 && now.Sub(e.sent[0].sent) > screamPacketTimeout {
+		e.forgetLocked(e.sent[0])
+		e.sent = e.sent[1:]
+	}
+	if old, ok := e.packets[key]; ok {
+		// Sequence numbers wrapped around.
+		e.forgetLocked(old)
+	}
+	p := &screamPacket{key: key, sent: now, size: size, inFlight: true}
+	e.packets[key] = p
+	e.sent = append(e.sent, p)
+	e.bytesInFlight += size
+}
+
+func (e *screamEstimator) forgetLocked(p *screamPacket) {
+	if p.inFlight {
+		p.inFlight = false
+		e.bytesInFlight -= p.size
+	}
+	if e.packets[p.key] == p {
+		delete(e.packets, p.key)
+	}
+}
+
+// WriteRTCP updates the window from TWCC and RFC 8888 feedback.
+func (e *screamEstimator) WriteRTCP(pkts []rtcp.Packet, _ interceptor.Attributes) error {
+	for _, pkt := range pkts {
+		var acks []screamAck
+		switch fb := pkt.(type) {
+		case *rtcp.TransportLayerCC:
+			acks = twccAcks(fb)
+		case *rtcp.CCFeedbackReport:
+			acks = ccfbAcks(fb)
+		default:
+			continue
+		}
+		e.onFeedback(acks)
+	}
+	return nil
+}
+
+// twccAcks unpacks the packet statuses and arrival times of a TWCC report.
+func twccAcks(fb *rtcp.TransportLayerCC) []screamAck {
+	acks := make([]screamAck, 0, fb.PacketStatusCount)
+	arrival := time.Duration(fb.ReferenceTime) * 64 * time.Millisecond
+	seq := fb.BaseSequenceNumber
+	deltas := fb.RecvDeltas
+	add := func(symbol uint16) {
+		ack := screamAck{key: screamKey{seq: seq}}
+		if symbol == rtcp.TypeTCCPacketReceivedSmallDelta || symbol == rtcp.TypeTCCPacketReceivedLargeDelta {
+			if len(deltas) == 0 {
+				return
+			}
+			arrival += time.Duration(deltas[0].Delta) * time.Microsecond
+			deltas = deltas[1:]
+			ack.received = true
+			ack.arrival = arrival
+		}
+		acks = append(acks, ack)
+		seq++
+	}
+	for _, chunk := range fb.PacketChunks {
+		switch chunk := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			for i := uint16(0); i < chunk.RunLength; i++ {
+				add(chunk.PacketStatusSymbol)
+			}
+		case *rtcp.StatusVectorChunk:
+			for _, symbol := range chunk.SymbolList {
+				add(symbol)
+			}
+		}
+		if len(acks) >= int(fb.PacketStatusCount) {
+			break
+		}
+	}
+	return acks[:min(len(acks), int(fb.PacketStatusCount))]
+}
+
+// ccfbAcks unpacks an RFC 8888 report. Arrival times are offsets in 1/1024 s
+// before the report timestamp, which is the middle 32 bits of an NTP time.
+func ccfbAcks(fb *rtcp.CCFeedbackReport) []screamAck {
+	var acks []screamAck
+	reportTime := time.Duration(fb.ReportTimestamp) * time.Second / 65536
+	for _, block := range fb.ReportBlocks {
+		for i, metric := range block.MetricBlocks {
+			ack := screamAck{key: screamKey{ssrc: block.MediaSSRC, seq: block.BeginSequence + uint16(i)}}
+			if metric.Received {
+				ack.received = true
+				ack.arrival = reportTime - time.Duration(metric.ArrivalTimeOffset)*time.Second/1024
+				ack.ecnCE = metric.ECN == rtcp.ECNCE
+			}
+			acks = append(acks, ack)
+		}
+	}
+	return acks
+}
+
+func (e *screamEstimator) onFeedback(acks []screamAck) {
+	e.Lock()
+	now := e.now()
+
+	// A packet is lost once enough later ones in the same report arrived.
+	lastReceived := -1
+	for i, ack := range acks {
+		if ack.received {
+			lastReceived = i
+		}
+	}
+
+	var ackedBytes, ackedPackets, lostPackets int
+	var lost, ecnCE bool
+	var latestSent time.Time
+	var queueDelay time.Duration
+	var owdSamples int
+	for i, ack := range acks {
+		p, ok := e.packets[ack.key]
+		if !ok || !p.inFlight {
+			continue
+		}
+		if !ack.received {
+			if lastReceived-i >= screamReorderThreshold {
+				lost = true
+				lostPackets++
+				e.forgetLocked(p)
+			}
+			continue
+		}
+		ackedBytes += p.size
+		ackedPackets++
+		ecnCE = ecnCE || ack.ecnCE
+		if p.sent.After(latestSent) {
+			latestSent = p.sent
+		}
+		owd := ack.arrival - p.sent.Sub(e.epoch)
+		qd := owd - e.updateBaseOWDLocked(now, owd)
+		queueDelay += qd
+		owdSamples++
+		e.marks += min(max(float64(qd-screamMarkThreshold)/float64(screamQueueDelayTarget-screamMarkThreshold), 0), 1)
+		e.markSamples++
+		e.forgetLocked(p)
+	}
+
+	if n := ackedPackets + lostPackets; n > 0 {
+		e.averageLoss += (float64(lostPackets)/float64(n) - e.averageLoss) / 8
+	}
+	if owdSamples > 0 {
+		e.queueDelay = queueDelay / time.Duration(owdSamples)
+		e.rtt = max(now.Sub(latestSent), screamMinRTT)
+		e.srtt = (7*e.srtt + e.rtt) / 8
+	}
+	e.updateCwndLocked(now, ackedBytes, lost, ecnCE)
+
+	targetBitrate := int(e.cwnd * 8 / e.rtt.Seconds())
+	targetBitrate = min(max(targetBitrate, e.minBitrate), e.maxBitrate)
+	changed := targetBitrate != e.targetBitrate
+	e.targetBitrate = targetBitrate
+	onChange := e.onChange
+	e.Unlock()
+
+	if changed && onChange != nil {
+		onChange(targetBitrate)
+	}
+}
+
+// updateBaseOWDLocked adds a one way delay sample and returns the lowest one
+// of the last minute, which is taken as the delay with empty queues.
+func (e *screamEstimator) updateBaseOWDLocked(now time.Time, owd time.Duration) time.Duration {
+	if n := len(e.baseOWD); n == 0 || now.Sub(e.baseOWD[n-1].start) >= screamBaseOWDBucket {
+		e.baseOWD = append(e.baseOWD, screamOWDBucket{start: now, min: owd})
+		if len(e.baseOWD) > screamBaseOWDHistory {
+			e.baseOWD = e.baseOWD[1:]
+		}
+	}
+	last := &e.baseOWD[len(e.baseOWD)-1]
+	last.min = min(last.min, owd)
+	base := last.min
+	for _, bucket := range e.baseOWD {
+		base = min(base, bucket.min)
+	}
+	return base
+}
+
+// updateCwndLocked backs the window off at most once per RTT on loss or
+// ECN-CE and in proportion to alpha on queue delay, and otherwise grows it by
+// the acknowledged bytes while the window is actually used.
+func (e *screamEstimator) updateCwndLocked(now time.Time, ackedBytes int, lost, ecnCE bool) {
+	congested := false
+	if (lost || ecnCE) && now.Sub(e.lastCongestion) >= e.srtt {
+		if lost {
+			e.cwnd *= screamLossBeta
+			e.lossEvents++
+		} else {
+			e.cwnd *= screamECNCEBeta
+			e.ecnEvents++
+		}
+		e.lastCongestion = now
+		congested = true
+	}
+	if e.markSamples > 0 && now.Sub(e.lastMarkRTT) >= e.srtt {
+		fraction := e.marks / float64(e.markSamples)
+		e.alpha = (1-screamAlphaGain)*e.alpha + screamAlphaGain*fraction
+		if fraction > 0 {
+			if !congested {
+				e.cwnd *= 1 - e.alpha/2
+			}
+			e.lastCongestion = now
+			congested = true
+		}
+		e.marks, e.markSamples = 0, 0
+		e.lastMarkRTT = now
+	}
+	e.cwnd = max(e.cwnd, screamMinCwnd)
+	if congested || ackedBytes == 0 || float64(e.bytesInFlight+ackedBytes)*screamBytesInFlightHeadroom < e.cwnd {
+		return
+	}
+	// One MSS per RTT, plus up to 5% per RTT while nothing is marked,
+	// ramping up over the time since the last congestion so the window
+	// finds new capacity quickly without overshooting right after a backoff.
+	increase := float64(ackedBytes) * screamMSS / e.cwnd
+	if e.queueDelay < screamMarkThreshold {
+		ramp := min(now.Sub(e.lastCongestion).Seconds()/screamRampUpTime.Seconds(), 1)
+		increase += float64(ackedBytes) * screamMultiplicativeIncrease * ramp
+	}
+	e.cwnd += increase
+	// Don't let the window grow past what the maximum bitrate needs.
+	e.cwnd = min(e.cwnd, float64(e.maxBitrate)/8*e.srtt.Seconds()*screamBytesInFlightHeadroom)
+}
+
+func (e *screamEstimator) GetTargetBitrate() int {
+	e.Lock()
+	defer e.Unlock()
+	return e.targetBitrate
+}
+
+func (e *screamEstimator) OnTargetBitrateChange(f func(int)) {
+	e.Lock()
+	defer e.Unlock()
+	e.onChange = f
+}
+
+func (e *screamEstimator) GetStats() map[string]any {
+	e.Lock()
+	defer e.Unlock()
+	return map[string]any{
+		"targetBitrate": e.targetBitrate,
+		"cwnd":          int(e.cwnd),
+		"bytesInFlight": e.bytesInFlight,
+		"srtt":          e.srtt,
+		"rtt":           e.rtt,
+		"queueDelay":    e.queueDelay,
+		"lossEvents":    e.lossEvents,
+		"averageLoss":   e.averageLoss,
+		"ecnEvents":     e.ecnEvents,
+		"alpha":         e.alpha,
+	}
+}
+
+func (e *screamEstimator) Close() error {
+	e.Lock()
+	defer e.Unlock()
+	e.packets = make(map[screamKey]*screamPacket)
+	e.sent = nil
+	e.bytesInFlight = 0
+	return nil
+}
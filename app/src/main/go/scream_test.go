@@ -0,0 +1,246 @@
+package kinetic
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// simulatedLink is a bottleneck with a FIFO queue, random loss and a
+// receiver that sends RFC 8888 feedback every 50 ms.
+type simulatedLink struct {
+	capacity func(now time.Duration) int // bps
+	delay    time.Duration               // One way propagation delay
+	loss     float64
+	rng      *rand.Rand
+
+	busyUntil  time.Duration
+	pending    []simulatedPacket // Not reported yet
+	feedback   []simulatedFeedback
+	lastReport time.Duration
+
+	queueDelay time.Duration // Sum over received packets
+	received   int
+}
+
+type simulatedPacket struct {
+	seq      uint16
+	received bool
+	arrival  time.Duration
+}
+
+type simulatedFeedback struct {
+	at     time.Duration
+	report *rtcp.CCFeedbackReport
+}
+
+const simulatedSSRC = 1234
+
+func (l *simulatedLink) send(now time.Duration, seq uint16, size int) {
+	p := simulatedPacket{seq: seq}
+	start := max(l.busyUntil, now)
+	// Random loss, or tail drop once half a second is queued.
+	if l.rng.Float64() >= l.loss && start-now < 500*time.Millisecond {
+		l.busyUntil = start + time.Duration(size*8)*time.Second/time.Duration(l.capacity(now))
+		p.received = true
+		p.arrival = l.busyUntil + l.delay
+		l.queueDelay += l.busyUntil - now
+		l.received++
+	}
+	l.pending = append(l.pending, p)
+}
+
+// report builds the receiver's feedback at now, covering packets up to the
+// last one that arrived, and schedules it to reach the sender a propagation
+// delay later.
+func (l *simulatedLink) report(now time.Duration) {
+	last := -1
+	for i, p := range l.pending {
+		if p.received && p.arrival <= now {
+			last = i
+		}
+	}
+	if last < 0 {
+		return
+	}
+	block := rtcp.CCFeedbackReportBlock{MediaSSRC: simulatedSSRC, BeginSequence: l.pending[0].seq}
+	for _, p := range l.pending[:last+1] {
+		metric := rtcp.CCFeedbackMetricBlock{}
+		if p.received && p.arrival <= now {
+			metric.Received = true
+			metric.ArrivalTimeOffset = uint16((now - p.arrival) * 1024 / time.Second)
+		}
+		block.MetricBlocks = append(block.MetricBlocks, metric)
+	}
+	l.pending = l.pending[last+1:]
+	l.feedback = append(l.feedback, simulatedFeedback{
+		at: now + l.delay,
+		report: &rtcp.CCFeedbackReport{
+			ReportBlocks:    []rtcp.CCFeedbackReportBlock{block},
+			ReportTimestamp: uint32(now * 65536 / time.Second),
+		},
+	})
+}
+
+// simulateScream sends at the estimator's target over link for duration and
+// returns the average target bitrate over the last five seconds.
+func simulateScream(t *testing.T, e *screamEstimator, link *simulatedLink, duration time.Duration) int {
+	t.Helper()
+	const packetSize = 1200
+	const step = time.Millisecond
+	start := time.Unix(1_700_000_000, 0)
+	var now time.Duration
+	e.now = func() time.Time { return start.Add(now) }
+
+	writer := e.AddStream(&interceptor.StreamInfo{SSRC: simulatedSSRC}, interceptor.RTPWriterFunc(
+		func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+			link.send(now, header.SequenceNumber, header.MarshalSize()+len(payload))
+			return len(payload), nil
+		}))
+	payload := make([]byte, packetSize-12)
+
+	var seq uint16
+	var credit float64
+	var total, samples int
+	for ; now < duration; now += step {
+		credit += float64(e.GetTargetBitrate()) / 8 * step.Seconds()
+		for ; credit >= packetSize; credit -= packetSize {
+			header := &rtp.Header{Version: 2, SSRC: simulatedSSRC, SequenceNumber: seq}
+			if _, err := writer.Write(header, payload, nil); err != nil {
+				t.Fatal(err)
+			}
+			seq++
+		}
+		if now-link.lastReport >= 50*time.Millisecond {
+			link.report(now)
+			link.lastReport = now
+		}
+		for len(link.feedback) > 0 && link.feedback[0].at <= now {
+			if err := e.WriteRTCP([]rtcp.Packet{link.feedback[0].report}, nil); err != nil {
+				t.Fatal(err)
+			}
+			link.feedback = link.feedback[1:]
+		}
+		if now >= duration-5*time.Second {
+			total += e.GetTargetBitrate()
+			samples++
+		}
+	}
+	return total / samples
+}
+
+func TestScreamTracksCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		capacity int
+		loss     float64
+		minShare float64 // Of capacity
+	}{
+		{"clean", 3_000_000, 0, 0.8},
+		{"lossy", 3_000_000, 0.01, 0.7},
+		{"slow", 800_000, 0.005, 0.8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newScreamEstimator(1_000_000, 100_000, 10_000_000)
+			link := &simulatedLink{
+				capacity: func(time.Duration) int { return tc.capacity },
+				delay:    20 * time.Millisecond,
+				loss:     tc.loss,
+				rng:      rand.New(rand.NewSource(1)),
+			}
+			got := simulateScream(t, e, link, 30*time.Second)
+			if got < int(tc.minShare*float64(tc.capacity)) || got > tc.capacity*11/10 {
+				t.Errorf("target %d bps on a %d bps link, want %.0f%% to 110%% of capacity; stats %v",
+					got, tc.capacity, 100*tc.minShare, e.GetStats())
+			}
+			if avg := link.queueDelay / time.Duration(link.received); avg > screamQueueDelayTarget {
+				t.Errorf("average queue delay %v, want at most %v", avg, screamQueueDelayTarget)
+			}
+		})
+	}
+}
+
+func TestScreamFollowsCapacityDrop(t *testing.T) {
+	e := newScreamEstimator(1_000_000, 100_000, 10_000_000)
+	link := &simulatedLink{
+		capacity: func(now time.Duration) int {
+			if now < 20*time.Second {
+				return 4_000_000
+			}
+			return 1_000_000
+		},
+		delay: 20 * time.Millisecond,
+		rng:   rand.New(rand.NewSource(1)),
+	}
+	if got := simulateScream(t, e, link, 30*time.Second); got > 1_100_000 || got < 600_000 {
+		t.Errorf("target %d bps after the link dropped to 1 Mbps; stats %v", got, e.GetStats())
+	}
+}
+
+func TestScreamBounds(t *testing.T) {
+	e := newScreamEstimator(1_000_000, 500_000, 2_000_000)
+	link := &simulatedLink{
+		capacity: func(time.Duration) int { return 10_000_000 },
+		delay:    20 * time.Millisecond,
+		rng:      rand.New(rand.NewSource(1)),
+	}
+	if got := simulateScream(t, e, link, 20*time.Second); got != 2_000_000 {
+		t.Errorf("target %d bps on a fast link, want the 2 Mbps maximum", got)
+	}
+
+	e = newScreamEstimator(1_000_000, 500_000, 2_000_000)
+	link = &simulatedLink{
+		capacity: func(time.Duration) int { return 200_000 },
+		delay:    20 * time.Millisecond,
+		rng:      rand.New(rand.NewSource(1)),
+	}
+	if got := simulateScream(t, e, link, 20*time.Second); got != 500_000 {
+		t.Errorf("target %d bps on a slow link, want the 500 kbps minimum", got)
+	}
+}
+
+func TestTWCCAcks(t *testing.T) {
+	fb := &rtcp.TransportLayerCC{
+		BaseSequenceNumber: 65534,
+		PacketStatusCount:  5,
+		ReferenceTime:      10,
+		PacketChunks: []rtcp.PacketStatusChunk{
+			&rtcp.StatusVectorChunk{
+				SymbolSize: rtcp.TypeTCCSymbolSizeTwoBit,
+				SymbolList: []uint16{
+					rtcp.TypeTCCPacketReceivedSmallDelta,
+					rtcp.TypeTCCPacketNotReceived,
+					rtcp.TypeTCCPacketReceivedLargeDelta,
+				},
+			},
+			&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketReceivedSmallDelta, RunLength: 2},
+		},
+		RecvDeltas: []*rtcp.RecvDelta{
+			{Type: rtcp.TypeTCCPacketReceivedSmallDelta, Delta: 1000},
+			{Type: rtcp.TypeTCCPacketReceivedLargeDelta, Delta: -250},
+			{Type: rtcp.TypeTCCPacketReceivedSmallDelta, Delta: 500},
+			{Type: rtcp.TypeTCCPacketReceivedSmallDelta, Delta: 0},
+		},
+	}
+	ref := 640 * time.Millisecond
+	want := []screamAck{
+		{key: screamKey{seq: 65534}, received: true, arrival: ref + 1000*time.Microsecond},
+		{key: screamKey{seq: 65535}},
+		{key: screamKey{seq: 0}, received: true, arrival: ref + 750*time.Microsecond},
+		{key: screamKey{seq: 1}, received: true, arrival: ref + 1250*time.Microsecond},
+		{key: screamKey{seq: 2}, received: true, arrival: ref + 1250*time.Microsecond},
+	}
+	got := twccAcks(fb)
+	if len(got) != len(want) {
+		t.Fatalf("got %d acks, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ack %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -23,6 +23,18 @@ package kinetic
 #include <stdint.h>
 #include <srt/srt.h>
 
+// Listener mode access control. The Go side is in srt_access.go, since a file
+// with //export can't define C functions.
+extern int goSRTListenCallback(uintptr_t handle, SRTSOCKET ns, char* streamid, struct sockaddr* peeraddr);
+
+static int srt_listen_callback_go(void* opaque, SRTSOCKET ns, int hsversion, const struct sockaddr* peeraddr, const char* streamid) {
+    return goSRTListenCallback((uintptr_t)opaque, ns, (char*)streamid, (struct sockaddr*)peeraddr);
+}
+
+static int srt_set_listen_callback(SRTSOCKET lsn, uintptr_t handle) {
+    return srt_listen_callback(lsn, srt_listen_callback_go, (void*)handle);
+}
+
 // SRT stats for BBR-like bandwidth estimation
 typedef struct {
     double msRTT;           // Round-trip time in ms
@@ -42,10 +54,10 @@ typedef struct {
 } srt_bwe_stats_t;
 
 // Helper to get all stats needed for bandwidth estimation
-// Uses clear=1 to get interval-based stats (pktSndLoss resets each call)
-static int srt_get_bwe_stats(SRTSOCKET sock, srt_bwe_stats_t* out) {
+// Use clear=1 to get interval-based stats (pktSndLoss resets each call)
+static int srt_get_bwe_stats(SRTSOCKET sock, srt_bwe_stats_t* out, int clear) {
     SRT_TRACEBSTATS stats;
-    if (srt_bstats(sock, &stats, 1) == 0) {
+    if (srt_bstats(sock, &stats, clear) == 0) {
         out->msRTT = stats.msRTT;
         out->pktFlightSize = stats.pktFlightSize;
         out->pktSndLoss = stats.pktSndLoss;
@@ -68,6 +80,7 @@ static int srt_get_bwe_stats(SRTSOCKET sock, srt_bwe_stats_t* out) {
 import "C"
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"log"
 	"net"
@@ -75,6 +88,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -148,7 +162,7 @@ func setSocketOptions(s C.int, binding int, options map[string]string) error {
 					if err == nil {
 						result := C.srt_setsockflag(s, C.SRT_SOCKOPT(so.option), unsafe.Pointer(&v32), C.int32_t(unsafe.Sizeof(v32)))
 						if result == -1 {
-							return fmt.Errorf("warning - error setting option %s to %s, %w", so.name, val, srtGetAndClearError())
+							return fmt.Errorf("warning - error setting option %s to %s, %w", so.name, redactSRTOption(so.name, val), srtGetAndClearError())
 						}
 					}
 				} else if so.dataType == tInteger64 {
@@ -156,7 +170,7 @@ func setSocketOptions(s C.int, binding int, options map[string]string) error {
 					if err == nil {
 						result := C.srt_setsockflag(s, C.SRT_SOCKOPT(so.option), unsafe.Pointer(&v), C.int32_t(unsafe.Sizeof(v)))
 						if result == -1 {
-							return fmt.Errorf("warning - error setting option %s to %s, %w", so.name, val, srtGetAndClearError())
+							return fmt.Errorf("warning - error setting option %s to %s, %w", so.name, redactSRTOption(so.name, val), srtGetAndClearError())
 						}
 					}
 				} else if so.dataType == tString {
@@ -164,7 +178,7 @@ func setSocketOptions(s C.int, binding int, options map[string]string) error {
 					defer C.free(unsafe.Pointer(sval))
 					result := C.srt_setsockflag(s, C.SRT_SOCKOPT(so.option), unsafe.Pointer(sval), C.int32_t(len(val)))
 					if result == -1 {
-						return fmt.Errorf("warning - error setting option %s to %s, %w", so.name, val, srtGetAndClearError())
+						return fmt.Errorf("warning - error setting option %s to %s, %w", so.name, redactSRTOption(so.name, val), srtGetAndClearError())
 					}
 
 				} else if so.dataType == tBoolean {
@@ -177,7 +191,7 @@ func setSocketOptions(s C.int, binding int, options map[string]string) error {
 						result = C.srt_setsockflag(s, C.SRT_SOCKOPT(so.option), unsafe.Pointer(&v), C.int32_t(unsafe.Sizeof(v)))
 					}
 					if result == -1 {
-						return fmt.Errorf("warning - error setting option %s to %s, %w", so.name, val, srtGetAndClearError())
+						return fmt.Errorf("warning - error setting option %s to %s, %w", so.name, redactSRTOption(so.name, val), srtGetAndClearError())
 					}
 				} else if so.dataType == tTransType {
 					var result C.int
@@ -189,7 +203,7 @@ func setSocketOptions(s C.int, binding int, options map[string]string) error {
 						result = C.srt_setsockflag(s, C.SRT_SOCKOPT(so.option), unsafe.Pointer(&v), C.int32_t(unsafe.Sizeof(v)))
 					}
 					if result == -1 {
-						return fmt.Errorf("warning - error setting option %s to %s: %w", so.name, val, srtGetAndClearError())
+						return fmt.Errorf("warning - error setting option %s to %s: %w", so.name, redactSRTOption(so.name, val), srtGetAndClearError())
 					}
 				}
 			}
@@ -252,9 +266,113 @@ const (
 	bweLossCooldown   = 2 * time.Second        // Wait 2s after loss before probing again
 )
 
+// srtMode is how an SRT sink or source establishes its connection, from the URL's mode
+// parameter as in srt-live-transmit.
+type srtMode int
+
+const (
+	srtModeCaller srtMode = iota
+	srtModeListener
+	srtModeRendezvous
+)
+
+func (m srtMode) String() string {
+	switch m {
+	case srtModeListener:
+		return "listener"
+	case srtModeRendezvous:
+		return "rendezvous"
+	}
+	return "caller"
+}
+
+// parseSRTMode parses the mode parameter. Without one, a URL with no host
+// (srt://:9000) listens and any other calls.
+func parseSRTMode(mode, host string) (srtMode, error) {
+	switch mode {
+	case "":
+		if host == "" {
+			return srtModeListener, nil
+		}
+		return srtModeCaller, nil
+	case "caller", "client":
+		return srtModeCaller, nil
+	case "listener", "server":
+		return srtModeListener, nil
+	case "rendezvous":
+		return srtModeRendezvous, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q", mode)
+}
+
+// srtListenBacklog is how many handshakes a listener queues before accepting
+// them.
+const srtListenBacklog = 8
+
+// srtReceiver is a receiver connected to a listener mode SRTSink.
+type srtReceiver struct {
+	sck      SRTSocket
+	addr     net.Addr
+	streamID string
+
+	lastPktSndLossTotal int64
+}
+
+// srtBroadcast writes to every receiver of a listener mode sink, so each gets
+// the same MPEG-TS stream. Receivers that fail are dropped rather than
+// failing the write. Caller must hold the sink's lock.
+type srtBroadcast struct {
+	s *SRTSink
+}
+
+func (b srtBroadcast) Write(p []byte) (int, error) {
+	receivers := b.s.receivers[:0]
+	for _, r := range b.s.receivers {
+		if _, err := r.sck.Write(p); err != nil {
+			log.Printf("SRT: dropping receiver %s: %v\n", r.addr, err)
+			C.srt_close(r.sck.fd)
+			continue
+		}
+		receivers = append(receivers, r)
+	}
+	clear(b.s.receivers[len(receivers):])
+	b.s.receivers = receivers
+	return len(p), nil
+}
+
+// SRTSinkOption configures the SRT sink
+type SRTSinkOption func(*SRTSink)
+
+// WithSRTAccessCallback sets the callback that accepts or rejects receivers
+// of a listener mode sink by their stream ID. Without one, every receiver is
+// accepted.
+func WithSRTAccessCallback(callback SRTAccessCallback) SRTSinkOption {
+	return func(s *SRTSink) {
+		s.accessCallback = callback
+	}
+}
+
+// WithSRTSecurity encrypts the connection, see SRTSecurityConfig.
+func WithSRTSecurity(config SRTSecurityConfig) SRTSinkOption {
+	return func(s *SRTSink) {
+		s.security = &config
+	}
+}
+
+// WithSRTBonding bonds a caller mode sink's connection across local
+// interfaces, see SRTBondingConfig. It takes precedence over the grouptype
+// URL parameter.
+func WithSRTBonding(config SRTBondingConfig) SRTSinkOption {
+	return func(s *SRTSink) {
+		s.bonding = &config
+	}
+}
+
 type SRTSink struct {
 	sync.Mutex
 
+	security *SRTSecurityConfig
+
 	mpw         *mpegts.Writer
 	bw          *bufio.Writer
 	sck         SRTSocket
@@ -262,10 +380,26 @@ type SRTSink struct {
 	pliCallback SRTPLICallback
 	closed      bool
 
+	// Samples waiting for sendLoop, so WriteSample never blocks on the
+	// connection.
+	queue     *srtSendQueue
+	queueSize int
+
 	// Connection parameters for reconnect
-	ip      net.IP
-	port    uint16
-	options map[string]string
+	addr *srtAddress
+
+	// Listener mode state
+	listener          C.int
+	receivers         []*srtReceiver
+	accessCallback    SRTAccessCallback
+	listenCallbackKey uintptr
+	passphrase        atomic.Pointer[string] // Set by SetPassphrase
+
+	// Bonding state, nil bonding if not bonded. sck is the group.
+	bonding        *SRTBondingConfig
+	links          []*srtLink
+	linkInterfaces func(ip net.IP) (map[string]net.IP, error)
+	linksDone      chan struct{} // Closed to stop monitorLinks
 
 	// AIMD bandwidth estimation state
 	targetBitrate       int64     // Current target bitrate in bps
@@ -274,7 +408,32 @@ type SRTSink struct {
 	lastPktSndLossTotal int64     // For detecting new packet loss
 }
 
-var sinkCount int
+var _ Sink = (*SRTSink)(nil)
+
+// libsrt is started up for the first sink or source and cleaned up after the
+// last one.
+var (
+	srtUsersMu sync.Mutex
+	srtUsers   int
+)
+
+func srtStartup() {
+	srtUsersMu.Lock()
+	defer srtUsersMu.Unlock()
+	if srtUsers == 0 {
+		C.srt_startup()
+	}
+	srtUsers++
+}
+
+func srtCleanup() {
+	srtUsersMu.Lock()
+	defer srtUsersMu.Unlock()
+	srtUsers--
+	if srtUsers == 0 {
+		C.srt_cleanup()
+	}
+}
 
 func sockAddrFromIp4(ip net.IP, port uint16) (*C.struct_sockaddr, int, error) {
 	var raw syscall.RawSockaddrInet4
@@ -311,27 +470,66 @@ func sockAddrFromIp(ip net.IP, port uint16) (*C.struct_sockaddr, int, error) {
 	return nil, 0, fmt.Errorf("unknown address family")
 }
 
-func NewSRTSink(s, encodedMediaFormatMimeTypes string) (*SRTSink, error) {
-	log.Printf("SRT: creating sink for %s with mimeTypes %s\n", s, encodedMediaFormatMimeTypes)
+// addrFromSockaddr converts a sockaddr filled in by libsrt.
+func addrFromSockaddr(sa *C.struct_sockaddr) net.Addr {
+	if sa == nil {
+		return nil
+	}
+	switch (*syscall.RawSockaddr)(unsafe.Pointer(sa)).Family {
+	case syscall.AF_INET:
+		raw := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		p := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		return &net.UDPAddr{IP: net.IP(raw.Addr[:]).To16(), Port: int(p[0])<<8 | int(p[1])}
+	case syscall.AF_INET6:
+		raw := (*syscall.RawSockaddrInet6)(unsafe.Pointer(sa))
+		p := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		return &net.UDPAddr{IP: append(net.IP(nil), raw.Addr[:]...), Port: int(p[0])<<8 | int(p[1])}
+	}
+	return nil
+}
 
-	parsed, err := url.Parse(s)
+func resolveSRTHost(host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	ips, err := net.LookupIP(host)
 	if err != nil {
-		return nil, fmt.Errorf("SRT: failed to parse URL: %w", err)
+		return nil, fmt.Errorf("SRT: failed to resolve hostname %s: %w", host, err)
 	}
+	log.Printf("SRT: resolved %s to %s\n", host, ips[0])
+	return ips[0], nil
+}
 
-	ip := net.ParseIP(parsed.Hostname())
-	if ip == nil {
-		ips, err := net.LookupIP(parsed.Hostname())
-		if err != nil {
-			return nil, fmt.Errorf("SRT: failed to resolve hostname %s: %w", parsed.Hostname(), err)
-		}
-		ip = ips[0]
-		log.Printf("SRT: resolved %s to %s\n", parsed.Hostname(), ip)
-	}
+// srtAddress is where an SRT sink or source connects or listens, parsed from
+// its URL.
+type srtAddress struct {
+	mode      srtMode
+	ip        net.IP // In listener mode, the address listened on
+	port      uint16
+	localIP   net.IP // Rendezvous mode only
+	localPort uint16
+	options   map[string]string
+}
 
-	port, err := strconv.Atoi(parsed.Port())
+// parseSRTURL parses an srt:// URL. The mode parameter selects how the
+// connection is made:
+//
+//   - caller (the default) connects to host:port.
+//   - listener binds host:port, which may leave out the host. srt://:9000
+//     listens without a mode.
+//   - rendezvous connects to host:port from the local adapter and port
+//     parameters, which default to any address and the remote port.
+//
+// In caller mode, grouptype=broadcast|backup bonds the connection across
+// the interfaces named by groupinterfaces, see SRTBondingConfig. Other
+// parameters set socket options, see SocketOptions.
+func parseSRTURL(s string) (*srtAddress, error) {
+	parsed, err := url.Parse(s)
 	if err != nil {
-		return nil, fmt.Errorf("SRT: failed to parse port: %w", err)
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = redactSRTURL(urlErr.URL)
+		}
+		return nil, fmt.Errorf("SRT: failed to parse URL: %w", err)
 	}
 
 	options := map[string]string{}
@@ -341,77 +539,371 @@ func NewSRTSink(s, encodedMediaFormatMimeTypes string) (*SRTSink, error) {
 		}
 	}
 
-	tracks := []*mpegts.Track{}
-	for _, v := range strings.Split(encodedMediaFormatMimeTypes, ";") {
-		codec := MediaFormatMimeType(v).MPEGTSCodec()
-		tracks = append(tracks, &mpegts.Track{Codec: codec})
+	mode, err := parseSRTMode(options["mode"], parsed.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("SRT: %w", err)
 	}
 
-	if sinkCount == 0 {
-		C.srt_startup()
+	var ip net.IP
+	if parsed.Hostname() != "" {
+		if ip, err = resolveSRTHost(parsed.Hostname()); err != nil {
+			return nil, err
+		}
+	} else if mode == srtModeListener {
+		ip = net.IPv4zero
+	} else {
+		return nil, fmt.Errorf("SRT: %s mode needs a host", mode)
 	}
-	sinkCount++
 
-	sink := &SRTSink{
-		tracks:        tracks,
-		ip:            ip,
-		port:          uint16(port),
-		options:       options,
-		targetBitrate: startBitrateBps,
+	port, err := strconv.Atoi(parsed.Port())
+	if err != nil {
+		return nil, fmt.Errorf("SRT: failed to parse port: %w", err)
 	}
 
-	if err := sink.connect(); err != nil {
-		sinkCount--
-		if sinkCount == 0 {
-			C.srt_cleanup()
+	var localIP net.IP
+	localPort := port
+	if mode == srtModeRendezvous {
+		if adapter, ok := options["adapter"]; ok {
+			if localIP = net.ParseIP(adapter); localIP == nil {
+				return nil, fmt.Errorf("SRT: invalid adapter address %s", adapter)
+			}
+		} else if ip.To4() != nil {
+			localIP = net.IPv4zero
+		} else {
+			localIP = net.IPv6unspecified
+		}
+		if p, ok := options["port"]; ok {
+			if localPort, err = strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("SRT: failed to parse local port: %w", err)
+			}
 		}
-		return nil, err
 	}
 
-	log.Printf("SRT: sink created with %d tracks\n", len(tracks))
-	return sink, nil
+	return &srtAddress{
+		mode:      mode,
+		ip:        ip,
+		port:      uint16(port),
+		localIP:   localIP,
+		localPort: uint16(localPort),
+		options:   options,
+	}, nil
 }
 
-// connect creates a new SRT socket and connects. Caller must hold the lock or
-// be in the constructor (no concurrent access yet).
-func (s *SRTSink) connect() error {
-	sa, salen, err := sockAddrFromIp(s.ip, s.port)
+// dial creates a new SRT socket and connects it as a caller or rendezvous.
+func (a *srtAddress) dial() (C.int, error) {
+	sa, salen, err := sockAddrFromIp(a.ip, a.port)
 	if err != nil {
-		return fmt.Errorf("SRT: failed to create sockaddr: %w", err)
+		return -1, fmt.Errorf("SRT: failed to create sockaddr: %w", err)
 	}
 
 	fd := C.srt_create_socket()
 
-	if err := setSocketOptions(fd, bindingPre, s.options); err != nil {
+	if err := setSocketOptions(fd, bindingPre, a.options); err != nil {
 		C.srt_close(fd)
-		return fmt.Errorf("SRT: failed to set pre-bind options: %w", err)
+		return -1, fmt.Errorf("SRT: failed to set pre-bind options: %w", err)
 	}
 
-	log.Printf("SRT: connecting to %s:%d...\n", s.ip, s.port)
-	if res := C.srt_connect(fd, sa, C.int(salen)); res == -1 {
+	var res C.int
+	if a.mode == srtModeRendezvous {
+		lsa, lsalen, err := sockAddrFromIp(a.localIP, a.localPort)
+		if err != nil {
+			C.srt_close(fd)
+			return -1, fmt.Errorf("SRT: failed to create local sockaddr: %w", err)
+		}
+		log.Printf("SRT: rendezvous with %s:%d from port %d...\n", a.ip, a.port, a.localPort)
+		res = C.srt_rendezvous(fd, lsa, C.int(lsalen), sa, C.int(salen))
+	} else {
+		log.Printf("SRT: connecting to %s:%d...\n", a.ip, a.port)
+		res = C.srt_connect(fd, sa, C.int(salen))
+	}
+	if res == -1 {
 		err := srtGetAndClearError()
 		C.srt_close(fd)
-		return fmt.Errorf("SRT: connect failed: %w", err)
+		return -1, fmt.Errorf("SRT: connect failed: %w", err)
 	}
 	log.Printf("SRT: connected\n")
 
-	if err := setSocketOptions(fd, bindingPost, s.options); err != nil {
+	if err := setSocketOptions(fd, bindingPost, a.options); err != nil {
 		C.srt_close(fd)
-		return fmt.Errorf("SRT: failed to set post-bind options: %w", err)
+		return -1, fmt.Errorf("SRT: failed to set post-bind options: %w", err)
+	}
+	return fd, nil
+}
+
+// listen creates a new SRT socket listening on the address, and sets a.port
+// to the one picked if it was 0. A nonzero listenCallbackKey installs the
+// registered srtListenCallback to vet callers.
+func (a *srtAddress) listen(listenCallbackKey uintptr) (C.int, error) {
+	sa, salen, err := sockAddrFromIp(a.ip, a.port)
+	if err != nil {
+		return -1, fmt.Errorf("SRT: failed to create sockaddr: %w", err)
+	}
+
+	fd := C.srt_create_socket()
+
+	// Accepted sockets inherit the listener's options.
+	if err := setSocketOptions(fd, bindingPre, a.options); err != nil {
+		C.srt_close(fd)
+		return -1, fmt.Errorf("SRT: failed to set pre-bind options: %w", err)
+	}
+
+	if listenCallbackKey != 0 {
+		if res := C.srt_set_listen_callback(fd, C.uintptr_t(listenCallbackKey)); res == -1 {
+			err := srtGetAndClearError()
+			C.srt_close(fd)
+			return -1, fmt.Errorf("SRT: failed to set listen callback: %w", err)
+		}
+	}
+
+	if res := C.srt_bind(fd, sa, C.int(salen)); res == -1 {
+		err := srtGetAndClearError()
+		C.srt_close(fd)
+		return -1, fmt.Errorf("SRT: bind failed: %w", err)
+	}
+
+	var bound syscall.RawSockaddrAny
+	boundlen := C.int(unsafe.Sizeof(bound))
+	if res := C.srt_getsockname(fd, (*C.struct_sockaddr)(unsafe.Pointer(&bound)), &boundlen); res != -1 {
+		if addr, ok := addrFromSockaddr((*C.struct_sockaddr)(unsafe.Pointer(&bound))).(*net.UDPAddr); ok {
+			a.port = uint16(addr.Port)
+		}
+	}
+
+	if res := C.srt_listen(fd, srtListenBacklog); res == -1 {
+		err := srtGetAndClearError()
+		C.srt_close(fd)
+		return -1, fmt.Errorf("SRT: listen failed: %w", err)
+	}
+	log.Printf("SRT: listening on %s:%d\n", a.ip, a.port)
+	return fd, nil
+}
+
+// srtAccept waits for the next caller on listener. It returns -1 once the
+// listener is closed.
+func srtAccept(listener C.int) (C.int, net.Addr, error) {
+	var raw syscall.RawSockaddrAny
+	rawlen := C.int(unsafe.Sizeof(raw))
+	fd := C.srt_accept(listener, (*C.struct_sockaddr)(unsafe.Pointer(&raw)), &rawlen)
+	if fd == -1 {
+		return -1, nil, fmt.Errorf("SRT: accept failed: %w", srtGetAndClearError())
+	}
+	return fd, addrFromSockaddr((*C.struct_sockaddr)(unsafe.Pointer(&raw))), nil
+}
+
+// NewSRTSink creates a sink that sends MPEG-TS over SRT to the URL, see
+// parseSRTURL. In listener mode, every receiver that connects gets the
+// stream.
+func NewSRTSink(s, encodedMediaFormatMimeTypes string, opts ...SRTSinkOption) (*SRTSink, error) {
+	log.Printf("SRT: creating sink for %s with mimeTypes %s\n", redactSRTURL(s), encodedMediaFormatMimeTypes)
+
+	addr, err := parseSRTURL(s)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []*mpegts.Track{}
+	for _, v := range strings.Split(encodedMediaFormatMimeTypes, ";") {
+		codec := MediaFormatMimeType(v).MPEGTSCodec()
+		tracks = append(tracks, &mpegts.Track{Codec: codec})
+	}
+
+	srtStartup()
+
+	sink := &SRTSink{
+		tracks:        tracks,
+		addr:          addr,
+		queueSize:     defaultSRTSendQueueSize,
+		targetBitrate: startBitrateBps,
+	}
+	for _, opt := range opts {
+		opt(sink)
+	}
+	if sink.queueSize <= 0 {
+		srtCleanup()
+		return nil, fmt.Errorf("SRT: send queue size must be positive, not %d", sink.queueSize)
+	}
+	sink.queue = newSRTSendQueue(sink.queueSize)
+	if sink.security != nil {
+		if err := sink.security.apply(addr.options); err != nil {
+			srtCleanup()
+			return nil, err
+		}
+	}
+	if sink.bonding == nil {
+		if sink.bonding, err = parseSRTBonding(addr.options); err != nil {
+			srtCleanup()
+			return nil, fmt.Errorf("SRT: %w", err)
+		}
+	}
+	if sink.bonding != nil {
+		if addr.mode != srtModeCaller {
+			srtCleanup()
+			return nil, fmt.Errorf("SRT: bonding requires caller mode, not %s", addr.mode)
+		}
+		sink.linkInterfaces = sink.bonding.bondingInterfaces
+		sink.linksDone = make(chan struct{})
+	}
+
+	if addr.mode == srtModeListener {
+		err = sink.listen()
+	} else {
+		err = sink.connect()
+	}
+	if err != nil {
+		srtCleanup()
+		return nil, err
+	}
+	if sink.bonding != nil {
+		go sink.monitorLinks()
 	}
+	go sink.sendLoop()
 
+	log.Printf("SRT: sink created with %d tracks\n", len(tracks))
+	return sink, nil
+}
+
+func srtPayloadSize(fd C.int) (int, error) {
 	var payloadSize C.int
 	if res, _ := C.srt_getsockflag(fd, C.SRTO_PAYLOADSIZE, unsafe.Pointer(&payloadSize), (*C.int)(unsafe.Pointer(&payloadSize))); res == -1 {
+		return 0, fmt.Errorf("SRT: failed to get payload size")
+	}
+	return int(payloadSize), nil
+}
+
+func srtStreamID(fd C.int) string {
+	var buf [512]C.char
+	size := C.int(len(buf))
+	if res := C.srt_getsockflag(fd, C.SRTO_STREAMID, unsafe.Pointer(&buf[0]), &size); res == -1 {
+		return ""
+	}
+	return C.GoStringN(&buf[0], size)
+}
+
+// connect creates a new SRT socket and connects, as a caller or rendezvous.
+// Caller must hold the lock or be in the constructor (no concurrent access
+// yet).
+func (s *SRTSink) connect() error {
+	var fd C.int
+	var links []*srtLink
+	var err error
+	if s.bonding != nil {
+		addrs, ierr := s.linkInterfaces(s.addr.ip)
+		if ierr != nil {
+			log.Printf("SRT: failed to list interfaces, connecting over the default route: %v\n", ierr)
+		}
+		fd, links, err = s.addr.dialGroup(s.bonding, addrs)
+	} else {
+		fd, err = s.addr.dial()
+	}
+	if err != nil {
+		return err
+	}
+
+	payloadSize, err := srtPayloadSize(fd)
+	if err != nil {
 		C.srt_close(fd)
-		return fmt.Errorf("SRT: failed to get payload size")
+		return err
 	}
 
-	sck := SRTSocket{fd: fd, payloadSize: int(payloadSize)}
-	bw := bufio.NewWriterSize(sck, int(payloadSize))
+	sck := SRTSocket{fd: fd, payloadSize: payloadSize}
+	bw := bufio.NewWriterSize(sck, payloadSize)
 
 	s.sck = sck
 	s.bw = bw
 	s.mpw = mpegts.NewWriter(bw, s.tracks)
+	s.links = links
+	return nil
+}
+
+// listen starts accepting receivers for a listener mode sink. Only called
+// from the constructor.
+func (s *SRTSink) listen() error {
+	s.listenCallbackKey = registerSRTListenCallback(s.onListen)
+	fd, err := s.addr.listen(s.listenCallbackKey)
+	if err != nil {
+		unregisterSRTListenCallback(s.listenCallbackKey)
+		return err
+	}
+
+	payloadSize, err := srtPayloadSize(fd)
+	if err != nil {
+		C.srt_close(fd)
+		unregisterSRTListenCallback(s.listenCallbackKey)
+		return err
+	}
+
+	s.listener = fd
+	s.bw = bufio.NewWriterSize(srtBroadcast{s: s}, payloadSize)
+	s.mpw = mpegts.NewWriter(s.bw, s.tracks)
+
+	go s.acceptLoop(fd)
+	return nil
+}
+
+// onListen vets a receiver with the access callback, then gives it the
+// passphrase set by SetPassphrase, since a listening socket's can't change.
+// It runs on an SRT thread, so it doesn't take the lock.
+func (s *SRTSink) onListen(ns C.int, streamID string, addr net.Addr) bool {
+	if s.accessCallback != nil && !s.accessCallback(streamID, addr) {
+		return false
+	}
+	if passphrase := s.passphrase.Load(); passphrase != nil {
+		if err := setSRTPassphrase(ns, *passphrase); err != nil {
+			log.Printf("SRT: failed to set passphrase for receiver %s: %v\n", addr, err)
+			return false
+		}
+	}
+	return true
+}
+
+// acceptLoop adds receivers as they connect to the listening socket, until
+// it's closed.
+func (s *SRTSink) acceptLoop(listener C.int) {
+	for {
+		fd, addr, err := srtAccept(listener)
+		if err != nil {
+			s.Lock()
+			closed := s.closed
+			s.Unlock()
+			if !closed {
+				log.Printf("SRT: no longer accepting receivers: %v\n", err)
+			}
+			return
+		}
+		if err := s.addReceiver(fd, addr); err != nil {
+			log.Printf("SRT: failed to add receiver: %v\n", err)
+			C.srt_close(fd)
+		}
+	}
+}
+
+func (s *SRTSink) addReceiver(fd C.int, addr net.Addr) error {
+	if err := setSocketOptions(fd, bindingPost, s.addr.options); err != nil {
+		return fmt.Errorf("SRT: failed to set post-bind options: %w", err)
+	}
+	payloadSize, err := srtPayloadSize(fd)
+	if err != nil {
+		return err
+	}
+	r := &srtReceiver{
+		sck:      SRTSocket{fd: fd, payloadSize: payloadSize},
+		addr:     addr,
+		streamID: srtStreamID(fd),
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return fmt.Errorf("SRT: sink closed")
+	}
+	s.receivers = append(s.receivers, r)
+	log.Printf("SRT: receiver %s connected with stream ID %q, %d receivers\n", addr, r.streamID, len(s.receivers))
+
+	// The receiver can only start decoding from a keyframe, which also
+	// carries the PAT and PMT.
+	if s.pliCallback != nil {
+		go s.pliCallback.OnPLI()
+	}
 	return nil
 }
 
@@ -446,52 +938,11 @@ func (s *SRTSink) reconnect() error {
 	}
 }
 
-
-
-// splitNALUs splits an Annex B byte stream into individual NAL units.
-// It handles both 3-byte (0x00 0x00 0x01) and 4-byte (0x00 0x00 0x00 0x01) start codes.
-func splitNALUs(buf []byte) [][]byte {
-	var nalus [][]byte
-	start := -1
-
-	for i := 0; i < len(buf); i++ {
-		// Check for 4-byte start code
-		if i+3 < len(buf) && buf[i] == 0x00 && buf[i+1] == 0x00 && buf[i+2] == 0x00 && buf[i+3] == 0x01 {
-			if start >= 0 {
-				nalus = append(nalus, buf[start:i])
-			}
-			start = i + 4
-			i += 3
-			continue
-		}
-		// Check for 3-byte start code
-		if i+2 < len(buf) && buf[i] == 0x00 && buf[i+1] == 0x00 && buf[i+2] == 0x01 {
-			if start >= 0 {
-				nalus = append(nalus, buf[start:i])
-			}
-			start = i + 3
-			i += 2
-			continue
-		}
-	}
-
-	// Append the last NAL unit
-	if start >= 0 && start < len(buf) {
-		nalus = append(nalus, buf[start:])
-	}
-
-	return nalus
-}
-
-func (s *SRTSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, mediaCodecFlags int32) error {
-	s.Lock()
-	defer s.Unlock()
-
-	if s.closed {
-		return fmt.Errorf("SRT: sink closed")
-	}
-
-	if i >= len(s.tracks) {
+// WriteSample queues the sample for sending and returns without waiting for
+// the connection. If the queue is full, e.g. while reconnecting, samples are
+// dropped as described by srtSendQueue, see QueueStats.
+func (s *SRTSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	if i < 0 || i >= len(s.tracks) {
 		return fmt.Errorf("SRT: invalid track index %d", i)
 	}
 
@@ -500,19 +951,43 @@ func (s *SRTSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, mediaCod
 		return fmt.Errorf("SRT: track %d has nil codec", i)
 	}
 
-	if err := s.writeSampleLocked(t, buf, ptsMicroseconds, mediaCodecFlags); err != nil {
-		log.Printf("SRT: write failed: %v, reconnecting...\n", err)
-		if reconnErr := s.reconnect(); reconnErr != nil {
-			return reconnErr
+	sample := &srtSample{
+		track:           i,
+		buf:             bytes.Clone(buf),
+		ptsMicroseconds: ptsMicroseconds,
+		flags:           flags,
+		keyframe:        flags&MediaCodecBufferFlagKeyFrame != 0,
+	}
+	switch t.Codec.(type) {
+	case *mpegts.CodecH264:
+		sample.video = true
+		sample.nonReference = isNonReferenceFrame(buf, false)
+	case *mpegts.CodecH265:
+		sample.video = true
+		sample.nonReference = isNonReferenceFrame(buf, true)
+	}
+	// Not every caller passes MediaCodec's flags, and the queue stops
+	// sending video until the next keyframe after dropping any.
+	if sample.video && !sample.keyframe {
+		_, hevc := t.Codec.(*mpegts.CodecH265)
+		if isRandomAccessFrame(buf, hevc) {
+			sample.keyframe = true
+			sample.flags |= MediaCodecBufferFlagKeyFrame
 		}
-		// Drop this sample - the mpegts writer state was reset on reconnect
-		return nil
+	}
+
+	ok, needKeyframe := s.queue.push(sample)
+	if !ok {
+		return fmt.Errorf("SRT: sink closed")
+	}
+	if needKeyframe {
+		s.requestKeyframe()
 	}
 	return nil
 }
 
-func (s *SRTSink) writeSampleLocked(t *mpegts.Track, buf []byte, ptsMicroseconds int64, mediaCodecFlags int32) error {
-	isKeyframe := MediaCodecBufferFlag(mediaCodecFlags)&MediaCodecBufferFlagKeyFrame != 0
+func (s *SRTSink) writeSampleLocked(t *mpegts.Track, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	isKeyframe := flags&MediaCodecBufferFlagKeyFrame != 0
 	pts := int64((time.Duration(ptsMicroseconds) * time.Microsecond).Seconds() * 90000)
 
 	var err error
@@ -543,12 +1018,26 @@ func (s *SRTSink) writeSampleLocked(t *mpegts.Track, buf []byte, ptsMicroseconds
 func (s *SRTSink) Close() error {
 	s.Lock()
 	defer s.Unlock()
+	if s.closed {
+		return nil
+	}
 	s.closed = true
-	C.srt_close(s.sck.fd)
-	sinkCount--
-	if sinkCount == 0 {
-		C.srt_cleanup()
+	s.queue.close()
+	if s.addr.mode == srtModeListener {
+		C.srt_close(s.listener)
+		for _, r := range s.receivers {
+			C.srt_close(r.sck.fd)
+		}
+		s.receivers = nil
+		unregisterSRTListenCallback(s.listenCallbackKey)
+	} else {
+		C.srt_close(s.sck.fd)
+		s.links = nil
 	}
+	if s.linksDone != nil {
+		close(s.linksDone)
+	}
+	srtCleanup()
 	return nil
 }
 
@@ -573,35 +1062,38 @@ func (s *SRTSink) GetStatsAndBandwidth() int64 {
 	}
 	s.lastProbeTime = now
 
-	// Get SRT stats
-	var stats C.srt_bwe_stats_t
-	if C.srt_get_bwe_stats(s.sck.fd, &stats) != 0 {
-		return s.targetBitrate
+	// A listener backs off when any receiver sees loss, since they all get
+	// the same stream.
+	var lostPackets int64
+	if s.addr.mode == srtModeListener {
+		if len(s.receivers) == 0 {
+			return s.targetBitrate
+		}
+		polled := false
+		for _, r := range s.receivers {
+			if lost, ok := s.pollLossLocked(r.sck.fd, &r.lastPktSndLossTotal); ok {
+				lostPackets += lost
+				polled = true
+			}
+		}
+		if !polled {
+			return s.targetBitrate
+		}
+	} else if s.bonding != nil {
+		lost, ok := s.pollLinksLossLocked()
+		if !ok {
+			return s.targetBitrate
+		}
+		lostPackets = lost
+	} else {
+		lost, ok := s.pollLossLocked(s.sck.fd, &s.lastPktSndLossTotal)
+		if !ok {
+			return s.targetBitrate
+		}
+		lostPackets = lost
 	}
 
-	lossTotal := int64(stats.pktSndLossTotal)
-	lossInterval := int(stats.pktSndLoss)
-	rtt := float64(stats.msRTT)
-	sendRateMbps := float64(stats.mbpsSendRate)
-	flightSize := int(stats.pktFlightSize)
-	sndBuf := int(stats.pktSndBuf)
-	estBwMbps := float64(stats.mbpsBandwidth)
-	cwnd := int(stats.pktCongestionWindow)
-	drops := int(stats.pktSndDrop)
-	dropsTotal := int64(stats.pktSndDropTotal)
-	retrans := int(stats.pktRetrans)
-	retransTotal := int64(stats.pktRetransTotal)
-
-	// Always log stats for debugging
-	log.Printf("SRT stats: RTT=%.0fms rate=%.2fMbps estBW=%.2fMbps cwnd=%d flight=%d sndbuf=%d",
-		rtt, sendRateMbps, estBwMbps, cwnd, flightSize, sndBuf)
-	log.Printf("SRT stats: loss=%d/%d retrans=%d/%d drops=%d/%d target=%dKbps",
-		lossInterval, lossTotal, retrans, retransTotal, drops, dropsTotal, s.targetBitrate/1000)
-
-	// Check for packet loss
-	if lossTotal > s.lastPktSndLossTotal {
-		lostPackets := lossTotal - s.lastPktSndLossTotal
-		s.lastPktSndLossTotal = lossTotal
+	if lostPackets > 0 {
 		s.lastLossTime = now
 
 		// Trigger PLI for keyframe
@@ -620,7 +1112,6 @@ func (s *SRTSink) GetStatsAndBandwidth() int64 {
 			lostPackets, s.targetBitrate/1000, oldBitrate/1000)
 		return s.targetBitrate
 	}
-	s.lastPktSndLossTotal = lossTotal
 
 	// Don't probe up during cooldown after loss
 	if !s.lastLossTime.IsZero() && now.Sub(s.lastLossTime) < bweLossCooldown {
@@ -643,3 +1134,38 @@ func (s *SRTSink) GetStatsAndBandwidth() int64 {
 
 	return s.targetBitrate
 }
+
+// pollLossLocked returns how many packets socket fd lost since
+// lastLossTotal, which it updates. Caller must hold the lock.
+func (s *SRTSink) pollLossLocked(fd C.int, lastLossTotal *int64) (int64, bool) {
+	var stats C.srt_bwe_stats_t
+	if C.srt_get_bwe_stats(fd, &stats, 1) != 0 {
+		return 0, false
+	}
+
+	lossTotal := int64(stats.pktSndLossTotal)
+	lost := max(lossTotal-*lastLossTotal, 0)
+	*lastLossTotal = lossTotal
+	return lost, true
+}
+
+// srtSocketStats returns the stats of socket fd, without resetting the
+// interval counters the AIMD estimator reads.
+func srtSocketStats(fd C.int) (SRTSocketStats, bool) {
+	var stats C.srt_bwe_stats_t
+	if C.srt_get_bwe_stats(fd, &stats, 0) != 0 {
+		return SRTSocketStats{}, false
+	}
+	return SRTSocketStats{
+		RTTMs:                float64(stats.msRTT),
+		SendRateMbps:         float64(stats.mbpsSendRate),
+		BandwidthMbps:        float64(stats.mbpsBandwidth),
+		FlightSize:           int(stats.pktFlightSize),
+		SendBuffer:           int(stats.pktSndBuf),
+		CongestionWindow:     int(stats.pktCongestionWindow),
+		PacketsSent:          int64(stats.pktSent),
+		PacketsLost:          int64(stats.pktSndLossTotal),
+		PacketsRetransmitted: int64(stats.pktRetransTotal),
+		PacketsDropped:       int64(stats.pktSndDropTotal),
+	}, true
+}
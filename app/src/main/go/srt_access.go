@@ -0,0 +1,66 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <stdint.h>
+#include <srt/srt.h>
+#include <srt/access_control.h>
+*/
+import "C"
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// SRTAccessCallback decides whether a receiver may connect to a listener mode
+// SRTSink. streamID is the receiver's SRTO_STREAMID, empty if it didn't set
+// one. It runs on an SRT thread during the handshake, so it should return
+// quickly and must not call back into the sink.
+type SRTAccessCallback func(streamID string, addr net.Addr) bool
+
+// srtListenCallback vets a caller of a listening socket before it's
+// accepted as ns, and may set options on ns, e.g. a rotated passphrase.
+type srtListenCallback func(ns C.int, streamID string, addr net.Addr) bool
+
+// The listen callback gets a registry key rather than a pointer to the
+// callback, so one that fires while the sink is closing finds nothing instead
+// of a freed handle.
+var (
+	srtListenCallbacks   sync.Map // uintptr -> srtListenCallback
+	srtListenCallbackKey atomic.Uintptr
+)
+
+func registerSRTListenCallback(callback srtListenCallback) uintptr {
+	key := srtListenCallbackKey.Add(1)
+	srtListenCallbacks.Store(key, callback)
+	return key
+}
+
+func unregisterSRTListenCallback(key uintptr) {
+	srtListenCallbacks.Delete(key)
+}
+
+// accessListenCallback adapts an SRTAccessCallback.
+func accessListenCallback(callback SRTAccessCallback) srtListenCallback {
+	return func(_ C.int, streamID string, addr net.Addr) bool {
+		return callback(streamID, addr)
+	}
+}
+
+//export goSRTListenCallback
+func goSRTListenCallback(key C.uintptr_t, ns C.int, streamid *C.char, peeraddr *C.struct_sockaddr) C.int {
+	addr := addrFromSockaddr(peeraddr)
+	var streamID string
+	if streamid != nil {
+		streamID = C.GoString(streamid)
+	}
+	if callback, ok := srtListenCallbacks.Load(uintptr(key)); ok && callback.(srtListenCallback)(ns, streamID, addr) {
+		return 0
+	}
+	log.Printf("SRT: rejected receiver %s with stream ID %q\n", addr, streamID)
+	C.srt_setrejectreason(ns, C.SRT_REJX_FORBIDDEN)
+	return -1
+}
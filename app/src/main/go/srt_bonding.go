@@ -0,0 +1,395 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <stdlib.h>
+#include <srt/srt.h>
+*/
+import "C"
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/kevmo314/kinetic/pkg/androidnet"
+)
+
+// SRTGroupType selects how a bonded SRTSink uses its links.
+type SRTGroupType int
+
+const (
+	// SRTGroupBroadcast sends every packet over every link and the receiver
+	// keeps the first copy, so a link can drop out without a glitch.
+	SRTGroupBroadcast SRTGroupType = iota
+	// SRTGroupBackup sends over the preferred healthy link and switches to
+	// another when it stalls, which costs only one link's data.
+	SRTGroupBackup
+)
+
+func (t SRTGroupType) String() string {
+	if t == SRTGroupBackup {
+		return "backup"
+	}
+	return "broadcast"
+}
+
+func (t SRTGroupType) srtGroupType() C.SRT_GROUP_TYPE {
+	if t == SRTGroupBackup {
+		return C.SRT_GTYPE_BACKUP
+	}
+	return C.SRT_GTYPE_BROADCAST
+}
+
+const defaultSRTBondingPollInterval = 2 * time.Second
+
+// SRTBondingConfig configures connection bonding on a caller mode SRTSink:
+// the sink connects a libsrt socket group with one member per local
+// interface, e.g. Wi-Fi and cellular, so the stream survives one of them
+// dropping out. The receiver must accept groups (SRTO_GROUPCONNECT).
+//
+// Members are bound to their interface's address rather than the device
+// (SO_BINDTODEVICE needs CAP_NET_RAW), which Android's per-network source
+// routing sends out of that interface.
+type SRTBondingConfig struct {
+	Type SRTGroupType
+
+	// Interfaces are name prefixes of the local interfaces to bond, e.g.
+	// "wlan" and "rmnet". In backup groups, earlier entries are preferred.
+	// Empty uses every interface that is up, except loopback.
+	Interfaces []string
+
+	// PollInterval is how often to check for interfaces coming and going.
+	// Zero uses 2 seconds.
+	PollInterval time.Duration
+}
+
+// parseSRTBonding reads the bonding configuration from the grouptype and
+// groupinterfaces (comma separated) URL parameters, or nil without them.
+func parseSRTBonding(options map[string]string) (*SRTBondingConfig, error) {
+	groupType, ok := options["grouptype"]
+	if !ok {
+		return nil, nil
+	}
+	config := &SRTBondingConfig{}
+	switch groupType {
+	case "broadcast":
+		config.Type = SRTGroupBroadcast
+	case "backup":
+		config.Type = SRTGroupBackup
+	default:
+		return nil, fmt.Errorf("unknown group type %q", groupType)
+	}
+	if interfaces := options["groupinterfaces"]; interfaces != "" {
+		config.Interfaces = strings.Split(interfaces, ",")
+	}
+	return config, nil
+}
+
+// weight returns the member weight of an interface, which orders backup
+// links by preference.
+func (c *SRTBondingConfig) weight(name string) uint16 {
+	for i, prefix := range c.Interfaces {
+		if strings.HasPrefix(name, prefix) {
+			return uint16(len(c.Interfaces) - i)
+		}
+	}
+	return 1
+}
+
+// bondingInterfaces returns the address of each interface to bond, in the
+// family of the remote address ip.
+func (c *SRTBondingConfig) bondingInterfaces(ip net.IP) (map[string]net.IP, error) {
+	ifaces, err := androidnet.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	addrs := map[string]net.IP{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || !c.matches(iface.Name, iface.Flags&net.FlagLoopback != 0) {
+			continue
+		}
+		ifaceAddrs, _ := iface.Addrs()
+		for _, addr := range ifaceAddrs {
+			a, ok := addr.(*net.IPAddr)
+			if !ok || a.IP.IsLinkLocalUnicast() || (a.IP.To4() != nil) != (ip.To4() != nil) {
+				continue
+			}
+			addrs[iface.Name] = a.IP
+			break
+		}
+	}
+	return addrs, nil
+}
+
+// matches reports whether the interface is selected. Loopback is only used
+// when named.
+func (c *SRTBondingConfig) matches(name string, loopback bool) bool {
+	if len(c.Interfaces) == 0 {
+		return !loopback
+	}
+	for _, prefix := range c.Interfaces {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// srtLink is a member of a bonded SRTSink's group, bound to one interface.
+type srtLink struct {
+	iface string
+	ip    net.IP
+	id    C.int
+
+	lastPktSndLossTotal int64
+}
+
+// SRTLinkStats describes one link of a bonded SRTSink.
+type SRTLinkStats struct {
+	Interface string `json:"interface"`
+	LocalIP   string `json:"localIP,omitempty"`
+	State     string `json:"state"` // "pending", "idle", "running" or "broken"
+	Weight    int    `json:"weight"`
+
+	SRTSocketStats
+}
+
+func srtMemberState(state C.SRT_MEMBERSTATUS) string {
+	switch state {
+	case C.SRT_GST_PENDING:
+		return "pending"
+	case C.SRT_GST_IDLE:
+		return "idle"
+	case C.SRT_GST_RUNNING:
+		return "running"
+	}
+	return "broken"
+}
+
+// srtGroupMembers returns the state and weight of each member of a group by
+// socket ID.
+func srtGroupMembers(group C.int) map[C.int]C.SRT_SOCKGROUPDATA {
+	var data [16]C.SRT_SOCKGROUPDATA
+	size := C.size_t(len(data))
+	if res := C.srt_group_data(group, &data[0], &size); res == -1 {
+		C.srt_clearlasterror()
+		return nil
+	}
+	members := map[C.int]C.SRT_SOCKGROUPDATA{}
+	for _, d := range data[:min(int(size), len(data))] {
+		members[C.int(d.id)] = d
+	}
+	return members
+}
+
+// dialGroup creates a socket group and connects a member from each of
+// addrs, returning once one of them is connected. The others keep
+// connecting in the background.
+func (a *srtAddress) dialGroup(config *SRTBondingConfig, addrs map[string]net.IP) (C.int, []*srtLink, error) {
+	group := C.srt_create_group(config.Type.srtGroupType())
+	if group == -1 {
+		return -1, nil, fmt.Errorf("SRT: failed to create group: %w", srtGetAndClearError())
+	}
+
+	if err := setSocketOptions(group, bindingPre, a.options); err != nil {
+		C.srt_close(group)
+		return -1, nil, fmt.Errorf("SRT: failed to set pre-bind options: %w", err)
+	}
+
+	log.Printf("SRT: connecting %s group to %s:%d over %d links...\n", config.Type, a.ip, a.port, len(addrs))
+	links, err := a.connectMembers(group, config, addrs)
+	if err != nil {
+		C.srt_close(group)
+		return -1, nil, err
+	}
+	log.Printf("SRT: connected\n")
+
+	if err := setSocketOptions(group, bindingPost, a.options); err != nil {
+		C.srt_close(group)
+		return -1, nil, fmt.Errorf("SRT: failed to set post-bind options: %w", err)
+	}
+	return group, links, nil
+}
+
+// connectMembers connects a group member bound to each of addrs. It blocks
+// until one of them is connected. With no addrs, it connects one member
+// from the default route.
+func (a *srtAddress) connectMembers(group C.int, config *SRTBondingConfig, addrs map[string]net.IP) ([]*srtLink, error) {
+	sa, salen, err := sockAddrFromIp(a.ip, a.port)
+	if err != nil {
+		return nil, fmt.Errorf("SRT: failed to create sockaddr: %w", err)
+	}
+
+	var links []*srtLink
+	var endpoints []C.SRT_SOCKGROUPCONFIG
+	if len(addrs) == 0 {
+		links = append(links, &srtLink{})
+		endpoints = append(endpoints, C.srt_prepare_endpoint(nil, sa, C.int(salen)))
+	}
+	for name, ip := range addrs {
+		src, _, err := sockAddrFromIp(ip, 0)
+		if err != nil {
+			return nil, fmt.Errorf("SRT: failed to create sockaddr for %s: %w", name, err)
+		}
+		endpoint := C.srt_prepare_endpoint(src, sa, C.int(salen))
+		endpoint.weight = C.uint16_t(config.weight(name))
+		links = append(links, &srtLink{iface: name, ip: ip})
+		endpoints = append(endpoints, endpoint)
+	}
+
+	// The endpoints are copied to C memory so libsrt can fill in the member
+	// IDs without holding Go pointers.
+	size := C.size_t(len(endpoints)) * C.size_t(unsafe.Sizeof(endpoints[0]))
+	cEndpoints := (*C.SRT_SOCKGROUPCONFIG)(C.malloc(size))
+	defer C.free(unsafe.Pointer(cEndpoints))
+	out := unsafe.Slice(cEndpoints, len(endpoints))
+	copy(out, endpoints)
+
+	if res := C.srt_connect_group(group, cEndpoints, C.int(len(endpoints))); res == -1 {
+		return nil, fmt.Errorf("SRT: connect failed: %w", srtGetAndClearError())
+	}
+
+	connected := links[:0]
+	for i, link := range links {
+		if out[i].errorcode != 0 {
+			log.Printf("SRT: link %s failed to connect: srt error %d\n", link.iface, out[i].errorcode)
+			continue
+		}
+		link.id = C.int(out[i].id)
+		connected = append(connected, link)
+	}
+	return connected, nil
+}
+
+// monitorLinks adds and removes members of a bonded sink's group as
+// interfaces come and go, until the sink is closed.
+func (s *SRTSink) monitorLinks() {
+	interval := s.bonding.PollInterval
+	if interval == 0 {
+		interval = defaultSRTBondingPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.updateLinks()
+		case <-s.linksDone:
+			return
+		}
+	}
+}
+
+// updateLinks reconciles the group's members with the interfaces that are
+// up now. Connecting blocks, so it runs without the lock.
+func (s *SRTSink) updateLinks() {
+	addrs, err := s.linkInterfaces(s.addr.ip)
+	if err != nil {
+		log.Printf("SRT: failed to list interfaces: %v\n", err)
+		return
+	}
+
+	s.Lock()
+	group := s.sck.fd
+	members := srtGroupMembers(group)
+	var remove []*srtLink
+	links := s.links[:0]
+	for _, link := range s.links {
+		_, member := members[link.id]
+		if ip, ok := addrs[link.iface]; (ok || link.iface == "") && ip.Equal(link.ip) && member {
+			links = append(links, link)
+			delete(addrs, link.iface)
+			continue
+		}
+		remove = append(remove, link)
+	}
+	clear(s.links[len(links):])
+	s.links = links
+	s.Unlock()
+
+	for _, link := range remove {
+		log.Printf("SRT: removing link %s (%s)\n", link.iface, link.ip)
+		C.srt_close(link.id)
+	}
+	if len(addrs) == 0 {
+		return
+	}
+
+	for name, ip := range addrs {
+		log.Printf("SRT: adding link %s (%s)\n", name, ip)
+	}
+	added, err := s.addr.connectMembers(group, s.bonding, addrs)
+	if err != nil {
+		log.Printf("SRT: failed to add links: %v\n", err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed || s.sck.fd != group {
+		// The group was replaced while connecting, closing its members.
+		return
+	}
+	s.links = append(s.links, added...)
+}
+
+// pollLinksLossLocked returns how many packets a bonded sink lost since the
+// last poll. A broadcast group only loses data when every link does, so it
+// counts the least lossy running link; a backup group counts every running
+// link, since only the active ones carry data. Caller must hold the lock.
+func (s *SRTSink) pollLinksLossLocked() (int64, bool) {
+	members := srtGroupMembers(s.sck.fd)
+	var lostPackets int64
+	polled := false
+	for _, link := range s.links {
+		if member, ok := members[link.id]; !ok || member.memberstate != C.SRT_GST_RUNNING {
+			continue
+		}
+		lost, ok := s.pollLossLocked(link.id, &link.lastPktSndLossTotal)
+		if !ok {
+			continue
+		}
+		switch {
+		case s.bonding.Type == SRTGroupBackup:
+			lostPackets += lost
+		case !polled || lost < lostPackets:
+			lostPackets = lost
+		}
+		polled = true
+	}
+	return lostPackets, polled
+}
+
+// LinkStats returns the stats of each link of a bonded sink, or nil if the
+// sink isn't bonded.
+func (s *SRTSink) LinkStats() []SRTLinkStats {
+	s.Lock()
+	defer s.Unlock()
+	return s.linkStatsLocked()
+}
+
+// linkStatsLocked is LinkStats with the lock held.
+func (s *SRTSink) linkStatsLocked() []SRTLinkStats {
+	if s.bonding == nil {
+		return nil
+	}
+	members := srtGroupMembers(s.sck.fd)
+	stats := make([]SRTLinkStats, 0, len(s.links))
+	for _, link := range s.links {
+		ls := SRTLinkStats{Interface: link.iface, State: "broken"}
+		ls.SRTSocketStats, _ = srtSocketStats(link.id)
+		if link.ip != nil {
+			ls.LocalIP = link.ip.String()
+		}
+		if member, ok := members[link.id]; ok {
+			ls.State = srtMemberState(member.memberstate)
+			ls.Weight = int(member.weight)
+		}
+		stats = append(stats, ls)
+	}
+	return stats
+}
@@ -2,7 +2,7 @@
 
 package kinetic
 
-// Host-only SRT listener helpers used by srt_test.go. CGO is not allowed in
+// Host-only SRT peer helpers used by srt_test.go. CGO is not allowed in
 // _test.go files, so the C glue lives here while the test logic stays in
 // srt_test.go.
 
@@ -13,10 +13,21 @@ package kinetic
 #include <netinet/in.h>
 #include <srt/srt.h>
 
-static int srt_test_listen(int* out_port) {
+// Listen on a loopback port, accepting socket groups if group is set and
+// requiring the passphrase if not NULL.
+static int srt_test_listen(int* out_port, int group, const char* passphrase) {
     SRTSOCKET sock = srt_create_socket();
     if (sock == SRT_INVALID_SOCK) return -1;
 
+    if (group && srt_setsockflag(sock, SRTO_GROUPCONNECT, &group, sizeof(group)) == SRT_ERROR) {
+        srt_close(sock);
+        return -1;
+    }
+    if (passphrase != NULL && srt_setsockflag(sock, SRTO_PASSPHRASE, passphrase, strlen(passphrase)) == SRT_ERROR) {
+        srt_close(sock);
+        return -1;
+    }
+
     struct sockaddr_in sa = {0};
     sa.sin_family = AF_INET;
     sa.sin_port = 0;
@@ -48,6 +59,47 @@ static int srt_test_accept(int listener) {
 static int srt_test_recv(int sock, char* buf, int len) {
     return srt_recv(sock, buf, len);
 }
+
+static void srt_test_loopback(struct sockaddr_in* sa, int port) {
+    memset(sa, 0, sizeof(*sa));
+    sa->sin_family = AF_INET;
+    sa->sin_port = htons(port);
+    sa->sin_addr.s_addr = htonl(INADDR_LOOPBACK);
+}
+
+// Connect to a loopback listener as a caller, with a stream ID if not NULL.
+static int srt_test_dial(int port, const char* streamid) {
+    SRTSOCKET sock = srt_create_socket();
+    if (sock == SRT_INVALID_SOCK) return -1;
+
+    if (streamid != NULL && srt_setsockflag(sock, SRTO_STREAMID, streamid, strlen(streamid)) == SRT_ERROR) {
+        srt_close(sock);
+        return -1;
+    }
+
+    struct sockaddr_in sa;
+    srt_test_loopback(&sa, port);
+    if (srt_connect(sock, (struct sockaddr*)&sa, sizeof(sa)) == SRT_ERROR) {
+        srt_close(sock);
+        return -1;
+    }
+    return sock;
+}
+
+// Rendezvous from one loopback port with another.
+static int srt_test_rendezvous(int local_port, int remote_port) {
+    SRTSOCKET sock = srt_create_socket();
+    if (sock == SRT_INVALID_SOCK) return -1;
+
+    struct sockaddr_in local, remote;
+    srt_test_loopback(&local, local_port);
+    srt_test_loopback(&remote, remote_port);
+    if (srt_rendezvous(sock, (struct sockaddr*)&local, sizeof(local), (struct sockaddr*)&remote, sizeof(remote)) == SRT_ERROR) {
+        srt_close(sock);
+        return -1;
+    }
+    return sock;
+}
 */
 import "C"
 
@@ -63,9 +115,33 @@ type srtTestListener struct {
 }
 
 func newSRTTestListener() (*srtTestListener, error) {
+	return listenSRTTest(false, "")
+}
+
+// newSRTGroupTestListener returns a listener that accepts bonded callers, so
+// AcceptAndDrain reads from the whole group.
+func newSRTGroupTestListener() (*srtTestListener, error) {
+	return listenSRTTest(true, "")
+}
+
+// newSRTEncryptedTestListener returns a listener that only accepts callers
+// with the passphrase.
+func newSRTEncryptedTestListener(passphrase string) (*srtTestListener, error) {
+	return listenSRTTest(false, passphrase)
+}
+
+func listenSRTTest(group bool, passphrase string) (*srtTestListener, error) {
+	var cPassphrase *C.char
+	if passphrase != "" {
+		cPassphrase = C.CString(passphrase)
+		defer C.free(unsafe.Pointer(cPassphrase))
+	}
 	C.srt_startup()
-	var cport C.int
-	sock := C.srt_test_listen(&cport)
+	var cport, cgroup C.int
+	if group {
+		cgroup = 1
+	}
+	sock := C.srt_test_listen(&cport, cgroup, cPassphrase)
 	if sock == -1 {
 		err := srtGetAndClearError()
 		C.srt_cleanup()
@@ -87,17 +163,71 @@ func (l *srtTestListener) AcceptAndDrain(max int) ([]byte, error) {
 	}
 	defer C.srt_close(client)
 
+	return srtTestDrain(client, max), nil
+}
+
+// Close closes the listener without accepting.
+func (l *srtTestListener) Close() {
+	C.srt_close(l.sock)
+	C.srt_cleanup()
+}
+
+// Port is the loopback port the listener is bound to.
+func (l *srtTestListener) Port() int { return l.port }
+
+// srtTestConn is a connected libsrt socket that receives from a sink.
+type srtTestConn struct {
+	sock C.int
+}
+
+// dialSRTTest connects to a loopback listener as a caller. streamID is sent
+// as the SRTO_STREAMID if not empty.
+func dialSRTTest(port int, streamID string) (*srtTestConn, error) {
+	var cStreamID *C.char
+	if streamID != "" {
+		cStreamID = C.CString(streamID)
+		defer C.free(unsafe.Pointer(cStreamID))
+	}
+	C.srt_startup()
+	sock := C.srt_test_dial(C.int(port), cStreamID)
+	if sock == -1 {
+		err := srtGetAndClearError()
+		C.srt_cleanup()
+		return nil, fmt.Errorf("srt_connect: %w", err)
+	}
+	return &srtTestConn{sock: sock}, nil
+}
+
+// rendezvousSRTTest rendezvouses from localPort with remotePort on loopback,
+// blocking until the peer does the same.
+func rendezvousSRTTest(localPort, remotePort int) (*srtTestConn, error) {
+	C.srt_startup()
+	sock := C.srt_test_rendezvous(C.int(localPort), C.int(remotePort))
+	if sock == -1 {
+		err := srtGetAndClearError()
+		C.srt_cleanup()
+		return nil, fmt.Errorf("srt_rendezvous: %w", err)
+	}
+	return &srtTestConn{sock: sock}, nil
+}
+
+// Drain reads bytes off the connection until either max bytes are collected
+// or recv returns <=0, then closes it.
+func (c *srtTestConn) Drain(max int) []byte {
+	defer C.srt_cleanup()
+	defer C.srt_close(c.sock)
+	return srtTestDrain(c.sock, max)
+}
+
+func srtTestDrain(sock C.int, max int) []byte {
 	var chunk [1500]byte
 	out := make([]byte, 0, max)
 	for len(out) < max {
-		n := C.srt_test_recv(client, (*C.char)(unsafe.Pointer(&chunk[0])), C.int(len(chunk)))
+		n := C.srt_test_recv(sock, (*C.char)(unsafe.Pointer(&chunk[0])), C.int(len(chunk)))
 		if n <= 0 {
 			break
 		}
 		out = append(out, chunk[:int(n)]...)
 	}
-	return out, nil
+	return out
 }
-
-// Port is the loopback port the listener is bound to.
-func (l *srtTestListener) Port() int { return l.port }
@@ -0,0 +1,261 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+import (
+	"log"
+	"sync"
+)
+
+// defaultSRTSendQueueSize is how many samples an SRTSink holds while the
+// connection is slow or reconnecting, about three seconds of 30 fps video
+// with audio.
+const defaultSRTSendQueueSize = 180
+
+// srtSample is a sample waiting to be sent, with its own copy of the buffer.
+type srtSample struct {
+	track           int
+	buf             []byte
+	ptsMicroseconds int64
+	flags           MediaCodecBufferFlag
+
+	video        bool
+	keyframe     bool
+	nonReference bool // No other frame is predicted from it
+}
+
+// SRTQueueStats describes an SRTSink's send queue. Dropped counts are totals
+// since the sink was created.
+type SRTQueueStats struct {
+	Queued             int   `json:"queued"`
+	DroppedVideoFrames int64 `json:"droppedVideoFrames"`
+	DroppedAudioFrames int64 `json:"droppedAudioFrames"`
+}
+
+// srtSendQueue decouples WriteSample from the connection. When it's full,
+// it drops what the receiver can do without, in order:
+//
+//  1. The oldest non-reference video frame.
+//  2. Whole GOPs older than the newest queued keyframe.
+//  3. Video after the newest keyframe, then incoming video until the next
+//     one.
+//  4. The oldest sample, which is audio or a keyframe.
+type srtSendQueue struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	samples []*srtSample
+	size    int
+	closed  bool
+
+	// waitKeyframe drops incoming video until a keyframe, since the frames
+	// after a dropped reference frame can't be decoded.
+	waitKeyframe bool
+
+	droppedVideo int64
+	droppedAudio int64
+}
+
+func newSRTSendQueue(size int) *srtSendQueue {
+	q := &srtSendQueue{size: size}
+	q.cond = sync.NewCond(&q.Mutex)
+	return q
+}
+
+// push queues the sample without blocking. It returns false if the queue is
+// closed, and needKeyframe if video was dropped up to the next keyframe, so
+// the encoder should make one.
+func (q *srtSendQueue) push(sample *srtSample) (ok, needKeyframe bool) {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return false, false
+	}
+
+	if len(q.samples) >= q.size {
+		needKeyframe = q.makeRoomLocked()
+	}
+	if sample.video {
+		if sample.keyframe {
+			q.waitKeyframe = false
+		} else if q.waitKeyframe {
+			q.droppedVideo++
+			return true, needKeyframe
+		}
+	}
+	q.samples = append(q.samples, sample)
+	q.cond.Signal()
+	return true, needKeyframe
+}
+
+// makeRoomLocked drops at least one queued sample and reports whether the
+// video now waits for a keyframe.
+func (q *srtSendQueue) makeRoomLocked() bool {
+	for i, sample := range q.samples {
+		if sample.video && sample.nonReference {
+			q.dropLocked(func(j int, _ *srtSample) bool { return j == i })
+			return false
+		}
+	}
+
+	lastKeyframe := -1
+	for i, sample := range q.samples {
+		if sample.video && sample.keyframe {
+			lastKeyframe = i
+		}
+	}
+	if q.dropLocked(func(i int, sample *srtSample) bool { return sample.video && i < lastKeyframe }) > 0 {
+		return false
+	}
+
+	if q.dropLocked(func(_ int, sample *srtSample) bool { return sample.video && !sample.keyframe }) > 0 {
+		q.waitKeyframe = true
+		return true
+	}
+
+	oldest := q.samples[0]
+	q.dropLocked(func(i int, _ *srtSample) bool { return i == 0 })
+	if oldest.video {
+		q.waitKeyframe = true
+		return true
+	}
+	return false
+}
+
+// dropLocked removes the samples drop matches and returns how many.
+func (q *srtSendQueue) dropLocked(drop func(i int, sample *srtSample) bool) int {
+	kept := q.samples[:0]
+	dropped := 0
+	for i, sample := range q.samples {
+		if !drop(i, sample) {
+			kept = append(kept, sample)
+			continue
+		}
+		if sample.video {
+			q.droppedVideo++
+		} else {
+			q.droppedAudio++
+		}
+		dropped++
+	}
+	clear(q.samples[len(kept):])
+	q.samples = kept
+	return dropped
+}
+
+// resync drops queued video up to the first queued keyframe, or all of it
+// and incoming video until the next one if none is queued. It's used when
+// the receiver lost the stream's state, e.g. after a reconnect, and returns
+// whether a keyframe is needed.
+func (q *srtSendQueue) resync() bool {
+	q.Lock()
+	defer q.Unlock()
+
+	firstKeyframe := len(q.samples)
+	for i, sample := range q.samples {
+		if sample.video && sample.keyframe {
+			firstKeyframe = i
+			break
+		}
+	}
+	needKeyframe := firstKeyframe == len(q.samples)
+	q.dropLocked(func(i int, sample *srtSample) bool { return sample.video && i < firstKeyframe })
+	q.waitKeyframe = q.waitKeyframe || needKeyframe
+	return needKeyframe
+}
+
+// pop blocks until a sample is queued, or returns nil once the queue is
+// closed.
+func (q *srtSendQueue) pop() *srtSample {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.samples) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil
+	}
+	sample := q.samples[0]
+	q.samples[0] = nil
+	q.samples = q.samples[1:]
+	return sample
+}
+
+// close discards the queued samples and wakes up pop.
+func (q *srtSendQueue) close() {
+	q.Lock()
+	defer q.Unlock()
+	q.closed = true
+	q.samples = nil
+	q.cond.Broadcast()
+}
+
+func (q *srtSendQueue) stats() SRTQueueStats {
+	q.Lock()
+	defer q.Unlock()
+	return SRTQueueStats{
+		Queued:             len(q.samples),
+		DroppedVideoFrames: q.droppedVideo,
+		DroppedAudioFrames: q.droppedAudio,
+	}
+}
+
+// WithSRTSendQueueSize sets how many samples the sink holds while the
+// connection is slow or reconnecting before dropping some, 180 by default.
+func WithSRTSendQueueSize(size int) SRTSinkOption {
+	return func(s *SRTSink) {
+		s.queueSize = size
+	}
+}
+
+// QueueStats returns the state of the sink's send queue.
+func (s *SRTSink) QueueStats() SRTQueueStats {
+	return s.queue.stats()
+}
+
+// requestKeyframe asks the encoder for a keyframe without waiting for the
+// lock, which the sender holds while reconnecting.
+func (s *SRTSink) requestKeyframe() {
+	go func() {
+		s.Lock()
+		callback := s.pliCallback
+		s.Unlock()
+		if callback != nil {
+			callback.OnPLI()
+		}
+	}()
+}
+
+// sendLoop sends queued samples until the sink is closed. A failed write
+// reconnects, and since the new connection's receiver needs a keyframe,
+// queued video is dropped up to the next one.
+func (s *SRTSink) sendLoop() {
+	for {
+		sample := s.queue.pop()
+		if sample == nil {
+			return
+		}
+
+		s.Lock()
+		if s.closed {
+			s.Unlock()
+			return
+		}
+		err := s.writeSampleLocked(s.tracks[sample.track], sample.buf, sample.ptsMicroseconds, sample.flags)
+		if err != nil && s.addr.mode == srtModeListener {
+			// Failed receivers were already dropped, so this is a muxing
+			// error and reconnecting wouldn't help.
+			log.Printf("SRT: write failed: %v\n", err)
+		} else if err != nil {
+			log.Printf("SRT: write failed: %v, reconnecting...\n", err)
+			if err := s.reconnect(); err != nil {
+				s.Unlock()
+				return
+			}
+			if s.queue.resync() {
+				s.requestKeyframe()
+			}
+		}
+		s.Unlock()
+	}
+}
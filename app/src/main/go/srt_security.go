@@ -0,0 +1,156 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <stdlib.h>
+#include <srt/srt.h>
+*/
+import "C"
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"unsafe"
+)
+
+// SRTSecurityConfig configures AES encryption of an SRT connection. It's
+// kept out of the URL so the passphrase isn't logged, and is JSON encoded
+// when passed over JNI.
+//
+// The passphrase only encrypts the exchange of the stream's key, which
+// libsrt replaces every KMRefreshRate packets without interrupting the
+// stream. Changing the passphrase itself takes a new connection, see
+// SRTSink.SetPassphrase.
+type SRTSecurityConfig struct {
+	// Passphrase is 10 to 79 characters. Both peers need the same one, and
+	// one with a different or no passphrase is rejected.
+	Passphrase string `json:"passphrase"`
+
+	// KeyLength is 16, 24 or 32 bytes, for AES-128, AES-192 or AES-256. Zero
+	// uses the receiver's, or 16 if neither side sets it.
+	KeyLength int `json:"keyLength,omitempty"`
+
+	// KMRefreshRate is how many packets are sent with a key before switching
+	// to a new one. Zero uses libsrt's default of 2^24.
+	KMRefreshRate int `json:"kmRefreshRate,omitempty"`
+
+	// KMPreAnnounce is how many packets before and after the switch both
+	// keys are valid, at most (KMRefreshRate-1)/2. Zero uses libsrt's default
+	// of 2^12.
+	KMPreAnnounce int `json:"kmPreAnnounce,omitempty"`
+}
+
+// String redacts the passphrase, so the config can be logged.
+func (c SRTSecurityConfig) String() string {
+	return fmt.Sprintf("{Passphrase:%s KeyLength:%d KMRefreshRate:%d KMPreAnnounce:%d}",
+		redactSRTOption("passphrase", c.Passphrase), c.KeyLength, c.KMRefreshRate, c.KMPreAnnounce)
+}
+
+func validateSRTPassphrase(passphrase string) error {
+	if n := len(passphrase); n != 0 && (n < 10 || n > 79) {
+		return fmt.Errorf("SRT: passphrase must be 10 to 79 characters, not %d", n)
+	}
+	return nil
+}
+
+func (c *SRTSecurityConfig) validate() error {
+	if err := validateSRTPassphrase(c.Passphrase); err != nil {
+		return err
+	}
+	switch c.KeyLength {
+	case 0, 16, 24, 32:
+	default:
+		return fmt.Errorf("SRT: key length must be 16, 24 or 32 bytes, not %d", c.KeyLength)
+	}
+	if c.KMRefreshRate < 0 || c.KMPreAnnounce < 0 {
+		return fmt.Errorf("SRT: negative key refresh rate or pre-announce")
+	}
+	if c.KMRefreshRate != 0 && c.KMPreAnnounce > (c.KMRefreshRate-1)/2 {
+		return fmt.Errorf("SRT: key pre-announce %d is more than half the refresh rate %d", c.KMPreAnnounce, c.KMRefreshRate)
+	}
+	return nil
+}
+
+// apply replaces the security socket options parsed from the URL with the
+// config's.
+func (c *SRTSecurityConfig) apply(options map[string]string) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	for _, name := range []string{"passphrase", "pbkeylen", "kmrefreshrate", "kmpreannounce"} {
+		delete(options, name)
+	}
+	if c.Passphrase != "" {
+		options["passphrase"] = c.Passphrase
+	}
+	if c.KeyLength != 0 {
+		options["pbkeylen"] = strconv.Itoa(c.KeyLength)
+	}
+	if c.KMRefreshRate != 0 {
+		options["kmrefreshrate"] = strconv.Itoa(c.KMRefreshRate)
+	}
+	if c.KMPreAnnounce != 0 {
+		options["kmpreannounce"] = strconv.Itoa(c.KMPreAnnounce)
+	}
+	return nil
+}
+
+var srtSecretParam = regexp.MustCompile(`(?i)([?&]passphrase=)[^&#]*`)
+
+// redactSRTURL hides the passphrase in an srt:// URL, for logging.
+func redactSRTURL(s string) string {
+	return srtSecretParam.ReplaceAllString(s, "${1}REDACTED")
+}
+
+// redactSRTOption hides the value of a secret socket option, for logging.
+func redactSRTOption(name, val string) string {
+	if name == "passphrase" && val != "" {
+		return "REDACTED"
+	}
+	return val
+}
+
+func setSRTPassphrase(fd C.int, passphrase string) error {
+	cPassphrase := C.CString(passphrase)
+	defer C.free(unsafe.Pointer(cPassphrase))
+	if res := C.srt_setsockflag(fd, C.SRTO_PASSPHRASE, unsafe.Pointer(cPassphrase), C.int(len(passphrase))); res == -1 {
+		return srtGetAndClearError()
+	}
+	return nil
+}
+
+// SetPassphrase changes the passphrase, e.g. to rotate it, or turns
+// encryption off if empty. A listener mode sink keeps its receivers and
+// requires the new passphrase from the next one. Other modes reconnect with
+// it, dropping the samples written meanwhile, and return an error if the
+// peer rejects it; the sender then keeps retrying.
+func (s *SRTSink) SetPassphrase(passphrase string) error {
+	if err := validateSRTPassphrase(passphrase); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return fmt.Errorf("SRT: sink closed")
+	}
+
+	if s.addr.mode == srtModeListener {
+		s.passphrase.Store(&passphrase)
+		log.Printf("SRT: passphrase changed for new receivers\n")
+		return nil
+	}
+
+	s.addr.options["passphrase"] = passphrase
+	C.srt_close(s.sck.fd)
+	if err := s.connect(); err != nil {
+		return fmt.Errorf("SRT: failed to reconnect with the new passphrase: %w", err)
+	}
+	if s.queue.resync() {
+		s.requestKeyframe()
+	}
+	log.Printf("SRT: reconnected with the new passphrase\n")
+	return nil
+}
@@ -0,0 +1,184 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <srt/srt.h>
+*/
+import "C"
+import (
+	"fmt"
+	"io"
+	"log"
+	"unsafe"
+)
+
+// SRTSource receives an MPEG-TS stream over SRT, e.g. from a remote encoder,
+// and demuxes its first video and audio tracks into frames. In listener mode
+// it takes one publisher at a time and waits for the next when one
+// disconnects; in caller and rendezvous mode it closes with the connection.
+type SRTSource struct {
+	mpegtsSource
+
+	addr              *srtAddress
+	listener          C.int
+	conn              C.int // Current publisher, -1 if none
+	accessCallback    SRTAccessCallback
+	listenCallbackKey uintptr
+	security          *SRTSecurityConfig
+}
+
+// SRTSourceOption configures the SRT source
+type SRTSourceOption func(*SRTSource)
+
+// WithSRTSourceAccessCallback sets the callback that accepts or rejects
+// publishers of a listener mode source by their stream ID. Without one,
+// every publisher is accepted.
+func WithSRTSourceAccessCallback(callback SRTAccessCallback) SRTSourceOption {
+	return func(s *SRTSource) {
+		s.accessCallback = callback
+	}
+}
+
+// WithSRTSourceSecurity decrypts the stream, see SRTSecurityConfig.
+func WithSRTSourceSecurity(config SRTSecurityConfig) SRTSourceOption {
+	return func(s *SRTSource) {
+		s.security = &config
+	}
+}
+
+// srtMessageReader reads one SRT message, normally seven TS packets, per
+// Read.
+type srtMessageReader struct {
+	fd C.int
+}
+
+func (r srtMessageReader) Read(p []byte) (int, error) {
+	n := C.srt_recvmsg(r.fd, (*C.char)(unsafe.Pointer(&p[0])), C.int(len(p)))
+	if n == -1 {
+		return 0, fmt.Errorf("srt_recvmsg failed: %w", srtGetAndClearError())
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return int(n), nil
+}
+
+// NewSRTSource creates a source that receives MPEG-TS over SRT from the URL,
+// see parseSRTURL. H.264 and H.265 video and AAC and Opus audio are
+// supported.
+func NewSRTSource(s string, opts ...SRTSourceOption) (*SRTSource, error) {
+	log.Printf("SRT: creating source for %s\n", redactSRTURL(s))
+
+	addr, err := parseSRTURL(s)
+	if err != nil {
+		return nil, err
+	}
+
+	srtStartup()
+
+	source := &SRTSource{
+		addr:     addr,
+		listener: -1,
+		conn:     -1,
+	}
+	source.init("SRT")
+	for _, opt := range opts {
+		opt(source)
+	}
+	if source.security != nil {
+		if err := source.security.apply(addr.options); err != nil {
+			srtCleanup()
+			return nil, err
+		}
+	}
+
+	if addr.mode == srtModeListener {
+		if source.accessCallback != nil {
+			source.listenCallbackKey = registerSRTListenCallback(accessListenCallback(source.accessCallback))
+		}
+		fd, err := addr.listen(source.listenCallbackKey)
+		if err != nil {
+			unregisterSRTListenCallback(source.listenCallbackKey)
+			srtCleanup()
+			return nil, err
+		}
+		source.listener = fd
+		go source.acceptLoop(fd)
+		return source, nil
+	}
+
+	fd, err := addr.dial()
+	if err != nil {
+		srtCleanup()
+		return nil, err
+	}
+	source.conn = fd
+	go func() {
+		source.receive(fd)
+		source.Close()
+	}()
+	return source, nil
+}
+
+// acceptLoop receives from one publisher at a time until the listening
+// socket is closed.
+func (s *SRTSource) acceptLoop(listener C.int) {
+	defer s.Close()
+	for {
+		fd, addr, err := srtAccept(listener)
+		if err != nil {
+			if !s.IsClosed() {
+				log.Printf("SRT: no longer accepting publishers: %v\n", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			C.srt_close(fd)
+			return
+		}
+		s.conn = fd
+		s.mu.Unlock()
+
+		log.Printf("SRT: publisher %s connected with stream ID %q\n", addr, srtStreamID(fd))
+		s.receive(fd)
+		log.Printf("SRT: publisher %s disconnected\n", addr)
+
+		s.mu.Lock()
+		if s.conn == fd {
+			C.srt_close(fd)
+			s.conn = -1
+		}
+		s.mu.Unlock()
+	}
+}
+
+// receive demuxes the stream on fd into the queues until the connection
+// ends.
+func (s *SRTSource) receive(fd C.int) {
+	s.demux(srtMessageReader{fd: fd})
+}
+
+// Close closes the source and its connection
+func (s *SRTSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if s.listener != -1 {
+		C.srt_close(s.listener)
+		unregisterSRTListenCallback(s.listenCallbackKey)
+	}
+	if s.conn != -1 {
+		C.srt_close(s.conn)
+		s.conn = -1
+	}
+	srtCleanup()
+	s.closeLocked()
+}
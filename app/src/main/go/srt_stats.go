@@ -0,0 +1,98 @@
+//go:build (android || darwin || linux) && cgo
+
+package kinetic
+
+/*
+#include <srt/srt.h>
+*/
+import "C"
+
+// SRTSocketStats is a snapshot of one SRT connection from the sender's side.
+// Packet counts are totals since the connection was made.
+type SRTSocketStats struct {
+	RTTMs         float64 `json:"rttMs"`
+	SendRateMbps  float64 `json:"sendRateMbps"`
+	BandwidthMbps float64 `json:"bandwidthMbps"` // Estimated by the receiver
+
+	FlightSize       int `json:"flightSize"`       // Packets sent but not acknowledged
+	SendBuffer       int `json:"sendBuffer"`       // Packets waiting to be sent
+	CongestionWindow int `json:"congestionWindow"` // In packets
+
+	PacketsSent          int64 `json:"packetsSent"`
+	PacketsLost          int64 `json:"packetsLost"`
+	PacketsRetransmitted int64 `json:"packetsRetransmitted"`
+	PacketsDropped       int64 `json:"packetsDropped"` // Too late to send
+}
+
+// add sums o into s, keeping the higher RTT.
+func (s *SRTSocketStats) add(o SRTSocketStats) {
+	s.RTTMs = max(s.RTTMs, o.RTTMs)
+	s.SendRateMbps += o.SendRateMbps
+	s.BandwidthMbps += o.BandwidthMbps
+	s.FlightSize += o.FlightSize
+	s.SendBuffer += o.SendBuffer
+	s.CongestionWindow += o.CongestionWindow
+	s.PacketsSent += o.PacketsSent
+	s.PacketsLost += o.PacketsLost
+	s.PacketsRetransmitted += o.PacketsRetransmitted
+	s.PacketsDropped += o.PacketsDropped
+}
+
+// SRTReceiverStats describes one receiver of a listener mode SRTSink.
+type SRTReceiverStats struct {
+	Addr     string `json:"addr"`
+	StreamID string `json:"streamID,omitempty"`
+
+	SRTSocketStats
+}
+
+// SRTStats is a snapshot of an SRTSink's connection health. It's JSON
+// encoded when passed over JNI.
+type SRTStats struct {
+	// The connection's stats, or for bonded and listener mode sinks, the sum
+	// of their links' or receivers' with the highest RTT.
+	SRTSocketStats
+
+	Connected        bool  `json:"connected"`
+	TargetBitrateBps int64 `json:"targetBitrateBps"` // See GetStatsAndBandwidth
+
+	Links     []SRTLinkStats     `json:"links,omitempty"`     // Bonded sinks only
+	Receivers []SRTReceiverStats `json:"receivers,omitempty"` // Listener mode only
+
+	Queue SRTQueueStats `json:"queue"`
+}
+
+// Stats returns a snapshot of the sink's connection stats. Unlike
+// GetStatsAndBandwidth, it doesn't update the bitrate estimate, so it can be
+// polled at any rate.
+func (s *SRTSink) Stats() SRTStats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := SRTStats{TargetBitrateBps: s.targetBitrate, Queue: s.queue.stats()}
+	if s.closed {
+		return stats
+	}
+	switch {
+	case s.addr.mode == srtModeListener:
+		for _, r := range s.receivers {
+			rs := SRTReceiverStats{Addr: r.addr.String(), StreamID: r.streamID}
+			if socketStats, ok := srtSocketStats(r.sck.fd); ok {
+				rs.SRTSocketStats = socketStats
+				stats.add(socketStats)
+			}
+			stats.Receivers = append(stats.Receivers, rs)
+		}
+		stats.Connected = len(stats.Receivers) > 0
+	case s.bonding != nil:
+		stats.Links = s.linkStatsLocked()
+		for _, ls := range stats.Links {
+			stats.add(ls.SRTSocketStats)
+			stats.Connected = stats.Connected || ls.State == "idle" || ls.State == "running"
+		}
+	default:
+		stats.SRTSocketStats, _ = srtSocketStats(s.sck.fd)
+		stats.Connected = C.srt_getsockstate(s.sck.fd) == C.SRTS_CONNECTED
+	}
+	return stats
+}
@@ -4,8 +4,14 @@ package kinetic
 
 import (
 	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
 )
 
 // TestSRTSink_RoundTrip starts a libsrt listener on localhost, connects an
@@ -43,6 +49,45 @@ func TestSRTSink_RoundTrip(t *testing.T) {
 		t.Fatalf("NewSRTSink: %v", err)
 	}
 
+	writeTestKeyframesAndClose(t, sink)
+
+	select {
+	case res := <-recvCh:
+		if res.err != nil {
+			t.Fatalf("listener: %v", res.err)
+		}
+		assertMPEGTS(t, res.bytes)
+	case <-time.After(7 * time.Second):
+		t.Fatal("listener did not return in time")
+	}
+}
+
+func assertMPEGTS(t *testing.T, got []byte) {
+	t.Helper()
+	if len(got) < 188 {
+		t.Fatalf("expected at least one MPEG-TS packet (188 bytes), got %d", len(got))
+	}
+	if got[0] != 0x47 {
+		t.Fatalf("expected MPEG-TS sync byte 0x47 at offset 0, got 0x%02x", got[0])
+	}
+	for off := 188; off < len(got); off += 188 {
+		if got[off] != 0x47 {
+			end := off + 8
+			if end > len(got) {
+				end = len(got)
+			}
+			t.Fatalf("expected 0x47 at offset %d, got 0x%02x (context: %x)",
+				off, got[off], got[off:end])
+		}
+	}
+	t.Logf("received %d bytes (%d MPEG-TS packets)", len(got), len(got)/188)
+}
+
+// writeTestKeyframesAndClose writes fake H.264 keyframes to sink, then closes
+// it once they're flushed.
+func writeTestKeyframesAndClose(t *testing.T, sink *SRTSink) {
+	t.Helper()
+
 	// A minimal Annex-B keyframe: SPS + PPS + IDR slice. The byte values
 	// don't form a decodable stream — the sink just needs to mux them
 	// into a TS payload.
@@ -59,7 +104,7 @@ func TestSRTSink_RoundTrip(t *testing.T) {
 	// been observed to land all sends before accept() returns.
 	for i := 0; i < 10; i++ {
 		pts := int64(i) * 33_000 // ~30fps
-		if err := sink.WriteSample(0, annexB, pts, int32(MediaCodecBufferFlagKeyFrame)); err != nil {
+		if err := sink.WriteSample(0, annexB, pts, MediaCodecBufferFlagKeyFrame); err != nil {
 			t.Fatalf("WriteSample[%d]: %v", i, err)
 		}
 		time.Sleep(30 * time.Millisecond)
@@ -67,9 +112,187 @@ func TestSRTSink_RoundTrip(t *testing.T) {
 
 	// Give SRT time to flush the live queue before we tear it down.
 	time.Sleep(500 * time.Millisecond)
+	if stats := sink.Stats(); !stats.Connected || stats.PacketsSent == 0 {
+		t.Errorf("got stats %+v, want packets sent on a connection", stats)
+	}
 	if err := sink.Close(); err != nil {
 		t.Fatalf("sink.Close: %v", err)
 	}
+}
+
+// TestSRTSink_Listener starts a listener mode SRTSink, connects two receivers
+// and one its access callback rejects, and verifies both receivers get the
+// MPEG-TS stream.
+func TestSRTSink_Listener(t *testing.T) {
+	// The access callback runs on an SRT thread.
+	var mu sync.Mutex
+	var streamIDs []string
+	sink, err := NewSRTSink("srt://127.0.0.1:0?mode=listener&transtype=live&tlpktdrop=0",
+		string(MediaFormatMimeTypeVideoH264),
+		WithSRTAccessCallback(func(streamID string, addr net.Addr) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			streamIDs = append(streamIDs, streamID)
+			return streamID != "denied"
+		}))
+	if err != nil {
+		t.Fatalf("NewSRTSink: %v", err)
+	}
+	port := int(sink.addr.port)
+	t.Logf("SRT sink listening on 127.0.0.1:%d", port)
+
+	if conn, err := dialSRTTest(port, "denied"); err == nil {
+		conn.Drain(0)
+		t.Fatal("receiver with a denied stream ID connected")
+	}
+
+	recvChs := make([]chan []byte, 2)
+	for i := range recvChs {
+		conn, err := dialSRTTest(port, fmt.Sprintf("receiver%d", i))
+		if err != nil {
+			t.Fatalf("dial receiver %d: %v", i, err)
+		}
+		recvChs[i] = make(chan []byte, 1)
+		go func(ch chan []byte) {
+			ch <- conn.Drain(1 << 20)
+		}(recvChs[i])
+	}
+
+	// The sink accepts asynchronously after the handshake completes.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sink.Lock()
+		n := len(sink.receivers)
+		sink.Unlock()
+		if n == len(recvChs) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sink has %d receivers, want %d", n, len(recvChs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := sink.Stats(); len(stats.Receivers) != 2 || stats.Receivers[1].StreamID != "receiver1" {
+		t.Errorf("got receiver stats %+v, want receiver0 and receiver1", stats.Receivers)
+	}
+
+	writeTestKeyframesAndClose(t, sink)
+
+	for i, ch := range recvChs {
+		select {
+		case got := <-ch:
+			assertMPEGTS(t, got)
+		case <-time.After(7 * time.Second):
+			t.Fatalf("receiver %d did not return in time", i)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"denied", "receiver0", "receiver1"}; fmt.Sprint(streamIDs) != fmt.Sprint(want) {
+		t.Errorf("access callback saw stream IDs %q, want %q", streamIDs, want)
+	}
+}
+
+// TestSRTSink_Rendezvous connects a rendezvous mode SRTSink with a libsrt
+// peer doing the same and verifies the peer receives MPEG-TS packets.
+func TestSRTSink_Rendezvous(t *testing.T) {
+	sinkPort, err := pickFreeUDPPort()
+	if err != nil {
+		t.Fatalf("pickFreeUDPPort: %v", err)
+	}
+	peerPort, err := pickFreeUDPPort()
+	if err != nil {
+		t.Fatalf("pickFreeUDPPort: %v", err)
+	}
+
+	type recvResult struct {
+		bytes []byte
+		err   error
+	}
+	recvCh := make(chan recvResult, 1)
+	go func() {
+		conn, err := rendezvousSRTTest(peerPort, sinkPort)
+		if err != nil {
+			recvCh <- recvResult{err: err}
+			return
+		}
+		recvCh <- recvResult{bytes: conn.Drain(1 << 20)}
+	}()
+
+	sinkURL := fmt.Sprintf("srt://127.0.0.1:%d?mode=rendezvous&port=%d&transtype=live&tlpktdrop=0", peerPort, sinkPort)
+	sink, err := NewSRTSink(sinkURL, string(MediaFormatMimeTypeVideoH264))
+	if err != nil {
+		t.Fatalf("NewSRTSink: %v", err)
+	}
+
+	writeTestKeyframesAndClose(t, sink)
+
+	select {
+	case res := <-recvCh:
+		if res.err != nil {
+			t.Fatalf("peer: %v", res.err)
+		}
+		assertMPEGTS(t, res.bytes)
+	case <-time.After(7 * time.Second):
+		t.Fatal("peer did not return in time")
+	}
+}
+
+// TestSRTSink_Bonding connects a broadcast bonded SRTSink to a listener that
+// accepts groups, adds and removes links as if interfaces came and went, and
+// verifies the listener still receives MPEG-TS packets.
+func TestSRTSink_Bonding(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to 127.0.0.2 needs the whole 127/8 on loopback")
+	}
+	listener, err := newSRTGroupTestListener()
+	if err != nil {
+		t.Fatalf("listener: %v", err)
+	}
+
+	type recvResult struct {
+		bytes []byte
+		err   error
+	}
+	recvCh := make(chan recvResult, 1)
+	go func() {
+		got, err := listener.AcceptAndDrain(1 << 20)
+		recvCh <- recvResult{bytes: got, err: err}
+	}()
+
+	// Interfaces are only polled when the test asks.
+	sinkURL := fmt.Sprintf("srt://127.0.0.1:%d?transtype=live&tlpktdrop=0", listener.Port())
+	sink, err := NewSRTSink(sinkURL, string(MediaFormatMimeTypeVideoH264), WithSRTBonding(SRTBondingConfig{
+		Type:         SRTGroupBroadcast,
+		Interfaces:   []string{"lo"},
+		PollInterval: time.Hour,
+	}))
+	if err != nil {
+		t.Fatalf("NewSRTSink: %v", err)
+	}
+	if links := sink.LinkStats(); len(links) != 1 || links[0].Interface != "lo" {
+		t.Fatalf("got links %+v, want one on lo", links)
+	}
+
+	// A second interface comes up.
+	sink.linkInterfaces = func(net.IP) (map[string]net.IP, error) {
+		return map[string]net.IP{"lo": net.IPv4(127, 0, 0, 1), "lo2": net.IPv4(127, 0, 0, 2)}, nil
+	}
+	sink.updateLinks()
+	if links := sink.LinkStats(); len(links) != 2 {
+		t.Fatalf("got links %+v, want two", links)
+	}
+
+	// The first one goes down.
+	sink.linkInterfaces = func(net.IP) (map[string]net.IP, error) {
+		return map[string]net.IP{"lo2": net.IPv4(127, 0, 0, 2)}, nil
+	}
+	sink.updateLinks()
+	if links := sink.LinkStats(); len(links) != 1 || links[0].LocalIP != "127.0.0.2" {
+		t.Fatalf("got links %+v, want one from 127.0.0.2", links)
+	}
+
+	writeTestKeyframesAndClose(t, sink)
 
 	select {
 	case res := <-recvCh:
@@ -82,23 +305,362 @@ func TestSRTSink_RoundTrip(t *testing.T) {
 	}
 }
 
-func assertMPEGTS(t *testing.T, got []byte) {
+func TestParseSRTBonding(t *testing.T) {
+	config, err := parseSRTBonding(map[string]string{"grouptype": "backup", "groupinterfaces": "wlan,rmnet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Type != SRTGroupBackup {
+		t.Errorf("got group type %v, want backup", config.Type)
+	}
+	// Earlier interfaces are preferred.
+	if w, c := config.weight("wlan0"), config.weight("rmnet_data1"); w <= c {
+		t.Errorf("wlan0 weight %d not above rmnet_data1 weight %d", w, c)
+	}
+	if config.matches("eth0", false) || !config.matches("rmnet_data1", false) {
+		t.Error("interfaces matched by the wrong prefixes")
+	}
+
+	if config, err := parseSRTBonding(map[string]string{}); config != nil || err != nil {
+		t.Errorf("got %+v, %v without grouptype, want nil", config, err)
+	}
+	if _, err := parseSRTBonding(map[string]string{"grouptype": "balancing"}); err == nil {
+		t.Error("expected an error for an unknown group type")
+	}
+}
+
+// TestSRTSink_Encryption connects an SRTSink to a listener that requires a
+// passphrase, and verifies the stream arrives with the right passphrase and
+// the connection is rejected with the wrong one.
+func TestSRTSink_Encryption(t *testing.T) {
+	const passphrase = "correct horse battery"
+
+	t.Run("right passphrase", func(t *testing.T) {
+		listener, err := newSRTEncryptedTestListener(passphrase)
+		if err != nil {
+			t.Fatalf("listener: %v", err)
+		}
+
+		type recvResult struct {
+			bytes []byte
+			err   error
+		}
+		recvCh := make(chan recvResult, 1)
+		go func() {
+			got, err := listener.AcceptAndDrain(1 << 20)
+			recvCh <- recvResult{bytes: got, err: err}
+		}()
+
+		sinkURL := fmt.Sprintf("srt://127.0.0.1:%d?transtype=live&tlpktdrop=0", listener.Port())
+		sink, err := NewSRTSink(sinkURL, string(MediaFormatMimeTypeVideoH264), WithSRTSecurity(SRTSecurityConfig{
+			Passphrase:    passphrase,
+			KeyLength:     32,
+			KMRefreshRate: 1000,
+			KMPreAnnounce: 100,
+		}))
+		if err != nil {
+			t.Fatalf("NewSRTSink: %v", err)
+		}
+
+		writeTestKeyframesAndClose(t, sink)
+
+		select {
+		case res := <-recvCh:
+			if res.err != nil {
+				t.Fatalf("listener: %v", res.err)
+			}
+			assertMPEGTS(t, res.bytes)
+		case <-time.After(7 * time.Second):
+			t.Fatal("listener did not return in time")
+		}
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		listener, err := newSRTEncryptedTestListener(passphrase)
+		if err != nil {
+			t.Fatalf("listener: %v", err)
+		}
+		defer listener.Close()
+
+		sinkURL := fmt.Sprintf("srt://127.0.0.1:%d?transtype=live", listener.Port())
+		sink, err := NewSRTSink(sinkURL, string(MediaFormatMimeTypeVideoH264), WithSRTSecurity(SRTSecurityConfig{
+			Passphrase: "incorrect horse battery",
+		}))
+		if err == nil {
+			sink.Close()
+			t.Fatal("connected with the wrong passphrase")
+		}
+	})
+}
+
+func TestSRTSecurityConfig(t *testing.T) {
+	for _, tc := range []struct {
+		config SRTSecurityConfig
+		valid  bool
+	}{
+		{SRTSecurityConfig{}, true},
+		{SRTSecurityConfig{Passphrase: "0123456789", KeyLength: 24}, true},
+		{SRTSecurityConfig{Passphrase: "short"}, false},
+		{SRTSecurityConfig{Passphrase: "0123456789", KeyLength: 20}, false},
+		{SRTSecurityConfig{KMRefreshRate: 1000, KMPreAnnounce: 499}, true},
+		{SRTSecurityConfig{KMRefreshRate: 1000, KMPreAnnounce: 500}, false},
+	} {
+		if err := tc.config.validate(); (err == nil) != tc.valid {
+			t.Errorf("%v: got error %v, want valid %v", tc.config, err, tc.valid)
+		}
+	}
+
+	// The config replaces the URL's security options.
+	options := map[string]string{"passphrase": "from the URL", "pbkeylen": "16", "latency": "200"}
+	if err := (&SRTSecurityConfig{Passphrase: "from the config"}).apply(options); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"passphrase": "from the config", "latency": "200"}; fmt.Sprint(options) != fmt.Sprint(want) {
+		t.Errorf("got options %v, want %v", options, want)
+	}
+
+	if got := fmt.Sprint(SRTSecurityConfig{Passphrase: "0123456789"}); strings.Contains(got, "0123456789") {
+		t.Errorf("passphrase not redacted in %s", got)
+	}
+	if got, want := redactSRTURL("srt://example.com:9000?latency=200&passphrase=0123456789&pbkeylen=32"),
+		"srt://example.com:9000?latency=200&passphrase=REDACTED&pbkeylen=32"; got != want {
+		t.Errorf("redactSRTURL = %s, want %s", got, want)
+	}
+}
+
+func TestParseSRTMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode, host string
+		want       srtMode
+	}{
+		{"", "example.com", srtModeCaller},
+		{"", "", srtModeListener},
+		{"caller", "example.com", srtModeCaller},
+		{"listener", "", srtModeListener},
+		{"server", "0.0.0.0", srtModeListener},
+		{"rendezvous", "example.com", srtModeRendezvous},
+	} {
+		got, err := parseSRTMode(tc.mode, tc.host)
+		if err != nil || got != tc.want {
+			t.Errorf("parseSRTMode(%q, %q) = %v, %v, want %v", tc.mode, tc.host, got, err, tc.want)
+		}
+	}
+	if _, err := parseSRTMode("push", "example.com"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+// TestSRTSource_RoundTrip sends H.264 or H.265 video and Opus audio from an
+// SRTSink to a listener mode SRTSource and verifies the source demuxes the
+// same frames and timestamps.
+func TestSRTSource_RoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		mimeType MediaFormatMimeType
+		keyframe []byte
+	}{
+		{MediaFormatMimeTypeVideoH264, []byte{
+			0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0x00, 0x1f, 0x96, 0x35, 0x40, 0xa0, 0x0b, 0x6a,
+			0x00, 0x00, 0x00, 0x01, 0x68, 0xce, 0x06, 0xe2,
+			0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x33,
+		}},
+		{MediaFormatMimeTypeVideoH265, []byte{
+			0x00, 0x00, 0x00, 0x01, 0x40, 0x01, 0x0c, 0x01, 0xff, 0xff,
+			0x00, 0x00, 0x00, 0x01, 0x42, 0x01, 0x01, 0x01, 0x60, 0x00,
+			0x00, 0x00, 0x00, 0x01, 0x44, 0x01, 0xc1, 0x72, 0xb4,
+			0x00, 0x00, 0x00, 0x01, 0x26, 0x01, 0xaf, 0x06, 0xb8,
+		}},
+	} {
+		t.Run(string(tc.mimeType), func(t *testing.T) {
+			source, err := NewSRTSource("srt://127.0.0.1:0?mode=listener&transtype=live&tlpktdrop=0")
+			if err != nil {
+				t.Fatalf("NewSRTSource: %v", err)
+			}
+			defer source.Close()
+
+			sinkURL := fmt.Sprintf("srt://127.0.0.1:%d?transtype=live&tlpktdrop=0", source.addr.port)
+			sink, err := NewSRTSink(sinkURL, string(tc.mimeType)+";"+string(MediaFormatMimeTypeAudioOpus))
+			if err != nil {
+				t.Fatalf("NewSRTSink: %v", err)
+			}
+			defer sink.Close()
+
+			opusPacket := []byte{0xfc, 0xff, 0xfe} // 20 ms CELT frame
+			const frames = 10
+			for i := 0; i < frames; i++ {
+				pts := int64(i) * 40_000
+				if err := sink.WriteSample(0, tc.keyframe, pts, MediaCodecBufferFlagKeyFrame); err != nil {
+					t.Fatalf("WriteSample video[%d]: %v", i, err)
+				}
+				if err := sink.WriteSample(1, opusPacket, pts, 0); err != nil {
+					t.Fatalf("WriteSample audio[%d]: %v", i, err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// The last frame of each track stays in the demuxer until the
+			// next PES starts, so expect all but the last.
+			for i := 0; i < frames-1; i++ {
+				frame := readTestFrame(t, source.ReadVideoFrame)
+				if want := int64(i) * 40_000; frame.PTS != want {
+					t.Errorf("video frame %d PTS %d, want %d", i, frame.PTS, want)
+				}
+				if got, want := splitNALUs(frame.Data), splitNALUs(tc.keyframe); fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("video frame %d NALUs %x, want %x", i, got, want)
+				}
+			}
+			for i := 0; i < frames-1; i++ {
+				frame := readTestFrame(t, source.ReadAudioFrame)
+				if want := int64(i) * 40_000; frame.PTS != want {
+					t.Errorf("audio frame %d PTS %d, want %d", i, frame.PTS, want)
+				}
+				if fmt.Sprint(frame.Data) != fmt.Sprint(opusPacket) {
+					t.Errorf("audio frame %d = %x, want %x", i, frame.Data, opusPacket)
+				}
+			}
+			if got := source.VideoCodec(); got != tc.mimeType {
+				t.Errorf("video codec %s, want %s", got, tc.mimeType)
+			}
+			if got := source.AudioCodec(); got != MediaFormatMimeTypeAudioOpus {
+				t.Errorf("audio codec %s, want %s", got, MediaFormatMimeTypeAudioOpus)
+			}
+		})
+	}
+}
+
+func readTestFrame(t *testing.T, read func() *MediaFrame) *MediaFrame {
 	t.Helper()
-	if len(got) < 188 {
-		t.Fatalf("expected at least one MPEG-TS packet (188 bytes), got %d", len(got))
+	ch := make(chan *MediaFrame, 1)
+	go func() { ch <- read() }()
+	select {
+	case frame := <-ch:
+		if frame == nil {
+			t.Fatal("source closed")
+		}
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a frame")
 	}
-	if got[0] != 0x47 {
-		t.Fatalf("expected MPEG-TS sync byte 0x47 at offset 0, got 0x%02x", got[0])
+	return nil
+}
+
+// TestSRTSendQueue fills a small queue with GOPs of audio, reference and
+// non-reference video, and verifies what it drops to make room.
+func TestSRTSendQueue(t *testing.T) {
+	key := func(pts int64) *srtSample {
+		return &srtSample{ptsMicroseconds: pts, video: true, keyframe: true}
 	}
-	for off := 188; off < len(got); off += 188 {
-		if got[off] != 0x47 {
-			end := off + 8
-			if end > len(got) {
-				end = len(got)
+	ref := func(pts int64) *srtSample { return &srtSample{ptsMicroseconds: pts, video: true} }
+	nonRef := func(pts int64) *srtSample {
+		return &srtSample{ptsMicroseconds: pts, video: true, nonReference: true}
+	}
+	audio := func(pts int64) *srtSample { return &srtSample{ptsMicroseconds: pts} }
+	queued := func(q *srtSendQueue) []int64 {
+		var pts []int64
+		for _, sample := range q.samples {
+			pts = append(pts, sample.ptsMicroseconds)
+		}
+		return pts
+	}
+	push := func(q *srtSendQueue, samples ...*srtSample) (needKeyframe bool) {
+		for _, sample := range samples {
+			ok, need := q.push(sample)
+			if !ok {
+				t.Fatalf("push(%d) on an open queue failed", sample.ptsMicroseconds)
 			}
-			t.Fatalf("expected 0x47 at offset %d, got 0x%02x (context: %x)",
-				off, got[off], got[off:end])
+			needKeyframe = needKeyframe || need
 		}
+		return needKeyframe
+	}
+
+	// Non-reference frames go first, oldest first.
+	q := newSRTSendQueue(4)
+	push(q, key(0), nonRef(1), ref(2), nonRef(3), audio(4), audio(5))
+	if got, want := fmt.Sprint(queued(q)), "[0 2 4 5]"; got != want {
+		t.Errorf("after dropping non-reference frames got %s, want %s", got, want)
+	}
+
+	// Then GOPs older than the newest keyframe, keeping audio.
+	q = newSRTSendQueue(5)
+	push(q, key(0), ref(1), audio(2), key(3), ref(4), ref(5))
+	if got, want := fmt.Sprint(queued(q)), "[2 3 4 5]"; got != want {
+		t.Errorf("after dropping an old GOP got %s, want %s", got, want)
+	}
+
+	// Then video after the newest keyframe, and incoming video until the
+	// next one, then the oldest sample.
+	q = newSRTSendQueue(3)
+	if !push(q, key(0), ref(1), audio(2), ref(3)) {
+		t.Errorf("dropping all video didn't ask for a keyframe")
+	}
+	push(q, ref(4), audio(5), key(6), ref(7))
+	if got, want := fmt.Sprint(queued(q)), "[5 6 7]"; got != want {
+		t.Errorf("after waiting for a keyframe got %s, want %s", got, want)
+	}
+
+	// Then the oldest sample.
+	q = newSRTSendQueue(2)
+	push(q, audio(0), audio(1), audio(2))
+	if got, want := fmt.Sprint(queued(q)), "[1 2]"; got != want {
+		t.Errorf("after dropping the oldest audio got %s, want %s", got, want)
+	}
+	if stats := q.stats(); stats.Queued != 2 || stats.DroppedAudioFrames != 1 || stats.DroppedVideoFrames != 0 {
+		t.Errorf("got stats %+v, want 2 queued and 1 dropped audio frame", stats)
+	}
+
+	// A resync drops video up to the first queued keyframe.
+	q = newSRTSendQueue(10)
+	push(q, ref(0), audio(1), ref(2), key(3), ref(4))
+	if q.resync() {
+		t.Errorf("resync asked for a keyframe with one queued")
+	}
+	if got, want := fmt.Sprint(queued(q)), "[1 3 4]"; got != want {
+		t.Errorf("after resync got %s, want %s", got, want)
+	}
+
+	// pop returns queued samples in order, then nil once closed.
+	if sample := q.pop(); sample.ptsMicroseconds != 1 {
+		t.Errorf("pop got %d, want 1", sample.ptsMicroseconds)
+	}
+	done := make(chan *srtSample)
+	go func() {
+		q.pop()
+		q.pop()
+		done <- q.pop()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.close()
+	if sample := <-done; sample != nil {
+		t.Errorf("pop on a closed queue got %d, want nil", sample.ptsMicroseconds)
+	}
+	if ok, _ := q.push(audio(5)); ok {
+		t.Errorf("push on a closed queue succeeded")
+	}
+}
+
+// TestSRTSink_FlaglessWrites writes H.264 the way the JNI writeH264 path
+// does, without MediaCodec's flags, and verifies video resumes at the next
+// IDR after the queue drops frames.
+func TestSRTSink_FlaglessWrites(t *testing.T) {
+	sink := &SRTSink{
+		tracks: []*mpegts.Track{{Codec: &mpegts.CodecH264{}}},
+		queue:  newSRTSendQueue(3),
+	}
+	idr := []byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x65, 0x88}
+	p := []byte{0, 0, 0, 1, 0x41, 0x9a}
+	for i, buf := range [][]byte{idr, p, p, p, p, idr, p} {
+		if err := sink.WriteSample(0, buf, int64(i), 0); err != nil {
+			t.Fatalf("WriteSample[%d]: %v", i, err)
+		}
+	}
+
+	var pts []int64
+	for _, sample := range sink.queue.samples {
+		pts = append(pts, sample.ptsMicroseconds)
+	}
+	if got, want := fmt.Sprint(pts), "[0 5 6]"; got != want {
+		t.Errorf("queued %s, want %s", got, want)
+	}
+	if sample := sink.queue.samples[1]; !sample.keyframe || sample.flags&MediaCodecBufferFlagKeyFrame == 0 {
+		t.Errorf("IDR written without flags not marked a keyframe")
 	}
-	t.Logf("received %d bytes (%d MPEG-TS packets)", len(got), len(got)/188)
 }
@@ -0,0 +1,106 @@
+package kinetic
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kevmo314/kinetic/pkg/androidnet"
+	"github.com/pion/webrtc/v4"
+)
+
+// defaultICEServers is used when neither the options nor the WHIP endpoint
+// give any ICE servers.
+var defaultICEServers = []webrtc.ICEServer{
+	{URLs: []string{"stun:stun.l.google.com:19302"}},
+}
+
+// WebRTCOptions configures ICE for WHIPSink and WHEPSink. The zero value
+// gathers candidates on every interface and any port, using Google's public
+// STUN server. It's JSON encoded when passed over JNI.
+type WebRTCOptions struct {
+	// ICEServers are the STUN and TURN servers to gather candidates with,
+	// e.g. {URLs: ["turn:turn.example.com?transport=udp"], Username: "user",
+	// Credential: "password"}.
+	ICEServers []webrtc.ICEServer `json:"iceServers,omitempty"`
+
+	// RelayOnly only uses TURN relay candidates, so the device's addresses
+	// are never revealed to the peer. It needs a TURN server.
+	RelayOnly bool `json:"relayOnly,omitempty"`
+
+	// PortMin and PortMax limit the local UDP ports, e.g. to those a
+	// firewall forwards. Zero leaves that end of the range open.
+	PortMin uint16 `json:"portMin,omitempty"`
+	PortMax uint16 `json:"portMax,omitempty"`
+
+	// NAT1To1IPs are public IPs that map one to one onto the device's, for
+	// a device behind a static NAT. They're advertised in place of the
+	// local addresses of host candidates.
+	NAT1To1IPs []string `json:"nat1To1IPs,omitempty"`
+
+	// ExcludeCellular doesn't gather candidates on mobile data interfaces,
+	// so media only goes over Wi-Fi or Ethernet.
+	ExcludeCellular bool `json:"excludeCellular,omitempty"`
+}
+
+// settingEngine returns a SettingEngine on androidNet with the options'
+// port range, NAT mapping and interface filter.
+func (o WebRTCOptions) settingEngine(androidNet *androidnet.Net) (webrtc.SettingEngine, error) {
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetNet(androidNet)
+	settingEngine.SetICERenomination()
+
+	if o.PortMin != 0 || o.PortMax != 0 {
+		portMax := o.PortMax
+		if portMax == 0 {
+			portMax = 65535
+		}
+		if err := settingEngine.SetEphemeralUDPPortRange(o.PortMin, portMax); err != nil {
+			return settingEngine, fmt.Errorf("invalid UDP port range %d-%d: %w", o.PortMin, portMax, err)
+		}
+	}
+	if len(o.NAT1To1IPs) > 0 {
+		if err := settingEngine.SetICEAddressRewriteRules(webrtc.ICEAddressRewriteRule{
+			External:        o.NAT1To1IPs,
+			AsCandidateType: webrtc.ICECandidateTypeHost,
+			Mode:            webrtc.ICEAddressRewriteReplace,
+		}); err != nil {
+			return settingEngine, fmt.Errorf("invalid NAT 1:1 IPs %v: %w", o.NAT1To1IPs, err)
+		}
+	}
+	if o.ExcludeCellular {
+		settingEngine.SetInterfaceFilter(func(name string) bool {
+			return !androidnet.IsCellular(name)
+		})
+	}
+	return settingEngine, nil
+}
+
+// configuration returns the PeerConnection configuration for the options,
+// adding extraServers, e.g. those a WHIP endpoint advertised, to the
+// configured ICE servers.
+func (o WebRTCOptions) configuration(extraServers []webrtc.ICEServer) webrtc.Configuration {
+	iceServers := append(append([]webrtc.ICEServer(nil), o.ICEServers...), extraServers...)
+	if len(iceServers) == 0 {
+		iceServers = defaultICEServers
+	}
+	config := webrtc.Configuration{ICEServers: iceServers}
+	if o.RelayOnly {
+		config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+		if !hasTURNServer(iceServers) {
+			log.Printf("Warning: relay-only ICE without a TURN server won't connect")
+		}
+	}
+	return config
+}
+
+func hasTURNServer(servers []webrtc.ICEServer) bool {
+	for _, server := range servers {
+		for _, url := range server.URLs {
+			if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+				return true
+			}
+		}
+	}
+	return false
+}
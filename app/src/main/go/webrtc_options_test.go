@@ -0,0 +1,33 @@
+package kinetic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestWebRTCOptionsConfiguration(t *testing.T) {
+	if config := (WebRTCOptions{}).configuration(nil); len(config.ICEServers) != 1 || config.ICEServers[0].URLs[0] != "stun:stun.l.google.com:19302" {
+		t.Errorf("default ICE servers = %+v", config.ICEServers)
+	}
+
+	// The JSON the Kotlin side sends.
+	var options WebRTCOptions
+	if err := json.Unmarshal([]byte(`{"iceServers":[{"urls":["turn:turn.example.com"],"username":"user","credential":"password"}],`+
+		`"relayOnly":true,"portMin":50000,"portMax":50100,"nat1To1IPs":["203.0.113.1"],"excludeCellular":true}`), &options); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if options.PortMin != 50000 || options.PortMax != 50100 || !options.RelayOnly || !options.ExcludeCellular || len(options.NAT1To1IPs) != 1 {
+		t.Errorf("options = %+v", options)
+	}
+
+	advertised := []webrtc.ICEServer{{URLs: []string{"stun:stun.example.com"}}}
+	config := options.configuration(advertised)
+	if len(config.ICEServers) != 2 || config.ICEServers[0].Username != "user" || config.ICEServers[1].URLs[0] != "stun:stun.example.com" {
+		t.Errorf("ICE servers = %+v, want the configured TURN server then the advertised STUN server", config.ICEServers)
+	}
+	if config.ICETransportPolicy != webrtc.ICETransportPolicyRelay {
+		t.Errorf("ICE transport policy = %v, want relay", config.ICETransportPolicy)
+	}
+}
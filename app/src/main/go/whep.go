@@ -1,118 +1,440 @@
 package kinetic
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/kevmo314/kinetic/pkg/androidnet"
 	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media"
 )
 
+const (
+	defaultWHEPAddr = ":8080"
+
+	// whepKeyframeInterval rate limits keyframe requests, since every
+	// viewer that joins or loses packets asks for one.
+	whepKeyframeInterval = 500 * time.Millisecond
+)
+
+// WHEPSink is a WHEP (WebRTC-HTTP Egress Protocol) server. Viewers POST an
+// SDP offer to /whep and get an answer with a Location of /whep/{id}, which
+// accepts PATCH for trickle ICE candidates and DELETE to hang up. Every viewer
+// gets its own PeerConnection fed from the same tracks, and keyframe requests
+// from any of them are forwarded to the encoder through the PLI callback.
 type WHEPSink struct {
-	tracks []*whepTrack
+	sync.Mutex
+
+	tracks        []*whepTrack
+	bearerToken   string
+	webrtcOptions WebRTCOptions
+	api           *webrtc.API
+
+	listener net.Listener
+	server   *http.Server
+
+	sessions      map[string]*whepSession
+	onPLICallback func()
+	lastKeyframe  time.Time
+	closed        bool
 }
 
+var _ Sink = (*WHEPSink)(nil)
+
 type whepTrack struct {
 	track *webrtc.TrackLocalStaticSample
+	video bool
+
+	// config holds the video codec config buffer, which is prepended to
+	// keyframes so that viewers joining mid-stream can decode them.
+	config []byte
 
 	ptsMicroseconds int64
 }
 
-func NewWHEPSink(url, bearerToken, encodedMediaFormatMimeTypes string) (*WHEPSink, error) {
-	mediaFormatMimeTypes := strings.Split(encodedMediaFormatMimeTypes, ";")
-	tracks := make([]*whepTrack, len(mediaFormatMimeTypes))
+type whepSession struct {
+	pc   *webrtc.PeerConnection
+	etag string
+}
+
+// WHEPSinkOption configures the WHEP sink
+type WHEPSinkOption func(*WHEPSink)
+
+// WithWHEPWebRTCOptions sets the ICE servers and network policy used for
+// every viewer.
+func WithWHEPWebRTCOptions(options WebRTCOptions) WHEPSinkOption {
+	return func(s *WHEPSink) {
+		s.webrtcOptions = options
+	}
+}
+
+// NewWHEPSink starts a WHEP server on addr, or :8080 if addr is empty. If
+// bearerToken is set, requests must carry it in an Authorization header.
+func NewWHEPSink(addr, bearerToken, encodedMediaFormatMimeTypes string, opts ...WHEPSinkOption) (*WHEPSink, error) {
+	s := &WHEPSink{
+		bearerToken: bearerToken,
+		sessions:    make(map[string]*whepSession),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
 
-	for i, mediaFormatMimeType := range mediaFormatMimeTypes {
-		mimeType := MediaFormatMimeType(mediaFormatMimeType).PionMimeType()
+	for _, v := range strings.Split(encodedMediaFormatMimeTypes, ";") {
+		t := MediaFormatMimeType(v)
+		switch t {
+		case MediaFormatMimeTypeVideoH264, MediaFormatMimeTypeVideoH265, MediaFormatMimeTypeVideoVP8,
+			MediaFormatMimeTypeVideoVP9, MediaFormatMimeTypeVideoAV1, MediaFormatMimeTypeAudioOpus:
+		default:
+			return nil, fmt.Errorf("WHEP: unsupported codec %s", v)
+		}
 		track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
-			MimeType: mimeType,
+			MimeType: t.PionMimeType(),
 		}, uuid.NewString(), uuid.NewString())
 		if err != nil {
 			return nil, err
 		}
-		tracks[i] = &whepTrack{track: track}
+		s.tracks = append(s.tracks, &whepTrack{track: track, video: strings.HasPrefix(v, "video/")})
 	}
 
-	go http.ListenAndServe(":8080", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
+	androidNet, err := androidnet.NewNet()
+	if err != nil {
+		return nil, err
+	}
 
-		net, err := androidnet.NewNet()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+	settingEngine, err := s.webrtcOptions.settingEngine(androidNet)
+	if err != nil {
+		return nil, fmt.Errorf("WHEP: %w", err)
+	}
 
-		settingEngine := webrtc.SettingEngine{}
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, err
+	}
 
-		settingEngine.SetNet(net)
-		settingEngine.SetICERenomination()
+	s.api = webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine), webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
+
+	if addr == "" {
+		addr = defaultWHEPAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("WHEP: failed to listen on %s: %w", addr, err)
+	}
+	s.listener = listener
 
-		m := &webrtc.MediaEngine{}
-		if err := m.RegisterDefaultCodecs(); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /whep", s.serveOffer)
+	mux.HandleFunc("PATCH /whep/{id}", s.serveTrickle)
+	mux.HandleFunc("DELETE /whep/{id}", s.serveDelete)
+	mux.HandleFunc("OPTIONS /", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	s.server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, ETag")
+		if r.Method != http.MethodOptions && s.bearerToken != "" && r.Header.Get("Authorization") != "Bearer "+s.bearerToken {
+			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		mux.ServeHTTP(w, r)
+	})}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("WHEP: server stopped: %v", err)
+		}
+	}()
+	return s, nil
+}
 
-		i := &interceptor.Registry{}
-		if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+// Addr returns the address the sink is serving on.
+func (s *WHEPSink) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// SetPLICallback sets the function called when a viewer needs a keyframe.
+func (s *WHEPSink) SetPLICallback(callback func()) {
+	s.Lock()
+	defer s.Unlock()
+	s.onPLICallback = callback
+}
+
+// requestKeyframe forwards a keyframe request to the encoder, at most once
+// per whepKeyframeInterval.
+func (s *WHEPSink) requestKeyframe() {
+	s.Lock()
+	callback := s.onPLICallback
+	if time.Since(s.lastKeyframe) < whepKeyframeInterval {
+		callback = nil
+	} else {
+		s.lastKeyframe = time.Now()
+	}
+	s.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
+
+func (s *WHEPSink) serveOffer(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/sdp" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	pc, err := s.api.NewPeerConnection(s.webrtcOptions.configuration(nil))
+	if err != nil {
+		log.Printf("WHEP: failed to create peer connection: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	id := uuid.NewString()
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("WHEP: viewer %s %s", id, state)
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			// The viewer can't decode anything until the next keyframe.
+			s.requestKeyframe()
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			s.removeSession(id)
 		}
+	})
 
-		api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine), webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
+	answer, err := s.answer(r.Context(), pc, string(offer))
+	if err != nil {
+		pc.Close()
+		log.Printf("WHEP: failed to answer offer: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	session := &whepSession{pc: pc, etag: fmt.Sprintf("%q", iceUfrag(answer))}
+
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		pc.Close()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	s.sessions[id] = session
+	s.Unlock()
 
-		// Create a new RTCPeerConnection
-		peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
-			ICEServers: []webrtc.ICEServer{
-				{
-					URLs: []string{"stun:stun.l.google.com:19302"},
-				},
-			},
-		})
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whep/"+id)
+	w.Header().Set("ETag", session.etag)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+// answer adds the tracks to pc and answers offer once ICE gathering is done,
+// since WHEP has no way to trickle candidates back to the viewer.
+func (s *WHEPSink) answer(ctx context.Context, pc *webrtc.PeerConnection, offer string) (string, error) {
+	for _, t := range s.tracks {
+		sender, err := pc.AddTrack(t.track)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return "", err
 		}
-
-		for _, track := range tracks {
-			rtpSender, err := peerConnection.AddTrack(track.track)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			go func() {
-				rtcpBuf := make([]byte, 1500)
-				for {
-					if _, _, err := rtpSender.Read(rtcpBuf); err != nil {
-						return
+		go func() {
+			for {
+				packets, _, err := sender.ReadRTCP()
+				if err != nil {
+					return
+				}
+				for _, packet := range packets {
+					switch packet.(type) {
+					case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+						s.requestKeyframe()
 					}
 				}
-			}()
+			}
+		}()
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return pc.LocalDescription().SDP, nil
+}
+
+func (s *WHEPSink) serveTrickle(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	session, ok := s.sessions[r.PathValue("id")]
+	s.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/trickle-ice-sdpfrag" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && match != "*" && match != session.etag {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	frag, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ufrag, candidates := parseTrickleICEFragment(string(frag))
+	if remote := session.pc.RemoteDescription(); ufrag != "" && remote != nil && ufrag != iceUfrag(remote.SDP) {
+		// A new ufrag is an ICE restart, which needs a new answer.
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	for _, candidate := range candidates {
+		if err := session.pc.AddICECandidate(candidate); err != nil {
+			log.Printf("WHEP: ignoring ICE candidate %q: %v", candidate.Candidate, err)
 		}
-	}))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	return &WHEPSink{tracks: tracks}, nil
+func (s *WHEPSink) serveDelete(w http.ResponseWriter, r *http.Request) {
+	session := s.removeSession(r.PathValue("id"))
+	if session == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
-func (s *WHEPSink) WriteSample(i int, buf []byte, ptsMicroseconds int64) (bool, error) {
+// removeSession forgets a viewer and closes its PeerConnection. It returns
+// nil if the session was already gone.
+func (s *WHEPSink) removeSession(id string) *whepSession {
+	s.Lock()
+	session, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := session.pc.Close(); err != nil {
+		log.Printf("WHEP: failed to close viewer %s: %v", id, err)
+	}
+	return session
+}
+
+// iceUfrag returns the first ice-ufrag attribute in an SDP or sdpfrag.
+func iceUfrag(sdp string) string {
+	return sdpAttribute(sdp, "ice-ufrag")
+}
+
+// sdpAttribute returns the value of the first a=name: line in an SDP or
+// sdpfrag.
+func sdpAttribute(sdp, name string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "a="+name+":"); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseTrickleICEFragment returns the ufrag and candidates in an
+// application/trickle-ice-sdpfrag body (RFC 8840).
+func parseTrickleICEFragment(frag string) (ufrag string, candidates []webrtc.ICECandidateInit) {
+	var mid *string
+	var index *uint16
+	for _, line := range strings.Split(frag, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			ufrag = strings.TrimPrefix(line, "a=ice-ufrag:")
+		case strings.HasPrefix(line, "m="):
+			next := uint16(0)
+			if index != nil {
+				next = *index + 1
+			}
+			index, mid = &next, nil
+		case strings.HasPrefix(line, "a=mid:"):
+			v := strings.TrimPrefix(line, "a=mid:")
+			mid = &v
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidates = append(candidates, webrtc.ICECandidateInit{
+				Candidate:     strings.TrimPrefix(line, "a="),
+				SDPMid:        mid,
+				SDPMLineIndex: index,
+			})
+		}
+	}
+	return ufrag, candidates
+}
+
+// WriteSample implements the Sink interface
+func (s *WHEPSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	if i < 0 || i >= len(s.tracks) {
+		return fmt.Errorf("track index out of range: %d", i)
+	}
 	t := s.tracks[i]
 
+	if flags&MediaCodecBufferFlagCodecConfig != 0 {
+		if t.video {
+			t.config = append([]byte(nil), buf...)
+		}
+		return nil
+	}
+	if t.video && flags&MediaCodecBufferFlagKeyFrame != 0 && t.config != nil {
+		buf = append(append([]byte(nil), t.config...), buf...)
+	}
+
 	if t.ptsMicroseconds == 0 {
 		t.ptsMicroseconds = ptsMicroseconds
 	}
 	duration := time.Duration(ptsMicroseconds-t.ptsMicroseconds) * time.Microsecond
 	t.ptsMicroseconds = ptsMicroseconds
-	return false, t.track.WriteSample(media.Sample{Data: buf, Duration: duration})
+	return t.track.WriteSample(media.Sample{Data: buf, Duration: duration})
 }
 
+// Close hangs up on every viewer and stops serving.
 func (s *WHEPSink) Close() error {
-	// TODO: close the http server
-	return nil
+	s.Lock()
+	s.closed = true
+	var ids []string
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	s.Unlock()
+
+	err := s.server.Shutdown(context.Background())
+	for _, id := range ids {
+		s.removeSession(id)
+	}
+	return err
 }
@@ -0,0 +1,221 @@
+package kinetic
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestParseTrickleICEFragment(t *testing.T) {
+	frag := "a=ice-ufrag:EsAw\r\n" +
+		"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n" +
+		"m=audio 9 RTP/AVP 0\r\n" +
+		"a=mid:0\r\n" +
+		"a=candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0\r\n" +
+		"m=video 9 RTP/AVP 96\r\n" +
+		"a=mid:1\r\n" +
+		"a=candidate:3471623853 1 udp 2122194687 198.51.100.2 61765 typ host generation 0\r\n" +
+		"a=end-of-candidates\r\n"
+
+	ufrag, candidates := parseTrickleICEFragment(frag)
+	if ufrag != "EsAw" {
+		t.Errorf("ufrag = %q, want EsAw", ufrag)
+	}
+	if len(candidates) != 2 {
+		t.Fatalf("got %d candidates, want 2", len(candidates))
+	}
+	for i, c := range candidates {
+		if !strings.HasPrefix(c.Candidate, "candidate:") {
+			t.Errorf("candidate %d = %q", i, c.Candidate)
+		}
+		if c.SDPMid == nil || *c.SDPMid != fmt.Sprint(i) || c.SDPMLineIndex == nil || *c.SDPMLineIndex != uint16(i) {
+			t.Errorf("candidate %d isn't tagged with m-line %d", i, i)
+		}
+	}
+}
+
+func postTestWHEPOffer(t *testing.T, url, token string, pc *webrtc.PeerConnection) *http.Response {
+	t.Helper()
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer: %v", err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatalf("SetLocalDescription: %v", err)
+	}
+	<-gatherComplete
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(pc.LocalDescription().SDP))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/sdp")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	return resp
+}
+
+func doTestWHEPRequest(t *testing.T, method, url, contentType, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWHEPSinkViewer(t *testing.T) {
+	sink, err := NewWHEPSink("127.0.0.1:0", "secret", string(MediaFormatMimeTypeVideoH264)+";"+string(MediaFormatMimeTypeAudioOpus))
+	if err != nil {
+		t.Fatalf("NewWHEPSink: %v", err)
+	}
+	defer sink.Close()
+	keyframeRequests := make(chan struct{}, 16)
+	sink.SetPLICallback(func() { keyframeRequests <- struct{}{} })
+	base := fmt.Sprintf("http://%s", sink.Addr())
+
+	viewer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer viewer.Close()
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err := viewer.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			t.Fatalf("AddTransceiverFromKind: %v", err)
+		}
+	}
+	received := make(chan *webrtc.TrackRemote, 2)
+	viewer.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if _, _, err := track.ReadRTP(); err == nil {
+			received <- track
+		}
+	})
+
+	resp, err := http.Post(base+"/whep", "application/sdp", strings.NewReader("v=0\r\n"))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST without token: status %d, want 401", resp.StatusCode)
+	}
+
+	resp = postTestWHEPOffer(t, base+"/whep", "secret", viewer)
+	answer, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: status %d: %s", resp.StatusCode, answer)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, "/whep/") {
+		t.Fatalf("Location = %q, want a /whep/ resource", location)
+	}
+	if err := viewer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)}); err != nil {
+		t.Fatalf("SetRemoteDescription: %v", err)
+	}
+
+	// Feed the sink until the viewer has both tracks.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		sink.WriteSample(0, testAnnexB(testH264SPS, testH264PPS), 0, MediaCodecBufferFlagCodecConfig)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			pts := int64(i) * 20_000
+			flags := MediaCodecBufferFlag(0)
+			nalu := []byte{0x41, 0x9a, byte(i)}
+			if i%30 == 0 {
+				flags = MediaCodecBufferFlagKeyFrame
+				nalu = []byte{0x65, 0x88, byte(i)}
+			}
+			sink.WriteSample(0, testAnnexB(nalu), pts, flags)
+			sink.WriteSample(1, []byte{0xf8, 0xff, 0xfe}, pts, 0)
+		}
+	}()
+
+	var video *webrtc.TrackRemote
+	for i := 0; i < 2; i++ {
+		select {
+		case track := <-received:
+			if track.Kind() == webrtc.RTPCodecTypeVideo {
+				video = track
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("viewer didn't receive both tracks")
+		}
+	}
+	if video == nil || !strings.EqualFold(video.Codec().MimeType, webrtc.MimeTypeH264) {
+		t.Fatalf("video track = %v, want H.264", video)
+	}
+
+	// Connecting asks for a keyframe, and so does a PLI from the viewer once
+	// the rate limit allows it.
+	select {
+	case <-keyframeRequests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no keyframe request when the viewer connected")
+	}
+	deadline := time.After(5 * time.Second)
+	for pli := false; !pli; {
+		if err := viewer.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(video.SSRC())}}); err != nil {
+			t.Fatalf("WriteRTCP: %v", err)
+		}
+		select {
+		case <-keyframeRequests:
+			pli = true
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("PLI wasn't forwarded")
+		}
+	}
+
+	frag := "a=ice-ufrag:" + iceUfrag(viewer.LocalDescription().SDP) + "\r\na=end-of-candidates\r\n"
+	if status := doTestWHEPRequest(t, http.MethodPatch, base+location, "application/trickle-ice-sdpfrag", frag); status != http.StatusNoContent {
+		t.Errorf("PATCH: status %d, want 204", status)
+	}
+	restart := "a=ice-ufrag:restart\r\na=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n"
+	if status := doTestWHEPRequest(t, http.MethodPatch, base+location, "application/trickle-ice-sdpfrag", restart); status != http.StatusNotImplemented {
+		t.Errorf("PATCH with a new ufrag: status %d, want 501", status)
+	}
+
+	if status := doTestWHEPRequest(t, http.MethodDelete, base+location, "", ""); status != http.StatusOK {
+		t.Errorf("DELETE: status %d, want 200", status)
+	}
+	if status := doTestWHEPRequest(t, http.MethodDelete, base+location, "", ""); status != http.StatusNotFound {
+		t.Errorf("second DELETE: status %d, want 404", status)
+	}
+	if status := doTestWHEPRequest(t, http.MethodPatch, base+location, "application/trickle-ice-sdpfrag", frag); status != http.StatusNotFound {
+		t.Errorf("PATCH after DELETE: status %d, want 404", status)
+	}
+}
+
+func TestNewWHEPSinkRejectsAAC(t *testing.T) {
+	if _, err := NewWHEPSink("127.0.0.1:0", "", string(MediaFormatMimeTypeVideoH264)+";"+string(MediaFormatMimeTypeAudioAAC)); err == nil {
+		t.Fatal("expected an error for AAC, which WebRTC can't carry")
+	}
+}
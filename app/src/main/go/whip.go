@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,11 +17,11 @@ import (
 	"github.com/kevmo314/kinetic/pkg/androidnet"
 	"github.com/pion/interceptor"
 	"github.com/pion/interceptor/pkg/cc"
-	"github.com/pion/interceptor/pkg/gcc"
 	"github.com/pion/interceptor/pkg/nack"
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media/h264reader"
 )
@@ -38,19 +40,132 @@ type WHIPSink struct {
 	connectionState webrtc.ICEConnectionState
 	reconnecting    bool
 	resourceURL     string // WHIP resource URL for DELETE on close
+	etag            string // Entity tag of the ICE session, for PATCH
 	closed          bool   // Set to true when intentionally closed
 	frameCount      uint64 // For periodic logging
+
+	// ICE
+	webrtcOptions WebRTCOptions
+	iceServers    []webrtc.ICEServer // From the endpoint's Link headers
+	trickler      *whipTrickler
+
+	// Simulcast
+	simulcastRIDs []string     // Highest quality first
+	layers        []*whipTrack // Video encodings, in simulcastRIDs order
+
+	bandwidthEstimator BandwidthEstimatorConfig
+
+	// Forward error correction
+	fec      FECConfig
+	videoFEC bool // The server accepted FlexFEC for the video track
 }
 
+var _ Sink = (*WHIPSink)(nil)
+
 type whipTrack struct {
+	codec           MediaFormatMimeType
+	rid             string // Simulcast layer, empty without simulcast
 	track           *webrtc.TrackLocalStaticRTP
-	packetizer      rtp.Packetizer
+	sender          *webrtc.RTPSender
+	payloader       rtp.Payloader
+	packetizer      rtp.Packetizer // created once the answer fixes the payload type
 	ptsMicroseconds int64
+	vps             []byte // Store last VPS NALU (H.265)
 	sps             []byte // Store last SPS NALU
 	pps             []byte // Store last PPS NALU
 	clockRate       uint32
 	ssrc            uint32
 	payloadType     uint8
+	red             *redEncoder // Set when Opus is sent with RED
+
+	// Header extensions identifying a simulcast layer
+	mid            string
+	midExtensionID uint8
+	ridExtensionID uint8
+}
+
+// setLayerExtensions tags a simulcast layer's packet with its MID and RID so
+// the server can tell the layers apart.
+func (t *whipTrack) setLayerExtensions(pkt *rtp.Packet) {
+	if t.rid == "" || t.midExtensionID == 0 || t.ridExtensionID == 0 {
+		return
+	}
+	if !pkt.Header.Extension {
+		pkt.Header.Extension = true
+		pkt.Header.ExtensionProfile = 0xBEDE // One-byte header extension
+	}
+	pkt.Header.SetExtension(t.midExtensionID, []byte(t.mid))
+	pkt.Header.SetExtension(t.ridExtensionID, []byte(t.rid))
+}
+
+// useRED replaces the Opus track with one that sends RED payloads wrapping
+// Opus frames of the given payload type.
+func (t *whipTrack) useRED(opusPayloadType uint8) error {
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+		MimeType:    whipREDMimeType,
+		ClockRate:   48000,
+		Channels:    2,
+		SDPFmtpLine: fmt.Sprintf("%d/%d", opusPayloadType, opusPayloadType),
+	}, t.track.ID(), t.track.StreamID())
+	if err != nil {
+		return err
+	}
+	if err := t.sender.ReplaceTrack(track); err != nil {
+		return err
+	}
+	t.track = track
+	t.red = &redEncoder{payloadType: opusPayloadType}
+	return nil
+}
+
+// newWHIPPayloader returns the RTP payloader and default clock rate for a
+// codec. The clock rate and payload type actually used come from the answer.
+func newWHIPPayloader(codec MediaFormatMimeType) (rtp.Payloader, uint32, error) {
+	switch codec {
+	case MediaFormatMimeTypeVideoH264:
+		return &codecs.H264Payloader{}, 90000, nil
+	case MediaFormatMimeTypeVideoH265:
+		return &codecs.H265Payloader{}, 90000, nil
+	case MediaFormatMimeTypeVideoVP8:
+		return &codecs.VP8Payloader{EnablePictureID: true}, 90000, nil
+	case MediaFormatMimeTypeVideoVP9:
+		return &codecs.VP9Payloader{}, 90000, nil
+	case MediaFormatMimeTypeVideoAV1:
+		return &codecs.AV1Payloader{}, 90000, nil
+	case MediaFormatMimeTypeAudioOpus:
+		return &codecs.OpusPayloader{}, 48000, nil
+	}
+	return nil, 0, fmt.Errorf("WHIP: unsupported codec %s", codec)
+}
+
+// negotiatedCodec finds the payload type and clock rate the answer uses for
+// mimeType in the media section with the given mid. H.264 prefers
+// packetization-mode=1, which is what the payloader produces.
+func negotiatedCodec(answer *sdp.SessionDescription, mid, mimeType string) (uint8, uint32, bool) {
+	for _, media := range answer.MediaDescriptions {
+		if v, _ := media.Attribute("mid"); v != mid || media.MediaName.Port.Value == 0 {
+			continue
+		}
+		var found *sdp.Codec
+		for _, format := range media.MediaName.Formats {
+			pt, err := strconv.ParseUint(format, 10, 8)
+			if err != nil {
+				continue
+			}
+			codec, err := answer.GetCodecForPayloadType(uint8(pt))
+			if err != nil || !strings.EqualFold(media.MediaName.Media+"/"+codec.Name, mimeType) {
+				continue
+			}
+			if found == nil || (strings.EqualFold(mimeType, webrtc.MimeTypeH264) &&
+				!strings.Contains(found.Fmtp, "packetization-mode=1") && strings.Contains(codec.Fmtp, "packetization-mode=1")) {
+				found = &codec
+			}
+		}
+		if found != nil {
+			return found.PayloadType, found.ClockRate, true
+		}
+	}
+	return 0, 0, false
 }
 
 const (
@@ -81,6 +196,54 @@ func addPlayoutDelayExtension(pkt *rtp.Packet) {
 // WHIPSinkOption configures the WHIP sink
 type WHIPSinkOption func(*WHIPSink)
 
+// WithWHIPWebRTCOptions sets the ICE servers and network policy. ICE servers
+// the endpoint advertises are used in addition to the configured ones.
+func WithWHIPWebRTCOptions(options WebRTCOptions) WHIPSinkOption {
+	return func(s *WHIPSink) {
+		s.webrtcOptions = options
+	}
+}
+
+// WithWHIPSimulcast publishes the video track as simulcast layers with the
+// given RIDs, from the highest quality to the lowest, e.g. "h", "m", "l".
+// Each layer is fed separately with WriteLayerSample and should be encoded at
+// half the resolution of the layer before it, at the bitrate given by
+// LayerTargetBitrates.
+func WithWHIPSimulcast(rids ...string) WHIPSinkOption {
+	return func(s *WHIPSink) {
+		s.simulcastRIDs = rids
+	}
+}
+
+// WithWHIPBandwidthEstimator selects the congestion controller and the
+// bounds of the target bitrate. The default is GCC between 400 kbps and
+// 7.5 Mbps.
+func WithWHIPBandwidthEstimator(config BandwidthEstimatorConfig) WHIPSinkOption {
+	return func(s *WHIPSink) {
+		s.bandwidthEstimator = config
+	}
+}
+
+// WithWHIPFEC adds forward error correction to NACK retransmissions.
+func WithWHIPFEC(config FECConfig) WHIPSinkOption {
+	return func(s *WHIPSink) {
+		s.fec = config
+	}
+}
+
+// validRID reports whether rid is a valid RFC 8851 rid-id.
+func validRID(rid string) bool {
+	if rid == "" || len(rid) > 255 {
+		return false
+	}
+	for _, c := range rid {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 func NewWHIPSink(url, bearerToken, encodedMediaFormatMimeTypes string, opts ...WHIPSinkOption) (*WHIPSink, error) {
 	s := &WHIPSink{
 		url:         url,
@@ -102,9 +265,35 @@ func NewWHIPSink(url, bearerToken, encodedMediaFormatMimeTypes string, opts ...W
 
 // connect establishes a new WebRTC connection
 func (s *WHIPSink) connect() error {
+	return s.connectSession(true)
+}
+
+// connectSession POSTs a new session. pion can't add ICE servers to a
+// running ICE agent, so if the POST response advertises ones the session
+// wasn't created with, e.g. the only TURN relay a relay-only endpoint can be
+// reached through, the session is replaced by one created with them when
+// retryWithServers is set.
+func (s *WHIPSink) connectSession(retryWithServers bool) error {
 	log.Printf("WHIP connect: starting with mimeTypes=%s", s.mimeTypes)
+	if err := s.bandwidthEstimator.validate(); err != nil {
+		return fmt.Errorf("WHIP: %w", err)
+	}
+	if err := s.fec.validate(); err != nil {
+		return fmt.Errorf("WHIP: %w", err)
+	}
 	mediaFormatMimeTypes := strings.Split(s.mimeTypes, ";")
 	tracks := make([]*whipTrack, len(mediaFormatMimeTypes))
+	videoIndex := slices.IndexFunc(mediaFormatMimeTypes, func(t string) bool { return strings.HasPrefix(t, "video/") })
+	if len(s.simulcastRIDs) > 0 {
+		if videoIndex < 0 {
+			return fmt.Errorf("WHIP: simulcast needs a video track")
+		}
+		for _, rid := range s.simulcastRIDs {
+			if !validRID(rid) {
+				return fmt.Errorf("WHIP: invalid simulcast RID %q", rid)
+			}
+		}
+	}
 
 	log.Printf("WHIP connect: getting network interfaces")
 	ifs, err := androidnet.Interfaces()
@@ -122,10 +311,10 @@ func (s *WHIPSink) connect() error {
 	}
 	log.Printf("WHIP connect: net created")
 
-	settingEngine := webrtc.SettingEngine{}
-
-	settingEngine.SetNet(net)
-	settingEngine.SetICERenomination()
+	settingEngine, err := s.webrtcOptions.settingEngine(net)
+	if err != nil {
+		return fmt.Errorf("WHIP: %w", err)
+	}
 
 	m := &webrtc.MediaEngine{}
 	if err := m.RegisterDefaultCodecs(); err != nil {
@@ -157,16 +346,14 @@ func (s *WHIPSink) connect() error {
 	}
 	i.Add(responderFactory)
 
+	// Add FlexFEC and RED, adapted to the loss the congestion controller sees
+	if err := s.fec.configure(m, i, s.lossRate); err != nil {
+		return err
+	}
+
 	// Create congestion controller
-	// Initial bitrate: 1 Mbps, Min: 400 Kbps (IVS requires 200+ for TWCC), Max: 7.5 Mbps
-	log.Printf("WHIP: Using GCC congestion control")
-	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
-		return gcc.NewSendSideBWE(
-			gcc.SendSideBWEInitialBitrate(1_000_000),
-			gcc.SendSideBWEMinBitrate(400_000),
-			gcc.SendSideBWEMaxBitrate(7_500_000),
-		)
-	})
+	log.Printf("WHIP: Using %v congestion control", s.bandwidthEstimator.Algorithm)
+	congestionController, err := cc.NewInterceptor(s.bandwidthEstimator.newEstimator)
 	if err != nil {
 		return err
 	}
@@ -188,105 +375,105 @@ func (s *WHIPSink) connect() error {
 		return err
 	}
 
+	if s.bandwidthEstimator.Algorithm == BandwidthEstimatorSCReAM {
+		// Offer RFC 8888 feedback too, which SCReAM can use instead of TWCC.
+		for _, codecType := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+			m.RegisterFeedback(webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBACK, Parameter: "ccfb"}, codecType)
+		}
+	}
+
 	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine), webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
 
-	// Create a new RTCPeerConnection
-	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{URLs: []string{"stun:stun.l.google.com:19302"}},
-		},
-	})
+	// Add the ICE servers the endpoint advertised on an earlier connection,
+	// if any.
+	peerConnection, err := api.NewPeerConnection(s.webrtcOptions.configuration(s.iceServers))
 	if err != nil {
 		return err
 	}
 
 	// add all the tracks
 	s.tracks = tracks
+	s.layers = nil
 	s.pc = peerConnection
 
 	for i, mediaFormatMimeType := range mediaFormatMimeTypes {
+		payloader, clockRate, err := newWHIPPayloader(MediaFormatMimeType(mediaFormatMimeType))
+		if err != nil {
+			return err
+		}
 		pionMimeType := MediaFormatMimeType(mediaFormatMimeType).PionMimeType()
 		log.Printf("WHIP connect: track %d: input=%s pion=%s", i, mediaFormatMimeType, pionMimeType)
 		codecCap := webrtc.RTPCodecCapability{
-			MimeType: pionMimeType,
+			MimeType:  pionMimeType,
+			ClockRate: clockRate,
 		}
 
-		// Create TrackLocalStaticRTP for direct RTP packet control
-		track, err := webrtc.NewTrackLocalStaticRTP(codecCap, uuid.NewString(), uuid.NewString())
-		if err != nil {
-			log.Printf("WHIP connect: failed to create track %d: %v", i, err)
-			return err
-		}
-
-		// Set up packetizer based on codec type
-		var payloader rtp.Payloader
-		var clockRate uint32
-		var payloadType uint8
-
-		if i == 0 { // Video track (H264)
-			payloader = &codecs.H264Payloader{}
-			clockRate = 90000
-			payloadType = 96
-		} else { // Audio track (Opus)
-			payloader = &codecs.OpusPayloader{}
-			clockRate = 48000
-			payloadType = 111
+		// The video track is sent as one encoding per simulcast layer, all
+		// sharing a sender and a track ID.
+		rids := []string{""}
+		if i == videoIndex && len(s.simulcastRIDs) > 0 {
+			rids = s.simulcastRIDs
 		}
+		trackID, streamID := uuid.NewString(), uuid.NewString()
+		var rtpSender *webrtc.RTPSender
+		var layers []*whipTrack
+		for _, rid := range rids {
+			var trackOpts []func(*webrtc.TrackLocalStaticRTP)
+			if rid != "" {
+				trackOpts = append(trackOpts, webrtc.WithRTPStreamID(rid))
+			}
 
-		// Generate a random SSRC
-		uuidObj := uuid.New()
-		ssrc := uint32(uuidObj[0])<<24 | uint32(uuidObj[1])<<16 | uint32(uuidObj[2])<<8 | uint32(uuidObj[3])
-		packetizer := rtp.NewPacketizer(
-			1200, // MTU
-			payloadType,
-			ssrc,
-			payloader,
-			rtp.NewRandomSequencer(),
-			clockRate,
-		)
+			// Create TrackLocalStaticRTP for direct RTP packet control
+			track, err := webrtc.NewTrackLocalStaticRTP(codecCap, trackID, streamID, trackOpts...)
+			if err != nil {
+				log.Printf("WHIP connect: failed to create track %d: %v", i, err)
+				return err
+			}
+			if rtpSender == nil {
+				rtpSender, err = peerConnection.AddTrack(track)
+			} else {
+				// Each layer needs its own payloader state.
+				payloader, _, err = newWHIPPayloader(MediaFormatMimeType(mediaFormatMimeType))
+				if err == nil {
+					err = rtpSender.AddEncoding(track)
+				}
+			}
+			if err != nil {
+				return err
+			}
 
-		tracks[i] = &whipTrack{
-			track:           track,
-			packetizer:      packetizer,
-			ptsMicroseconds: 0,
-			clockRate:       clockRate,
-			ssrc:            ssrc,
-			payloadType:     payloadType,
+			// Generate a random SSRC
+			uuidObj := uuid.New()
+			ssrc := uint32(uuidObj[0])<<24 | uint32(uuidObj[1])<<16 | uint32(uuidObj[2])<<8 | uint32(uuidObj[3])
+
+			layers = append(layers, &whipTrack{
+				codec:           MediaFormatMimeType(mediaFormatMimeType),
+				rid:             rid,
+				track:           track,
+				sender:          rtpSender,
+				payloader:       payloader,
+				ptsMicroseconds: 0,
+				clockRate:       clockRate,
+				ssrc:            ssrc,
+			})
 		}
-		rtpSender, err := peerConnection.AddTrack(track)
-		if err != nil {
-			return err
+		tracks[i] = layers[0]
+		if i == videoIndex {
+			s.layers = layers
 		}
 
-		// Handle RTCP for video track (index 0)
-		if i == 0 {
-			go func() {
-				var twccCount uint64
-				var lastTWCCLog time.Time
-				for {
-					packets, _, err := rtpSender.ReadRTCP()
-					if err != nil {
-						return
-					}
-					for _, packet := range packets {
-						if _, ok := packet.(*rtcp.PictureLossIndication); ok {
-							log.Printf("Received PLI request")
-							if s.onPLICallback != nil {
-								s.onPLICallback()
-							}
-						}
-						// Count TWCC feedback packets
-						if _, ok := packet.(*rtcp.TransportLayerCC); ok {
-							twccCount++
-							// Log TWCC count every 5 seconds
-							if time.Since(lastTWCCLog) > 5*time.Second {
-								log.Printf("TWCC feedback received: %d packets total", twccCount)
-								lastTWCCLog = time.Now()
-							}
-						}
+		// Handle RTCP for the video track
+		if i == videoIndex {
+			for j, layer := range layers {
+				read := rtpSender.ReadRTCP
+				if j > 0 {
+					rid := layer.rid
+					read = func() ([]rtcp.Packet, interceptor.Attributes, error) {
+						return rtpSender.ReadSimulcastRTCP(rid)
 					}
 				}
-			}()
+				go s.readVideoRTCP(read)
+			}
 		} else {
 			go func() {
 				rtcpBuf := make([]byte, 1500)
@@ -299,6 +486,12 @@ func (s *WHIPSink) connect() error {
 		}
 	}
 
+	// Candidates are trickled to the resource as they're gathered instead of
+	// waiting for gathering to finish before the POST.
+	trickler := &whipTrickler{s: s, pc: peerConnection}
+	s.trickler = trickler
+	peerConnection.OnICECandidate(trickler.add)
+
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
 		return err
@@ -346,6 +539,20 @@ func (s *WHIPSink) connect() error {
 		}
 		log.Printf("WHIP: resource URL: %s", s.resourceURL)
 	}
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.mu.Unlock()
+	if servers := parseICEServerLinks(resp.Header); len(servers) > 0 {
+		log.Printf("WHIP: endpoint advertised %d ICE servers", len(servers))
+		changed := !sameICEServers(servers, s.iceServers)
+		s.iceServers = servers
+		if changed && retryWithServers {
+			log.Printf("WHIP: replacing the session with one using the advertised ICE servers")
+			s.deleteResource()
+			peerConnection.Close()
+			return s.connectSession(false)
+		}
+	}
 
 	log.Printf("WHIP: got SDP answer (%d bytes)", len(body))
 	answer := webrtc.SessionDescription{
@@ -356,6 +563,10 @@ func (s *WHIPSink) connect() error {
 		log.Printf("WHIP: failed to set remote description: %v", err)
 		return err
 	}
+	if err := s.configurePacketizers(peerConnection, body); err != nil {
+		return err
+	}
+	trickler.start()
 
 	connectedCh := make(chan struct{})
 
@@ -388,8 +599,8 @@ func (s *WHIPSink) connect() error {
 			s.mu.Unlock()
 
 			if !alreadyReconnecting && !intentionallyClosed {
-				log.Printf("WHIP connection lost, initiating reconnection...")
-				go s.reconnect()
+				log.Printf("WHIP connection lost, restarting ICE...")
+				go s.recover(peerConnection)
 			}
 		}
 	})
@@ -408,19 +619,266 @@ func (s *WHIPSink) connect() error {
 	return nil
 }
 
-func (s *WHIPSink) WriteSample(i int, buf []byte, ptsMicroseconds int64) error {
-	// Redirect to codec-specific methods for backward compatibility
-	// This method is kept for compatibility but should not be used directly
-	if i == 0 {
-		_, err := s.WriteH264(buf, ptsMicroseconds)
+// readVideoRTCP handles RTCP for one encoding of the video track until the
+// sender is closed.
+func (s *WHIPSink) readVideoRTCP(read func() ([]rtcp.Packet, interceptor.Attributes, error)) {
+	var twccCount uint64
+	var lastTWCCLog time.Time
+	for {
+		packets, _, err := read()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			if _, ok := packet.(*rtcp.PictureLossIndication); ok {
+				log.Printf("Received PLI request")
+				if s.onPLICallback != nil {
+					s.onPLICallback()
+				}
+			}
+			// Count TWCC feedback packets
+			if _, ok := packet.(*rtcp.TransportLayerCC); ok {
+				twccCount++
+				// Log TWCC count every 5 seconds
+				if time.Since(lastTWCCLog) > 5*time.Second {
+					log.Printf("TWCC feedback received: %d packets total", twccCount)
+					lastTWCCLog = time.Now()
+				}
+			}
+		}
+	}
+}
+
+// configurePacketizers creates each track's packetizer with the payload type
+// and clock rate the server chose in its answer.
+func (s *WHIPSink) configurePacketizers(pc *webrtc.PeerConnection, answer []byte) error {
+	var parsed sdp.SessionDescription
+	if err := parsed.Unmarshal(answer); err != nil {
+		return fmt.Errorf("WHIP: failed to parse answer: %w", err)
+	}
+	encodings := append([]*whipTrack(nil), s.tracks...)
+	if len(s.layers) > 1 {
+		encodings = append(encodings, s.layers[1:]...)
+	}
+	for _, t := range encodings {
+		var mid string
+		for _, transceiver := range pc.GetTransceivers() {
+			if transceiver.Sender() == t.sender {
+				mid = transceiver.Mid()
+			}
+		}
+		payloadType, clockRate, ok := negotiatedCodec(&parsed, mid, t.codec.PionMimeType())
+		if !ok {
+			return fmt.Errorf("WHIP: server didn't accept %s", t.codec)
+		}
+		log.Printf("WHIP: track %s negotiated payload type %d at %d Hz", t.codec, payloadType, clockRate)
+		if strings.HasPrefix(string(t.codec), "video/") && s.fec.Video {
+			_, _, s.videoFEC = negotiatedCodec(&parsed, mid, webrtc.MimeTypeFlexFEC03)
+		}
+		if t.codec == MediaFormatMimeTypeAudioOpus && s.fec.Audio {
+			if redPayloadType, _, ok := negotiatedCodec(&parsed, mid, whipREDMimeType); ok {
+				if err := t.useRED(payloadType); err != nil {
+					log.Printf("WHIP: failed to switch Opus to RED: %v", err)
+				} else {
+					payloadType = redPayloadType
+				}
+			}
+		}
+		if t.rid != "" {
+			t.mid = mid
+			for _, ext := range t.sender.GetParameters().HeaderExtensions {
+				switch ext.URI {
+				case sdp.SDESMidURI:
+					t.midExtensionID = uint8(ext.ID)
+				case sdp.SDESRTPStreamIDURI:
+					t.ridExtensionID = uint8(ext.ID)
+				}
+			}
+			if t.midExtensionID == 0 || t.ridExtensionID == 0 {
+				return fmt.Errorf("WHIP: server didn't accept the simulcast header extensions")
+			}
+		}
+		t.payloadType = payloadType
+		t.clockRate = clockRate
+		t.packetizer = rtp.NewPacketizer(
+			1200, // MTU
+			payloadType,
+			t.ssrc,
+			t.payloader,
+			rtp.NewRandomSequencer(),
+			clockRate,
+		)
+	}
+	return nil
+}
+
+func (s *WHIPSink) WriteSample(i int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	s.mu.RLock()
+	reconnecting := s.reconnecting
+	s.mu.RUnlock()
+
+	if reconnecting || i < 0 || i >= len(s.tracks) {
+		// Drop packet during reconnection
+		return nil
+	}
+	return s.writeSample(s.tracks[i], buf, ptsMicroseconds, flags)
+}
+
+// WriteLayerSample writes a sample of one simulcast layer of the video track,
+// indexed in the order the RIDs were given to WithWHIPSimulcast. Without
+// simulcast, layer 0 is the video track.
+func (s *WHIPSink) WriteLayerSample(layer int, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	s.mu.RLock()
+	reconnecting := s.reconnecting
+	s.mu.RUnlock()
+
+	if reconnecting || layer < 0 || layer >= len(s.layers) {
+		// Drop packet during reconnection
+		return nil
+	}
+	return s.writeSample(s.layers[layer], buf, ptsMicroseconds, flags)
+}
+
+func (s *WHIPSink) writeSample(t *whipTrack, buf []byte, ptsMicroseconds int64, flags MediaCodecBufferFlag) error {
+	// Route to the codec-specific writers. The target bitrate returned by
+	// WriteH264 is dropped here; callers that drive the encoder bitrate
+	// should call WriteH264 directly.
+	switch t.codec {
+	case MediaFormatMimeTypeVideoH264:
+		return s.writeH264(t, buf, ptsMicroseconds)
+	case MediaFormatMimeTypeVideoH265:
+		return s.writeH265(t, buf, ptsMicroseconds)
+	case MediaFormatMimeTypeVideoAV1:
+		if flags&MediaCodecBufferFlagCodecConfig != 0 {
+			// The av1C record; keyframes carry their own sequence header.
+			return nil
+		}
+		return s.writeFrame(t, stripAV1TemporalDelimiters(buf), ptsMicroseconds)
+	default:
+		if flags&MediaCodecBufferFlagCodecConfig != 0 {
+			// e.g. OpusHead, which isn't sent over RTP.
+			return nil
+		}
+		return s.writeFrame(t, buf, ptsMicroseconds)
+	}
+}
+
+// recover tries to restore a lost connection with an ICE restart, which keeps
+// the server's session so viewers don't see a new stream, and falls back to
+// a new session if that fails.
+func (s *WHIPSink) recover(pc *webrtc.PeerConnection) {
+	if pc.ConnectionState() != webrtc.PeerConnectionStateClosed {
+		if err := s.restartICE(pc); err != nil {
+			log.Printf("WHIP: ICE restart failed: %v", err)
+		} else {
+			deadline := time.Now().Add(whipICERestartTimeout)
+			for time.Now().Before(deadline) {
+				s.mu.RLock()
+				reconnecting, closed := s.reconnecting, s.closed
+				s.mu.RUnlock()
+				if closed {
+					return
+				}
+				if !reconnecting {
+					log.Printf("WHIP: ICE restart succeeded")
+					// Packets were dropped while disconnected.
+					if s.onPLICallback != nil {
+						s.onPLICallback()
+					}
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			log.Printf("WHIP: ICE restart timed out")
+		}
+	}
+	log.Printf("WHIP: initiating reconnection...")
+	s.reconnect()
+}
+
+// restartICE restarts ICE on the existing session by PATCHing new
+// credentials to the resource (RFC 9725 section 4.3.2) and applying the ones
+// the server answers with.
+func (s *WHIPSink) restartICE(pc *webrtc.PeerConnection) error {
+	if s.resourceURL == "" {
+		return fmt.Errorf("WHIP: no resource URL to restart ICE on")
+	}
+	remote := pc.RemoteDescription()
+	if remote == nil {
+		return fmt.Errorf("WHIP: no remote description to restart ICE on")
+	}
+
+	// Hold back the new candidates until the server knows the new ufrag.
+	s.trickler.pause()
+	offer, err := pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return err
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	frag, err := buildTrickleICEFragment(offer.SDP, nil)
+	if err != nil {
 		return err
-	} else if i == 1 {
-		return s.WriteOpus(buf, ptsMicroseconds)
 	}
+	resp, body, err := s.patchResource(frag, "*")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("WHIP: ICE restart returned HTTP %d: %s", resp.StatusCode, string(body))
+	}
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.mu.Unlock()
 
+	ufrag, pwd := sdpAttribute(string(body), "ice-ufrag"), sdpAttribute(string(body), "ice-pwd")
+	if ufrag == "" || pwd == "" {
+		return fmt.Errorf("WHIP: ICE restart response has no credentials")
+	}
+	answer := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  replaceICECredentials(remote.SDP, ufrag, pwd),
+	}
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	_, candidates := parseTrickleICEFragment(string(body))
+	for _, candidate := range candidates {
+		if err := pc.AddICECandidate(candidate); err != nil {
+			log.Printf("WHIP: failed to add remote candidate: %v", err)
+		}
+	}
+	s.trickler.start()
 	return nil
 }
 
+// patchResource sends an application/trickle-ice-sdpfrag PATCH to the WHIP
+// resource and returns the response with its body read.
+func (s *WHIPSink) patchResource(frag, ifMatch string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodPatch, s.resourceURL, strings.NewReader(frag))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/trickle-ice-sdpfrag")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	if s.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearerToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
 // reconnect attempts to re-establish the WHIP connection
 func (s *WHIPSink) reconnect() {
 	log.Printf("Starting WHIP reconnection...")
@@ -456,7 +914,7 @@ func (s *WHIPSink) reconnect() {
 }
 
 // WriteH264 processes H.264 data using custom packetizer with absolute timestamps
-// Returns the target bitrate in bps (capped at 7.5 Mbps)
+// Returns the target bitrate in bps, within the bandwidth estimator's bounds
 func (s *WHIPSink) WriteH264(buf []byte, ptsMicroseconds int64) (int, error) {
 	s.mu.RLock()
 	reconnecting := s.reconnecting
@@ -467,21 +925,108 @@ func (s *WHIPSink) WriteH264(buf []byte, ptsMicroseconds int64) (int, error) {
 		return 0, nil
 	}
 
-	if len(s.tracks) == 0 {
+	if len(s.tracks) == 0 || s.tracks[0].codec != MediaFormatMimeTypeVideoH264 {
 		return 0, nil
 	}
 
-	videoTrack := s.tracks[0]
+	if err := s.writeH264(s.tracks[0], buf, ptsMicroseconds); err != nil {
+		return 0, err
+	}
+
+	targetBitrate := s.targetBitrate()
+	if s.estimator != nil {
+		// Log estimator stats every 30 frames (~1s at 30fps)
+		s.frameCount++
+		if s.frameCount%30 == 0 {
+			stats := s.estimator.GetStats()
+			switch s.bandwidthEstimator.Algorithm {
+			case BandwidthEstimatorGCC:
+				log.Printf("GCC stats: delay=%d kbps, loss=%v, using=%d kbps",
+					stats["delayTargetBitrate"], stats["lossTargetBitrate"], targetBitrate/1000)
+			case BandwidthEstimatorSCReAM:
+				log.Printf("SCReAM stats: cwnd=%v, inFlight=%v, srtt=%v, queueDelay=%v, using=%d kbps",
+					stats["cwnd"], stats["bytesInFlight"], stats["srtt"], stats["queueDelay"], targetBitrate/1000)
+			}
+		}
+	}
+	return s.TargetBitrate(), nil
+}
 
-	// Convert microseconds to RTP timestamp units (90kHz clock for video)
-	rtpTimestamp := uint32(ptsMicroseconds * 90000 / 1_000_000)
+// TargetBitrate returns the target bitrate in bps for the video track, within
+// the bandwidth estimator's bounds.
+func (s *WHIPSink) TargetBitrate() int {
+	if len(s.layers) > 1 {
+		// Track 0 is the highest simulcast layer, which only gets its share.
+		return s.LayerTargetBitrates()[0]
+	}
+	return s.targetBitrate()
+}
+
+// targetBitrate returns the total target bitrate in bps from the congestion
+// controller, within the configured bounds, less the video FEC overhead.
+func (s *WHIPSink) targetBitrate() int {
+	if s.estimator == nil {
+		return 2_000_000 // Default 2 Mbps if no estimator
+	}
+	targetBitrate := s.bandwidthEstimator.targetBitrate(s.estimator)
+	if s.videoFEC {
+		fecPackets := s.fec.videoFECPackets(s.lossRate(), whipFECGroupSize)
+		targetBitrate = targetBitrate * whipFECGroupSize / (whipFECGroupSize + fecPackets)
+	}
+	return targetBitrate
+}
+
+// lossRate returns the congestion controller's average packet loss, from 0
+// to 1, or 0 if it doesn't measure loss.
+func (s *WHIPSink) lossRate() float64 {
+	if s.estimator == nil {
+		return 0
+	}
+	loss, _ := s.estimator.GetStats()["averageLoss"].(float64)
+	return loss
+}
+
+// LayerTargetBitrates splits the congestion controller's target bitrate
+// between the simulcast layers, highest first. Each layer has a quarter of
+// the pixels of the one before it so it gets a quarter of the bitrate, and
+// layers that would get less than 100 kbps are given 0 so the encoder can
+// pause them until bandwidth recovers. The lowest layer is always sent.
+func (s *WHIPSink) LayerTargetBitrates() []int {
+	const minLayerBitrate = 100_000
+	n := len(s.layers)
+	if n == 0 {
+		return nil
+	}
+	bitrates := make([]int, n)
+	total := s.targetBitrate()
+
+	// Drop layers from the top until the lowest one gets its minimum. Layer
+	// i's weight is 4^(n-1-i), so layers top to n-1 weigh (4^(n-top)-1)/3.
+	for top := 0; top < n; top++ {
+		weights := (1<<(2*(n-top)) - 1) / 3
+		if total/weights < minLayerBitrate && top < n-1 {
+			continue
+		}
+		for i := top; i < n; i++ {
+			bitrates[i] = total * (1 << (2 * (n - 1 - i))) / weights
+		}
+		break
+	}
+	return bitrates
+}
+
+// writeH264 packetizes an Annex B access unit, holding back SPS/PPS and
+// resending them in front of every IDR.
+func (s *WHIPSink) writeH264(videoTrack *whipTrack, buf []byte, ptsMicroseconds int64) error {
+	// Convert microseconds to RTP timestamp units
+	rtpTimestamp := uint32(ptsMicroseconds * int64(videoTrack.clockRate) / 1_000_000)
 
 	// Create h264reader from the buffer
 	reader := bytes.NewReader(buf)
 	h264Reader, err := h264reader.NewReader(reader)
 	if err != nil {
 		log.Printf("WHIP: failed to create h264reader for %d bytes: %v", len(buf), err)
-		return 0, fmt.Errorf("failed to create h264reader: %v", err)
+		return fmt.Errorf("failed to create h264reader: %v", err)
 	}
 
 	nalCount := 0
@@ -492,7 +1037,7 @@ func (s *WHIPSink) WriteH264(buf []byte, ptsMicroseconds int64) (int, error) {
 			break
 		}
 		if err != nil {
-			return 0, fmt.Errorf("error reading NAL: %v", err)
+			return fmt.Errorf("error reading NAL: %v", err)
 		}
 
 		nalCount++
@@ -518,8 +1063,9 @@ func (s *WHIPSink) WriteH264(buf []byte, ptsMicroseconds int64) (int, error) {
 				for _, pkt := range packets {
 					pkt.Header.Timestamp = rtpTimestamp
 					addPlayoutDelayExtension(pkt)
+					videoTrack.setLayerExtensions(pkt)
 					if err := videoTrack.track.WriteRTP(pkt); err != nil {
-						return 0, fmt.Errorf("error writing SPS RTP: %v", err)
+						return fmt.Errorf("error writing SPS RTP: %v", err)
 					}
 				}
 			}
@@ -530,8 +1076,9 @@ func (s *WHIPSink) WriteH264(buf []byte, ptsMicroseconds int64) (int, error) {
 				for _, pkt := range packets {
 					pkt.Header.Timestamp = rtpTimestamp
 					addPlayoutDelayExtension(pkt)
+					videoTrack.setLayerExtensions(pkt)
 					if err := videoTrack.track.WriteRTP(pkt); err != nil {
-						return 0, fmt.Errorf("error writing PPS RTP: %v", err)
+						return fmt.Errorf("error writing PPS RTP: %v", err)
 					}
 				}
 			}
@@ -546,8 +1093,9 @@ func (s *WHIPSink) WriteH264(buf []byte, ptsMicroseconds int64) (int, error) {
 		for _, pkt := range packets {
 			pkt.Header.Timestamp = rtpTimestamp
 			addPlayoutDelayExtension(pkt)
+			videoTrack.setLayerExtensions(pkt)
 			if err := videoTrack.track.WriteRTP(pkt); err != nil {
-				return 0, fmt.Errorf("error writing RTP packet: %v", err)
+				return fmt.Errorf("error writing RTP packet: %v", err)
 			}
 			rtpPacketCount++
 		}
@@ -559,40 +1107,74 @@ func (s *WHIPSink) WriteH264(buf []byte, ptsMicroseconds int64) (int, error) {
 	}
 
 	videoTrack.ptsMicroseconds = ptsMicroseconds
+	return nil
+}
 
-	// Get target bitrate from congestion controller
-	targetBitrate := 2_000_000 // Default 2 Mbps if no estimator
-	if s.estimator != nil {
-		stats := s.estimator.GetStats()
-
-		// GCC: Use delay-based estimate only (ignore loss-based which IVS corrupts)
-		// IVS reports fake 60%+ packet loss at low bitrates, breaking loss-based estimation
-		if delayBitrate, ok := stats["delayTargetBitrate"].(int); ok && delayBitrate > 0 {
-			targetBitrate = delayBitrate
-		} else {
-			targetBitrate = s.estimator.GetTargetBitrate()
+// writeH265 packetizes an Annex B access unit like writeH264, holding back
+// VPS/SPS/PPS and resending them in front of every IRAP picture.
+func (s *WHIPSink) writeH265(t *whipTrack, buf []byte, ptsMicroseconds int64) error {
+	rtpTimestamp := uint32(ptsMicroseconds * int64(t.clockRate) / 1_000_000)
+	for _, nalu := range splitNALUs(buf) {
+		if len(nalu) < 2 {
+			continue
 		}
-
-		// Log GCC stats every 30 frames (~1s at 30fps)
-		s.frameCount++
-		if s.frameCount%30 == 0 {
-			log.Printf("GCC stats: delay=%d kbps, loss=%v, using=%d kbps",
-				stats["delayTargetBitrate"], stats["lossTargetBitrate"], targetBitrate/1000)
+		switch naluType := (nalu[0] >> 1) & 0x3f; {
+		case naluType == 32: // VPS
+			t.vps = append([]byte(nil), nalu...)
+			continue
+		case naluType == 33: // SPS
+			t.sps = append([]byte(nil), nalu...)
+			continue
+		case naluType == 34: // PPS
+			t.pps = append([]byte(nil), nalu...)
+			continue
+		case naluType >= 16 && naluType <= 21: // IRAP
+			for _, param := range [][]byte{t.vps, t.sps, t.pps} {
+				if param == nil {
+					continue
+				}
+				if err := s.writePackets(t, param, rtpTimestamp); err != nil {
+					return err
+				}
+			}
+		}
+		if err := s.writePackets(t, nalu, rtpTimestamp); err != nil {
+			return err
 		}
+	}
+	t.ptsMicroseconds = ptsMicroseconds
+	return nil
+}
 
-		// Floor at 400 kbps (336 kbps video + 64 kbps audio)
-		const minBitrate = 400_000
-		if targetBitrate < minBitrate {
-			targetBitrate = minBitrate
+// writeFrame packetizes a whole frame for codecs whose payloader takes the
+// encoder output as is: VP8, VP9, AV1 and Opus.
+func (s *WHIPSink) writeFrame(t *whipTrack, buf []byte, ptsMicroseconds int64) error {
+	rtpTimestamp := uint32(ptsMicroseconds * int64(t.clockRate) / 1_000_000)
+	if t.red != nil {
+		buf = t.red.encode(buf, rtpTimestamp, s.fec.redDistance(s.lossRate()))
+	}
+	if err := s.writePackets(t, buf, rtpTimestamp); err != nil {
+		return err
+	}
+	t.ptsMicroseconds = ptsMicroseconds
+	return nil
+}
+
+// writePackets packetizes payload at an absolute RTP timestamp and sends it.
+func (s *WHIPSink) writePackets(t *whipTrack, payload []byte, rtpTimestamp uint32) error {
+	// Pass 0 to not increment internal timestamp - we control it manually
+	packets := t.packetizer.Packetize(payload, 0)
+	for _, pkt := range packets {
+		pkt.Header.Timestamp = rtpTimestamp
+		if strings.HasPrefix(string(t.codec), "video/") {
+			addPlayoutDelayExtension(pkt)
+			t.setLayerExtensions(pkt)
 		}
-		// Cap at 7.5 Mbps maximum
-		const maxBitrate = 7_500_000
-		if targetBitrate > maxBitrate {
-			targetBitrate = maxBitrate
+		if err := t.track.WriteRTP(pkt); err != nil {
+			return fmt.Errorf("error writing %s RTP packet: %v", t.codec, err)
 		}
 	}
-
-	return targetBitrate, nil
+	return nil
 }
 
 // WriteOpus processes Opus audio data using custom packetizer with absolute timestamps
@@ -606,29 +1188,12 @@ func (s *WHIPSink) WriteOpus(buf []byte, ptsMicroseconds int64) error {
 		return nil
 	}
 
-	if len(s.tracks) < 2 {
-		return nil
-	}
-
-	// Send to audio track (assuming track 1 is audio)
-	audioTrack := s.tracks[1]
-
-	// Convert microseconds to RTP timestamp units (48kHz clock for Opus)
-	rtpTimestamp := uint32(ptsMicroseconds * 48000 / 1_000_000)
-
-	// Packetize Opus data (usually fits in one packet)
-	// Pass 0 to not increment internal timestamp - we control it manually
-	packets := audioTrack.packetizer.(rtp.Packetizer).Packetize(buf, 0)
-
-	// Override timestamp to use our absolute timestamp
-	for _, pkt := range packets {
-		pkt.Header.Timestamp = rtpTimestamp
-		if err := audioTrack.track.WriteRTP(pkt); err != nil {
-			return fmt.Errorf("error writing Opus RTP packet: %v", err)
+	// Send to the first Opus track
+	for _, t := range s.tracks {
+		if t.codec == MediaFormatMimeTypeAudioOpus {
+			return s.writeFrame(t, buf, ptsMicroseconds)
 		}
 	}
-
-	audioTrack.ptsMicroseconds = ptsMicroseconds
 	return nil
 }
 
@@ -642,28 +1207,33 @@ func (s *WHIPSink) Close() error {
 	s.closed = true
 	s.mu.Unlock()
 
-	// Send DELETE to WHIP resource URL to properly end the session
-	if s.resourceURL != "" {
-		req, err := http.NewRequest("DELETE", s.resourceURL, nil)
-		if err != nil {
-			log.Printf("WHIP: failed to create DELETE request: %v", err)
-		} else {
-			if s.bearerToken != "" {
-				req.Header.Set("Authorization", "Bearer "+s.bearerToken)
-			}
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				log.Printf("WHIP: DELETE request failed: %v", err)
-			} else {
-				resp.Body.Close()
-				log.Printf("WHIP: DELETE returned %d", resp.StatusCode)
-			}
-		}
-	}
-
+	s.deleteResource()
 	return s.pc.Close()
 }
 
+// deleteResource sends DELETE to the WHIP resource URL to properly end the
+// session.
+func (s *WHIPSink) deleteResource() {
+	if s.resourceURL == "" {
+		return
+	}
+	req, err := http.NewRequest("DELETE", s.resourceURL, nil)
+	if err != nil {
+		log.Printf("WHIP: failed to create DELETE request: %v", err)
+		return
+	}
+	if s.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearerToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("WHIP: DELETE request failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	log.Printf("WHIP: DELETE returned %d", resp.StatusCode)
+}
+
 // GetICEConnectionState returns the current ICE connection state as a string
 func (s *WHIPSink) GetICEConnectionState() string {
 	s.mu.RLock()
@@ -0,0 +1,187 @@
+package kinetic
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/flexfec"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// Payload types offered for FlexFEC and audio RED, unused by pion's
+	// default codecs. 63 is what Chrome uses for RED.
+	whipFlexFECPayloadType = 118
+	whipREDPayloadType     = 63
+
+	whipREDMimeType = "audio/red"
+
+	// whipFECGroupSize is how many video packets each round of FlexFEC
+	// repair packets protects.
+	whipFECGroupSize = 10
+
+	// redMaxDistance is the most earlier Opus frames a RED packet repeats.
+	redMaxDistance = 2
+
+	defaultFECMaxOverhead = 0.5
+)
+
+// FECConfig configures forward error correction on a WHIP sink, for links
+// where NACK retransmissions arrive too late, e.g. high RTT cellular. The
+// amount of protection follows the packet loss the congestion controller
+// sees, so it costs little on a clean link.
+type FECConfig struct {
+	// Video protects the video track with FlexFEC-03 (RFC 8627's draft 03,
+	// as implemented by browsers), if the server accepts it.
+	Video bool
+
+	// Audio sends Opus with RFC 2198 redundancy (RED), repeating up to two
+	// earlier frames in each packet, if the server accepts it. Opus in-band
+	// FEC is offered regardless (useinbandfec=1) but depends on the
+	// encoder.
+	Audio bool
+
+	// MinOverhead and MaxOverhead bound the video FEC bitrate as a fraction
+	// of the media bitrate. A MaxOverhead of 0 uses 0.5.
+	MinOverhead float64
+	MaxOverhead float64
+}
+
+func (c FECConfig) validate() error {
+	if c.MinOverhead < 0 || c.MaxOverhead < 0 || c.MinOverhead > 1 || c.MaxOverhead > 1 {
+		return fmt.Errorf("FEC overhead must be between 0 and 1")
+	}
+	if c.MaxOverhead != 0 && c.MinOverhead > c.MaxOverhead {
+		return fmt.Errorf("minimum FEC overhead %v is above maximum %v", c.MinOverhead, c.MaxOverhead)
+	}
+	return nil
+}
+
+// videoFECPackets returns how many FlexFEC packets to send for a group of n
+// media packets at the given loss rate. Protection is twice the loss rate,
+// which covers loss that doesn't spread evenly over the groups.
+func (c FECConfig) videoFECPackets(loss float64, n int) int {
+	maxOverhead := c.MaxOverhead
+	if maxOverhead == 0 {
+		maxOverhead = defaultFECMaxOverhead
+	}
+	overhead := min(max(2*loss, c.MinOverhead), maxOverhead)
+	return min(int(math.Round(overhead*float64(n))), n)
+}
+
+// redDistance returns how many earlier Opus frames to repeat at the given
+// loss rate.
+func (c FECConfig) redDistance(loss float64) int {
+	switch {
+	case loss <= 0:
+		return 0
+	case loss < 0.1:
+		return 1
+	}
+	return redMaxDistance
+}
+
+// configure registers the codecs and interceptors for c on a new
+// PeerConnection. loss returns the current loss rate. It must run before
+// the interceptors that add header extensions, so repair packets protect
+// the media packets as they're finally sent.
+func (c FECConfig) configure(m *webrtc.MediaEngine, i *interceptor.Registry, loss func() float64) error {
+	if c.Video {
+		if err := webrtc.ConfigureFlexFEC03(whipFlexFECPayloadType, m, i,
+			flexfec.NumMediaPackets(whipFECGroupSize),
+			flexfec.FECEncoderFactory(adaptiveFECEncoderFactory{
+				fecPackets: func(n int) int { return c.videoFECPackets(loss(), n) },
+			}),
+		); err != nil {
+			return err
+		}
+	}
+	if c.Audio {
+		if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    whipREDMimeType,
+				ClockRate:   48000,
+				Channels:    2,
+				SDPFmtpLine: "111/111", // pion's default Opus payload type
+			},
+			PayloadType: whipREDPayloadType,
+		}, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adaptiveFECEncoderFactory creates FlexFEC-03 encoders that send as many
+// repair packets per group as fecPackets asks for at the time.
+type adaptiveFECEncoderFactory struct {
+	fecPackets func(n int) int
+}
+
+func (f adaptiveFECEncoderFactory) NewEncoder(payloadType uint8, ssrc uint32) flexfec.FlexEncoder {
+	return &adaptiveFECEncoder{
+		FlexEncoder: flexfec.NewFlexEncoder03(payloadType, ssrc),
+		fecPackets:  f.fecPackets,
+	}
+}
+
+type adaptiveFECEncoder struct {
+	flexfec.FlexEncoder
+	fecPackets func(n int) int
+}
+
+func (e *adaptiveFECEncoder) EncodeFec(mediaPackets []rtp.Packet, _ uint32) []rtp.Packet {
+	n := e.fecPackets(len(mediaPackets))
+	if n == 0 {
+		return nil
+	}
+	return e.FlexEncoder.EncodeFec(mediaPackets, uint32(n))
+}
+
+// redEncoder wraps Opus frames in RFC 2198 RED payloads that repeat the
+// frames before them.
+type redEncoder struct {
+	payloadType uint8 // Of the Opus frames inside
+	history     []redFrame
+}
+
+type redFrame struct {
+	timestamp uint32
+	payload   []byte
+}
+
+// encode returns a RED payload with payload as the primary block after up
+// to distance earlier frames, and remembers payload for the next ones.
+func (r *redEncoder) encode(payload []byte, timestamp uint32, distance int) []byte {
+	var blocks []redFrame
+	for _, f := range r.history[max(0, len(r.history)-distance):] {
+		// The offset and length have 14 and 10 bits.
+		if offset := timestamp - f.timestamp; offset == 0 || offset > 0x3fff || len(f.payload) > 0x3ff {
+			continue
+		}
+		blocks = append(blocks, f)
+	}
+
+	size := 1 + len(payload)
+	for _, f := range blocks {
+		size += 4 + len(f.payload)
+	}
+	out := make([]byte, 0, size)
+	for _, f := range blocks {
+		offset, length := timestamp-f.timestamp, len(f.payload)
+		out = append(out, 0x80|r.payloadType, byte(offset>>6), byte(offset<<2)|byte(length>>8), byte(length))
+	}
+	out = append(out, r.payloadType)
+	for _, f := range blocks {
+		out = append(out, f.payload...)
+	}
+	out = append(out, payload...)
+
+	r.history = append(r.history, redFrame{timestamp: timestamp, payload: append([]byte(nil), payload...)})
+	if len(r.history) > redMaxDistance {
+		r.history = r.history[1:]
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package kinetic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestVideoFECPackets(t *testing.T) {
+	for _, tc := range []struct {
+		config FECConfig
+		loss   float64
+		want   int
+	}{
+		{FECConfig{}, 0, 0},
+		{FECConfig{}, 0.01, 0},
+		{FECConfig{}, 0.05, 1},
+		{FECConfig{}, 0.1, 2},
+		// Capped at half the media packets by default.
+		{FECConfig{}, 0.6, 5},
+		{FECConfig{MaxOverhead: 1}, 0.6, 10},
+		{FECConfig{MaxOverhead: 0.2}, 0.3, 2},
+		{FECConfig{MinOverhead: 0.1}, 0, 1},
+	} {
+		if got := tc.config.videoFECPackets(tc.loss, 10); got != tc.want {
+			t.Errorf("%+v at %v loss: %d FEC packets, want %d", tc.config, tc.loss, got, tc.want)
+		}
+	}
+}
+
+func TestAdaptiveFECEncoder(t *testing.T) {
+	fecPackets := 0
+	encoder := adaptiveFECEncoderFactory{fecPackets: func(int) int { return fecPackets }}.NewEncoder(118, 1234)
+	media := make([]rtp.Packet, whipFECGroupSize)
+	for i := range media {
+		media[i] = rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: uint16(100 + i), SSRC: 5678},
+			Payload: []byte{byte(i), 1, 2, 3},
+		}
+	}
+	for _, n := range []int{0, 2, 1} {
+		fecPackets = n
+		repair := encoder.EncodeFec(media, 5)
+		if len(repair) != n {
+			t.Errorf("got %d FEC packets, want %d", len(repair), n)
+		}
+		for _, p := range repair {
+			if p.SSRC != 1234 || p.PayloadType != 118 {
+				t.Errorf("FEC packet with SSRC %d and payload type %d", p.SSRC, p.PayloadType)
+			}
+		}
+	}
+}
+
+func TestREDEncoder(t *testing.T) {
+	r := &redEncoder{payloadType: 111}
+
+	// Without history, only the primary block.
+	if got, want := r.encode([]byte{1, 2}, 960, 2), []byte{111, 1, 2}; !bytes.Equal(got, want) {
+		t.Errorf("first packet = %v, want %v", got, want)
+	}
+	// One redundant block 960 samples back.
+	if got, want := r.encode([]byte{3}, 1920, 1), []byte{
+		0x80 | 111, 960 >> 6, (960 & 0x3f) << 2, 2,
+		111,
+		1, 2,
+		3,
+	}; !bytes.Equal(got, want) {
+		t.Errorf("second packet = %v, want %v", got, want)
+	}
+	// Two redundant blocks, oldest first.
+	if got, want := r.encode([]byte{4, 5, 6}, 2880, 2), []byte{
+		0x80 | 111, 1920 >> 6, (1920 & 0x3f) << 2, 2,
+		0x80 | 111, 960 >> 6, (960 & 0x3f) << 2, 1,
+		111,
+		1, 2,
+		3,
+		4, 5, 6,
+	}; !bytes.Equal(got, want) {
+		t.Errorf("third packet = %v, want %v", got, want)
+	}
+	// Frames too far back for the 14 bit offset are left out.
+	if got, want := r.encode([]byte{7}, 2880+0x4000, 2), []byte{111, 7}; !bytes.Equal(got, want) {
+		t.Errorf("packet after a gap = %v, want %v", got, want)
+	}
+}
+
+func TestREDDistance(t *testing.T) {
+	for loss, want := range map[float64]int{0: 0, 0.02: 1, 0.2: 2} {
+		if got := (FECConfig{Audio: true}).redDistance(loss); got != want {
+			t.Errorf("redDistance(%v) = %d, want %d", loss, got, want)
+		}
+	}
+}
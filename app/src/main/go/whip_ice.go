@@ -0,0 +1,281 @@
+package kinetic
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// whipICERestartTimeout is how long an ICE restart has to reconnect before
+// the sink gives up on the session and POSTs a new one.
+const whipICERestartTimeout = 10 * time.Second
+
+// whipTrickleMinBackoff and whipTrickleMaxBackoff bound the wait before
+// retrying candidates that couldn't be trickled.
+const (
+	whipTrickleMinBackoff = 100 * time.Millisecond
+	whipTrickleMaxBackoff = 5 * time.Second
+)
+
+// whipTrickler sends local ICE candidates to the WHIP resource with PATCH
+// (RFC 9725 section 4.3.1), queueing the ones gathered while the resource
+// URL isn't known yet or an ICE restart is in flight.
+type whipTrickler struct {
+	sync.Mutex
+	s  *WHIPSink
+	pc *webrtc.PeerConnection
+
+	pending  []string // Candidate attributes, "" for end of candidates
+	ready    bool
+	sending  bool
+	disabled bool // The server doesn't support trickle
+}
+
+// add is the OnICECandidate callback. A nil candidate ends gathering.
+func (t *whipTrickler) add(c *webrtc.ICECandidate) {
+	candidate := ""
+	if c != nil {
+		candidate = c.ToJSON().Candidate
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.pending = append(t.pending, candidate)
+	t.flushLocked()
+}
+
+// start sends the queued candidates and every one after.
+func (t *whipTrickler) start() {
+	t.Lock()
+	defer t.Unlock()
+	t.ready = true
+	t.flushLocked()
+}
+
+// pause queues candidates until the next start.
+func (t *whipTrickler) pause() {
+	t.Lock()
+	defer t.Unlock()
+	t.ready = false
+}
+
+func (t *whipTrickler) flushLocked() {
+	if !t.ready || t.sending || t.disabled || len(t.pending) == 0 {
+		return
+	}
+	t.sending = true
+	go t.send()
+}
+
+// send PATCHes batches of pending candidates until there are none left. A
+// batch that couldn't be sent is queued again and retried with backoff.
+func (t *whipTrickler) send() {
+	backoff := whipTrickleMinBackoff
+	for {
+		t.Lock()
+		candidates := t.pending
+		t.pending = nil
+		if !t.ready || t.disabled || len(candidates) == 0 || t.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			t.pending = append(candidates, t.pending...)
+			t.sending = false
+			t.Unlock()
+			return
+		}
+		t.Unlock()
+
+		if t.trickle(candidates) {
+			backoff = whipTrickleMinBackoff
+			continue
+		}
+		t.Lock()
+		t.pending = append(candidates, t.pending...)
+		t.Unlock()
+		time.Sleep(backoff)
+		backoff = min(2*backoff, whipTrickleMaxBackoff)
+	}
+}
+
+// trickle PATCHes one batch of candidates, reporting false if it should be
+// retried.
+func (t *whipTrickler) trickle(candidates []string) bool {
+	local := t.pc.LocalDescription()
+	if local == nil {
+		return false
+	}
+	frag, err := buildTrickleICEFragment(local.SDP, candidates)
+	if err != nil {
+		log.Printf("WHIP: failed to build trickle fragment: %v", err)
+		return false
+	}
+	t.s.mu.RLock()
+	etag := t.s.etag
+	t.s.mu.RUnlock()
+	resp, _, err := t.s.patchResource(frag, etag)
+	if err != nil {
+		log.Printf("WHIP: failed to trickle candidates: %v", err)
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return true
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		// The server only uses the candidates in the offer and the
+		// ones it learns from connectivity checks.
+		log.Printf("WHIP: server doesn't support trickle ICE (HTTP %d)", resp.StatusCode)
+		t.Lock()
+		t.disabled = true
+		t.Unlock()
+		return true
+	default:
+		log.Printf("WHIP: trickle ICE returned HTTP %d", resp.StatusCode)
+		return false
+	}
+}
+
+// buildTrickleICEFragment builds an application/trickle-ice-sdpfrag body
+// (RFC 8840) with the ICE credentials of localSDP and candidates for its
+// first media section, which all the others are bundled on. An empty
+// candidate marks the end of candidates.
+func buildTrickleICEFragment(localSDP string, candidates []string) (string, error) {
+	var parsed sdp.SessionDescription
+	if err := parsed.Unmarshal([]byte(localSDP)); err != nil {
+		return "", fmt.Errorf("WHIP: failed to parse local description: %w", err)
+	}
+	if len(parsed.MediaDescriptions) == 0 {
+		return "", fmt.Errorf("WHIP: local description has no media")
+	}
+	media := parsed.MediaDescriptions[0]
+	ufrag, pwd := sdpAttribute(localSDP, "ice-ufrag"), sdpAttribute(localSDP, "ice-pwd")
+	mid, _ := media.Attribute("mid")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "a=ice-ufrag:%s\r\n", ufrag)
+	fmt.Fprintf(&b, "a=ice-pwd:%s\r\n", pwd)
+	fmt.Fprintf(&b, "m=%s 9 %s %s\r\n", media.MediaName.Media,
+		strings.Join(media.MediaName.Protos, "/"), strings.Join(media.MediaName.Formats, " "))
+	fmt.Fprintf(&b, "a=mid:%s\r\n", mid)
+	for _, candidate := range candidates {
+		if candidate == "" {
+			b.WriteString("a=end-of-candidates\r\n")
+		} else {
+			fmt.Fprintf(&b, "a=%s\r\n", candidate)
+		}
+	}
+	return b.String(), nil
+}
+
+// replaceICECredentials returns remoteSDP with the ICE credentials from an
+// ICE restart and without the candidates of the previous ICE session.
+func replaceICECredentials(remoteSDP, ufrag, pwd string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(remoteSDP, "\n") {
+		attr := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(attr, "a=ice-ufrag:"):
+			fmt.Fprintf(&b, "a=ice-ufrag:%s\r\n", ufrag)
+		case strings.HasPrefix(attr, "a=ice-pwd:"):
+			fmt.Fprintf(&b, "a=ice-pwd:%s\r\n", pwd)
+		case strings.HasPrefix(attr, "a=candidate:"), attr == "a=end-of-candidates":
+		default:
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
+
+// parseICEServerLinks returns the STUN and TURN servers a WHIP endpoint
+// advertised in Link headers with rel="ice-server" (RFC 9725 section 4.6).
+func parseICEServerLinks(header http.Header) []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	for _, value := range header.Values("Link") {
+		for value != "" {
+			start, end := strings.IndexByte(value, '<'), strings.IndexByte(value, '>')
+			if start < 0 || end < start {
+				break
+			}
+			uri := value[start+1 : end]
+			var params map[string]string
+			params, value = parseLinkParams(value[end+1:])
+			if !strings.Contains(" "+params["rel"]+" ", " ice-server ") {
+				continue
+			}
+			server := webrtc.ICEServer{URLs: []string{uri}}
+			if username, ok := params["username"]; ok {
+				server.Username = username
+				server.Credential = params["credential"]
+			}
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// sameICEServers reports whether a and b are the same servers with the same
+// credentials.
+func sameICEServers(a, b []webrtc.ICEServer) bool {
+	return slices.EqualFunc(a, b, func(x, y webrtc.ICEServer) bool {
+		return slices.Equal(x.URLs, y.URLs) && x.Username == y.Username && x.Credential == y.Credential
+	})
+}
+
+// parseLinkParams parses the ;name=value parameters after a link's URI,
+// unquoting quoted values, and returns the rest of the header after the
+// comma that ends the link.
+func parseLinkParams(s string) (map[string]string, string) {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return params, ""
+		}
+		if s[0] == ',' {
+			return params, s[1:]
+		}
+		if s[0] != ';' {
+			// Malformed; skip to the next link.
+			if i := strings.IndexByte(s, ','); i >= 0 {
+				return params, s[i+1:]
+			}
+			return params, ""
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+		i := strings.IndexAny(s, "=;,")
+		if i < 0 {
+			i = len(s)
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i:]
+		if !strings.HasPrefix(s, "=") {
+			params[name] = ""
+			continue
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i = 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value, s = b.String(), s[min(i+1, len(s)):]
+		} else {
+			i = strings.IndexAny(s, ";,")
+			if i < 0 {
+				i = len(s)
+			}
+			value, s = strings.TrimSpace(s[:i]), s[i:]
+		}
+		if _, ok := params[name]; !ok {
+			params[name] = value
+		}
+	}
+}
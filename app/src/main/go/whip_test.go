@@ -0,0 +1,361 @@
+package kinetic
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/turn/v4"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestNegotiatedCodec(t *testing.T) {
+	answer := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 102 108 45\r\n" +
+		"a=mid:0\r\n" +
+		"a=rtpmap:102 H264/90000\r\n" +
+		"a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f\r\n" +
+		"a=rtpmap:108 H264/90000\r\n" +
+		"a=fmtp:108 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f\r\n" +
+		"a=rtpmap:45 AV1/90000\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 109\r\n" +
+		"a=mid:1\r\n" +
+		"a=rtpmap:109 opus/48000/2\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 98\r\n" +
+		"a=mid:2\r\n" +
+		"a=rtpmap:98 VP9/90000\r\n"
+	var parsed sdp.SessionDescription
+	if err := parsed.Unmarshal([]byte(answer)); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	for _, tc := range []struct {
+		mid, mimeType string
+		payloadType   uint8
+		clockRate     uint32
+		ok            bool
+	}{
+		{"0", webrtc.MimeTypeH264, 108, 90000, true},
+		{"0", webrtc.MimeTypeAV1, 45, 90000, true},
+		{"1", webrtc.MimeTypeOpus, 109, 48000, true},
+		{"1", webrtc.MimeTypeH264, 0, 0, false},
+		// Rejected m-line.
+		{"2", webrtc.MimeTypeVP9, 0, 0, false},
+		{"3", webrtc.MimeTypeOpus, 0, 0, false},
+	} {
+		payloadType, clockRate, ok := negotiatedCodec(&parsed, tc.mid, tc.mimeType)
+		if payloadType != tc.payloadType || clockRate != tc.clockRate || ok != tc.ok {
+			t.Errorf("negotiatedCodec(%s, %s) = %d, %d, %v, want %d, %d, %v",
+				tc.mid, tc.mimeType, payloadType, clockRate, ok, tc.payloadType, tc.clockRate, tc.ok)
+		}
+	}
+}
+
+func TestNewWHIPPayloaderRejectsAAC(t *testing.T) {
+	if _, _, err := newWHIPPayloader(MediaFormatMimeTypeAudioAAC); err == nil {
+		t.Fatal("expected an error for AAC, which WebRTC can't carry")
+	}
+}
+
+func TestLayerTargetBitrates(t *testing.T) {
+	// Without an estimator the target is 2 Mbps.
+	for _, tc := range []struct {
+		layers int
+		want   []int
+	}{
+		{0, nil},
+		{1, []int{2_000_000}},
+		{2, []int{1_600_000, 400_000}},
+		// The lowest of three layers would get 95 kbps, so the top one is
+		// paused.
+		{3, []int{0, 1_600_000, 400_000}},
+	} {
+		s := &WHIPSink{layers: make([]*whipTrack, tc.layers)}
+		if got := s.LayerTargetBitrates(); !slices.Equal(got, tc.want) {
+			t.Errorf("%d layers: got %v, want %v", tc.layers, got, tc.want)
+		}
+	}
+}
+
+func TestValidRID(t *testing.T) {
+	for rid, want := range map[string]bool{
+		"h":       true,
+		"low_1":   true,
+		"mid-2":   true,
+		"":        false,
+		"a b":     false,
+		"hi;lo":   false,
+		"caf\xe9": false,
+	} {
+		if got := validRID(rid); got != want {
+			t.Errorf("validRID(%q) = %v, want %v", rid, got, want)
+		}
+	}
+}
+
+func TestParseICEServerLinks(t *testing.T) {
+	header := http.Header{}
+	header.Add("Link", `<stun:stun.example.net>; rel="ice-server"`)
+	header.Add("Link", `<turn:turn.example.net?transport=udp>; rel="ice-server"; username="user"; credential="my\"Password"; credential-type="password", </resource/1/layer>; rel="urn:ietf:params:whip:ext:core:layer"`)
+	header.Add("Link", `<turns:turn.example.net?transport=tcp>;rel=ice-server;username=u2;credential=p2`)
+
+	want := []webrtc.ICEServer{
+		{URLs: []string{"stun:stun.example.net"}},
+		{URLs: []string{"turn:turn.example.net?transport=udp"}, Username: "user", Credential: `my"Password`},
+		{URLs: []string{"turns:turn.example.net?transport=tcp"}, Username: "u2", Credential: "p2"},
+	}
+	got := parseICEServerLinks(header)
+	if len(got) != len(want) {
+		t.Fatalf("got %d servers, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !slices.Equal(got[i].URLs, want[i].URLs) || got[i].Username != want[i].Username || got[i].Credential != want[i].Credential {
+			t.Errorf("server %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBuildTrickleICEFragment(t *testing.T) {
+	local := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=group:BUNDLE 0 1\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n" +
+		"a=mid:0\r\n" +
+		"a=ice-ufrag:EsAw\r\n" +
+		"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:1\r\n" +
+		"a=ice-ufrag:EsAw\r\n" +
+		"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n"
+	candidate := "candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0"
+
+	frag, err := buildTrickleICEFragment(local, []string{candidate, ""})
+	if err != nil {
+		t.Fatalf("buildTrickleICEFragment: %v", err)
+	}
+	want := "a=ice-ufrag:EsAw\r\n" +
+		"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n" +
+		"a=mid:0\r\n" +
+		"a=" + candidate + "\r\n" +
+		"a=end-of-candidates\r\n"
+	if frag != want {
+		t.Errorf("fragment =\n%s\nwant\n%s", frag, want)
+	}
+	if ufrag, candidates := parseTrickleICEFragment(frag); ufrag != "EsAw" || len(candidates) != 1 || candidates[0].Candidate != candidate {
+		t.Errorf("fragment doesn't round trip: %q, %+v", ufrag, candidates)
+	}
+}
+
+// TestWHIPTricklerRetries checks candidates survive a missing local
+// description and a failed PATCH, and are sent once both are resolved.
+func TestWHIPTricklerRetries(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc.Close()
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatalf("AddTransceiverFromKind: %v", err)
+	}
+
+	candidate := "candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0"
+	trickler := &whipTrickler{s: &WHIPSink{resourceURL: server.URL}, pc: pc}
+	trickler.pending = []string{candidate}
+	trickler.start()
+
+	// Nothing can be sent without a local description.
+	time.Sleep(250 * time.Millisecond)
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer: %v", err)
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatalf("SetLocalDescription: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		trickler.Lock()
+		done := !trickler.sending && len(trickler.pending) == 0
+		trickler.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("candidates weren't sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 {
+		t.Fatalf("got %d PATCHes, want 2", len(bodies))
+	}
+	for i, body := range bodies {
+		if !strings.Contains(body, "a="+candidate+"\r\n") {
+			t.Errorf("PATCH %d doesn't have the candidate:\n%s", i, body)
+		}
+	}
+}
+
+func TestReplaceICECredentials(t *testing.T) {
+	remote := "v=0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=ice-ufrag:old\r\n" +
+		"a=ice-pwd:oldpassword\r\n" +
+		"a=candidate:1 1 udp 2122260223 192.0.2.1 61764 typ host\r\n" +
+		"a=end-of-candidates\r\n" +
+		"a=mid:0\r\n"
+	want := "v=0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=ice-ufrag:new\r\n" +
+		"a=ice-pwd:newpassword\r\n" +
+		"a=mid:0\r\n"
+	if got := replaceICECredentials(remote, "new", "newpassword"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestWHIPSinkRelayFromLink connects a relay-only sink to an endpoint whose
+// only TURN server is advertised in the POST response's Link header, which
+// the sink must use on the session it's connecting.
+func TestWHIPSinkRelayFromLink(t *testing.T) {
+	turnConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	turnServer, err := turn.NewServer(turn.ServerConfig{
+		Realm: "kinetic",
+		AuthHandler: func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return turn.GenerateAuthKey(username, realm, "pass"), username == "user"
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            turnConn,
+			RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{RelayAddress: net.IPv4(127, 0, 0, 1), Address: "127.0.0.1"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("turn.NewServer: %v", err)
+	}
+	defer turnServer.Close()
+	link := fmt.Sprintf(`<turn:%s?transport=udp>; rel="ice-server"; username="user"; credential="pass"`, turnConn.LocalAddr())
+
+	var mu sync.Mutex
+	var posts int
+	var endpoints []*webrtc.PeerConnection
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, pc := range endpoints {
+			pc.Close()
+		}
+	}()
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetIncludeLoopbackCandidate(true)
+	settingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			pc, err := api.NewPeerConnection(webrtc.Configuration{})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			endpoints = append(endpoints, pc)
+			gathered := webrtc.GatheringCompletePromise(pc)
+			if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(body)}); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			answer, err := pc.CreateAnswer(nil)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if err := pc.SetLocalDescription(answer); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			<-gathered
+			posts++
+			w.Header().Set("Location", fmt.Sprintf("/resource/%d", len(endpoints)-1))
+			w.Header().Set("Link", link)
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, pc.LocalDescription().SDP)
+		case http.MethodPatch:
+			var i int
+			fmt.Sscanf(r.URL.Path, "/resource/%d", &i)
+			_, candidates := parseTrickleICEFragment(string(body))
+			for _, candidate := range candidates {
+				endpoints[i].AddICECandidate(candidate)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	type result struct {
+		sink *WHIPSink
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sink, err := NewWHIPSink(server.URL, "", string(MediaFormatMimeTypeVideoH264),
+			WithWHIPWebRTCOptions(WebRTCOptions{RelayOnly: true}))
+		done <- result{sink, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("NewWHIPSink: %v", res.err)
+		}
+		defer res.sink.Close()
+		if state := res.sink.GetICEConnectionState(); state != webrtc.ICEConnectionStateConnected.String() {
+			t.Errorf("ICE connection state %s, want connected", state)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("the sink didn't connect through the advertised relay")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 2 {
+		t.Errorf("got %d POSTs, want 2", posts)
+	}
+}
@@ -35,6 +35,7 @@ import androidx.core.app.ActivityCompat
 import androidx.core.app.NotificationCompat
 import com.kevmo314.kineticstreamer.kinetic.WHIPSink
 import com.kevmo314.kineticstreamer.kinetic.RISTSink
+import com.kevmo314.kineticstreamer.kinetic.RTMPSink
 import com.kevmo314.kineticstreamer.kinetic.SRTSink
 import com.kevmo314.kineticstreamer.kinetic.PLICallback
 import com.kevmo314.kineticstreamer.kinetic.RTMPSource
@@ -141,6 +142,7 @@ class StreamingService : Service() {
     private var whipSink: WHIPSink? = null
     private var srtSink: SRTSink? = null
     private var ristSink: RISTSink? = null
+    private var rtmpSink: RTMPSink? = null
     private var networkExecutor: ExecutorService? = null  // Dedicated thread for network writes
     @Volatile private var lastBitrate: Int = 0 // Track last bitrate to avoid frequent updates
     private var videoFrameCount: Long = 0 // Counter for debug logging
@@ -275,6 +277,8 @@ class StreamingService : Service() {
             srtSink = null
             ristSink?.close()
             ristSink = null
+            rtmpSink?.close()
+            rtmpSink = null
 
             // Clean up queue
             networkExecutor?.shutdown()
@@ -373,10 +377,12 @@ class StreamingService : Service() {
         val whipEnabled = outputConfigs.any { it.enabled && (it.url.startsWith("whip://") || it.url.startsWith("https://") || it.url.startsWith("http://")) }
         val srtEnabled = outputConfigs.any { it.enabled && it.url.startsWith("srt://") }
         val ristEnabled = outputConfigs.any { it.enabled && it.url.startsWith("rist://") }
-        // Opus if WHIP enabled or no MPEG-TS sinks configured. SRT and RIST both
-        // ship MPEG-TS and prefer AAC.
-        useOpusAudio = whipEnabled || !(srtEnabled || ristEnabled)
-        Log.i("StreamingService", "Audio codec: ${if (useOpusAudio) "Opus" else "AAC"} (WHIP=$whipEnabled, SRT=$srtEnabled, RIST=$ristEnabled)")
+        val rtmpEnabled = outputConfigs.any { it.enabled && (it.url.startsWith("rtmp://") || it.url.startsWith("rtmps://")) }
+        // Opus if WHIP enabled or no MPEG-TS/FLV sinks configured. SRT and RIST
+        // both ship MPEG-TS and prefer AAC; RTMP carries either, Opus through
+        // Enhanced RTMP.
+        useOpusAudio = whipEnabled || !(srtEnabled || ristEnabled || rtmpEnabled)
+        Log.i("StreamingService", "Audio codec: ${if (useOpusAudio) "Opus" else "AAC"} (WHIP=$whipEnabled, SRT=$srtEnabled, RIST=$ristEnabled, RTMP=$rtmpEnabled)")
 
         // Read video codec setting
         val videoCodec = runBlocking { settings?.codec?.first() } ?: SupportedVideoCodec.H264
@@ -461,7 +467,7 @@ class StreamingService : Service() {
 
             when {
                 config.url.startsWith("srt://") -> {
-                    Log.i("StreamingService", "Creating SRT sink for ${config.url}")
+                    Log.i("StreamingService", "Creating SRT sink for ${SRTSink.redactUrl(config.url)}")
                     try {
                         srtSink = SRTSink(config.url, mimeTypes)
 
@@ -485,7 +491,7 @@ class StreamingService : Service() {
                     }
                 }
                 config.url.startsWith("rist://") -> {
-                    Log.i("StreamingService", "Creating RIST sink for ${config.url}")
+                    Log.i("StreamingService", "Creating RIST sink for ${RISTSink.redactUrl(config.url)}")
                     try {
                         ristSink = RISTSink(config.url, mimeTypes)
                     } catch (e: Exception) {
@@ -496,6 +502,19 @@ class StreamingService : Service() {
                         return
                     }
                 }
+                config.url.startsWith("rtmp://") || config.url.startsWith("rtmps://") -> {
+                    // Don't log the full URL, the last path segment is the stream key
+                    Log.i("StreamingService", "Creating RTMP sink for ${config.url.substringBeforeLast('/')}")
+                    try {
+                        rtmpSink = RTMPSink(config.url, mimeTypes)
+                    } catch (e: Exception) {
+                        Log.e("StreamingService", "Failed to create RTMP sink: ${e.message}", e)
+                        Handler(Looper.getMainLooper()).post {
+                            Toast.makeText(this@StreamingService, "RTMP connection failed: ${e.message}", Toast.LENGTH_LONG).show()
+                        }
+                        return
+                    }
+                }
                 config.url.startsWith("whip://") || config.url.startsWith("https://") || config.url.startsWith("http://") -> {
                     // WHIP sink - strip whip:// prefix and extract token from query params
                     val rawUrl = if (config.url.startsWith("whip://")) config.url.removePrefix("whip://") else config.url
@@ -575,7 +594,7 @@ class StreamingService : Service() {
                     networkExecutor?.execute {
                         try {
                             // Write to WHIP sink if configured
-                            val gccBitrate = whipSink?.writeH264(array, ts) ?: 0
+                            val gccBitrate = whipSink?.writeSample(0, array, ts, flags) ?: 0
 
                             // Write to SRT sink if configured
                             srtSink?.writeSample(0, array, ts, flags)
@@ -583,13 +602,18 @@ class StreamingService : Service() {
                             // Write to RIST sink if configured
                             ristSink?.writeSample(0, array, ts, flags)
 
-                            // Get SRT bandwidth estimate (in bps)
+                            // Write to RTMP sink if configured
+                            rtmpSink?.writeSample(0, array, ts, flags)
+
+                            // Get SRT and RIST bandwidth estimates (in bps)
                             val srtBandwidth = srtSink?.getEstimatedBandwidth() ?: 0L
+                            val ristBandwidth = ristSink?.getEstimatedBandwidth() ?: 0L
 
                             // Update encoder bitrate based on bandwidth estimation
                             val targetBitrate = when {
                                 gccBitrate > 0 -> gccBitrate
                                 srtBandwidth > 0 -> srtBandwidth.toInt()
+                                ristBandwidth > 0 -> ristBandwidth.toInt()
                                 else -> 0
                             }
 
@@ -751,14 +775,16 @@ class StreamingService : Service() {
                         networkExecutor?.execute {
                             try {
                                 if (useOpusAudio) {
-                                    // Opus mode - write to WHIP (WebRTC) and SRT/RIST (if configured)
-                                    whipSink?.writeOpus(array, pts)
+                                    // Opus mode - write to WHIP (WebRTC), SRT/RIST and RTMP (if configured)
+                                    whipSink?.writeSample(1, array, pts, flags)
                                     srtSink?.writeSample(1, array, pts, flags)
                                     ristSink?.writeSample(1, array, pts, flags)
+                                    rtmpSink?.writeSample(1, array, pts, flags)
                                 } else {
-                                    // AAC mode - SRT/RIST both carry AAC in MPEG-TS
+                                    // AAC mode - SRT/RIST both carry AAC in MPEG-TS, RTMP in FLV
                                     srtSink?.writeSample(1, array, pts, flags)
                                     ristSink?.writeSample(1, array, pts, flags)
+                                    rtmpSink?.writeSample(1, array, pts, flags)
                                 }
                             } catch (e: Exception) {
                                 Log.e("StreamingService", "Error writing audio data: ${e.message}", e)
@@ -1276,6 +1302,8 @@ class StreamingService : Service() {
         srtSink = null
         ristSink?.close()
         ristSink = null
+        rtmpSink?.close()
+        rtmpSink = null
 
         networkExecutor?.shutdown()
         networkExecutor = null
@@ -468,16 +468,30 @@ fun RtmpAudioSource(rtmpSourceRef: AtomicReference<RTMPSource?>): Flow<Timestamp
  * This function is similar to startUvcFrameProcessing but reads from RTMP instead of UVC.
  */
 fun startRtmpFrameProcessing(rtmpSource: RTMPSource, outputSurface: Surface, renderer: SurfaceTextureRenderer? = null): Pair<Thread, MediaCodec> {
-    val format = MediaFormat.createVideoFormat(MediaFormat.MIMETYPE_VIDEO_AVC, 1920, 1080)
+    // The codec is known once the publisher sends its sequence header, which
+    // is normally right after publish. Legacy publishers are always H.264.
+    var mimeType = rtmpSource.getVideoCodec()
+    val deadline = System.currentTimeMillis() + 2000
+    while (mimeType.isEmpty() && System.currentTimeMillis() < deadline && !rtmpSource.isClosed()) {
+        Thread.sleep(20)
+        mimeType = rtmpSource.getVideoCodec()
+    }
+    if (mimeType.isEmpty()) {
+        mimeType = MediaFormat.MIMETYPE_VIDEO_AVC
+    }
+    Log.i("VideoSource", "RTMP: video codec $mimeType")
+
+    val format = MediaFormat.createVideoFormat(mimeType, 1920, 1080)
     format.setInteger(MediaFormat.KEY_LOW_LATENCY, 1)
     format.setInteger(MediaFormat.KEY_MAX_INPUT_SIZE, 1920 * 1080)
     format.setInteger("vendor.qcom-ext-dec-input-buffer-count.value", 30)
     format.setInteger("input-buffer-count", 30)
 
-    var sentOA4PPS = false
+    // The dummy parameter sets are H.264 only
+    var sentOA4PPS = mimeType != MediaFormat.MIMETYPE_VIDEO_AVC
     val frameQueue = LinkedBlockingQueue<FrameWithPTS>(30)
 
-    val decoder = MediaCodec.createDecoderByType(MediaFormat.MIMETYPE_VIDEO_AVC).apply {
+    val decoder = MediaCodec.createDecoderByType(mimeType).apply {
         setCallback(object : MediaCodec.Callback() {
             override fun onInputBufferAvailable(codec: MediaCodec, index: Int) {
                 if (!sentOA4PPS) {
@@ -0,0 +1,34 @@
+package com.kevmo314.kineticstreamer.kinetic
+
+import org.json.JSONArray
+import org.json.JSONObject
+
+/**
+ * An extra peer of a [RISTSink], e.g. the same receiver reached over another
+ * link. Peers with zero weight get every packet, so the receiver can keep the
+ * first copy for seamless redundancy; the rest share the packets in
+ * proportion to their weights.
+ *
+ * @param url a rist:// URL; the sink's profile= applies to every peer
+ * @param weight 0 to get every packet, or the peer's share of them
+ * @param iface name of the local interface to pin the peer to, e.g. "rmnet_data0"
+ */
+data class RISTPeerConfig(
+    val url: String,
+    val weight: Int = 0,
+    val iface: String? = null,
+) {
+    internal fun toJsonObject(): JSONObject {
+        val json = JSONObject()
+            .put("url", url)
+            .put("weight", weight)
+        iface?.let { json.put("interface", it) }
+        return json
+    }
+
+    companion object {
+        internal fun toJson(peers: List<RISTPeerConfig>): String {
+            return JSONArray(peers.map { it.toJsonObject() }).toString()
+        }
+    }
+}
@@ -0,0 +1,40 @@
+package com.kevmo314.kineticstreamer.kinetic
+
+import org.json.JSONObject
+
+/**
+ * Encryption and authentication of RIST peers, kept out of the URL so the
+ * secrets aren't logged. The Simple profile supports neither.
+ *
+ * @param secret pre-shared key to encrypt the stream with, the same on both peers, or empty
+ * @param keySize 128 or 256 bits of AES, 0 for 128
+ * @param keyRotation packets sent with a key before deriving a new one, 0 for librist's default
+ * @param srpUsername EAP-SRP username to authenticate to the receiver with, or empty
+ * @param srpPassword EAP-SRP password, set together with [srpUsername]
+ */
+data class RISTSecurityConfig(
+    val secret: String = "",
+    val keySize: Int = 0,
+    val keyRotation: Int = 0,
+    val srpUsername: String = "",
+    val srpPassword: String = "",
+) {
+    /**
+     * Encodes the config as the JSON the native library expects.
+     */
+    fun toJson(): String {
+        return JSONObject()
+            .put("secret", secret)
+            .put("keySize", keySize)
+            .put("keyRotation", keyRotation)
+            .put("srpUsername", srpUsername)
+            .put("srpPassword", srpPassword)
+            .toString()
+    }
+
+    override fun toString(): String {
+        return "RISTSecurityConfig(secret=${if (secret.isEmpty()) "" else "REDACTED"}, keySize=$keySize, " +
+            "keyRotation=$keyRotation, srpUsername=$srpUsername, " +
+            "srpPassword=${if (srpPassword.isEmpty()) "" else "REDACTED"})"
+    }
+}
@@ -6,24 +6,39 @@ import java.io.Closeable
  * RIST sink for streaming video/audio over the RIST protocol (TR-06-1 / 06-2).
  * The URL is forwarded to librist's parser, so query parameters supported by
  * `rist_parse_address2` (e.g. buffer=, bandwidth=, cname=) are honoured.
+ *
+ * @param peers sends to more peers besides [url], e.g. over other links, see [RISTPeerConfig]
+ * @param security encrypts or authenticates the connections without putting secrets in the URL
  */
-class RISTSink(url: String, mimeTypes: String) : Closeable {
+class RISTSink(
+    url: String,
+    mimeTypes: String,
+    peers: List<RISTPeerConfig> = emptyList(),
+    security: RISTSecurityConfig? = null,
+) : Closeable {
     private var nativeHandle: Long
 
     init {
         // Ensure Kinetic library is loaded
         Kinetic
 
-        nativeHandle = create(url, mimeTypes)
+        nativeHandle = create(
+            url,
+            mimeTypes,
+            if (peers.isEmpty()) "" else RISTPeerConfig.toJson(peers),
+            security?.toJson() ?: "",
+        )
         if (nativeHandle == 0L) {
             throw RuntimeException("Failed to create RISTSink")
         }
     }
 
-    private external fun create(url: String, mimeTypes: String): Long
+    private external fun create(url: String, mimeTypes: String, peers: String, security: String): Long
     private external fun writeH264(handle: Long, data: ByteArray, pts: Long)
     private external fun writeH265(handle: Long, data: ByteArray, pts: Long)
     private external fun writeOpus(handle: Long, data: ByteArray, pts: Long)
+    private external fun getBandwidth(handle: Long): Long
+    private external fun getStats(handle: Long): String
     private external fun close(handle: Long)
 
     /**
@@ -41,6 +56,21 @@ class RISTSink(url: String, mimeTypes: String) : Closeable {
         }
     }
 
+    /**
+     * Get estimated bandwidth in bits per second, backing off when packets
+     * need retransmitting or a peer times out
+     */
+    fun getEstimatedBandwidth(): Long {
+        return getBandwidth(nativeHandle)
+    }
+
+    /**
+     * Get a snapshot of the connection's health as of librist's last report.
+     */
+    fun getStats(): RISTStats {
+        return RISTStats.fromJson(getStats(nativeHandle))
+    }
+
     override fun close() {
         if (nativeHandle != 0L) {
             close(nativeHandle)
@@ -51,4 +81,15 @@ class RISTSink(url: String, mimeTypes: String) : Closeable {
     protected fun finalize() {
         close()
     }
+
+    companion object {
+        private val SECRET_PARAM = Regex("([?&](?:secret|password)=)[^&#]*", RegexOption.IGNORE_CASE)
+
+        /**
+         * Hide the secret and SRP password in a rist:// URL, for logging.
+         */
+        fun redactUrl(url: String): String {
+            return SECRET_PARAM.replace(url, "$1REDACTED")
+        }
+    }
 }
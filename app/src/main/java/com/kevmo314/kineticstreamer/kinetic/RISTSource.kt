@@ -0,0 +1,107 @@
+package com.kevmo314.kineticstreamer.kinetic
+
+import java.io.Closeable
+
+/**
+ * RIST source for receiving an MPEG-TS contribution feed. `rist://@host:port`
+ * listens for senders, `rist://host:port` connects to a listening sender. Like
+ * [RISTSink], `profile=` picks the RIST profile and the other query
+ * parameters are handed to librist.
+ *
+ * @param security decrypts the stream or authenticates to the sender without putting secrets in the URL
+ */
+class RISTSource(url: String, security: RISTSecurityConfig? = null) : Closeable {
+    private var handle: Long
+
+    // PTS of the frames last returned by readVideoFrame and readAudioFrame,
+    // written by the same native call that reads the frame.
+    @Volatile private var videoPTS = 0L
+    @Volatile private var audioPTS = 0L
+
+    init {
+        // Ensure Kinetic library is loaded
+        Kinetic
+
+        handle = nativeCreate(url, security?.toJson() ?: "")
+        if (handle == 0L) {
+            throw RuntimeException("Failed to create RISTSource")
+        }
+    }
+
+    /**
+     * Read a video frame from the source (blocking)
+     * Returns H.264/H.265 NALUs in Annex B format
+     * Returns null if source is closed
+     */
+    fun readVideoFrame(): ByteArray? {
+        if (handle == 0L) return null
+        val pts = LongArray(1)
+        val frame = nativeReadVideoFrame(handle, pts) ?: return null
+        videoPTS = pts[0]
+        return frame
+    }
+
+    /**
+     * Read an audio frame from the source (blocking)
+     * Returns raw AAC frame data or Opus packets depending on [getAudioCodec]
+     * Returns null if source is closed
+     */
+    fun readAudioFrame(): ByteArray? {
+        if (handle == 0L) return null
+        val pts = LongArray(1)
+        val frame = nativeReadAudioFrame(handle, pts) ?: return null
+        audioPTS = pts[0]
+        return frame
+    }
+
+    /**
+     * Get the PTS of the frame last returned by [readVideoFrame] in microseconds
+     */
+    fun getVideoPTS(): Long = videoPTS
+
+    /**
+     * Get the PTS of the frame last returned by [readAudioFrame] in microseconds
+     */
+    fun getAudioPTS(): Long = audioPTS
+
+    /**
+     * Get the MediaFormat mime type of the video track, or an empty string if
+     * the sender hasn't sent any video yet
+     */
+    fun getVideoCodec(): String {
+        if (handle == 0L) return ""
+        return nativeGetVideoCodec(handle)
+    }
+
+    /**
+     * Get the MediaFormat mime type of the audio track, or an empty string if
+     * the sender hasn't sent any audio yet
+     */
+    fun getAudioCodec(): String {
+        if (handle == 0L) return ""
+        return nativeGetAudioCodec(handle)
+    }
+
+    /**
+     * Check if the source is closed
+     */
+    fun isClosed(): Boolean {
+        if (handle == 0L) return true
+        return nativeIsClosed(handle) != 0
+    }
+
+    override fun close() {
+        if (handle != 0L) {
+            nativeClose(handle)
+            handle = 0L
+        }
+    }
+
+    private external fun nativeCreate(url: String, security: String): Long
+    private external fun nativeReadVideoFrame(handle: Long, ptsOut: LongArray): ByteArray?
+    private external fun nativeReadAudioFrame(handle: Long, ptsOut: LongArray): ByteArray?
+    private external fun nativeGetVideoCodec(handle: Long): String
+    private external fun nativeGetAudioCodec(handle: Long): String
+    private external fun nativeIsClosed(handle: Long): Int
+    private external fun nativeClose(handle: Long)
+}
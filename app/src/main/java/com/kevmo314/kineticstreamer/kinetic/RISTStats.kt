@@ -0,0 +1,80 @@
+package com.kevmo314.kineticstreamer.kinetic
+
+import org.json.JSONArray
+import org.json.JSONObject
+
+/**
+ * Health of one RIST peer's connection, as of librist's last report (once a
+ * second). Packet counts are totals since the peer was created.
+ *
+ * @param retryBandwidthBps bandwidth spent on retransmissions
+ * @param quality percentage of packets delivered without being retransmitted
+ * @param packetsReceived RTCP packets from the receiver
+ */
+data class RISTConnectionStats(
+    val rttMs: Double,
+    val bandwidthBps: Long,
+    val retryBandwidthBps: Long,
+    val quality: Double,
+    val packetsSent: Long,
+    val packetsReceived: Long,
+    val packetsRetransmitted: Long,
+) {
+    companion object {
+        internal fun fromJson(json: JSONObject) = RISTConnectionStats(
+            rttMs = json.optDouble("rttMs", 0.0),
+            bandwidthBps = json.optLong("bandwidthBps"),
+            retryBandwidthBps = json.optLong("retryBandwidthBps"),
+            quality = json.optDouble("quality", 0.0),
+            packetsSent = json.optLong("packetsSent"),
+            packetsReceived = json.optLong("packetsReceived"),
+            packetsRetransmitted = json.optLong("packetsRetransmitted"),
+        )
+    }
+}
+
+/**
+ * One peer of a RIST sink. Peer IDs are librist's, numbered as peers are
+ * created starting with the sink's URL, and again when one reconnects.
+ *
+ * @param cname the receiver's
+ */
+data class RISTPeerStats(
+    val peerID: Long,
+    val cname: String,
+    val stats: RISTConnectionStats,
+)
+
+/**
+ * A snapshot of a RIST sink's connection health.
+ *
+ * @param stats the sum of the peers' stats, with the highest RTT and lowest quality
+ * @param connected whether at least one peer is connected
+ * @param targetBitrateBps the bitrate estimate from [RISTSink.getEstimatedBandwidth]
+ */
+data class RISTStats(
+    val stats: RISTConnectionStats,
+    val connected: Boolean,
+    val targetBitrateBps: Long,
+    val peers: List<RISTPeerStats>,
+) {
+    companion object {
+        internal fun fromJson(s: String): RISTStats {
+            val json = JSONObject(s)
+            val peers = json.optJSONArray("peers") ?: JSONArray()
+            return RISTStats(
+                stats = RISTConnectionStats.fromJson(json),
+                connected = json.optBoolean("connected"),
+                targetBitrateBps = json.optLong("targetBitrateBps"),
+                peers = (0 until peers.length()).map {
+                    val peer = peers.getJSONObject(it)
+                    RISTPeerStats(
+                        peerID = peer.optLong("peerID"),
+                        cname = peer.optString("cname"),
+                        stats = RISTConnectionStats.fromJson(peer),
+                    )
+                },
+            )
+        }
+    }
+}
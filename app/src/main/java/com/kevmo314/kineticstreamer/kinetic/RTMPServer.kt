@@ -3,7 +3,18 @@ package com.kevmo314.kineticstreamer.kinetic
 import java.io.Closeable
 
 /**
- * RTMP server for receiving video/audio streams from external publishers
+ * Decides whether an RTMP publisher is allowed in. Called once on connect
+ * with an empty stream key to vet the app, and again on publish with the full
+ * publishing name, including any query string.
+ */
+interface RTMPAuthorizer {
+    fun authorize(app: String, streamKey: String): Boolean
+}
+
+/**
+ * RTMP server for receiving video/audio streams from external publishers.
+ * Any number of publishers can be connected at once; each is keyed by
+ * "app/streamKey".
  */
 class RTMPServer(private val port: Int = 1935) : Closeable {
     private var nativeHandle: Long = 0L
@@ -46,11 +57,12 @@ class RTMPServer(private val port: Int = 1935) : Closeable {
     }
 
     /**
-     * Get the current source (may be null if no publisher connected)
+     * Get the source publishing on [key] ("app/streamKey"), or the most recent
+     * publisher if [key] is empty (may be null if no publisher connected)
      */
-    fun getSource(): RTMPSource? {
+    fun getSource(key: String = ""): RTMPSource? {
         if (nativeHandle == 0L) return null
-        val sourceHandle = nativeGetSource(nativeHandle)
+        val sourceHandle = nativeGetSource(nativeHandle, key)
         if (sourceHandle == 0L) return null
         return RTMPSource(sourceHandle)
     }
@@ -60,13 +72,39 @@ class RTMPServer(private val port: Int = 1935) : Closeable {
      * @param timeoutMs timeout in milliseconds
      * @return RTMPSource if a publisher connected, null on timeout
      */
-    fun waitForSource(timeoutMs: Int): RTMPSource? {
+    fun waitForSource(timeoutMs: Int): RTMPSource? = waitForSource("", timeoutMs)
+
+    /**
+     * Wait for a publisher on [key] ("app/streamKey"). If [key] is empty, wait
+     * for the next publisher on any key.
+     * @param timeoutMs timeout in milliseconds
+     * @return RTMPSource if a publisher connected, null on timeout
+     */
+    fun waitForSource(key: String, timeoutMs: Int): RTMPSource? {
         if (nativeHandle == 0L) return null
-        val sourceHandle = nativeWaitForSource(nativeHandle, timeoutMs)
+        val sourceHandle = nativeWaitForSource(nativeHandle, key, timeoutMs)
         if (sourceHandle == 0L) return null
         return RTMPSource(sourceHandle)
     }
 
+    /**
+     * List the keys ("app/streamKey") of all connected publishers
+     */
+    fun listSources(): List<String> {
+        if (nativeHandle == 0L) return emptyList()
+        return nativeListSources(nativeHandle).split("\n").filter { it.isNotEmpty() }
+    }
+
+    /**
+     * Install a callback to accept or reject publishers. Without one every
+     * publisher is accepted.
+     * @throws IllegalStateException if the server is closed
+     */
+    fun setAuthorizer(authorizer: RTMPAuthorizer) {
+        check(nativeHandle != 0L) { "RTMPServer is closed" }
+        nativeSetAuthorizer(nativeHandle, authorizer)
+    }
+
     override fun close() {
         stop()
     }
@@ -75,6 +113,8 @@ class RTMPServer(private val port: Int = 1935) : Closeable {
     private external fun nativeStart(handle: Long): Int
     private external fun nativeStop(handle: Long)
     private external fun nativeGetPort(handle: Long): Int
-    private external fun nativeGetSource(handle: Long): Long
-    private external fun nativeWaitForSource(handle: Long, timeoutMs: Int): Long
+    private external fun nativeGetSource(handle: Long, key: String): Long
+    private external fun nativeWaitForSource(handle: Long, key: String, timeoutMs: Int): Long
+    private external fun nativeListSources(handle: Long): String
+    private external fun nativeSetAuthorizer(handle: Long, authorizer: RTMPAuthorizer)
 }
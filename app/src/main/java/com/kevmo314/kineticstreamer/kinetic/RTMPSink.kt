@@ -0,0 +1,54 @@
+package com.kevmo314.kineticstreamer.kinetic
+
+import java.io.Closeable
+
+/**
+ * RTMP sink for publishing to an RTMP or RTMPS ingest (YouTube, Twitch, ...).
+ * The URL is of the form `rtmp://host[:port]/app/streamKey`.
+ *
+ * [mimeTypes] is a `;`-separated list of the video then audio track's
+ * MediaFormat mime types. Video is `video/avc`, `video/hevc` or
+ * `video/av01`, and audio is `audio/mp4a-latm` or `audio/opus`. H.264 and
+ * AAC are plain RTMP; HEVC, AV1 and Opus are sent with Enhanced RTMP
+ * FourCCs, so they need an ingest that supports E-RTMP.
+ */
+class RTMPSink(url: String, mimeTypes: String) : Closeable {
+    private var nativeHandle: Long
+
+    init {
+        // Ensure Kinetic library is loaded
+        Kinetic
+
+        nativeHandle = nativeCreate(url, mimeTypes)
+        if (nativeHandle == 0L) {
+            throw RuntimeException("Failed to create RTMPSink")
+        }
+    }
+
+    private external fun nativeCreate(url: String, mimeTypes: String): Long
+    private external fun nativeWriteSample(handle: Long, streamIndex: Int, data: ByteArray, ptsMicroseconds: Long, flags: Int)
+    private external fun nativeClose(handle: Long)
+
+    /**
+     * Write a sample to the RTMP stream.
+     * @param streamIndex 0 for video, 1 for audio
+     * @param data The encoded data
+     * @param ptsMicroseconds Presentation timestamp in microseconds
+     * @param flags MediaCodec buffer flags
+     */
+    fun writeSample(streamIndex: Int, data: ByteArray, ptsMicroseconds: Long, flags: Int) {
+        if (nativeHandle == 0L) return
+        nativeWriteSample(nativeHandle, streamIndex, data, ptsMicroseconds, flags)
+    }
+
+    override fun close() {
+        if (nativeHandle != 0L) {
+            nativeClose(nativeHandle)
+            nativeHandle = 0L
+        }
+    }
+
+    protected fun finalize() {
+        close()
+    }
+}
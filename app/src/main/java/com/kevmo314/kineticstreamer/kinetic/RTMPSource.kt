@@ -14,7 +14,8 @@ class RTMPSource(private var handle: Long) : Closeable {
 
     /**
      * Read a video frame from the source (blocking)
-     * Returns H.264 NALUs in Annex B format
+     * Returns H.264/H.265 NALUs in Annex B format, AV1 OBUs or VP9 frames
+     * depending on [getVideoCodec]
      * Returns null if source is closed
      */
     fun readVideoFrame(): ByteArray? {
@@ -24,7 +25,7 @@ class RTMPSource(private var handle: Long) : Closeable {
 
     /**
      * Read an audio frame from the source (blocking)
-     * Returns raw AAC frame data
+     * Returns raw AAC frame data or Opus packets depending on [getAudioCodec]
      * Returns null if source is closed
      */
     fun readAudioFrame(): ByteArray? {
@@ -48,6 +49,24 @@ class RTMPSource(private var handle: Long) : Closeable {
         return nativeGetAudioPTS(handle)
     }
 
+    /**
+     * Get the MediaFormat mime type of the video track, or an empty string if
+     * the publisher hasn't sent any video yet
+     */
+    fun getVideoCodec(): String {
+        if (handle == 0L) return ""
+        return nativeGetVideoCodec(handle)
+    }
+
+    /**
+     * Get the MediaFormat mime type of the audio track, or an empty string if
+     * the publisher hasn't sent any audio yet
+     */
+    fun getAudioCodec(): String {
+        if (handle == 0L) return ""
+        return nativeGetAudioCodec(handle)
+    }
+
     /**
      * Check if the source is closed
      */
@@ -67,6 +86,8 @@ class RTMPSource(private var handle: Long) : Closeable {
     private external fun nativeReadAudioFrame(handle: Long): ByteArray?
     private external fun nativeGetVideoPTS(handle: Long): Long
     private external fun nativeGetAudioPTS(handle: Long): Long
+    private external fun nativeGetVideoCodec(handle: Long): String
+    private external fun nativeGetAudioCodec(handle: Long): String
     private external fun nativeIsClosed(handle: Long): Int
     private external fun nativeClose(handle: Long)
 }
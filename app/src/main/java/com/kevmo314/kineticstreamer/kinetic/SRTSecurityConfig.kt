@@ -0,0 +1,38 @@
+package com.kevmo314.kineticstreamer.kinetic
+
+import org.json.JSONObject
+
+/**
+ * AES encryption of an SRT connection, kept out of the URL so the passphrase
+ * isn't logged. The stream's key is replaced every [kmRefreshRate] packets
+ * without interrupting it; changing the passphrase takes
+ * [SRTSink.setPassphrase].
+ *
+ * @param passphrase 10 to 79 characters, the same on both peers
+ * @param keyLength 16, 24 or 32 bytes for AES-128, AES-192 or AES-256, 0 for the receiver's
+ * @param kmRefreshRate packets sent with a key before switching, 0 for libsrt's default
+ * @param kmPreAnnounce packets before and after the switch both keys are valid, 0 for libsrt's default
+ */
+data class SRTSecurityConfig(
+    val passphrase: String,
+    val keyLength: Int = 0,
+    val kmRefreshRate: Int = 0,
+    val kmPreAnnounce: Int = 0,
+) {
+    /**
+     * Encodes the config as the JSON the native library expects.
+     */
+    fun toJson(): String {
+        return JSONObject()
+            .put("passphrase", passphrase)
+            .put("keyLength", keyLength)
+            .put("kmRefreshRate", kmRefreshRate)
+            .put("kmPreAnnounce", kmPreAnnounce)
+            .toString()
+    }
+
+    override fun toString(): String {
+        return "SRTSecurityConfig(passphrase=REDACTED, keyLength=$keyLength, " +
+            "kmRefreshRate=$kmRefreshRate, kmPreAnnounce=$kmPreAnnounce)"
+    }
+}
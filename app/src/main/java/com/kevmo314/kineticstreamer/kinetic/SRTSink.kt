@@ -4,8 +4,10 @@ import java.io.Closeable
 
 /**
  * SRT sink for streaming video/audio over SRT protocol
+ *
+ * @param security encrypts the connection without putting the passphrase in the URL
  */
-class SRTSink(url: String, mimeTypes: String) : Closeable {
+class SRTSink(url: String, mimeTypes: String, security: SRTSecurityConfig? = null) : Closeable {
     private var nativeHandle: Long
     private var pliCallback: PLICallback? = null
 
@@ -13,18 +15,21 @@ class SRTSink(url: String, mimeTypes: String) : Closeable {
         // Ensure Kinetic library is loaded
         Kinetic
 
-        nativeHandle = create(url, mimeTypes)
+        nativeHandle = create(url, mimeTypes, security?.toJson() ?: "")
         if (nativeHandle == 0L) {
             throw RuntimeException("Failed to create SRTSink")
         }
     }
 
-    private external fun create(url: String, mimeTypes: String): Long
+    private external fun create(url: String, mimeTypes: String, security: String): Long
 
+    private external fun writeSample(handle: Long, streamIndex: Int, data: ByteArray, pts: Long, flags: Int)
     private external fun writeH264(handle: Long, data: ByteArray, pts: Long)
     private external fun writeH265(handle: Long, data: ByteArray, pts: Long)
     private external fun writeOpus(handle: Long, data: ByteArray, pts: Long)
     private external fun getBandwidth(handle: Long): Long
+    private external fun getStats(handle: Long): String
+    private external fun setPassphrase(handle: Long, passphrase: String): Boolean
     private external fun setPLICallback(handle: Long, callback: PLICallback)
 
     /**
@@ -35,11 +40,12 @@ class SRTSink(url: String, mimeTypes: String) : Closeable {
      * @param flags MediaCodec flags
      */
     fun writeSample(streamIndex: Int, data: ByteArray, ptsMicroseconds: Long, flags: Int) {
-        when (streamIndex) {
-            0 -> writeH264(nativeHandle, data, ptsMicroseconds) // Video stream
-            1 -> writeOpus(nativeHandle, data, ptsMicroseconds) // Audio stream
-            else -> throw IllegalArgumentException("Invalid stream index: $streamIndex")
+        if (streamIndex != 0 && streamIndex != 1) {
+            throw IllegalArgumentException("Invalid stream index: $streamIndex")
         }
+        // The flags mark keyframes, which the send queue resumes video at
+        // after dropping frames
+        writeSample(nativeHandle, streamIndex, data, ptsMicroseconds, flags)
     }
 
     /**
@@ -49,6 +55,23 @@ class SRTSink(url: String, mimeTypes: String) : Closeable {
         return getBandwidth(nativeHandle)
     }
 
+    /**
+     * Get a snapshot of the connection's health, e.g. to graph RTT and loss
+     */
+    fun getStats(): SRTStats {
+        return SRTStats.fromJson(getStats(nativeHandle))
+    }
+
+    /**
+     * Change the passphrase, e.g. to rotate it. A listener keeps its receivers
+     * and requires the new passphrase from the next one; otherwise the sink
+     * reconnects with it.
+     * @return false if the passphrase is invalid or the peer rejected it
+     */
+    fun setPassphrase(passphrase: String): Boolean {
+        return setPassphrase(nativeHandle, passphrase)
+    }
+
     /**
      * Set callback for keyframe requests (PLI - Picture Loss Indication)
      */
@@ -71,6 +94,15 @@ class SRTSink(url: String, mimeTypes: String) : Closeable {
     }
 
     companion object {
+        private val PASSPHRASE_PARAM = Regex("([?&]passphrase=)[^&#]*", RegexOption.IGNORE_CASE)
+
+        /**
+         * Hide the passphrase in an srt:// URL, for logging.
+         */
+        fun redactUrl(url: String): String {
+            return PASSPHRASE_PARAM.replace(url, "$1REDACTED")
+        }
+
         // MediaCodec flags that might be used
         const val BUFFER_FLAG_KEY_FRAME = 1
         const val BUFFER_FLAG_CODEC_CONFIG = 2
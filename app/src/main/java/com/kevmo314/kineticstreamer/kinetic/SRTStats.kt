@@ -0,0 +1,134 @@
+package com.kevmo314.kineticstreamer.kinetic
+
+import org.json.JSONArray
+import org.json.JSONObject
+
+/**
+ * Health of one SRT connection from the sender's side. Packet counts are
+ * totals since the connection was made.
+ *
+ * @param bandwidthMbps bandwidth estimated by the receiver
+ * @param flightSize packets sent but not acknowledged
+ * @param sendBuffer packets waiting to be sent
+ * @param packetsDropped packets dropped for being too late to send
+ */
+data class SRTSocketStats(
+    val rttMs: Double,
+    val sendRateMbps: Double,
+    val bandwidthMbps: Double,
+    val flightSize: Int,
+    val sendBuffer: Int,
+    val congestionWindow: Int,
+    val packetsSent: Long,
+    val packetsLost: Long,
+    val packetsRetransmitted: Long,
+    val packetsDropped: Long,
+) {
+    companion object {
+        internal fun fromJson(json: JSONObject) = SRTSocketStats(
+            rttMs = json.optDouble("rttMs", 0.0),
+            sendRateMbps = json.optDouble("sendRateMbps", 0.0),
+            bandwidthMbps = json.optDouble("bandwidthMbps", 0.0),
+            flightSize = json.optInt("flightSize"),
+            sendBuffer = json.optInt("sendBuffer"),
+            congestionWindow = json.optInt("congestionWindow"),
+            packetsSent = json.optLong("packetsSent"),
+            packetsLost = json.optLong("packetsLost"),
+            packetsRetransmitted = json.optLong("packetsRetransmitted"),
+            packetsDropped = json.optLong("packetsDropped"),
+        )
+    }
+}
+
+/**
+ * One link of a bonded SRT sink.
+ *
+ * @param state "pending", "idle", "running" or "broken"
+ */
+data class SRTLinkStats(
+    val iface: String,
+    val localIP: String,
+    val state: String,
+    val weight: Int,
+    val stats: SRTSocketStats,
+)
+
+/**
+ * One receiver of a listener mode SRT sink.
+ */
+data class SRTReceiverStats(
+    val addr: String,
+    val streamID: String,
+    val stats: SRTSocketStats,
+)
+
+/**
+ * An SRT sink's send queue, which drops frames when the connection can't
+ * keep up. Dropped counts are totals since the sink was created.
+ *
+ * @param queued samples waiting to be sent
+ */
+data class SRTQueueStats(
+    val queued: Int,
+    val droppedVideoFrames: Long,
+    val droppedAudioFrames: Long,
+) {
+    companion object {
+        internal fun fromJson(json: JSONObject?) = SRTQueueStats(
+            queued = json?.optInt("queued") ?: 0,
+            droppedVideoFrames = json?.optLong("droppedVideoFrames") ?: 0,
+            droppedAudioFrames = json?.optLong("droppedAudioFrames") ?: 0,
+        )
+    }
+}
+
+/**
+ * A snapshot of an SRT sink's connection health.
+ *
+ * @param stats the connection's stats, or for bonded and listener mode sinks,
+ * the sum of their links' or receivers' with the highest RTT
+ * @param targetBitrateBps the bitrate estimate from [SRTSink.getEstimatedBandwidth]
+ * @param links bonded sinks only
+ * @param receivers listener mode sinks only
+ * @param queue the send queue
+ */
+data class SRTStats(
+    val stats: SRTSocketStats,
+    val connected: Boolean,
+    val targetBitrateBps: Long,
+    val links: List<SRTLinkStats>,
+    val receivers: List<SRTReceiverStats>,
+    val queue: SRTQueueStats,
+) {
+    companion object {
+        internal fun fromJson(s: String): SRTStats {
+            val json = JSONObject(s)
+            val links = json.optJSONArray("links") ?: JSONArray()
+            val receivers = json.optJSONArray("receivers") ?: JSONArray()
+            return SRTStats(
+                stats = SRTSocketStats.fromJson(json),
+                connected = json.optBoolean("connected"),
+                targetBitrateBps = json.optLong("targetBitrateBps"),
+                links = (0 until links.length()).map {
+                    val link = links.getJSONObject(it)
+                    SRTLinkStats(
+                        iface = link.optString("interface"),
+                        localIP = link.optString("localIP"),
+                        state = link.optString("state"),
+                        weight = link.optInt("weight"),
+                        stats = SRTSocketStats.fromJson(link),
+                    )
+                },
+                receivers = (0 until receivers.length()).map {
+                    val receiver = receivers.getJSONObject(it)
+                    SRTReceiverStats(
+                        addr = receiver.optString("addr"),
+                        streamID = receiver.optString("streamID"),
+                        stats = SRTSocketStats.fromJson(receiver),
+                    )
+                },
+                queue = SRTQueueStats.fromJson(json.optJSONObject("queue")),
+            )
+        }
+    }
+}
@@ -9,22 +9,28 @@ interface PLICallback {
 /**
  * WHIP sink for WebRTC streaming
  */
-class WHIPSink(url: String, token: String, mimeTypes: String) : Closeable {
+class WHIPSink(
+    url: String,
+    token: String,
+    mimeTypes: String,
+    options: WebRTCOptions = WebRTCOptions(),
+) : Closeable {
     private var nativeHandle: Long
 
     init {
         // Ensure Kinetic library is loaded
         Kinetic
 
-        nativeHandle = create(url, token, mimeTypes)
+        nativeHandle = create(url, token, mimeTypes, options.toJson())
         if (nativeHandle == 0L) {
             throw RuntimeException("Failed to create WHIPSink")
         }
     }
 
-    private external fun create(url: String, token: String, mimeTypes: String): Long
+    private external fun create(url: String, token: String, mimeTypes: String, options: String): Long
     private external fun setPLICallback(handle: Long, callback: PLICallback)
 
+    private external fun writeSample(handle: Long, streamIndex: Int, data: ByteArray, pts: Long, flags: Int): Int
     private external fun writeH264(handle: Long, data: ByteArray, pts: Long): Int
     private external fun writeOpus(handle: Long, data: ByteArray, pts: Long)
 
@@ -51,7 +57,8 @@ class WHIPSink(url: String, token: String, mimeTypes: String) : Closeable {
     }
 
     /**
-     * Write a sample to the WHIP stream
+     * Write a sample to the WHIP stream in whichever codec its track was
+     * created with, e.g. H.265, AV1 or VP9 video
      * @param streamIndex 0 for video, 1 for audio
      * @param data The encoded data
      * @param ptsMicroseconds Presentation timestamp in microseconds
@@ -59,14 +66,10 @@ class WHIPSink(url: String, token: String, mimeTypes: String) : Closeable {
      * @return Target bitrate in bps for video, 0 for audio
      */
     fun writeSample(streamIndex: Int, data: ByteArray, ptsMicroseconds: Long, flags: Int): Int {
-        return when (streamIndex) {
-            0 -> writeH264(nativeHandle, data, ptsMicroseconds) // Video stream returns bitrate
-            1 -> {
-                writeOpus(nativeHandle, data, ptsMicroseconds) // Audio stream
-                0 // No bitrate control for audio
-            }
-            else -> throw IllegalArgumentException("Invalid stream index: $streamIndex")
+        if (streamIndex != 0 && streamIndex != 1) {
+            throw IllegalArgumentException("Invalid stream index: $streamIndex")
         }
+        return writeSample(nativeHandle, streamIndex, data, ptsMicroseconds, flags)
     }
 
     override fun close() {
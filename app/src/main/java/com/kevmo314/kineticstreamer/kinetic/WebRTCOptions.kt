@@ -0,0 +1,53 @@
+package com.kevmo314.kineticstreamer.kinetic
+
+import org.json.JSONArray
+import org.json.JSONObject
+
+/**
+ * A STUN or TURN server, e.g. `ICEServer(listOf("turn:turn.example.com"), "user", "password")`.
+ */
+data class ICEServer(
+    val urls: List<String>,
+    val username: String? = null,
+    val credential: String? = null,
+)
+
+/**
+ * ICE servers and network policy for WebRTC sinks. The defaults gather
+ * candidates on every interface and any port using Google's public STUN server.
+ *
+ * @param relayOnly only use TURN relay candidates, hiding the device's addresses
+ * @param portMin lowest local UDP port, 0 for no limit
+ * @param portMax highest local UDP port, 0 for no limit
+ * @param nat1To1IPs public IPs mapped one to one onto the device's, advertised in place of its own
+ * @param excludeCellular don't use mobile data interfaces
+ */
+data class WebRTCOptions(
+    val iceServers: List<ICEServer> = emptyList(),
+    val relayOnly: Boolean = false,
+    val portMin: Int = 0,
+    val portMax: Int = 0,
+    val nat1To1IPs: List<String> = emptyList(),
+    val excludeCellular: Boolean = false,
+) {
+    /**
+     * Encodes the options as the JSON the native library expects.
+     */
+    fun toJson(): String {
+        val servers = JSONArray()
+        for (server in iceServers) {
+            val json = JSONObject().put("urls", JSONArray(server.urls))
+            server.username?.let { json.put("username", it) }
+            server.credential?.let { json.put("credential", it) }
+            servers.put(json)
+        }
+        return JSONObject()
+            .put("iceServers", servers)
+            .put("relayOnly", relayOnly)
+            .put("portMin", portMin)
+            .put("portMax", portMax)
+            .put("nat1To1IPs", JSONArray(nat1To1IPs))
+            .put("excludeCellular", excludeCellular)
+            .toString()
+    }
+}